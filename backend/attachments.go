@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  attachment_blobs:
+    - _id        (ObjectId, the GridFS file in bucket "attachments")
+    - sha256     (string, hex)
+    - size       (int64, bytes)
+    - refs       (int64, attachments rows pointing here)
+    - residency  (string, tag; absent = untagged, see residency.go)
+    - created_at (int64, millis)
+  Unique index on (sha256, size, residency)
+
+  attachments:
+    - _id             (ObjectId)
+    - blob_id         (ObjectId -> attachment_blobs)
+    - conversation_id (ObjectId)
+    - uploader_id     (ObjectId)
+    - filename        (string)
+    - content_type    (string)
+    - size            (int64)
+    - sha256          (string)
+    - residency       (string, the conversation's tag)
+    - width, height   (int, pixels; images only)
+    - scan_status     (string, "pending" | "clean" | "infected"; scanner.go)
+    - scan_signature  (string, what the scanner found; infected only)
+    - scanned_at      (int64, millis)
+    - created_at      (int64, millis)
+
+Content-addressed storage: the same bytes are stored once per residency
+tag however many times they're uploaded. putAttachment hashes the upload, then either bumps
+refs on the matching blob or stores the bytes and inserts the blob row; a
+concurrent upload of the same file loses on the unique index, drops its
+copy and bumps the winner instead. A blob row only exists once its bytes
+are complete. deleteAttachment drops the row and releases the blob, whose
+bytes go when refs reaches 0; an upload that bumps refs in between keeps
+them (the delete only matches refs: 0).
+
+Images are uploaded and served by images.go (POST /uploads, GET
+/files/:id); other routes should also go through putAttachment and
+deleteAttachment. GET /admin/stats reports the bytes saved.
+*/
+
+type Attachment struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"   json:"id"`
+	BlobID         primitive.ObjectID `bson:"blob_id"         json:"-"`
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	UploaderID     primitive.ObjectID `bson:"uploader_id"     json:"uploader_id"`
+	Filename       string             `bson:"filename"        json:"filename"`
+	ContentType    string             `bson:"content_type"    json:"content_type"`
+	Size           int64              `bson:"size"            json:"size"`
+	SHA256         string             `bson:"sha256"          json:"sha256"`
+	Residency      string             `bson:"residency,omitempty" json:"-"`
+	// malware scan (scanner.go); empty on uploads from before scanning
+	ScanStatus    string `bson:"scan_status,omitempty"    json:"scan_status,omitempty"`
+	ScanSignature string `bson:"scan_signature,omitempty" json:"-"`
+	ScannedAt     int64  `bson:"scanned_at,omitempty"     json:"-"`
+	// images only (images.go)
+	Width     int   `bson:"width,omitempty"  json:"width,omitempty"`
+	Height    int   `bson:"height,omitempty" json:"height,omitempty"`
+	CreatedAt int64 `bson:"created_at"      json:"created_at"`
+}
+
+func maxAttachmentBytes() int64 {
+	return int64(envInt("ATTACHMENT_MAX_MB", 25)) << 20
+}
+
+func attachmentBucket(ctx context.Context, db *mongo.Database) (*gridfs.Bucket, error) {
+	b, err := gridfs.NewBucket(db, options.GridFSBucket().SetName("attachments"))
+	if err != nil {
+		return nil, err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = b.SetReadDeadline(dl)
+		_ = b.SetWriteDeadline(dl)
+	}
+	return b, nil
+}
+
+func ensureAttachmentIndexes(ctx context.Context, db *mongo.Database) error {
+	blobs := db.Collection("attachment_blobs").Indexes()
+	// the pre-residency key would stop two tags holding the same bytes
+	_, _ = blobs.DropOne(ctx, "sha256_1_size_1")
+	if _, err := blobs.CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "sha256", Value: 1}, {Key: "size", Value: 1}, {Key: "residency", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+	_, err := db.Collection("attachments").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "blob_id", Value: 1}}},
+		{Keys: bson.D{{Key: "conversation_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// spoolUpload copies r to a temp file, hashing it on the way. The caller
+// removes the file.
+func spoolUpload(r io.Reader) (f *os.File, sum string, size int64, err error) {
+	f, err = os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return nil, "", 0, err
+	}
+	h := sha256.New()
+	limit := maxAttachmentBytes()
+	size, err = io.Copy(io.MultiWriter(f, h), io.LimitReader(r, limit+1))
+	if err == nil && size > limit {
+		err = svcFail(http.StatusRequestEntityTooLarge, fmt.Sprintf("attachments are limited to %d MB", limit>>20))
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", 0, err
+	}
+	return f, hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// acquireBlob returns the blob holding f's bytes under residency tag r with
+// one more ref, storing them first if no upload has yet.
+func acquireBlob(ctx context.Context, db *mongo.Database, f *os.File, sum string, size int64, r string) (primitive.ObjectID, error) {
+	blobs := db.Collection("attachment_blobs")
+	for attempt := 0; attempt < 3; attempt++ {
+		var hit struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		// untagged blobs have no residency field; null matches that
+		key := bson.M{"sha256": sum, "size": size, "residency": nil}
+		if r != "" {
+			key["residency"] = r
+		}
+		err := blobs.FindOneAndUpdate(ctx, key,
+			bson.M{"$inc": bson.M{"refs": 1}},
+		).Decode(&hit)
+		if err == nil {
+			return hit.ID, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return primitive.NilObjectID, err
+		}
+
+		// first copy: bytes, then the row that makes them findable
+		bucket, err := attachmentBucket(ctx, db)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return primitive.NilObjectID, err
+		}
+		id, err := bucket.UploadFromStream(sum, f, residencyMetadata(r))
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		row := bson.M{
+			"_id": id, "sha256": sum, "size": size, "refs": int64(1),
+			"created_at": time.Now().UnixMilli(),
+		}
+		if r != "" {
+			row["residency"] = r
+		}
+		_, err = blobs.InsertOne(ctx, row)
+		if err == nil {
+			return id, nil
+		}
+		_ = bucket.Delete(id)
+		if !mongo.IsDuplicateKeyError(err) {
+			return primitive.NilObjectID, err
+		}
+		// a concurrent upload stored it first; take a ref on theirs
+	}
+	return primitive.NilObjectID, svcFail(http.StatusServiceUnavailable, "attachment store busy, retry")
+}
+
+// releaseBlob drops one ref on id and removes the bytes at zero.
+func releaseBlob(ctx context.Context, db *mongo.Database, id primitive.ObjectID) error {
+	blobs := db.Collection("attachment_blobs")
+	var after struct {
+		Refs int64 `bson:"refs"`
+	}
+	err := blobs.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"refs": -1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&after)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil || after.Refs > 0 {
+		return err
+	}
+	// only if nobody took a ref since
+	res, err := blobs.DeleteOne(ctx, bson.M{"_id": id, "refs": bson.M{"$lte": 0}})
+	if err != nil || res.DeletedCount == 0 {
+		return err
+	}
+	bucket, err := attachmentBucket(ctx, db)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Delete(id); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+		return err
+	}
+	return nil
+}
+
+// putAttachment stores r as a new attachment described by a (conversation,
+// uploader, filename, content type), sharing bytes with any identical
+// upload, and queues its malware scan. Returns the stored row, still
+// scan_status pending.
+func putAttachment(ctx context.Context, db *mongo.Database, a Attachment, r io.Reader) (Attachment, error) {
+	if err := ensureAttachmentIndexes(ctx, db); err != nil {
+		return Attachment{}, svcFail(http.StatusInternalServerError, "index error")
+	}
+	f, sum, size, err := spoolUpload(r)
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	residency, err := convResidency(ctx, db, a.ConversationID)
+	if err != nil {
+		return Attachment{}, err
+	}
+	blobID, err := acquireBlob(ctx, db, f, sum, size, residency)
+	if err != nil {
+		fmt.Println("attachment store error:", err)
+		return Attachment{}, err
+	}
+	a.ID = primitive.NilObjectID
+	a.BlobID, a.Size, a.SHA256, a.Residency = blobID, size, sum, residency
+	a.ScanStatus, a.ScanSignature, a.ScannedAt = ScanPending, "", 0
+	a.CreatedAt = time.Now().UnixMilli()
+	res, err := db.Collection("attachments").InsertOne(ctx, a)
+	if err != nil {
+		fmt.Println("insert attachment error:", err)
+		if rerr := releaseBlob(ctx, db, blobID); rerr != nil {
+			fmt.Println("release blob error:", rerr)
+		}
+		return Attachment{}, err
+	}
+	a.ID = res.InsertedID.(primitive.ObjectID)
+	scanAttachment(db, a)
+	return a, nil
+}
+
+// deleteAttachment removes attachment id; its bytes go with the last
+// attachment using them.
+func deleteAttachment(ctx context.Context, db *mongo.Database, id primitive.ObjectID) error {
+	var a Attachment
+	err := db.Collection("attachments").FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&a)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return svcFail(http.StatusNotFound, "attachment not found")
+	}
+	if err != nil {
+		return err
+	}
+	return releaseBlob(ctx, db, a.BlobID)
+}
+
+type attachmentStats struct {
+	Blobs        int64 `bson:"blobs"         json:"blobs"`
+	Files        int64 `bson:"files"         json:"files"`
+	StoredBytes  int64 `bson:"stored_bytes"  json:"stored_bytes"`
+	LogicalBytes int64 `bson:"logical_bytes" json:"logical_bytes"`
+	SavedBytes   int64 `bson:"-"             json:"saved_bytes"`
+}
+
+func loadAttachmentStats(ctx context.Context, db *mongo.Database) (attachmentStats, error) {
+	var st attachmentStats
+	cur, err := db.Collection("attachment_blobs").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"refs": bson.M{"$gt": 0}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           nil,
+			"blobs":         bson.M{"$sum": 1},
+			"files":         bson.M{"$sum": "$refs"},
+			"stored_bytes":  bson.M{"$sum": "$size"},
+			"logical_bytes": bson.M{"$sum": bson.M{"$multiply": bson.A{"$size", "$refs"}}},
+		}}},
+	})
+	if err != nil {
+		return st, err
+	}
+	defer cur.Close(ctx)
+	if cur.Next(ctx) {
+		if err := cur.Decode(&st); err != nil {
+			return st, err
+		}
+	}
+	st.SavedBytes = st.LogicalBytes - st.StoredBytes
+	return st, cur.Err()
+}
+
+// GET /admin/stats (admin only)
+// Returns: { attachments: { blobs, files, stored_bytes, logical_bytes,
+// saved_bytes }, residency: { "<tag>": { conversations, messages,
+// attachment_files, attachment_bytes } } }; saved_bytes is what
+// deduplication kept out of storage, and tag "" is untagged data.
+func AdminStatsHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+		st, err := loadAttachmentStats(ctx, db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		rs, err := loadResidencyStats(ctx, db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"attachments": st, "residency": rs})
+	}
+}
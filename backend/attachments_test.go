@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func countDocs(t *testing.T, db *mongo.Database, coll string, filter bson.M) int64 {
+	t.Helper()
+	n, err := db.Collection(coll).CountDocuments(testCtx(t), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAttachmentConcurrentDedup(t *testing.T) {
+	_, db := testDB(t)
+	ann := seedUser(t, db, "ann")
+	conv := seedConv(t, db, "ops", ann)
+	data := bytes.Repeat([]byte("same bytes "), 4096)
+
+	const n = 8
+	var (
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+		ids   [n]primitive.ObjectID
+		errs  [n]error
+	)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			a, err := putAttachment(testCtx(t), db, Attachment{
+				ConversationID: conv.ID, UploaderID: ann.ID, Filename: "f.txt", ContentType: "text/plain",
+			}, bytes.NewReader(data))
+			ids[i], errs[i] = a.ID, err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("upload %d: %v", i, err)
+		}
+	}
+
+	var blobs []struct {
+		ID   primitive.ObjectID `bson:"_id"`
+		Refs int64              `bson:"refs"`
+	}
+	cur, err := db.Collection("attachment_blobs").Find(testCtx(t), bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cur.All(testCtx(t), &blobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 1 || blobs[0].Refs != n {
+		t.Fatalf("blobs = %+v, want one with %d refs", blobs, n)
+	}
+	if got := countDocs(t, db, "attachments.files", bson.M{}); got != 1 {
+		t.Fatalf("%d stored copies, want 1", got)
+	}
+	if got := countDocs(t, db, "attachments", bson.M{"blob_id": blobs[0].ID}); got != n {
+		t.Fatalf("%d attachments on the blob, want %d", got, n)
+	}
+
+	// concurrent deletes free the bytes exactly once, after the last one
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = deleteAttachment(testCtx(t), db, ids[i])
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("delete %d: %v", i, err)
+		}
+	}
+	for _, coll := range []string{"attachment_blobs", "attachments", "attachments.files", "attachments.chunks"} {
+		if got := countDocs(t, db, coll, bson.M{}); got != 0 {
+			t.Errorf("%d documents left in %s", got, coll)
+		}
+	}
+}
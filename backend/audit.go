@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Schema:
+  audit_log:
+    - action          (string, e.g. "messages.purge")
+    - actor_id        (ObjectId)
+    - conversation_id (ObjectId, optional)
+    - details         (object)
+    - ts              (int64, millis)
+*/
+
+type AuditEntry struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Action         string             `bson:"action" json:"action"`
+	ActorID        primitive.ObjectID `bson:"actor_id" json:"actor_id"`
+	ConversationID primitive.ObjectID `bson:"conversation_id,omitempty" json:"conversation_id,omitempty"`
+	Details        interface{}        `bson:"details" json:"details"`
+	Ts             int64              `bson:"ts" json:"ts"`
+}
+
+// writeAudit records an entry; failures are logged, never surfaced.
+func writeAudit(ctx context.Context, db *mongo.Database, e AuditEntry) {
+	e.Ts = time.Now().UnixMilli()
+	if _, err := db.Collection("audit_log").InsertOne(ctx, e); err != nil {
+		fmt.Println("audit write error:", err)
+	}
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -21,6 +24,18 @@ type User struct {
 	Username  string             `bson:"username" json:"username"`
 	CreatedAt int64              `bson:"created_at" json:"created_at"`
 	LastSeen  int64              `bson:"last_seen" json:"last_seen"`
+	// casing as claimed (or changed by a case-only rename). username is its
+	// normalized form and is what lookups, mentions and uniqueness use.
+	Display string `bson:"username_display,omitempty" json:"-"`
+}
+
+// DisplayName is the name to show; users from before username_display
+// fall back to username.
+func (u User) DisplayName() string {
+	if u.Display != "" {
+		return u.Display
+	}
+	return u.Username
 }
 
 // === Username Rules ===
@@ -43,6 +58,9 @@ func validateUsername(u string) error {
 	if _, bad := reservedName[u]; bad {
 		return errors.New("username is reserved")
 	}
+	if containsProfanity(u) {
+		return errors.New("username contains blocked words")
+	}
 	return nil
 }
 
@@ -50,30 +68,134 @@ func validateUsername(u string) error {
 
 type Claims struct {
 	UserID   string `json:"user_id"`
-	Username string `json:"username"`
+	Username string `json:"username"` // normalized
+	Display  string `json:"display,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func jwtSecret() []byte {
-	if s := os.Getenv("JWT_SECRET"); s != "" {
-		return []byte(s)
+// jwtKey is one HMAC secret identified by a short, non-secret kid.
+type jwtKey struct {
+	kid    string
+	secret []byte
+}
+
+// jwtKeys returns the configured keys; the first one signs.
+// JWT_SECRETS="new,old" supports rotation; JWT_SECRET is the single-key form.
+func jwtKeys() []jwtKey {
+	var secrets []string
+	for _, s := range strings.Split(os.Getenv("JWT_SECRETS"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+	if len(secrets) == 0 {
+		if s := os.Getenv("JWT_SECRET"); s != "" {
+			secrets = []string{s}
+		} else {
+			secrets = []string{"dev-secret-key-change-me"}
+		}
 	}
-	return []byte("dev-secret-key-change-me")
+	keys := make([]jwtKey, 0, len(secrets))
+	for _, s := range secrets {
+		sum := sha256.Sum256([]byte(s))
+		keys = append(keys, jwtKey{kid: hex.EncodeToString(sum[:4]), secret: []byte(s)})
+	}
+	return keys
 }
 
-func signJWT(id primitive.ObjectID, username string, ttl time.Duration) (string, error) {
+func signJWT(u User, ttl time.Duration) (string, error) {
 	claims := Claims{
-		UserID:   id.Hex(),
-		Username: username,
+		UserID:   u.ID.Hex(),
+		Username: u.Username,
+		Display:  u.DisplayName(),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
+	key := jwtKeys()[0]
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return t.SignedString(jwtSecret())
+	t.Header["kid"] = key.kid
+	return t.SignedString(key.secret)
+}
+
+// per-kid verification stats, so operators can tell when an old key is unused
+var jwtKeyUsage = struct {
+	sync.Mutex
+	m map[string]*keyUsage
+}{m: make(map[string]*keyUsage)}
+
+type keyUsage struct {
+	Count    int64 `json:"count"`
+	LastUsed int64 `json:"last_used"`
+}
+
+func noteKeyUsed(kid string) {
+	jwtKeyUsage.Lock()
+	defer jwtKeyUsage.Unlock()
+	u, ok := jwtKeyUsage.m[kid]
+	if !ok {
+		u = &keyUsage{}
+		jwtKeyUsage.m[kid] = u
+	}
+	u.Count++
+	u.LastUsed = time.Now().UnixMilli()
+}
+
+// parseToken verifies tokenStr against the configured keys. Tokens carrying
+// a known kid are checked with that key only; older tokens without one are
+// tried against every key.
+func parseToken(tokenStr string) (*Claims, error) {
+	keys := jwtKeys()
+	var kidHint string
+	if t, _, err := jwt.NewParser().ParseUnverified(tokenStr, &Claims{}); err == nil {
+		kidHint, _ = t.Header["kid"].(string)
+	}
+
+	var lastErr error = jwt.ErrTokenUnverifiable
+	for _, k := range keys {
+		if kidHint != "" && k.kid != kidHint {
+			continue
+		}
+		var claims Claims
+		_, err := jwt.ParseWithClaims(tokenStr, &claims, func(t *jwt.Token) (interface{}, error) {
+			return k.secret, nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+		if err == nil {
+			noteKeyUsed(k.kid)
+			return &claims, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// GET /admin/jwt-keys
+// Lists configured key ids (first one signs) and how often each verified a token.
+func JWTKeysHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jwtKeyUsage.Lock()
+		defer jwtKeyUsage.Unlock()
+		keys := jwtKeys()
+		out := make([]gin.H, 0, len(keys))
+		for i, k := range keys {
+			u := keyUsage{}
+			if x, ok := jwtKeyUsage.m[k.kid]; ok {
+				u = *x
+			}
+			out = append(out, gin.H{
+				"kid":       k.kid,
+				"signing":   i == 0,
+				"count":     u.Count,
+				"last_used": u.LastUsed,
+			})
+		}
+		c.JSON(200, gin.H{"keys": out, "since": processStart.UnixMilli()})
+	}
 }
 
+var processStart = time.Now()
+
 // AuthRequired parses Bearer token and injects uid/uname into context.
 func AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -83,16 +205,39 @@ func AuthRequired() gin.HandlerFunc {
 			return
 		}
 		tokenStr := strings.TrimPrefix(h, "Bearer ")
-		var claims Claims
-		_, err := jwt.ParseWithClaims(tokenStr, &claims, func(t *jwt.Token) (interface{}, error) {
-			return jwtSecret(), nil
-		})
+		claims, err := parseToken(tokenStr)
 		if err != nil {
 			c.AbortWithStatusJSON(401, gin.H{"error": "invalid token"})
 			return
 		}
 		c.Set("uid", claims.UserID)
 		c.Set("uname", claims.Username)
+		display := claims.Display
+		if display == "" {
+			display = claims.Username // tokens from before display names
+		}
+		c.Set("udisplay", display)
+		c.Next()
+	}
+}
+
+// isAdmin reports whether uname is listed in ADMIN_USERS (comma separated).
+func isAdmin(uname string) bool {
+	for _, a := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if a = normalizeUsername(a); a != "" && a == uname {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminRequired allows only admins. Must run after AuthRequired.
+func AdminRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isAdmin(c.GetString("uname")) {
+			c.AbortWithStatusJSON(403, gin.H{"error": "admin only"})
+			return
+		}
 		c.Next()
 	}
 }
@@ -128,6 +273,7 @@ func ClaimUsernameHandler(client *mongo.Client) gin.HandlerFunc {
 		now := time.Now().UnixMilli()
 		doc := User{
 			Username:  u,
+			Display:   strings.TrimSpace(in.Username),
 			CreatedAt: now,
 			LastSeen:  now,
 		}
@@ -154,9 +300,9 @@ func ClaimUsernameHandler(client *mongo.Client) gin.HandlerFunc {
 			}
 			_, _ = db.Collection("users").UpdateByID(ctx, existing.ID,
 				bson.M{"$set": bson.M{"last_seen": now}})
-			tok, _ := signJWT(existing.ID, existing.Username, 24*time.Hour)
+			tok, _ := signJWT(existing, 24*time.Hour)
 			c.JSON(200, gin.H{"token": tok, "user": gin.H{
-				"id": existing.ID.Hex(), "username": existing.Username,
+				"id": existing.ID.Hex(), "username": existing.DisplayName(),
 			}})
 			return
 		}
@@ -165,11 +311,11 @@ func ClaimUsernameHandler(client *mongo.Client) gin.HandlerFunc {
 			return
 		}
 
-		oid := res.InsertedID.(primitive.ObjectID)
-		tok, _ := signJWT(oid, u, 24*time.Hour)
+		doc.ID = res.InsertedID.(primitive.ObjectID)
+		tok, _ := signJWT(doc, 24*time.Hour)
 		c.JSON(201, gin.H{
 			"token": tok,
-			"user":  gin.H{"id": oid.Hex(), "username": u},
+			"user":  gin.H{"id": doc.ID.Hex(), "username": doc.Display},
 		})
 	}
 }
@@ -178,7 +324,7 @@ func ClaimUsernameHandler(client *mongo.Client) gin.HandlerFunc {
 func MeHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		uid, _ := c.Get("uid")
-		uname, _ := c.Get("uname")
+		uname, _ := c.Get("udisplay")
 		c.JSON(200, gin.H{"user_id": uid, "username": uname})
 	}
 }
@@ -220,7 +366,7 @@ func ListUsersHandler(client *mongo.Client) gin.HandlerFunc {
 			}
 			users = append(users, gin.H{
 				"id":       user.ID.Hex(),
-				"username": user.Username,
+				"username": user.DisplayName(),
 			})
 		}
 
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testUser = User{ID: primitive.NewObjectID(), Username: "ann"}
+
+// signedWith signs a token for testUser with JWT_SECRETS=secrets in effect.
+func signedWith(t *testing.T, secrets string) string {
+	t.Helper()
+	t.Setenv("JWT_SECRETS", secrets)
+	tok, err := signJWT(testUser, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func kidOf(t *testing.T, tok string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(tok, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestJWTRotation(t *testing.T) {
+	old := signedWith(t, "old-secret")
+
+	// rotate: the new key signs, the old one still verifies
+	t.Setenv("JWT_SECRETS", "new-secret, old-secret")
+	claims, err := parseToken(old)
+	if err != nil {
+		t.Fatalf("token signed with the previous key: %v", err)
+	}
+	if claims.UserID != testUser.ID.Hex() {
+		t.Fatalf("user_id = %q", claims.UserID)
+	}
+	fresh, err := signJWT(testUser, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := jwtKeys()
+	if kidOf(t, fresh) != keys[0].kid || kidOf(t, old) != keys[1].kid {
+		t.Fatalf("kids: fresh %s, old %s; keys %s, %s", kidOf(t, fresh), kidOf(t, old), keys[0].kid, keys[1].kid)
+	}
+	if _, err := parseToken(fresh); err != nil {
+		t.Fatalf("token signed with the new key: %v", err)
+	}
+
+	// retire the old key
+	t.Setenv("JWT_SECRETS", "new-secret")
+	if _, err := parseToken(old); err == nil {
+		t.Fatal("token signed with a retired key accepted")
+	}
+	if _, err := parseToken(fresh); err != nil {
+		t.Fatalf("token signed with the current key: %v", err)
+	}
+}
+
+func TestJWTRetiredKeyRejectedByAuthRequired(t *testing.T) {
+	tests := []struct {
+		name string
+		tok  string
+		want int
+	}{
+		{"retired key", signedWith(t, "old-secret"), http.StatusUnauthorized},
+		{"current key", signedWith(t, "new-secret"), http.StatusOK},
+	}
+	t.Setenv("JWT_SECRETS", "new-secret")
+
+	r := gin.New()
+	r.GET("/me", AuthRequired(), func(c *gin.Context) { c.String(http.StatusOK, c.GetString("uid")) })
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.tok)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestJWTKidPinsTheKey(t *testing.T) {
+	t.Setenv("JWT_SECRETS", "new-secret,old-secret")
+	keys := jwtKeys()
+	sign := func(kid string, secret []byte) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+			UserID:           testUser.ID.Hex(),
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		})
+		if kid != "" {
+			tok.Header["kid"] = kid
+		}
+		s, err := tok.SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// tokens from before kids are tried against every key
+	if _, err := parseToken(sign("", keys[1].secret)); err != nil {
+		t.Fatalf("kid-less token on a configured key: %v", err)
+	}
+	if _, err := parseToken(sign("", []byte("retired-secret"))); err == nil {
+		t.Fatal("kid-less token on a retired key accepted")
+	}
+	// a kid names the one key to check
+	if _, err := parseToken(sign(keys[0].kid, keys[1].secret)); err == nil {
+		t.Fatal("token verified with a key other than its kid's")
+	}
+	if _, err := parseToken(sign("deadbeef", keys[0].secret)); err == nil {
+		t.Fatal("token with an unknown kid accepted")
+	}
+
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, Claims{UserID: testUser.ID.Hex()}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseToken(none); err == nil {
+		t.Fatal("unsigned token accepted")
+	}
+}
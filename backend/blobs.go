@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Large message bodies.
+
+Bodies may be up to maxBodyRunes characters. One over bodyInlineMax bytes
+is stored in the GridFS bucket "bodies"; the message keeps only the first
+bodyPreviewRunes characters in body, plus:
+  - body_ref  (ObjectId, the GridFS file)
+  - body_len  (int, full length in bytes)
+  - truncated (true)
+Lists and events carry the preview; GET /messages/:cid/:mid/body streams the
+full text. Anything that matches on body inside Mongo (muted keywords, any
+future search index) sees the preview only. Code that needs the whole text
+goes through loadFullBody.
+
+Expiring messages always stay inline: the TTL index can't remove blobs.
+*/
+
+const (
+	maxBodyRunes     = 16384
+	bodyInlineMax    = 4096
+	bodyPreviewRunes = 500
+)
+
+func bodyBucket(ctx context.Context, db *mongo.Database) (*gridfs.Bucket, error) {
+	b, err := gridfs.NewBucket(db, options.GridFSBucket().SetName("bodies"))
+	if err != nil {
+		return nil, err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = b.SetReadDeadline(dl)
+		_ = b.SetWriteDeadline(dl)
+	}
+	return b, nil
+}
+
+// validBodyLen reports whether body is 1..maxBodyRunes characters.
+func validBodyLen(body string) bool {
+	return body != "" && utf8.RuneCountInString(body) <= maxBodyRunes
+}
+
+// externalizeBody moves msg.Body to GridFS when it is too big to keep
+// inline, leaving the preview and body_ref on msg.
+func externalizeBody(ctx context.Context, db *mongo.Database, msg *Message) error {
+	if len(msg.Body) <= bodyInlineMax || msg.ExpiresAt > 0 {
+		return nil
+	}
+	b, err := bodyBucket(ctx, db)
+	if err != nil {
+		return err
+	}
+	ref, err := b.UploadFromStream("message-body", bytes.NewReader([]byte(msg.Body)),
+		residencyMetadata(msg.Residency))
+	if err != nil {
+		return err
+	}
+	msg.BodyRef = &ref
+	msg.BodyLen = len(msg.Body)
+	msg.Truncated = true
+	msg.Body = previewRunes(msg.Body, bodyPreviewRunes)
+	return nil
+}
+
+// previewRunes cuts s to its first n characters, never inside a multi-byte
+// one.
+func previewRunes(s string, n int) string {
+	i := 0
+	for pos := range s {
+		if i == n {
+			return s[:pos]
+		}
+		i++
+	}
+	return s
+}
+
+// loadFullBody returns m's complete body, whichever way it is stored.
+func loadFullBody(ctx context.Context, db *mongo.Database, m Message) (string, error) {
+	if m.BodyRef == nil {
+		return m.Body, nil
+	}
+	b, err := bodyBucket(ctx, db)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if _, err := b.DownloadToStream(*m.BodyRef, &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// dropBodies deletes the blobs behind refs. Failures are logged: a leftover
+// blob is unreachable, not visible.
+func dropBodies(ctx context.Context, db *mongo.Database, refs []primitive.ObjectID) {
+	if len(refs) == 0 {
+		return
+	}
+	b, err := bodyBucket(ctx, db)
+	if err != nil {
+		fmt.Println("drop bodies error:", err)
+		return
+	}
+	for _, ref := range refs {
+		if err := b.DeleteContext(ctx, ref); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			fmt.Println("drop body error:", ref.Hex(), err)
+		}
+	}
+}
+
+// GET /messages/:cid/:mid/body
+// The full body as text/plain, streamed from GridFS for large messages.
+func GetMessageBodyHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		mid, err := mustOID(c.Param("mid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		ok, err := canRead(ctx, db, cid, uid, "messages.body")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+
+		var m Message
+		err = db.Collection("messages").FindOne(ctx, unexpired(bson.M{"_id": mid, "conversation_id": cid})).Decode(&m)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if m.BodyRef == nil {
+			c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(m.Body))
+			return
+		}
+
+		b, err := bodyBucket(ctx, db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		ds, err := b.OpenDownloadStream(*m.BodyRef)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "body unavailable"})
+			return
+		}
+		defer ds.Close()
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Header("Content-Length", strconv.FormatInt(ds.GetFile().Length, 10))
+		c.Status(http.StatusOK)
+		if _, err := io.Copy(c.Writer, ds); err != nil {
+			fmt.Println("stream body error:", err)
+		}
+	}
+}
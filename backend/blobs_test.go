@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// pasteOf is n characters of log-like text, some of them two bytes long.
+func pasteOf(n int, tag string) string {
+	var b strings.Builder
+	for i := 0; utf8.RuneCountInString(b.String()) < n; i++ {
+		b.WriteString(tag + " ligne d'état ok\n")
+	}
+	return string([]rune(b.String())[:n])
+}
+
+// TestLargeBodyRoundTrip sends a 15k-character paste and follows it
+// through the list, the full-body fetch, an edit and the exports: a
+// snapshot and the public widget feed.
+func TestLargeBodyRoundTrip(t *testing.T) {
+	client, db := testDB(t)
+	ann := seedUser(t, db, "ann")
+	conv := seedConv(t, db, "ops", ann)
+	r, api := testAPI()
+	api.POST("/messages/:cid", SendMessageHandler(client))
+	api.GET("/messages/:cid", ListMessagesHandler(client))
+	api.GET("/messages/:cid/:mid/body", GetMessageBodyHandler(client))
+	api.PATCH("/messages/:cid/:mid", EditMessageHandler(client))
+	api.POST("/conversations/:cid/snapshots", CreateSnapshotHandler(client))
+	api.POST("/conversations/:cid/widgets", CreateWidgetHandler(client))
+	r.GET("/snapshots/:token", GetSnapshotHandler(client))
+	r.GET("/widget/:token/feed", WidgetFeedHandler(client))
+	base := "/messages/" + conv.ID.Hex()
+
+	paste := pasteOf(15000, "v1")
+	start := time.Now().UnixMilli()
+	w := serve(t, r, http.MethodPost, base, &ann, gin.H{"body": paste})
+	var sent Message
+	decode(t, w, &sent)
+	if w.Code != http.StatusCreated || !sent.Truncated || sent.BodyLen != len(paste) || sent.Body != previewRunes(paste, bodyPreviewRunes) {
+		t.Fatalf("send: %d, truncated %v, body_len %d, %d-char body", w.Code, sent.Truncated, sent.BodyLen, utf8.RuneCountInString(sent.Body))
+	}
+
+	w = serve(t, r, http.MethodGet, base, &ann, nil)
+	var list []Message
+	decode(t, w, &list)
+	if len(list) != 1 || list[0].Body != sent.Body || !list[0].Truncated {
+		t.Fatalf("list: %d messages", len(list))
+	}
+
+	full := func(want string) {
+		t.Helper()
+		w := serve(t, r, http.MethodGet, base+"/"+sent.ID.Hex()+"/body", &ann, nil)
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Fatalf("full body: %d, %d bytes, want %d", w.Code, w.Body.Len(), len(want))
+		}
+	}
+	full(paste)
+
+	edited := pasteOf(15000, "v2")
+	w = serve(t, r, http.MethodPatch, base+"/"+sent.ID.Hex(), &ann, gin.H{"body": edited})
+	var after Message
+	decode(t, w, &after)
+	if w.Code != http.StatusOK || !after.Truncated || after.Body != previewRunes(edited, bodyPreviewRunes) {
+		t.Fatalf("edit: %d %.200s", w.Code, w.Body)
+	}
+	full(edited)
+	if n := countDocs(t, db, "bodies.files", bson.M{}); n != 1 {
+		t.Fatalf("%d stored bodies after the edit, want 1", n)
+	}
+
+	w = serve(t, r, http.MethodPost, "/conversations/"+conv.ID.Hex()+"/snapshots", &ann,
+		gin.H{"from": start - 1000, "to": time.Now().UnixMilli() + 1000})
+	var snap Snapshot
+	decode(t, w, &snap)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("snapshot: %d %s", w.Code, w.Body)
+	}
+	w = serve(t, r, http.MethodGet, "/snapshots/"+snap.Token, nil, nil)
+	var page snapshotPage
+	decode(t, w, &page)
+	if len(page.Messages) != 1 || page.Messages[0].Body != edited {
+		t.Fatalf("snapshot: %d messages", len(page.Messages))
+	}
+
+	w = serve(t, r, http.MethodPost, "/conversations/"+conv.ID.Hex()+"/widgets", &ann,
+		gin.H{"allowed_origins": []string{"https://example.com"}})
+	var wt WidgetToken
+	decode(t, w, &wt)
+	req := httptest.NewRequest(http.MethodGet, "/widget/"+wt.Token+"/feed", nil)
+	req.Header.Set("Origin", "https://example.com")
+	fw := httptest.NewRecorder()
+	r.ServeHTTP(fw, req)
+	var feed struct {
+		Messages []struct {
+			Body string `json:"body"`
+		} `json:"messages"`
+	}
+	decode(t, fw, &feed)
+	if len(feed.Messages) != 1 || feed.Messages[0].Body != edited {
+		t.Fatalf("widget feed: %d messages", len(feed.Messages))
+	}
+	if got := widgetFullBody(client, sent.ID.Hex()); got != edited {
+		t.Fatalf("widget stream body: %d chars", utf8.RuneCountInString(got))
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  canned:
+    - scope      ("user" | "conversation")
+    - owner_id   (ObjectId, user id or conversation id depending on scope)
+    - trigger    (string, slash-command name without the "/")
+    - body       (string)
+    - uses       (int64, bumped when a client reports using it)
+    - created_at (int64, millis)
+Unique index on (scope, owner_id, trigger)
+
+Storage and lookup only; sending stays a normal message send.
+*/
+
+type Canned struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Scope     string             `bson:"scope" json:"scope"`
+	OwnerID   primitive.ObjectID `bson:"owner_id" json:"owner_id"`
+	Trigger   string             `bson:"trigger" json:"trigger"`
+	Body      string             `bson:"body" json:"body"`
+	Uses      int64              `bson:"uses" json:"uses"`
+	CreatedAt int64              `bson:"created_at" json:"created_at"`
+}
+
+const (
+	cannedScopeUser = "user"
+	cannedScopeConv = "conversation"
+)
+
+var (
+	cannedTriggerRe = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+	cannedLimits    = map[string]int64{cannedScopeUser: 50, cannedScopeConv: 100}
+)
+
+func ensureCannedIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("canned").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "scope", Value: 1}, {Key: "owner_id", Value: 1}, {Key: "trigger", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func listCanned(ctx context.Context, db *mongo.Database, scope string, owner primitive.ObjectID) ([]Canned, error) {
+	cur, err := db.Collection("canned").Find(ctx,
+		bson.M{"scope": scope, "owner_id": owner},
+		options.Find().SetSort(bson.D{{Key: "uses", Value: -1}, {Key: "trigger", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Canned, 0, 16)
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// cannedOwner resolves which scope a request works on and whether the
+// caller may read/write it. Writes the error response itself on failure.
+func cannedOwner(c *gin.Context, ctx context.Context, db *mongo.Database, write bool) (string, primitive.ObjectID, bool) {
+	uidHex, _ := c.Get("uid")
+	uid, err := mustOID(uidHex.(string))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return "", primitive.NilObjectID, false
+	}
+	if c.Param("cid") == "" {
+		return cannedScopeUser, uid, true
+	}
+
+	cid, err := mustOID(c.Param("cid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+		return "", primitive.NilObjectID, false
+	}
+	role, err := memberRole(ctx, db, cid, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return "", primitive.NilObjectID, false
+	}
+	if role == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+		return "", primitive.NilObjectID, false
+	}
+	if write && role != "owner" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+		return "", primitive.NilObjectID, false
+	}
+	return cannedScopeConv, cid, true
+}
+
+func bindCanned(c *gin.Context) (trigger, body string, ok bool) {
+	var in struct {
+		Trigger string `json:"trigger"`
+		Body    string `json:"body"`
+	}
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+		return "", "", false
+	}
+	trigger = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(in.Trigger), "/"))
+	if !cannedTriggerRe.MatchString(trigger) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "trigger must match /^[a-z0-9_-]{1,32}$/"})
+		return "", "", false
+	}
+	if l := len(in.Body); l == 0 || l > 2048 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "body must be 1-2048 chars"})
+		return "", "", false
+	}
+	return trigger, in.Body, true
+}
+
+// === Handlers ===
+
+// GET /me/canned
+// GET /conversations/:cid/canned
+func ListCannedHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		scope, owner, ok := cannedOwner(c, ctx, db, false)
+		if !ok {
+			return
+		}
+		out, err := listCanned(ctx, db, scope, owner)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"canned": out})
+	}
+}
+
+// POST /me/canned
+// POST /conversations/:cid/canned (owner only)
+// Body: { "trigger": "thanks", "body": "Thanks for reaching out!" }
+func CreateCannedHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		scope, owner, ok := cannedOwner(c, ctx, db, true)
+		if !ok {
+			return
+		}
+		trigger, body, ok := bindCanned(c)
+		if !ok {
+			return
+		}
+
+		if err := ensureCannedIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
+			return
+		}
+		n, err := db.Collection("canned").CountDocuments(ctx, bson.M{"scope": scope, "owner_id": owner})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if n >= cannedLimits[scope] {
+			c.JSON(http.StatusConflict, gin.H{"error": "too many canned responses"})
+			return
+		}
+
+		doc := Canned{
+			Scope:     scope,
+			OwnerID:   owner,
+			Trigger:   trigger,
+			Body:      body,
+			CreatedAt: time.Now().UnixMilli(),
+		}
+		res, err := db.Collection("canned").InsertOne(ctx, doc)
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "trigger already exists"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		doc.ID = res.InsertedID.(primitive.ObjectID)
+		c.JSON(http.StatusCreated, doc)
+	}
+}
+
+// PATCH /me/canned/:id
+// PATCH /conversations/:cid/canned/:id (owner only)
+func UpdateCannedHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := mustOID(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		scope, owner, ok := cannedOwner(c, ctx, db, true)
+		if !ok {
+			return
+		}
+		trigger, body, ok := bindCanned(c)
+		if !ok {
+			return
+		}
+
+		var doc Canned
+		err = db.Collection("canned").FindOneAndUpdate(ctx,
+			bson.M{"_id": id, "scope": scope, "owner_id": owner},
+			bson.M{"$set": bson.M{"trigger": trigger, "body": body}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&doc)
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "trigger already exists"})
+			return
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		c.JSON(http.StatusOK, doc)
+	}
+}
+
+// DELETE /me/canned/:id
+// DELETE /conversations/:cid/canned/:id (owner only)
+func DeleteCannedHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := mustOID(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		scope, owner, ok := cannedOwner(c, ctx, db, true)
+		if !ok {
+			return
+		}
+		res, err := db.Collection("canned").DeleteOne(ctx, bson.M{"_id": id, "scope": scope, "owner_id": owner})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if res.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	}
+}
+
+// GET /conversations/:cid/canned/effective
+// Personal and conversation entries merged for composer autocomplete.
+// A personal trigger shadows a conversation one with the same name.
+func EffectiveCannedHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		_, cid, ok := cannedOwner(c, ctx, db, false)
+		if !ok {
+			return
+		}
+		mine, err := listCanned(ctx, db, cannedScopeUser, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		shared, err := listCanned(ctx, db, cannedScopeConv, cid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		seen := make(map[string]struct{}, len(mine))
+		out := make([]Canned, 0, len(mine)+len(shared))
+		for _, x := range mine {
+			seen[x.Trigger] = struct{}{}
+			out = append(out, x)
+		}
+		for _, x := range shared {
+			if _, dup := seen[x.Trigger]; !dup {
+				out = append(out, x)
+			}
+		}
+		sort.SliceStable(out, func(i, j int) bool { return out[i].Uses > out[j].Uses })
+		c.JSON(http.StatusOK, gin.H{"canned": out})
+	}
+}
+
+// POST /canned/:id/used
+// Client reports the entry was used; bumps its popularity counter.
+func UseCannedHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		id, err := mustOID(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		var doc Canned
+		if err := db.Collection("canned").FindOne(ctx, bson.M{"_id": id}).Decode(&doc); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+		allowed := doc.Scope == cannedScopeUser && doc.OwnerID == uid
+		if doc.Scope == cannedScopeConv {
+			if allowed, err = isMember(ctx, db, doc.OwnerID, uid); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+		}
+		if !allowed {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+
+		if _, err := db.Collection("canned").UpdateByID(ctx, id, bson.M{"$inc": bson.M{"uses": 1}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true, "uses": doc.Uses + 1})
+	}
+}
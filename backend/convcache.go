@@ -0,0 +1,249 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+Per-user cache of the GET /conversations response.
+
+Off unless CONV_LIST_CACHE_SIZE > 0 (max users kept, LRU). Entries live at
+most CONV_LIST_CACHE_TTL seconds (default 30) and never past the next
+snooze wake-up. They are dropped early by:
+  - any event published for a conversation in the entry (Broadcaster tap)
+  - member.added for the added user (the conversation isn't in their entry yet)
+  - direct invalidateConvList calls for changes that publish nothing
+    (create, mute, snooze, muted keywords)
+  - invalidateAll on a username change, since entries embed sender names
+*/
+
+type convListEntry struct {
+	uid     primitive.ObjectID
+	body    []byte
+	etag    string
+	cids    []primitive.ObjectID
+	expires time.Time
+}
+
+type convListCache struct {
+	mu     sync.Mutex
+	max    int
+	ttl    time.Duration
+	lru    *list.List // front = most recent; values are *convListEntry
+	byUser map[primitive.ObjectID]*list.Element
+	byConv map[primitive.ObjectID]map[primitive.ObjectID]struct{}
+
+	hits, misses atomic.Int64
+	// bumped on every invalidation; a put whose query started before the
+	// latest bump is dropped so a slow read can't resurrect stale data
+	epoch atomic.Uint64
+}
+
+var convCache = newConvListCache(
+	envInt("CONV_LIST_CACHE_SIZE", 0),
+	time.Duration(envInt("CONV_LIST_CACHE_TTL", 30))*time.Second,
+)
+
+func newConvListCache(max int, ttl time.Duration) *convListCache {
+	c := &convListCache{
+		max:    max,
+		ttl:    ttl,
+		lru:    list.New(),
+		byUser: make(map[primitive.ObjectID]*list.Element),
+		byConv: make(map[primitive.ObjectID]map[primitive.ObjectID]struct{}),
+	}
+	if max > 0 {
+		broadcaster.Tap(c.onEvent)
+	}
+	return c
+}
+
+func (c *convListCache) enabled() bool { return c != nil && c.max > 0 }
+
+func (c *convListCache) get(uid primitive.ObjectID) (*convListEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.byUser[uid]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	e := el.Value.(*convListEntry)
+	if time.Now().After(e.expires) {
+		c.removeLocked(el)
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	c.hits.Add(1)
+	return e, true
+}
+
+// begin returns the token to hand to put once the live query finishes.
+func (c *convListCache) begin() uint64 { return c.epoch.Load() }
+
+// put stores body for uid unless something was invalidated since begin.
+// wake, if non-zero, caps the lifetime (millis). The entry is returned
+// either way so the caller can serve it.
+func (c *convListCache) put(uid primitive.ObjectID, since uint64, cids []primitive.ObjectID, body []byte, wake int64) *convListEntry {
+	sum := sha1.Sum(body)
+	e := &convListEntry{
+		uid:     uid,
+		body:    body,
+		etag:    `W/"` + hex.EncodeToString(sum[:8]) + `"`,
+		cids:    cids,
+		expires: time.Now().Add(c.ttl),
+	}
+	if wake > 0 {
+		if w := time.UnixMilli(wake); w.Before(e.expires) {
+			e.expires = w
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.epoch.Load() != since {
+		return e
+	}
+	if el, ok := c.byUser[uid]; ok {
+		c.removeLocked(el)
+	}
+	c.byUser[uid] = c.lru.PushFront(e)
+	for _, cid := range cids {
+		if c.byConv[cid] == nil {
+			c.byConv[cid] = make(map[primitive.ObjectID]struct{})
+		}
+		c.byConv[cid][uid] = struct{}{}
+	}
+	for c.lru.Len() > c.max {
+		c.removeLocked(c.lru.Back())
+	}
+	return e
+}
+
+func (c *convListCache) removeLocked(el *list.Element) {
+	e := el.Value.(*convListEntry)
+	c.lru.Remove(el)
+	delete(c.byUser, e.uid)
+	for _, cid := range e.cids {
+		if m := c.byConv[cid]; m != nil {
+			delete(m, e.uid)
+			if len(m) == 0 {
+				delete(c.byConv, cid)
+			}
+		}
+	}
+}
+
+func (c *convListCache) invalidateUser(uid primitive.ObjectID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch.Add(1)
+	if el, ok := c.byUser[uid]; ok {
+		c.removeLocked(el)
+	}
+}
+
+// invalidateAll empties the cache, for changes that may touch any entry.
+func (c *convListCache) invalidateAll() {
+	if !c.enabled() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch.Add(1)
+	c.lru.Init()
+	c.byUser = make(map[primitive.ObjectID]*list.Element)
+	c.byConv = make(map[primitive.ObjectID]map[primitive.ObjectID]struct{})
+}
+
+func (c *convListCache) invalidateConv(cid primitive.ObjectID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch.Add(1)
+	for uid := range c.byConv[cid] {
+		if el, ok := c.byUser[uid]; ok {
+			c.removeLocked(el)
+		}
+	}
+}
+
+// onEvent runs inside Broadcaster.Publish for every conversation event.
+func (c *convListCache) onEvent(e Event) {
+	switch e.Type {
+	case events.TypeReceiptDelivered, events.TypeHello, events.TypeMaintenance, events.TypeConversationViewing:
+		// nothing in the list depends on these
+		return
+	}
+	if cid, err := primitive.ObjectIDFromHex(e.ConversationID); err == nil {
+		c.invalidateConv(cid)
+	}
+	if p, ok := e.Payload.(events.MemberAdded); ok {
+		if uid, err := primitive.ObjectIDFromHex(p.UserID); err == nil {
+			c.invalidateUser(uid)
+		}
+	}
+}
+
+// invalidateConvList drops cached lists for uids; a no-op when the cache is off.
+func invalidateConvList(uids ...primitive.ObjectID) {
+	if !convCache.enabled() {
+		return
+	}
+	for _, uid := range uids {
+		convCache.invalidateUser(uid)
+	}
+}
+
+// serve writes a cached or fresh entry, answering If-None-Match with 304.
+func (e *convListEntry) serve(c *gin.Context, hit bool) {
+	c.Header("ETag", e.etag)
+	c.Header("Cache-Control", "private, max-age=5")
+	if hit {
+		c.Header("X-Cache", "hit")
+	} else {
+		c.Header("X-Cache", "miss")
+	}
+	if c.GetHeader("If-None-Match") == e.etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", e.body)
+}
+
+func (c *convListCache) stats() gin.H {
+	if !c.enabled() {
+		return gin.H{"enabled": false}
+	}
+	hits, misses := c.hits.Load(), c.misses.Load()
+	rate := 0.0
+	if hits+misses > 0 {
+		rate = float64(hits) / float64(hits+misses)
+	}
+	c.mu.Lock()
+	n := c.lru.Len()
+	c.mu.Unlock()
+	return gin.H{"enabled": true, "entries": n, "hits": hits, "misses": misses, "hit_rate": rate}
+}
+
+// GET /admin/metrics
+func MetricsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"conv_list_cache": convCache.stats(),
+			"ws":              wsStats(),
+			"mongo":           gin.H{"pool": dbMon.pool(), "commands": dbMon.commandStats()},
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// useConvCache turns the list cache on for t. The replaced cache's
+// broadcaster tap stays registered but no longer serves anything.
+func useConvCache(t *testing.T) {
+	old := convCache
+	convCache = newConvListCache(16, time.Minute)
+	t.Cleanup(func() { convCache = old })
+}
+
+func TestConvCacheEvents(t *testing.T) {
+	c := newConvListCache(16, time.Minute)
+	cid, other := primitive.NewObjectID(), primitive.NewObjectID()
+	ann, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	cached := func() bool {
+		_, ok := c.get(ann)
+		return ok
+	}
+
+	for _, tt := range []struct {
+		event Event
+		drops bool
+	}{
+		{events.New(cid.Hex(), events.MessageCreated{ID: "m1"}), true},
+		{events.New(cid.Hex(), events.MessageDeleted{ID: "m1"}), true},
+		{events.New(cid.Hex(), events.ConversationUpdated{Title: new(string)}), true},
+		{events.New(cid.Hex(), events.MemberRemoved{UserID: bob.Hex()}), true},
+		{events.New(cid.Hex(), events.ReceiptUpdated{UserID: bob.Hex()}), true},
+		{events.New(cid.Hex(), events.ReceiptDelivered{UserID: bob.Hex()}), false},
+		{events.New(cid.Hex(), events.ConversationViewing{}), false},
+		{events.New(other.Hex(), events.MessageCreated{ID: "m2"}), false},
+		// ann isn't in other yet: the entry is dropped by uid
+		{events.New(other.Hex(), events.MemberAdded{UserID: ann.Hex()}), true},
+	} {
+		c.put(ann, c.begin(), []primitive.ObjectID{cid}, []byte(`[]`), 0)
+		c.onEvent(tt.event)
+		if cached() == tt.drops {
+			t.Errorf("%s on %s: dropped = %v, want %v", tt.event.Type, tt.event.ConversationID, !cached(), tt.drops)
+		}
+	}
+}
+
+func TestConvCachePutAfterInvalidate(t *testing.T) {
+	c := newConvListCache(16, time.Minute)
+	cid, ann := primitive.NewObjectID(), primitive.NewObjectID()
+	epoch := c.begin()
+	// a change lands while the list query is running
+	c.invalidateConv(cid)
+	c.put(ann, epoch, []primitive.ObjectID{cid}, []byte(`[]`), 0)
+	if _, ok := c.get(ann); ok {
+		t.Fatal("stale list cached")
+	}
+}
+
+func TestConvCacheInvalidation(t *testing.T) {
+	client, db := testDB(t)
+	useConvCache(t)
+	ann := seedUser(t, db, "ann")
+	bob := seedUser(t, db, "bob")
+	cat := seedUser(t, db, "cat")
+	conv := seedConv(t, db, "ops", ann, bob)
+	cid := conv.ID.Hex()
+
+	r, api := testAPI()
+	api.GET("/conversations", ListConverHandler(client))
+	api.POST("/conversations/:cid/members", AddMembersHandler(client))
+	api.PATCH("/conversations/:cid", RenameConverHandler(client))
+	api.POST("/messages/:cid", SendMessageHandler(client))
+
+	list := func(u User, want string) []convListItem {
+		t.Helper()
+		w := serve(t, r, http.MethodGet, "/conversations", &u, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("list: %d %s", w.Code, w.Body)
+		}
+		if got := w.Header().Get("X-Cache"); got != want {
+			t.Fatalf("%s's list: X-Cache %q, want %q", u.Username, got, want)
+		}
+		var out []convListItem
+		decode(t, w, &out)
+		return out
+	}
+	do := func(method, path string, body any) {
+		t.Helper()
+		if w := serve(t, r, method, path, &ann, body); w.Code >= 300 {
+			t.Fatalf("%s %s: %d %s", method, path, w.Code, w.Body)
+		}
+	}
+
+	list(ann, "miss")
+	list(ann, "hit")
+	list(cat, "miss")
+	if got := list(cat, "hit"); len(got) != 0 {
+		t.Fatalf("cat sees %d conversations before joining", len(got))
+	}
+
+	do(http.MethodPost, "/conversations/"+cid+"/members", gin.H{"members": []string{"cat"}})
+	if got := list(ann, "miss"); len(got) != 1 || got[0].Count != 3 {
+		t.Fatalf("after member add: %+v", got)
+	}
+	if got := list(cat, "miss"); len(got) != 1 || got[0].ID != conv.ID {
+		t.Fatalf("added member's list: %+v", got)
+	}
+	list(ann, "hit")
+
+	do(http.MethodPatch, "/conversations/"+cid, gin.H{"title": "ops-2"})
+	if got := list(ann, "miss"); got[0].Title != "ops-2" {
+		t.Fatalf("after rename: title %q", got[0].Title)
+	}
+	list(ann, "hit")
+
+	do(http.MethodPost, "/messages/"+cid, gin.H{"body": "hello"})
+	if got := list(ann, "miss"); got[0].LastMsg == nil || got[0].LastMsg.Body != "hello" {
+		t.Fatalf("after message: last_msg %+v", got[0].LastMsg)
+	}
+	list(ann, "hit")
+	list(bob, "miss")
+}
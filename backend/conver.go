@@ -1,11 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"backend/events"
+
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,26 +27,87 @@ type Member struct {
 	Role   string             `bson:"role" json:"role"`
 }
 
+type Pin struct {
+	MessageID primitive.ObjectID `bson:"message_id" json:"message_id"`
+	PinnedBy  primitive.ObjectID `bson:"pinned_by" json:"pinned_by"`
+	PinnedAt  int64              `bson:"pinned_at" json:"pinned_at"`
+}
+
 type Conversation struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Title     string             `bson:"title" json:"title"`
 	Members   []Member           `bson:"members" json:"members"`
+	Pins      []Pin              `bson:"pins,omitempty" json:"pins,omitempty"`
 	CreatedAt int64              `bson:"created_at" json:"created_at"`
+	// optional group profile (see policy.go for validation)
+	Description string `bson:"description,omitempty" json:"description,omitempty"`
+	AvatarURL   string `bson:"avatar_url,omitempty" json:"avatar_url,omitempty"`
+	// last change to membership, title/profile or settings (millis); 0 on
+	// documents older than this field
+	UpdatedAt int64 `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	// unread baseline for members added later: "start_read" (default) or "full_history"
+	JoinUnread string `bson:"join_unread,omitempty" json:"join_unread,omitempty"`
+	// members live in the memberships collection (see membership.go)
+	MembersExternal bool `bson:"members_external,omitempty" json:"-"`
+	// nil means enabled; use ReceiptsOn
+	ReceiptsEnabled *bool `bson:"receipts_enabled,omitempty" json:"-"`
+	// min seconds between a member's messages; 0 = off (see slowmode.go)
+	SlowModeSecs int `bson:"slow_mode_secs,omitempty" json:"slow_mode_secs,omitempty"`
+	// override of the per-conversation send budget (sendrate.go)
+	SendRate *sendRate `bson:"send_rate,omitempty" json:"-"`
+	// opted in to the weekly activity card (weeklysummary.go)
+	WeeklySummary bool `bson:"weekly_summary,omitempty" json:"weekly_summary,omitempty"`
+	// data residency tag, fixed at creation (residency.go); "" = untagged
+	Residency string `bson:"residency,omitempty" json:"residency,omitempty"`
+}
+
+// ReceiptsOn reports whether members' read/delivered positions are shared.
+func (c *Conversation) ReceiptsOn() bool {
+	return c.ReceiptsEnabled == nil || *c.ReceiptsEnabled
 }
 
 // === Ensure Indexed ===
 func ensureConverIndexes(ctx context.Context, db *mongo.Database) error {
-	_, err := db.Collection("conversations").Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "members.user_id", Value: 1}},
+	_, err := db.Collection("conversations").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "members.user_id", Value: 1}}},
+		// the conversation list, newest first (see convListQuery)
+		{Keys: bson.D{{Key: "members.user_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}},
 	})
 	return err
 }
 
+// join_unread values
+const (
+	JoinUnreadStartRead   = "start_read"
+	JoinUnreadFullHistory = "full_history"
+)
+
+// per-user limit on newly created conversations (DM reuse doesn't count)
+var convCreateLimiter = newWindowLimiter(envInt("CONV_CREATE_PER_HOUR", 20), time.Hour)
+
 // === Helpers ===
 func mustObjectID(hex string) (primitive.ObjectID, error) {
 	return primitive.ObjectIDFromHex(hex)
 }
 
+// minMembers is the smallest conversation size, creator included:
+// MIN_MEMBERS, default and floor 2.
+func minMembers() int {
+	return max(envInt("MIN_MEMBERS", 2), 2)
+}
+
+// memberCountError explains a too-small member list in terms of the others
+// the creator named (the creator is always added), or nil if it's enough.
+func memberCountError(others int) gin.H {
+	if others == 0 {
+		return gin.H{"error": "you only specified yourself; add at least one other member", "code": "only_self"}
+	}
+	if need := minMembers() - 1; others < need {
+		return gin.H{"error": fmt.Sprintf("at least %d other members required", need), "code": "too_few_members"}
+	}
+	return nil
+}
+
 func uniqLower(in []string) []string {
 	seen := map[string]struct{}{}
 	out := make([]string, 0, len(in))
@@ -59,6 +129,7 @@ func resolveUsernames(ctx context.Context, db *mongo.Database, usernames []strin
 	if len(usernames) == 0 {
 		return nil, errors.New("member list can't be empty")
 	}
+	// callers pass normalized names (uniqLower); lookups never use display casing
 	cur, err := db.Collection("users").Find(ctx, bson.M{"username": bson.M{"$in": usernames}})
 	if err != nil {
 		return nil, err
@@ -104,17 +175,23 @@ func findExistingDM(ctx context.Context, db *mongo.Database, a, b primitive.Obje
 	return &conv, err
 }
 
+// getLastMessage returns cid's newest unexpired message, as a tombstone if
+// it was deleted.
 func getLastMessage(ctx context.Context, db *mongo.Database, cid primitive.ObjectID) (*Message, error) {
 	var m Message
 	err := db.Collection("messages").FindOne(
 		ctx,
-		bson.M{"conversation_id": cid},
+		unexpired(bson.M{"conversation_id": cid}),
 		options.FindOne().SetSort(bson.D{{Key: "ts", Value: -1}}),
 	).Decode(&m)
 	if errors.Is(err, mongo.ErrNoDocuments) {
 		return nil, nil
 	}
-	return &m, err
+	if err != nil {
+		return nil, err
+	}
+	m.tombstone()
+	return &m, nil
 }
 
 // === Handlers ===
@@ -129,25 +206,44 @@ func CreateConverHandler(client *mongo.Client) gin.HandlerFunc {
 		}
 
 		var in struct {
-			Title   string   `json:"title"`
-			Members []string `json:"members"`
+			Title       string   `json:"title"`
+			Members     []string `json:"members"`
+			JoinUnread  string   `json:"join_unread"`
+			Description string   `json:"description"`
+			AvatarURL   string   `json:"avatar_url"`
 		}
 		if err := c.ShouldBindJSON(&in); err != nil {
 			c.JSON(400, gin.H{"error": "bad json"})
 			return
 		}
-		if in.Title == "" {
-			in.Title = "New Conversation"
+		title, err := normalizeTitle(in.Title)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error(), "code": titleErrCode(err)})
+			return
+		}
+		in.Title = title
+		if in.Description, err = cleanDescription(in.Description); err != nil {
+			c.JSON(400, gin.H{"error": err.Error(), "code": titleErrCode(err)})
+			return
+		}
+		if in.AvatarURL, err = cleanAvatarURL(in.AvatarURL); err != nil {
+			c.JSON(400, gin.H{"error": err.Error(), "code": titleErrCode(err)})
+			return
+		}
+		switch in.JoinUnread {
+		case "", JoinUnreadStartRead, JoinUnreadFullHistory:
+		default:
+			c.JSON(400, gin.H{"error": "join_unread must be start_read or full_history"})
+			return
 		}
 
-		// ensure creator is included
-		creatorUname := c.GetString("uname")
-		in.Members = append(in.Members, creatorUname)
-
-		// normalize & req >= 2
-		membersU := uniqLower(in.Members)
-		if len(membersU) < 2 {
-			c.JSON(400, gin.H{"error": "at least 2 unique members required"})
+		// the creator is always a member and joins by uid: their token's
+		// username may be stale (renamed since, or even claimed by someone
+		// else). Listing themselves doesn't count toward minMembers.
+		creatorUname := normalizeUsername(c.GetString("uname"))
+		others := slices.DeleteFunc(uniqLower(in.Members), func(u string) bool { return u == creatorUname })
+		if e := memberCountError(len(others)); e != nil {
+			c.JSON(400, e)
 			return
 		}
 
@@ -156,11 +252,19 @@ func CreateConverHandler(client *mongo.Client) gin.HandlerFunc {
 		db := getDB(client)
 		_ = ensureConverIndexes(ctx, db)
 
-		memberIDs, err := resolveUsernames(ctx, db, membersU)
+		memberIDs, err := resolveUsernames(ctx, db, others)
 		if err != nil {
 			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
+		// a stale token can name the creator under a username that now
+		// resolves to them, so count again by uid
+		memberIDs = slices.DeleteFunc(memberIDs, func(id primitive.ObjectID) bool { return id == uid })
+		if e := memberCountError(len(memberIDs)); e != nil {
+			c.JSON(400, e)
+			return
+		}
+		memberIDs = append(memberIDs, uid)
 
 		// DM Reuse
 		if len(memberIDs) == 2 {
@@ -175,6 +279,12 @@ func CreateConverHandler(client *mongo.Client) gin.HandlerFunc {
 			}
 		}
 
+		if !convCreateLimiter.Allow(uid.Hex()) {
+			writeSvcError(c, rateLimited("too many conversations created, try again later",
+				convCreateLimiter.status(uid.Hex())))
+			return
+		}
+
 		// build members with owner role
 		members := make([]Member, 0, len(memberIDs))
 		for _, id := range memberIDs {
@@ -184,46 +294,78 @@ func CreateConverHandler(client *mongo.Client) gin.HandlerFunc {
 			}
 			members = append(members, Member{UserID: id, Role: role})
 		}
+		if !slices.ContainsFunc(members, func(m Member) bool { return m.Role == "owner" }) {
+			fmt.Println("create conversation: no owner among members, creator", uid.Hex())
+			c.JSON(500, gin.H{"error": "could not assign an owner"})
+			return
+		}
 
+		now := time.Now().UnixMilli()
 		conv := Conversation{
 			Title:     in.Title,
 			Members:   members,
-			CreatedAt: time.Now().UnixMilli(),
+			CreatedAt: now,
+			UpdatedAt: now,
+			// empty means start_read
+			JoinUnread:  in.JoinUnread,
+			Description: in.Description,
+			AvatarURL:   in.AvatarURL,
+			Residency:   deploymentResidency(),
 		}
-
-		res, err := db.Collection("conversations").InsertOne(ctx, conv)
-		if err != nil {
-			fmt.Println("insert conversation error:", err)
+		if err := createConversation(ctx, db, &conv); err != nil {
 			c.JSON(500, gin.H{"error": "db error"})
 			return
 		}
-		c.JSON(201, gin.H{
-			"id":      res.InsertedID.(primitive.ObjectID).Hex(),
+		out := gin.H{
+			"id":      conv.ID.Hex(),
 			"title":   conv.Title,
 			"members": conv.Members,
-		})
+		}
+		if conv.Description != "" {
+			out["description"] = conv.Description
+		}
+		if conv.AvatarURL != "" {
+			out["avatar_url"] = conv.AvatarURL
+		}
+		c.JSON(201, out)
 	}
 }
 
-// GET /conversations
+// createConversation inserts conv (ID is filled in), picking the embedded
+// or memberships layout by size, and drops the members' cached lists.
+func createConversation(ctx context.Context, db *mongo.Database, conv *Conversation) error {
+	members := conv.Members
+	// too big to embed: start directly in the memberships layout
+	external := len(members) > memberInlineLimit()
+	if external {
+		conv.Members = []Member{}
+		conv.MembersExternal = true
+	}
 
-func ListConverHandler(client *mongo.Client) gin.HandlerFunc {
-	type lastMsg struct {
-		ID       primitive.ObjectID `json:"id"`
-		SenderID primitive.ObjectID `json:"sender_id"`
-		Type     string             `json:"type"`
-		Body     string             `json:"body"`
-		Ts       int64              `json:"ts"`
-	}
-	type item struct {
-		ID        primitive.ObjectID `bson:"_id" json:"id"`
-		Title     string             `bson:"title" json:"title"`
-		Members   []Member           `bson:"members" json:"members"`
-		CreatedAt int64              `bson:"created_at" json:"created_at"`
-		Unread    int64              `json:"unread"`
-		LastMsg   *lastMsg           `json:"last_msg,omitempty"`
+	res, err := db.Collection("conversations").InsertOne(ctx, conv)
+	if err != nil {
+		fmt.Println("insert conversation error:", err)
+		return err
+	}
+	conv.ID = res.InsertedID.(primitive.ObjectID)
+	if external {
+		if err := insertMemberships(ctx, db, conv.ID, members); err != nil {
+			fmt.Println("insert memberships error:", err)
+			return err
+		}
+		conv.Members = members
+	}
+	uids := make([]primitive.ObjectID, 0, len(members))
+	for _, m := range members {
+		uids = append(uids, m.UserID)
 	}
+	invalidateConvList(uids...)
+	return nil
+}
+
+// GET /conversations
 
+func ListConverHandler(client *mongo.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		uidHex, _ := c.Get("uid")
 		uid, err := primitive.ObjectIDFromHex(uidHex.(string))
@@ -232,90 +374,1029 @@ func ListConverHandler(client *mongo.Client) gin.HandlerFunc {
 			return
 		}
 
+		// ?limit=&cursor= pages the list and wraps it as
+		// { conversations, next_cursor }; without limit the bare array is
+		// returned as before. ?filter=unread|muted|unmuted|pinned narrows it.
+		page := convPage{Filter: c.Query("filter")}
+		if !validConvFilter(page.Filter) {
+			c.JSON(400, gin.H{"error": "filter must be unread, muted, unmuted or pinned"})
+			return
+		}
+		if s := c.Query("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				c.JSON(400, gin.H{"error": "invalid limit"})
+				return
+			}
+			page.Limit = n
+			page.Cursor = c.Query("cursor")
+		}
+		if page.Limit > 0 {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+			defer cancel()
+			convs, next, _, err := listConversations(ctx, getDB(client), uid, page)
+			if err != nil {
+				writeSvcError(c, err)
+				return
+			}
+			c.JSON(200, gin.H{"conversations": convs, "next_cursor": next})
+			return
+		}
+
+		var epoch uint64
+		// only the full, unfiltered list is cached
+		cacheable := convCache.enabled() && page.Filter == ""
+		if cacheable {
+			if e, ok := convCache.get(uid); ok {
+				e.serve(c, true)
+				return
+			}
+			epoch = convCache.begin()
+		}
+
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 		defer cancel()
 		db := getDB(client)
 
-		// 1. fetch all conver the usr is in
-		cur, err := db.Collection("conversations").Find(
-			ctx,
-			bson.M{"members.user_id": uid},
-			options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
-		)
+		convs, _, wake, err := listConversations(ctx, db, uid, page)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "db error"})
+			writeSvcError(c, err)
 			return
 		}
-		defer cur.Close(ctx)
 
-		convs := make([]item, 0, 16)
-		ids := make([]primitive.ObjectID, 0, 16)
-		for cur.Next(ctx) {
-			var x item
-			if err := cur.Decode(&x); err != nil {
-				c.JSON(500, gin.H{"error": "decode error"})
-				return
-			}
-			convs = append(convs, x)
+		// a degraded list is served but never cached
+		if !cacheable || (len(convs) > 0 && convs[0].RcptDegraded) {
+			c.JSON(200, convs)
+			return
+		}
+		body, err := json.Marshal(convs)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "encode error"})
+			return
+		}
+		ids := make([]primitive.ObjectID, 0, len(convs))
+		for _, x := range convs {
 			ids = append(ids, x.ID)
 		}
+		convCache.put(uid, epoch, ids, body, wake).serve(c, false)
+	}
+}
 
-		if len(convs) == 0 {
-			c.JSON(200, []item{})
-			return
+type convListLastMsg struct {
+	ID       primitive.ObjectID `json:"id"`
+	SenderID primitive.ObjectID `json:"sender_id"`
+	// joined at read time, never stored (see users.go)
+	SenderName string `json:"sender_username,omitempty"`
+	Type       string `json:"type"`
+	Body       string `json:"body"`
+	Ts         int64  `json:"ts"`
+}
+
+type convListItem struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Title     string             `bson:"title" json:"title"`
+	Members   []Member           `bson:"members" json:"members"`
+	CreatedAt int64              `bson:"created_at" json:"created_at"`
+	External  bool               `bson:"members_external" json:"-"`
+	RcptFlag  *bool              `bson:"receipts_enabled" json:"-"`
+	Pins      []Pin              `bson:"pins" json:"-"`
+	Muted     bool               `bson:"-" json:"muted"`
+	Receipts  bool               `bson:"-" json:"receipts_enabled"`
+	Count     int64              `bson:"-" json:"member_count"`
+	IsDM      bool               `bson:"-" json:"is_dm"`
+	Unread    int64              `json:"unread"`
+	// of Unread, messages sent with priority "high"
+	UnreadHigh int64 `bson:"-" json:"unread_high,omitempty"`
+	// of Unread, messages that @mention the caller
+	MentionCount int64 `bson:"-" json:"mention_count"`
+	// receipts couldn't be read: Unread counts from the start (see fillUnread)
+	RcptDegraded bool             `bson:"-" json:"receipts_degraded,omitempty"`
+	LastMsg      *convListLastMsg `json:"last_msg,omitempty"`
+
+	key int64 // list position: created_at or bumped wake time
+}
+
+// convPage selects one page (and optional subset) of the conversation list;
+// Limit 0 means all.
+type convPage struct {
+	Limit  int // max 100
+	Cursor string
+	Filter string               // one of the convFilter* values, "" = everything
+	IDs    []primitive.ObjectID // only these conversations; nil = all
+}
+
+// ?filter= values for the conversation list
+const (
+	convFilterUnread  = "unread"
+	convFilterMuted   = "muted"
+	convFilterUnmuted = "unmuted"
+	convFilterPinned  = "pinned" // has at least one pinned message
+)
+
+func validConvFilter(f string) bool {
+	switch f {
+	case "", convFilterUnread, convFilterMuted, convFilterUnmuted, convFilterPinned:
+		return true
+	}
+	return false
+}
+
+const maxConvPage = 100
+
+// conversation list cursor: "<sort key>.<id hex>" of the last item served,
+// base64url encoded. Opaque to clients.
+func encodeConvCursor(key int64, id primitive.ObjectID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(key, 10) + "." + id.Hex()))
+}
+
+func decodeConvCursor(s string) (int64, primitive.ObjectID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, primitive.NilObjectID, err
+	}
+	k, h, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return 0, primitive.NilObjectID, errors.New("bad cursor")
+	}
+	key, err := strconv.ParseInt(k, 10, 64)
+	if err != nil {
+		return 0, primitive.NilObjectID, err
+	}
+	id, err := primitive.ObjectIDFromHex(h)
+	return key, id, err
+}
+
+// listConversations builds uid's conversation list with unread counts and
+// last messages. Shared by the HTTP and gRPC list paths. Ordered by
+// created_at (or snooze wake time, see below) desc, then id desc; only the
+// requested page is enriched. next is the cursor for the following page
+// ("" on the last one). wake is the next snooze wake-up (millis, 0 if
+// none) or next message expiry, after which the list changes by itself.
+func listConversations(ctx context.Context, db *mongo.Database, uid primitive.ObjectID, page convPage) (convs []convListItem, next string, wake int64, err error) {
+	if page.Limit > maxConvPage {
+		page.Limit = maxConvPage
+	}
+	q := convListQuery{db: db}
+	if page.Cursor != "" {
+		q.after = true
+		if q.afterKey, q.afterID, err = decodeConvCursor(page.Cursor); err != nil {
+			return nil, "", 0, svcFail(400, "invalid cursor")
 		}
+	}
 
-		// 2, load receipt for this user across all those conv -> map[cid]last_read_ts
-		recCur, err := db.Collection("receipts").Find(ctx, bson.M{
-			"user_id":         uid,
-			"conversation_id": bson.M{"$in": ids},
-		})
-		if err != nil {
-			c.JSON(500, gin.H{"error": "db error"})
-			return
+	// snoozed conversations stay hidden until they wake up; woken ones
+	// with bump sort as if they were created at wake time
+	prefs, err := userPrefs(ctx, db, uid)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	now := time.Now().UnixMilli()
+	hidden, muted := []primitive.ObjectID{}, []primitive.ObjectID{}
+	for id, p := range prefs {
+		switch {
+		case p.Snoozed(now):
+			hidden = append(hidden, id)
+			if wake == 0 || p.SnoozeUntil < wake {
+				wake = p.SnoozeUntil
+			}
+		case p.SnoozeBump && p.SnoozeUntil > 0:
+			if q.bumped == nil {
+				q.bumped = make(map[primitive.ObjectID]int64)
+			}
+			q.bumped[id] = p.SnoozeUntil
 		}
-		type recDoc struct {
-			CID        primitive.ObjectID `bson:"conversation_id"`
-			LastReadTS int64              `bson:"last_read_ts"`
+		if p.Muted {
+			muted = append(muted, id)
 		}
-		lastRead := make(map[primitive.ObjectID]int64, len(ids))
-		for recCur.Next(ctx) {
-			var r recDoc
-			if err := recCur.Decode(&r); err != nil {
-				c.JSON(500, gin.H{"error": "decode error"})
-				return
+	}
+
+	filter, err := memberConvFilter(ctx, db, uid)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	and := bson.A{filter}
+	if page.IDs != nil {
+		and = append(and, bson.M{"_id": bson.M{"$in": page.IDs}})
+	}
+	if len(hidden) > 0 {
+		and = append(and, bson.M{"_id": bson.M{"$nin": hidden}})
+	}
+	switch page.Filter {
+	case convFilterMuted:
+		and = append(and, bson.M{"_id": bson.M{"$in": muted}})
+	case convFilterUnmuted:
+		and = append(and, bson.M{"_id": bson.M{"$nin": muted}})
+	case convFilterPinned:
+		and = append(and, bson.M{"pins.0": bson.M{"$exists": true}})
+	}
+	q.filter = bson.M{"$and": and}
+
+	// one more than the page tells whether there is a next one
+	want := 0
+	if page.Limit > 0 {
+		want = page.Limit + 1
+	}
+	unreadDone := page.Filter == convFilterUnread
+	if unreadDone {
+		// unread isn't stored on the conversation: count a batch at a time
+		// until the page is full
+		convs = make([]convListItem, 0, want)
+		for {
+			batch, err := q.next(ctx, want)
+			if err != nil {
+				return nil, "", 0, err
+			}
+			if err := fillUnread(ctx, db, uid, batch); err != nil {
+				return nil, "", 0, err
+			}
+			for _, x := range batch {
+				if x.Unread > 0 {
+					convs = append(convs, x)
+				}
+			}
+			if want == 0 || len(batch) < want || len(convs) >= want {
+				break
 			}
-			lastRead[r.CID] = r.LastReadTS
 		}
-		recCur.Close(ctx)
+	} else if convs, err = q.next(ctx, want); err != nil {
+		return nil, "", 0, err
+	}
 
-		// 3. for each conver, compute unread + fetch last msg
-		for i := range convs {
-			cid := convs[i].ID
-			// unread
-			since := lastRead[cid] // default 0
-			n, err := db.Collection("messages").CountDocuments(ctx, bson.M{
-				"conversation_id": cid,
-				"ts":              bson.M{"$gt": since},
-			})
+	if page.Limit > 0 && len(convs) > page.Limit {
+		convs = convs[:page.Limit]
+		last := convs[len(convs)-1]
+		next = encodeConvCursor(last.key, last.ID)
+	}
+	if len(convs) == 0 {
+		return convs, next, wake, nil
+	}
+
+	for i := range convs {
+		x := &convs[i]
+		if x.External {
+			x.Members, err = listMembers(ctx, db, &Conversation{ID: x.ID, MembersExternal: true})
 			if err != nil {
-				c.JSON(500, gin.H{"error": "db error"})
-				return
+				return nil, "", 0, err
+			}
+		}
+		x.Muted = prefs[x.ID].Muted
+		x.Count = int64(len(x.Members))
+		x.IsDM = isDM(x.Count)
+		x.Receipts = x.RcptFlag == nil || *x.RcptFlag
+	}
+	if !unreadDone {
+		if err := fillUnread(ctx, db, uid, convs); err != nil {
+			return nil, "", 0, err
+		}
+	}
+
+	ids := make([]primitive.ObjectID, len(convs))
+	for i := range convs {
+		ids[i] = convs[i].ID
+	}
+	// an expiring message drops out of unread/last_msg silently
+	exp, err := nextExpiry(ctx, db, ids)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	if exp > 0 && (wake == 0 || exp < wake) {
+		wake = exp
+	}
+
+	// last msg per conversation
+	senders := make([]primitive.ObjectID, 0, len(convs))
+	for i := range convs {
+		if m, err := getLastMessage(ctx, db, convs[i].ID); err == nil && m != nil {
+			convs[i].LastMsg = &convListLastMsg{
+				ID:       m.ID,
+				SenderID: m.SenderID,
+				Type:     m.Type,
+				Body:     m.Body,
+				Ts:       m.Ts,
+			}
+			senders = append(senders, m.SenderID)
+		}
+	}
+	names, err := usernamesByID(ctx, db, senders)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	for i := range convs {
+		if lm := convs[i].LastMsg; lm != nil {
+			lm.SenderName = names[lm.SenderID]
+		}
+	}
+	return convs, next, wake, nil
+}
+
+// convListQuery walks the conversation list in order from a cursor. Most
+// conversations sort by created_at, which the index serves; the caller's
+// woken snooze_bump ones (few, from their prefs) are fetched by id and
+// merged in by wake time.
+type convListQuery struct {
+	db       *mongo.Database
+	filter   bson.M                       // membership, snooze and ?filter=
+	bumped   map[primitive.ObjectID]int64 // conversation -> wake time
+	after    bool                         // a cursor is set
+	afterKey int64
+	afterID  primitive.ObjectID
+}
+
+// next returns up to n items (all if n is 0) after the cursor, each with
+// its sort key, and moves the cursor past them.
+func (q *convListQuery) next(ctx context.Context, n int) ([]convListItem, error) {
+	col := q.db.Collection("conversations")
+	bumped := make([]primitive.ObjectID, 0, len(q.bumped))
+	for id := range q.bumped {
+		bumped = append(bumped, id)
+	}
+
+	and := bson.A{q.filter}
+	if len(bumped) > 0 {
+		and = append(and, bson.M{"_id": bson.M{"$nin": bumped}})
+	}
+	if q.after {
+		and = append(and, bson.M{"$or": bson.A{
+			bson.M{"created_at": bson.M{"$lt": q.afterKey}},
+			bson.M{"created_at": q.afterKey, "_id": bson.M{"$lt": q.afterID}},
+		}})
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	if n > 0 {
+		opts.SetLimit(int64(n))
+	}
+	cur, err := col.Find(ctx, bson.M{"$and": and}, opts)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]convListItem, 0, n)
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, svcFail(500, "decode error")
+	}
+	for i := range out {
+		out[i].key = out[i].CreatedAt
+	}
+
+	if len(bumped) > 0 {
+		cur, err := col.Find(ctx, bson.M{"$and": bson.A{q.filter, bson.M{"_id": bson.M{"$in": bumped}}}})
+		if err != nil {
+			return nil, err
+		}
+		var woken []convListItem
+		if err := cur.All(ctx, &woken); err != nil {
+			return nil, svcFail(500, "decode error")
+		}
+		for _, x := range woken {
+			x.key = max(x.CreatedAt, q.bumped[x.ID])
+			if !q.after || x.before(q.afterKey, q.afterID) {
+				out = append(out, x)
 			}
-			convs[i].Unread = n
-
-			// last msg
-			if m, err := getLastMessage(ctx, db, cid); err == nil && m != nil {
-				convs[i].LastMsg = &lastMsg{
-					ID:       m.ID,
-					SenderID: m.SenderID,
-					Type:     m.Type,
-					Body:     m.Body,
-					Ts:       m.Ts,
+		}
+		sort.Slice(out, func(i, j int) bool { return out[j].before(out[i].key, out[i].ID) })
+		if n > 0 && len(out) > n {
+			out = out[:n]
+		}
+	}
+
+	if len(out) > 0 {
+		last := out[len(out)-1]
+		q.after, q.afterKey, q.afterID = true, last.key, last.ID
+	}
+	return out, nil
+}
+
+// before reports whether x comes after (key, id) in the list, which runs
+// from the newest key down.
+func (x convListItem) before(key int64, id primitive.ObjectID) bool {
+	return x.key < key || (x.key == key && bytes.Compare(x.ID[:], id[:]) < 0)
+}
+
+// fillUnread sets Unread on each item: uid's visible messages newer than
+// their read position, minus muted keywords. UnreadHigh counts the
+// high-priority ones among them, MentionCount the ones @mentioning uid.
+// Three queries however many items there are.
+func fillUnread(ctx context.Context, db *mongo.Database, uid primitive.ObjectID, convs []convListItem) error {
+	if len(convs) == 0 {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, len(convs))
+	for i := range convs {
+		ids[i] = convs[i].ID
+	}
+
+	// load receipt for this user across all those conv -> map[cid]last_read_ts.
+	// A failing receipts read doesn't sink the list: positions fall back
+	// to 0 and the items are flagged receipts_degraded.
+	lastRead, err := lastReadPositions(ctx, db, uid, ids)
+	if err != nil {
+		fmt.Println("unread: receipts unavailable, counting from 0:", err)
+		for i := range convs {
+			convs[i].RcptDegraded = true
+		}
+	}
+
+	kw, err := loadKeywordMatcher(ctx, db, uid)
+	if err != nil {
+		return err
+	}
+
+	unread := make(bson.A, len(convs))
+	for i, cid := range ids {
+		unread[i] = bson.M{"conversation_id": cid, "ts": bson.M{"$gt": lastRead[cid]}} // default 0
+	}
+	count := func(extra bson.M) (map[primitive.ObjectID]int64, error) {
+		filter := kw.applyUnread(visible(bson.M{"$or": unread}))
+		for k, v := range extra {
+			filter[k] = v
+		}
+		return countByConversation(ctx, db, filter)
+	}
+
+	total, err := count(nil)
+	if err != nil {
+		return err
+	}
+	if len(total) == 0 {
+		return nil
+	}
+	high, err := count(bson.M{"priority": priorityHigh})
+	if err != nil {
+		return err
+	}
+	mentioned, err := count(bson.M{"mentions": uid})
+	if err != nil {
+		return err
+	}
+	for i := range convs {
+		cid := convs[i].ID
+		convs[i].Unread, convs[i].UnreadHigh, convs[i].MentionCount = total[cid], high[cid], mentioned[cid]
+	}
+	return nil
+}
+
+// countByConversation counts the messages matching filter per conversation.
+func countByConversation(ctx context.Context, db *mongo.Database, filter bson.M) (map[primitive.ObjectID]int64, error) {
+	cur, err := db.Collection("messages").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{"_id": "$conversation_id", "n": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		CID primitive.ObjectID `bson:"_id"`
+		N   int64              `bson:"n"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	out := make(map[primitive.ObjectID]int64, len(rows))
+	for _, r := range rows {
+		out[r.CID] = r.N
+	}
+	return out, nil
+}
+
+// lastReadPositions maps each of ids to uid's last_read_ts (absent = 0).
+func lastReadPositions(ctx context.Context, db *mongo.Database, uid primitive.ObjectID, ids []primitive.ObjectID) (map[primitive.ObjectID]int64, error) {
+	lastRead := make(map[primitive.ObjectID]int64, len(ids))
+	cur, err := db.Collection("receipts").Find(ctx, bson.M{
+		"user_id":         uid,
+		"conversation_id": bson.M{"$in": ids},
+	})
+	if err != nil {
+		return lastRead, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var r struct {
+			CID        primitive.ObjectID `bson:"conversation_id"`
+			LastReadTS int64              `bson:"last_read_ts"`
+		}
+		if err := cur.Decode(&r); err != nil {
+			return make(map[primitive.ObjectID]int64), err
+		}
+		lastRead[r.CID] = r.LastReadTS
+	}
+	if err := cur.Err(); err != nil {
+		return make(map[primitive.ObjectID]int64), err
+	}
+	return lastRead, nil
+}
+
+// GET /conversations/:cid/members
+func ListMembersHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustObjectID(uidHex.(string))
+		if err != nil {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustObjectID(c.Param("cid"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		ok, err := canRead(ctx, db, cid, uid, "members.list")
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		if !ok {
+			c.JSON(403, gin.H{"error": "not a member"})
+			return
+		}
+
+		var conv Conversation
+		if err := db.Collection("conversations").FindOne(ctx, bson.M{"_id": cid}).Decode(&conv); err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		members, err := listMembers(ctx, db, &conv)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		c.JSON(200, gin.H{"members": members})
+	}
+}
+
+// GET /conversations/:cid/membership
+// Returns: { member: true, role: "owner" } or { member: false }
+// Cheap permission check for the caller; "not a member" (including an
+// unknown conversation) is a 200, not a 403.
+func MembershipHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustObjectID(uidHex.(string))
+		if err != nil {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustObjectID(c.Param("cid"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		if role == "" {
+			c.JSON(200, gin.H{"member": false})
+			return
+		}
+		c.JSON(200, gin.H{"member": true, "role": role})
+	}
+}
+
+// POST /conversations/:cid/members (owner only)
+// Body: { "members": ["alice", "bob"] }
+func AddMembersHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustObjectID(uidHex.(string))
+		if err != nil {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustObjectID(c.Param("cid"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		var in struct {
+			Members []string `json:"members"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(400, gin.H{"error": "bad json"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		if role != "owner" {
+			c.JSON(403, gin.H{"error": "owner only"})
+			return
+		}
+
+		ids, err := resolveUsernames(ctx, db, uniqLower(in.Members))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		members := make([]Member, 0, len(ids))
+		for _, id := range ids {
+			members = append(members, Member{UserID: id, Role: "member"})
+		}
+
+		added, err := addMembers(ctx, db, cid, members)
+		if err != nil {
+			fmt.Println("add members error:", err)
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+
+		// new members start with nothing unread unless the conversation opts into full history
+		var conv Conversation
+		if err := db.Collection("conversations").FindOne(ctx, bson.M{"_id": cid}).Decode(&conv); err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		if conv.JoinUnread != JoinUnreadFullHistory {
+			uids := make([]primitive.ObjectID, 0, len(added))
+			for _, m := range added {
+				uids = append(uids, m.UserID)
+			}
+			if err := initJoinReceipts(ctx, db, cid, uids, time.Now().UnixMilli()); err != nil {
+				fmt.Println("init join receipts error:", err)
+				c.JSON(500, gin.H{"error": "db error"})
+				return
+			}
+		}
+
+		for _, m := range added {
+			broadcaster.Publish(events.New(cid.Hex(), events.MemberAdded{UserID: m.UserID.Hex(), Role: m.Role}))
+		}
+		c.JSON(200, gin.H{"added": added})
+	}
+}
+
+// DELETE /conversations/:cid/members/:uid
+// Owners can remove anyone; members can remove themselves (leave).
+func RemoveMemberHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustObjectID(uidHex.(string))
+		if err != nil {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustObjectID(c.Param("cid"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		target, err := mustObjectID(c.Param("uid"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		if role == "" || (role != "owner" && target != uid) {
+			c.JSON(403, gin.H{"error": "forbidden"})
+			return
+		}
+
+		removed, err := removeMember(ctx, db, cid, target)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		if !removed {
+			c.JSON(404, gin.H{"error": "not a member"})
+			return
+		}
+
+		// membership is read from the db at upgrade, so a reconnect is
+		// already refused; drop the sockets still open
+		broadcaster.CloseUserInRoom(target, cid)
+		broadcaster.Publish(events.New(cid.Hex(), events.MemberRemoved{UserID: target.Hex()}))
+		c.JSON(200, gin.H{"ok": true})
+	}
+}
+
+// GET /me/conversations/ids?updated_since=<ts>
+// Returns just { conversations: [{ id, role }], server_time } for the caller.
+// With updated_since, only conversations whose membership (or title or
+// settings) changed after ts.
+func MyConversationIDsHandler(client *mongo.Client) gin.HandlerFunc {
+	type entry struct {
+		ID   primitive.ObjectID `json:"id"`
+		Role string             `json:"role"`
+	}
+
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustObjectID(uidHex.(string))
+		if err != nil {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var since int64
+		if s := c.Query("updated_since"); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < 0 {
+				c.JSON(400, gin.H{"error": "invalid updated_since"})
+				return
+			}
+			since = n
+		}
+		serverTime := time.Now().UnixMilli()
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		filter, err := memberConvFilter(ctx, db, uid)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		if since > 0 {
+			filter = bson.M{"$and": bson.A{filter, bson.M{"$or": bson.A{
+				bson.M{"updated_at": bson.M{"$gt": since}},
+				bson.M{"updated_at": bson.M{"$exists": false}, "created_at": bson.M{"$gt": since}},
+			}}}}
+		}
+
+		cur, err := db.Collection("conversations").Find(ctx, filter,
+			options.Find().SetProjection(bson.M{
+				"members_external": 1,
+				"members":          bson.M{"$elemMatch": bson.M{"user_id": uid}},
+			}),
+		)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		defer cur.Close(ctx)
+
+		out := make([]entry, 0, 16)
+		for cur.Next(ctx) {
+			var x struct {
+				ID       primitive.ObjectID `bson:"_id"`
+				External bool               `bson:"members_external"`
+				Members  []Member           `bson:"members"`
+			}
+			if err := cur.Decode(&x); err != nil {
+				c.JSON(500, gin.H{"error": "decode error"})
+				return
+			}
+			e := entry{ID: x.ID}
+			if len(x.Members) > 0 {
+				e.Role = x.Members[0].Role
+			} else if x.External {
+				if e.Role, err = memberRole(ctx, db, x.ID, uid); err != nil {
+					c.JSON(500, gin.H{"error": "db error"})
+					return
+				}
+			}
+			out = append(out, e)
+		}
+
+		c.JSON(200, gin.H{"conversations": out, "server_time": serverTime})
+	}
+}
+
+// GET /me/roles
+// Returns { roles: { "<cid>": "owner" | "member", ... } } for every
+// conversation the caller is in. One query per membership layout: the
+// matching member element of embedded lists, plus the caller's memberships.
+func MyRolesHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustObjectID(uidHex.(string))
+		if err != nil {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		cur, err := db.Collection("conversations").Find(ctx,
+			bson.M{"members.user_id": uid},
+			options.Find().SetProjection(bson.M{
+				"members": bson.M{"$elemMatch": bson.M{"user_id": uid}},
+			}),
+		)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		var inline []struct {
+			ID      primitive.ObjectID `bson:"_id"`
+			Members []Member           `bson:"members"`
+		}
+		if err := cur.All(ctx, &inline); err != nil {
+			c.JSON(500, gin.H{"error": "decode error"})
+			return
+		}
+		roles := make(map[string]string, len(inline))
+		for _, x := range inline {
+			if len(x.Members) > 0 {
+				roles[x.ID.Hex()] = x.Members[0].Role
+			}
+		}
+
+		cur, err = db.Collection("memberships").Find(ctx, bson.M{"user_id": uid},
+			options.Find().SetProjection(bson.M{"conversation_id": 1, "role": 1}))
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		var external []Membership
+		if err := cur.All(ctx, &external); err != nil {
+			c.JSON(500, gin.H{"error": "decode error"})
+			return
+		}
+		for _, m := range external {
+			roles[m.ConversationID.Hex()] = m.Role
+		}
+
+		c.JSON(200, gin.H{"roles": roles})
+	}
+}
+
+// PATCH /conversations/:cid (owner only)
+// Body: { "title": "New name", "description": "...", "avatar_url": "https://..." }
+func RenameConverHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustObjectID(uidHex.(string))
+		if err != nil {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustObjectID(c.Param("cid"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		// any subset; an empty description or avatar_url clears it
+		var in struct {
+			Title       *string `json:"title"`
+			Description *string `json:"description"`
+			AvatarURL   *string `json:"avatar_url"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(400, gin.H{"error": "bad json"})
+			return
+		}
+		set := bson.M{}
+		var changed events.ConversationUpdated
+		if in.Title != nil {
+			// blank resets to the default, as on create
+			title, err := normalizeTitle(*in.Title)
+			if err != nil {
+				c.JSON(400, gin.H{"error": err.Error(), "code": titleErrCode(err)})
+				return
+			}
+			set["title"], changed.Title = title, &title
+		}
+		if in.Description != nil {
+			d, err := cleanDescription(*in.Description)
+			if err != nil {
+				c.JSON(400, gin.H{"error": err.Error(), "code": titleErrCode(err)})
+				return
+			}
+			set["description"], changed.Description = d, &d
+		}
+		if in.AvatarURL != nil {
+			a, err := cleanAvatarURL(*in.AvatarURL)
+			if err != nil {
+				c.JSON(400, gin.H{"error": err.Error(), "code": titleErrCode(err)})
+				return
+			}
+			set["avatar_url"], changed.AvatarURL = a, &a
+		}
+		if len(set) == 0 {
+			c.JSON(400, gin.H{"error": "nothing to update"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		if role != "owner" {
+			c.JSON(403, gin.H{"error": "owner only"})
+			return
+		}
+
+		set["updated_at"] = time.Now().UnixMilli()
+		if _, err := db.Collection("conversations").UpdateByID(ctx, cid,
+			bson.M{"$set": set}); err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+
+		broadcaster.Publish(events.New(cid.Hex(), changed))
+		// the response is the event payload plus the id
+		c.JSON(200, struct {
+			ID string `json:"id"`
+			events.ConversationUpdated
+		}{cid.Hex(), changed})
+	}
+}
+
+// GET /conversations/:cid
+func GetConverHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustObjectID(uidHex.(string))
+		if err != nil {
+			c.JSON(401, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustObjectID(c.Param("cid"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		ok, err := canRead(ctx, db, cid, uid, "conversation.get")
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		if !ok {
+			c.JSON(403, gin.H{"error": "not a member"})
+			return
+		}
+
+		var conv Conversation
+		if err := db.Collection("conversations").FindOne(ctx, bson.M{"_id": cid}).Decode(&conv); err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		members, err := listMembers(ctx, db, &conv)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "db error"})
+			return
+		}
+		conv.Members = members
+		count := int64(len(members))
+
+		// owners see who holds compliance read access (watch.go) and the
+		// conversation's snapshots with their tokens (snapshots.go)
+		var watchers []Watcher
+		var snapshots []Snapshot
+		for _, m := range members {
+			if m.UserID == uid && m.Role == "owner" {
+				if watchers, err = listWatchers(ctx, db, cid); err != nil {
+					c.JSON(500, gin.H{"error": "db error"})
+					return
+				}
+				if snapshots, err = listSnapshots(ctx, db, cid); err != nil {
+					c.JSON(500, gin.H{"error": "db error"})
+					return
 				}
 			}
 		}
 
-		c.JSON(200, convs)
+		c.JSON(200, struct {
+			Conversation
+			MemberCount     int64      `json:"member_count"`
+			IsDM            bool       `json:"is_dm"`
+			ReceiptsEnabled bool       `json:"receipts_enabled"`
+			Watchers        []Watcher  `json:"watchers,omitempty"`
+			Snapshots       []Snapshot `json:"snapshots,omitempty"`
+			Viewing         []string   `json:"viewing"` // uids with it on screen (viewing.go)
+			// effective send budget per sender (sendrate.go)
+			SendRate sendRateInfo `json:"send_rate"`
+		}{conv, count, isDM(count), conv.ReceiptsOn(), watchers, snapshots, viewing.users(cid), effectiveSendRate(conv.SendRate)})
 	}
 }
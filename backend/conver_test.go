@@ -0,0 +1,452 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// convListFixture is owner's list of seven conversations, created an hour
+// apart: "c6" newest, "c0" oldest.
+type convListFixture struct {
+	client *mongo.Client
+	db     *mongo.Database
+	owner  User
+	convs  map[string]Conversation
+	base   int64
+}
+
+func newConvListFixture(t *testing.T) *convListFixture {
+	t.Helper()
+	client, db := testDB(t)
+	f := &convListFixture{client: client, db: db, convs: map[string]Conversation{}}
+	f.owner = seedUser(t, db, "owner")
+	other := seedUser(t, db, "other")
+	f.base = time.Now().Add(-24 * time.Hour).UnixMilli()
+	for i := range 7 {
+		title := "c" + strconv.Itoa(i)
+		conv := seedConv(t, db, title, f.owner, other)
+		conv.CreatedAt = f.base + int64(i)*time.Hour.Milliseconds()
+		if _, err := db.Collection("conversations").UpdateByID(testCtx(t), conv.ID, bson.M{"$set": bson.M{"created_at": conv.CreatedAt}}); err != nil {
+			t.Fatal(err)
+		}
+		f.convs[title] = conv
+	}
+	return f
+}
+
+func (f *convListFixture) prefs(t *testing.T, title string, p ConvPrefs) {
+	t.Helper()
+	p.ConversationID, p.UserID = f.convs[title].ID, f.owner.ID
+	if _, err := f.db.Collection("conv_prefs").InsertOne(testCtx(t), p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *convListFixture) pin(t *testing.T, title string) {
+	t.Helper()
+	pin := Pin{MessageID: primitive.NewObjectID(), PinnedBy: f.owner.ID, PinnedAt: f.base}
+	if _, err := f.db.Collection("conversations").UpdateByID(testCtx(t), f.convs[title].ID, bson.M{"$push": bson.M{"pins": pin}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *convListFixture) send(t *testing.T, title string, m Message) {
+	t.Helper()
+	m.ID, m.ConversationID = primitive.NewObjectID(), f.convs[title].ID
+	if m.SenderID.IsZero() {
+		m.SenderID = primitive.NewObjectID()
+	}
+	if m.Type == "" {
+		m.Type = "text"
+	}
+	if m.Ts == 0 {
+		m.Ts = time.Now().UnixMilli()
+	}
+	if _, err := f.db.Collection("messages").InsertOne(testCtx(t), m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pages lists the conversations limit at a time, following next_cursor
+// to the end, and returns the titles in the order served.
+func (f *convListFixture) pages(t *testing.T, filter string, limit int) []string {
+	t.Helper()
+	r, api := testAPI()
+	api.GET("/conversations", ListConverHandler(f.client))
+
+	var titles []string
+	cursor := ""
+	for range 20 {
+		path := "/conversations?limit=" + strconv.Itoa(limit) + "&filter=" + filter + "&cursor=" + cursor
+		w := serve(t, r, http.MethodGet, path, &f.owner, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, w.Code, w.Body)
+		}
+		var page struct {
+			Conversations []convListItem `json:"conversations"`
+			NextCursor    string         `json:"next_cursor"`
+		}
+		decode(t, w, &page)
+		if len(page.Conversations) > limit {
+			t.Fatalf("page of %d, limit %d", len(page.Conversations), limit)
+		}
+		for _, x := range page.Conversations {
+			titles = append(titles, x.Title)
+		}
+		if page.NextCursor == "" {
+			return titles
+		}
+		if len(page.Conversations) == 0 {
+			t.Fatal("empty page with a next_cursor")
+		}
+		cursor = page.NextCursor
+	}
+	t.Fatal("next_cursor never ran out")
+	return nil
+}
+
+func TestConvListPagingSnooze(t *testing.T) {
+	f := newConvListFixture(t)
+	now := time.Now().UnixMilli()
+	// hidden until tomorrow
+	f.prefs(t, "c5", ConvPrefs{SnoozeUntil: now + time.Hour.Milliseconds()})
+	// woke a minute ago: c1 floats to the top, c3 stays where it was
+	f.prefs(t, "c1", ConvPrefs{SnoozeUntil: now - time.Minute.Milliseconds(), SnoozeBump: true})
+	f.prefs(t, "c3", ConvPrefs{SnoozeUntil: now - time.Minute.Milliseconds()})
+	// bumped, but woke before it was created: no effect
+	f.prefs(t, "c2", ConvPrefs{SnoozeUntil: f.base, SnoozeBump: true})
+
+	want := []string{"c1", "c6", "c4", "c3", "c2", "c0"}
+	for _, limit := range []int{1, 2, 3, 100} {
+		if got := f.pages(t, "", limit); !slices.Equal(got, want) {
+			t.Errorf("limit %d: got %v, want %v", limit, got, want)
+		}
+	}
+}
+
+func TestConvListPagingBumpTies(t *testing.T) {
+	f := newConvListFixture(t)
+	// c0 and c4 wake at c5's created_at: ties break on id, newest first
+	at := f.convs["c5"].CreatedAt
+	f.prefs(t, "c0", ConvPrefs{SnoozeUntil: at, SnoozeBump: true})
+	f.prefs(t, "c4", ConvPrefs{SnoozeUntil: at, SnoozeBump: true})
+
+	tied := []string{"c5", "c4", "c0"}
+	slices.SortFunc(tied, func(a, b string) int {
+		x, y := f.convs[a].ID, f.convs[b].ID
+		return -slices.Compare(x[:], y[:])
+	})
+	want := append([]string{"c6"}, tied...)
+	want = append(want, "c3", "c2", "c1")
+	for _, limit := range []int{1, 2, 4} {
+		if got := f.pages(t, "", limit); !slices.Equal(got, want) {
+			t.Errorf("limit %d: got %v, want %v", limit, got, want)
+		}
+	}
+}
+
+func TestConvListPagingMute(t *testing.T) {
+	f := newConvListFixture(t)
+	now := time.Now().UnixMilli()
+	f.prefs(t, "c2", ConvPrefs{Muted: true})
+	f.prefs(t, "c4", ConvPrefs{Muted: true})
+	f.prefs(t, "c0", ConvPrefs{Muted: true, SnoozeUntil: now - time.Minute.Milliseconds(), SnoozeBump: true})
+	f.prefs(t, "c6", ConvPrefs{Muted: true, SnoozeUntil: now + time.Hour.Milliseconds()})
+
+	for _, tt := range []struct {
+		filter string
+		want   []string
+	}{
+		{convFilterMuted, []string{"c0", "c4", "c2"}},
+		{convFilterUnmuted, []string{"c5", "c3", "c1"}},
+		{"", []string{"c0", "c5", "c4", "c3", "c2", "c1"}},
+	} {
+		for _, limit := range []int{1, 2, 5} {
+			if got := f.pages(t, tt.filter, limit); !slices.Equal(got, tt.want) {
+				t.Errorf("filter %q, limit %d: got %v, want %v", tt.filter, limit, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestConvListPagingPinned(t *testing.T) {
+	f := newConvListFixture(t)
+	f.pin(t, "c1")
+	f.pin(t, "c3")
+	f.pin(t, "c3")
+	f.pin(t, "c6")
+	f.prefs(t, "c1", ConvPrefs{SnoozeUntil: time.Now().UnixMilli() - 1, SnoozeBump: true})
+	// emptied pin list: not pinned
+	if _, err := f.db.Collection("conversations").UpdateByID(testCtx(t), f.convs["c4"].ID, bson.M{"$set": bson.M{"pins": bson.A{}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"c1", "c6", "c3"}
+	for _, limit := range []int{1, 2, 3} {
+		if got := f.pages(t, convFilterPinned, limit); !slices.Equal(got, want) {
+			t.Errorf("limit %d: got %v, want %v", limit, got, want)
+		}
+	}
+}
+
+func TestConvListPagingUnread(t *testing.T) {
+	f := newConvListFixture(t)
+	for _, title := range []string{"c0", "c2", "c5", "c6"} {
+		f.send(t, title, Message{Body: "hi"})
+	}
+	// nothing left to read in c6
+	if _, err := f.db.Collection("receipts").InsertOne(testCtx(t), bson.M{
+		"conversation_id": f.convs["c6"].ID, "user_id": f.owner.ID, "last_read_ts": time.Now().Add(time.Minute).UnixMilli(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	f.prefs(t, "c0", ConvPrefs{SnoozeUntil: time.Now().UnixMilli() - 1, SnoozeBump: true})
+
+	want := []string{"c0", "c5", "c2"}
+	for _, limit := range []int{1, 2, 3, 10} {
+		if got := f.pages(t, convFilterUnread, limit); !slices.Equal(got, want) {
+			t.Errorf("limit %d: got %v, want %v", limit, got, want)
+		}
+	}
+}
+
+func TestConvListBadCursor(t *testing.T) {
+	f := newConvListFixture(t)
+	r, api := testAPI()
+	api.GET("/conversations", ListConverHandler(f.client))
+	if w := serve(t, r, http.MethodGet, "/conversations?limit=2&cursor=nope", &f.owner, nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad cursor: %d %s", w.Code, w.Body)
+	}
+}
+
+// Unread must only ever count visible messages. Each step changes what is
+// visible or read and checks every place the count is served.
+func TestUnreadAcrossPurgeReadExpire(t *testing.T) {
+	f := newConvListFixture(t)
+	// bob owns the conversation and sends, f.owner reads
+	bob := seedUser(t, f.db, "bob")
+	conv := seedConv(t, f.db, "ops", bob, f.owner)
+	cid := conv.ID.Hex()
+
+	r, api := testAPI()
+	api.GET("/conversations", ListConverHandler(f.client))
+	api.GET("/conversations/unread", UnreadCountsHandler(f.client))
+	api.GET("/conversations/:cid/unread", UnreadCountHandler(f.client))
+	api.POST("/conversations/:cid/read", MarkReadHandler(f.client))
+	api.POST("/conversations/:cid/messages/purge", PurgeMessagesHandler(f.client))
+	api.POST("/messages/:cid", SendMessageHandler(f.client))
+
+	send := func(in gin.H) Message {
+		t.Helper()
+		w := serve(t, r, http.MethodPost, "/messages/"+cid, &bob, in)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("send: %d %s", w.Code, w.Body)
+		}
+		var m Message
+		decode(t, w, &m)
+		return m
+	}
+	expire := func(m Message) {
+		t.Helper()
+		if _, err := f.db.Collection("messages").UpdateByID(testCtx(t), m.ID, bson.M{"$set": bson.M{"expires_at_ms": time.Now().UnixMilli() - 1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(step string, unread, high, mentions int64, last Message) {
+		t.Helper()
+		var one struct {
+			Unread   int64 `json:"unread"`
+			Mentions int64 `json:"mention_count"`
+		}
+		decode(t, serve(t, r, http.MethodGet, "/conversations/"+cid+"/unread", &f.owner, nil), &one)
+		if one.Unread != unread || one.Mentions != mentions {
+			t.Errorf("%s: /conversations/:cid/unread = %d, %d mentions; want %d, %d", step, one.Unread, one.Mentions, unread, mentions)
+		}
+
+		var all []struct {
+			CID      string `json:"cid"`
+			Unread   int64  `json:"unread"`
+			High     int64  `json:"unread_high"`
+			Mentions int64  `json:"mention_count"`
+		}
+		decode(t, serve(t, r, http.MethodGet, "/conversations/unread", &f.owner, nil), &all)
+		for _, x := range all {
+			if x.CID == cid && (x.Unread != unread || x.High != high || x.Mentions != mentions) {
+				t.Errorf("%s: /conversations/unread = %d, %d high, %d mentions; want %d, %d, %d", step, x.Unread, x.High, x.Mentions, unread, high, mentions)
+			}
+		}
+
+		var list []convListItem
+		decode(t, serve(t, r, http.MethodGet, "/conversations", &f.owner, nil), &list)
+		for _, x := range list {
+			if x.ID != conv.ID {
+				continue
+			}
+			if x.Unread != unread || x.UnreadHigh != high || x.MentionCount != mentions {
+				t.Errorf("%s: list = %d, %d high, %d mentions; want %d, %d, %d", step, x.Unread, x.UnreadHigh, x.MentionCount, unread, high, mentions)
+			}
+			if x.LastMsg == nil || x.LastMsg.ID != last.ID {
+				t.Errorf("%s: list last_msg = %+v, want %s", step, x.LastMsg, last.ID.Hex())
+			}
+		}
+	}
+
+	m1 := send(gin.H{"body": "one"})
+	m2 := send(gin.H{"body": "two", "mentions": []string{"owner"}})
+	m3 := send(gin.H{"body": "three", "priority": priorityHigh})
+	check("send", 3, 1, 1, m3)
+
+	w := serve(t, r, http.MethodPost, "/conversations/"+cid+"/messages/purge", &bob, gin.H{"message_ids": []string{m1.ID.Hex(), m2.ID.Hex()}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("purge: %d %s", w.Code, w.Body)
+	}
+	check("purge", 1, 1, 0, m3)
+
+	w = serve(t, r, http.MethodPost, "/conversations/"+cid+"/read", &f.owner, gin.H{"up_to_message": m3.ID.Hex()})
+	if w.Code != http.StatusOK {
+		t.Fatalf("read: %d %s", w.Code, w.Body)
+	}
+	check("read", 0, 0, 0, m3)
+
+	m4 := send(gin.H{"body": "four", "expires_in_seconds": 60})
+	m5 := send(gin.H{"body": "five", "expires_in_seconds": 60, "mentions": []string{"owner"}})
+	check("send again", 2, 0, 1, m5)
+
+	expire(m5)
+	check("expire newest", 1, 0, 0, m4)
+
+	expire(m4)
+	check("expire all", 0, 0, 0, m3)
+}
+
+func TestMemberCountError(t *testing.T) {
+	for _, tt := range []struct {
+		env    string
+		others int
+		code   string
+	}{
+		{"", 0, "only_self"},
+		{"", 1, ""},
+		{"1", 1, ""}, // 2 is the floor
+		{"0", 0, "only_self"},
+		{"4", 0, "only_self"},
+		{"4", 2, "too_few_members"},
+		{"4", 3, ""},
+		{"junk", 1, ""},
+	} {
+		t.Setenv("MIN_MEMBERS", tt.env)
+		var code any = ""
+		if e := memberCountError(tt.others); e != nil {
+			code = e["code"]
+		}
+		if code != tt.code {
+			t.Errorf("MIN_MEMBERS=%q, %d others: code %v, want %q", tt.env, tt.others, code, tt.code)
+		}
+	}
+}
+
+func TestCreateConverMinMembers(t *testing.T) {
+	client, db := testDB(t)
+	t.Setenv("MIN_MEMBERS", "3")
+	ann := seedUser(t, db, "ann")
+	seedUser(t, db, "bob")
+	seedUser(t, db, "cat")
+	r, api := testAPI()
+	api.POST("/conversations", CreateConverHandler(client))
+
+	// ann's token still carries "ann" after the rename to "anna"
+	if _, err := db.Collection("users").UpdateByID(testCtx(t), ann.ID, bson.M{"$set": bson.M{"username": "anna"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		members []string
+		status  int
+		code    string
+	}{
+		{[]string{"ann", "ANN "}, http.StatusBadRequest, "only_self"},
+		{[]string{"bob", "Bob", "ann"}, http.StatusBadRequest, "too_few_members"},
+		{[]string{"bob", "anna"}, http.StatusBadRequest, "too_few_members"},
+		{[]string{"bob", "cat", "ann"}, http.StatusCreated, ""},
+	} {
+		w := serve(t, r, http.MethodPost, "/conversations", &ann, gin.H{"title": "t", "members": tt.members})
+		var body struct {
+			Code    string   `json:"code"`
+			Members []Member `json:"members"`
+		}
+		decode(t, w, &body)
+		if w.Code != tt.status || body.Code != tt.code {
+			t.Fatalf("members %q: %d %s, want %d %q", tt.members, w.Code, w.Body, tt.status, tt.code)
+		}
+		if w.Code == http.StatusCreated && len(body.Members) != 3 {
+			t.Fatalf("members %q: created with %d members", tt.members, len(body.Members))
+		}
+	}
+}
+
+func TestJoinerUnreadBaseline(t *testing.T) {
+	for _, tt := range []struct {
+		name, joinUnread string
+		before, after    int64
+	}{
+		{"default", "", 0, 1},
+		{"full history", JoinUnreadFullHistory, 2, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client, db := testDB(t)
+			ann, cat := seedUser(t, db, "ann"), seedUser(t, db, "cat")
+			conv := seedConv(t, db, "ops", ann)
+			if tt.joinUnread != "" {
+				if _, err := db.Collection("conversations").UpdateByID(testCtx(t), conv.ID, bson.M{"$set": bson.M{"join_unread": tt.joinUnread}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cid := conv.ID.Hex()
+			r, api := testAPI()
+			api.POST("/messages/:cid", SendMessageHandler(client))
+			api.POST("/conversations/:cid/members", AddMembersHandler(client))
+			api.GET("/conversations/:cid/unread", UnreadCountHandler(client))
+			send := func(body string) {
+				t.Helper()
+				if w := serve(t, r, http.MethodPost, "/messages/"+cid, &ann, gin.H{"body": body}); w.Code != http.StatusCreated {
+					t.Fatalf("send: %d %s", w.Code, w.Body)
+				}
+			}
+			unread := func() int64 {
+				t.Helper()
+				var out struct {
+					Unread int64 `json:"unread"`
+				}
+				w := serve(t, r, http.MethodGet, "/conversations/"+cid+"/unread", &cat, nil)
+				decode(t, w, &out)
+				if w.Code != http.StatusOK {
+					t.Fatalf("unread: %d %s", w.Code, w.Body)
+				}
+				return out.Unread
+			}
+
+			send("one")
+			send("two")
+			if w := serve(t, r, http.MethodPost, "/conversations/"+cid+"/members", &ann, gin.H{"members": []string{"cat"}}); w.Code != http.StatusOK {
+				t.Fatalf("add: %d %s", w.Code, w.Body)
+			}
+			if got := unread(); got != tt.before {
+				t.Fatalf("unread on joining: %d, want %d", got, tt.before)
+			}
+			// past the join receipt's millisecond
+			time.Sleep(2 * time.Millisecond)
+			send("three")
+			if got := unread(); got != tt.after {
+				t.Fatalf("unread after a new message: %d, want %d", got, tt.after)
+			}
+		})
+	}
+}
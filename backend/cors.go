@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+CORS is chosen per route group by path prefix:
+  /widget/, /snapshots/
+            public reads: WIDGET_ORIGINS ("*" = any origin; empty = the
+            core list), GET only, no credentials. Each widget still checks
+            its own allowed origins in the handler; a snapshot link is
+            readable by anyone who has it.
+  /admin/   ADMIN_CORS_ORIGINS if set (e.g. an internal console), else the
+            core list
+  the rest  CORS_ORIGINS (comma separated), else the built-in dev/prod list
+Preflights are cacheable for CORS_MAX_AGE seconds (default 600).
+
+This has to be one global middleware: preflight OPTIONS requests match no
+route, so middleware attached to a gin group never sees them.
+*/
+
+var defaultCORSOrigins = []string{"http://localhost:3000", "http://localhost:5173", "http://127.0.0.1:3000", "http://127.0.0.1:5173", "http://localhost:8080", "http://127.0.0.1:8080", "http://127.0.0.1:5500", "https://gui-im.netlify.app"}
+
+func originList(env string) []string {
+	var out []string
+	for _, o := range strings.Split(os.Getenv(env), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+func coreCORSOrigins() []string {
+	if o := originList("CORS_ORIGINS"); len(o) > 0 {
+		return o
+	}
+	return defaultCORSOrigins
+}
+
+func corsMaxAge() time.Duration {
+	return time.Duration(envInt("CORS_MAX_AGE", 600)) * time.Second
+}
+
+func apiCORSConfig(origins []string) cors.Config {
+	config := cors.DefaultConfig()
+	config.AllowOrigins = origins
+	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key", "X-Timezone", "If-None-Match"}
+	config.ExposeHeaders = []string{"X-Timezone-Fallback", "Retry-After", "ETag", "X-Cache",
+		"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"}
+	config.AllowCredentials = true
+	config.MaxAge = corsMaxAge()
+	return config
+}
+
+func publicCORSConfig() cors.Config {
+	config := cors.DefaultConfig()
+	origins := widgetOrigins()
+	switch {
+	case len(origins) == 1 && origins[0] == "*":
+		config.AllowAllOrigins = true
+	case len(origins) == 0:
+		config.AllowOrigins = coreCORSOrigins()
+	default:
+		config.AllowOrigins = origins
+	}
+	config.AllowMethods = []string{"GET", "OPTIONS"}
+	config.AllowHeaders = []string{"Origin", "Accept", "If-None-Match", "Last-Event-ID"}
+	config.ExposeHeaders = []string{"ETag"}
+	config.MaxAge = corsMaxAge()
+	return config
+}
+
+// CORS picks the public, admin or core policy for each request.
+func CORS() gin.HandlerFunc {
+	core := cors.New(apiCORSConfig(coreCORSOrigins()))
+	admin := core
+	if o := originList("ADMIN_CORS_ORIGINS"); len(o) > 0 {
+		admin = cors.New(apiCORSConfig(o))
+	}
+	public := cors.New(publicCORSConfig())
+
+	return func(c *gin.Context) {
+		switch p := c.Request.URL.Path; {
+		case strings.HasPrefix(p, "/widget/"), strings.HasPrefix(p, "/snapshots/"):
+			public(c)
+		case strings.HasPrefix(p, "/admin/"):
+			admin(c)
+		default:
+			core(c)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORSPolicies(t *testing.T) {
+	t.Setenv("CORS_ORIGINS", "")
+	t.Setenv("WIDGET_ORIGINS", "*")
+	t.Setenv("ADMIN_CORS_ORIGINS", "https://console.example")
+	t.Setenv("CORS_MAX_AGE", "120")
+	r := gin.New()
+	r.Use(CORS())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/widget/:token/feed", ok)
+	r.GET("/admin/features", ok)
+	r.GET("/conversations", ok)
+	r.GET("/snapshots/:token", ok)
+
+	const app = "http://localhost:5173"
+	for _, tt := range []struct {
+		name, method, path, origin string
+		allowOrigin, creds, maxAge string
+	}{
+		{"widget preflight", http.MethodOptions, "/widget/t/feed", "https://blog.example", "*", "", "120"},
+		{"widget simple", http.MethodGet, "/widget/t/feed", "https://blog.example", "*", "", ""},
+		{"snapshot preflight", http.MethodOptions, "/snapshots/t", "https://blog.example", "*", "", "120"},
+		{"snapshot simple", http.MethodGet, "/snapshots/t", "https://blog.example", "*", "", ""},
+		{"admin preflight", http.MethodOptions, "/admin/features", "https://console.example", "https://console.example", "true", "120"},
+		{"admin simple", http.MethodGet, "/admin/features", "https://console.example", "https://console.example", "true", ""},
+		{"admin from the app", http.MethodGet, "/admin/features", app, "", "", ""},
+		{"core preflight", http.MethodOptions, "/conversations", app, app, "true", "120"},
+		{"core simple", http.MethodGet, "/conversations", app, app, "true", ""},
+		{"core from a stranger", http.MethodOptions, "/conversations", "https://blog.example", "", "", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			h := w.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Allow-Origin %q, want %q", got, tt.allowOrigin)
+			}
+			if got := h.Get("Access-Control-Allow-Credentials"); got != tt.creds {
+				t.Errorf("Allow-Credentials %q, want %q", got, tt.creds)
+			}
+			if got := h.Get("Access-Control-Max-Age"); got != tt.maxAge {
+				t.Errorf("Max-Age %q, want %q", got, tt.maxAge)
+			}
+			if tt.allowOrigin == "" && w.Code != http.StatusForbidden {
+				t.Errorf("refused origin: %d, want 403", w.Code)
+			}
+		})
+	}
+}
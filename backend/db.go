@@ -3,30 +3,77 @@ package main
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// connectMongo connects to MongoDB and pings it to confirm connection.
-func connectMongo(uri string) *mongo.Client {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// connectMongo connects to MongoDB and pings it until it answers, backing
+// off exponentially (with jitter) between attempts so a container started
+// alongside Mongo waits for it instead of crash-looping.
+//
+//	MONGO_CONNECT_TIMEOUT          per-attempt timeout, secs (default 10)
+//	MONGO_CONNECT_MAX_WAIT         give up after this long, secs (default 120)
+//	MONGO_MAX_POOL_SIZE            connection pool size (default 100)
+//	MONGO_SERVER_SELECTION_TIMEOUT secs (default 5)
+func connectMongo(uri string) (*mongo.Client, error) {
+	if !strings.HasPrefix(uri, "mongodb://") && !strings.HasPrefix(uri, "mongodb+srv://") {
+		return nil, fmt.Errorf("MONGO_URI must start with mongodb:// or mongodb+srv://")
+	}
+	opts := options.Client().
+		ApplyURI(uri).
+		SetMaxPoolSize(uint64(envInt("MONGO_MAX_POOL_SIZE", 100))).
+		SetServerSelectionTimeout(time.Duration(envInt("MONGO_SERVER_SELECTION_TIMEOUT", 5)) * time.Second)
+	opts = dbMon.attach(opts) // pool/topology/command counters (dbmonitor.go)
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid MONGO_URI: %w", err)
+	}
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	// Connect doesn't dial; the pings below do
+	client, err := mongo.Connect(context.Background(), opts)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	// quick ping
-	if err := client.Ping(ctx, nil); err != nil {
-		panic(err)
+	timeout := time.Duration(envInt("MONGO_CONNECT_TIMEOUT", 10)) * time.Second
+	maxWait := time.Duration(envInt("MONGO_CONNECT_MAX_WAIT", 120)) * time.Second
+	if err := pingWithBackoff(client, timeout, maxWait); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, err
 	}
-
 	fmt.Println("✅ Connected to MongoDB")
-	return client
+	return client, nil
+}
+
+func pingWithBackoff(client *mongo.Client, timeout, maxWait time.Duration) error {
+	start := time.Now()
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := client.Ping(ctx, nil)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		// full jitter: sleep somewhere in [backoff/2, backoff)
+		sleep := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+		elapsed := time.Since(start)
+		if elapsed+sleep > maxWait {
+			fmt.Printf("mongo connect attempt=%d elapsed=%s giving_up=true err=%q\n", attempt, elapsed.Round(time.Millisecond), err)
+			return fmt.Errorf("mongo unreachable after %d attempts in %s: %w", attempt, elapsed.Round(time.Second), err)
+		}
+		fmt.Printf("mongo connect attempt=%d elapsed=%s retry_in=%s err=%q\n", attempt, elapsed.Round(time.Millisecond), sleep.Round(time.Millisecond), err)
+		time.Sleep(sleep)
+		if backoff < 15*time.Second {
+			backoff *= 2
+		}
+	}
 }
 
 func getDB(client *mongo.Client) *mongo.Database {
@@ -36,3 +83,11 @@ func getDB(client *mongo.Client) *mongo.Database {
 	}
 	return client.Database(name)
 }
+
+// envInt reads a positive integer env var, falling back to def.
+func envInt(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
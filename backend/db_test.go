@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestConnectMongoBadURI(t *testing.T) {
+	for _, uri := range []string{"", "localhost:27017", "http://localhost:27017", "mongodb://"} {
+		start := time.Now()
+		if _, err := connectMongo(uri); err == nil {
+			t.Errorf("connectMongo(%q) succeeded", uri)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("connectMongo(%q) retried a bad URI for %s", uri, d)
+		}
+	}
+}
+
+// freeAddr is a loopback address nothing listens on (yet).
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestPingWithBackoffGivesUp(t *testing.T) {
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://"+freeAddr(t)+"/?directConnection=true").
+		SetServerSelectionTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	start := time.Now()
+	err = pingWithBackoff(client, time.Second, 1500*time.Millisecond)
+	elapsed := time.Since(start)
+	if err == nil || !strings.Contains(err.Error(), "mongo unreachable after") {
+		t.Fatalf("err = %v", err)
+	}
+	if strings.Contains(err.Error(), "after 1 attempts") {
+		t.Fatalf("no retry within the wait: %v", err)
+	}
+	// the last sleep is only taken if it ends within maxWait
+	if elapsed > 1500*time.Millisecond+time.Second {
+		t.Fatalf("gave up after %s, past the max wait", elapsed)
+	}
+}
+
+// TestConnectMongoWaitsForServer starts a proxy to MONGO_TEST_URI a while
+// after connecting begins, as when the app container is up before Mongo.
+func TestConnectMongoWaitsForServer(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := freeAddr(t)
+	t.Setenv("MONGO_CONNECT_TIMEOUT", "1")
+	t.Setenv("MONGO_SERVER_SELECTION_TIMEOUT", "1")
+	t.Setenv("MONGO_CONNECT_MAX_WAIT", "30")
+
+	up := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Error(err)
+			close(up)
+			return
+		}
+		up <- l
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				m, err := net.Dial("tcp", u.Host)
+				if err != nil {
+					return
+				}
+				defer m.Close()
+				go io.Copy(m, c)
+				io.Copy(c, m)
+			}()
+		}
+	}()
+	defer func() {
+		if l, ok := <-up; ok {
+			l.Close()
+		}
+	}()
+
+	start := time.Now()
+	client, err := connectMongo("mongodb://" + addr + "/?directConnection=true")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+	if d := time.Since(start); d < time.Second {
+		t.Fatalf("connected after %s, before the server was up", d)
+	}
+	if err := client.Ping(testCtx(t), nil); err != nil {
+		t.Fatal(err)
+	}
+}
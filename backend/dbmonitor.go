@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Mongo driver observability. connectMongo plugs pool, server and command
+monitors into the client; their events only bump the counters below, so
+they cost nothing on the request path.
+
+  pool:     open connections (created - closed), in use (checked out -
+            checked in), available (open - in use), checkouts waiting
+            right now, and totals of checkouts and failed checkouts
+  topology: the driver's latest view: kind ("ReplicaSetWithPrimary", ...)
+            and each server's address, kind and average round trip
+  commands: failed commands, in total and over the last
+            dbFailureWindow minutes, plus failed heartbeats
+
+GET /health/db pings and returns { ok, latency_ms } to anyone; with an
+admin's Bearer token it adds { pool, topology, commands }. The same
+numbers are on /metrics as mongo_*.
+*/
+
+// minutes of command failures counted as recent
+const dbFailureWindow = 5
+
+type dbMonitor struct {
+	created, closed        atomic.Int64
+	checkedOut, checkedIn  atomic.Int64
+	waiting                atomic.Int64
+	checkouts, checkoutErr atomic.Int64
+	cmdFailed, hbFailed    atomic.Int64
+	maxPool                atomic.Int64
+
+	mu       sync.Mutex
+	topology description.Topology
+	// failures per minute, indexed by unix minute % dbFailureWindow
+	recent   [dbFailureWindow]int64
+	recentAt [dbFailureWindow]int64
+}
+
+var dbMon = &dbMonitor{}
+
+func (m *dbMonitor) poolEvent(e *event.PoolEvent) {
+	switch e.Type {
+	case event.PoolCreated:
+		if e.PoolOptions != nil {
+			m.maxPool.Store(int64(e.PoolOptions.MaxPoolSize))
+		}
+	case event.ConnectionCreated:
+		m.created.Add(1)
+	case event.ConnectionClosed:
+		m.closed.Add(1)
+	case event.GetStarted:
+		m.waiting.Add(1)
+	case event.GetSucceeded:
+		m.waiting.Add(-1)
+		m.checkouts.Add(1)
+		m.checkedOut.Add(1)
+	case event.GetFailed:
+		m.waiting.Add(-1)
+		m.checkoutErr.Add(1)
+	case event.ConnectionReturned:
+		m.checkedIn.Add(1)
+	}
+}
+
+func (m *dbMonitor) commandFailed(_ context.Context, _ *event.CommandFailedEvent) {
+	m.cmdFailed.Add(1)
+	m.addRecent(time.Now())
+}
+
+func (m *dbMonitor) addRecent(now time.Time) {
+	minute := now.Unix() / 60
+	i := minute % dbFailureWindow
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.recentAt[i] != minute {
+		m.recentAt[i], m.recent[i] = minute, 0
+	}
+	m.recent[i]++
+}
+
+// recentFailures is the command failures in the last dbFailureWindow
+// minutes before now.
+func (m *dbMonitor) recentFailures(now time.Time) int64 {
+	minute := now.Unix() / 60
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for i := range m.recent {
+		if minute-m.recentAt[i] < dbFailureWindow {
+			n += m.recent[i]
+		}
+	}
+	return n
+}
+
+func (m *dbMonitor) topologyChanged(e *event.TopologyDescriptionChangedEvent) {
+	m.mu.Lock()
+	m.topology = e.NewDescription
+	m.mu.Unlock()
+}
+
+// attach plugs m's monitors into opts.
+func (m *dbMonitor) attach(opts *options.ClientOptions) *options.ClientOptions {
+	return opts.
+		SetPoolMonitor(&event.PoolMonitor{Event: m.poolEvent}).
+		SetServerMonitor(&event.ServerMonitor{
+			TopologyDescriptionChanged: m.topologyChanged,
+			ServerHeartbeatFailed:      func(*event.ServerHeartbeatFailedEvent) { m.hbFailed.Add(1) },
+		}).
+		SetMonitor(&event.CommandMonitor{Failed: m.commandFailed})
+}
+
+type dbPoolStats struct {
+	Max            int64 `json:"max"`
+	Open           int64 `json:"open"`
+	InUse          int64 `json:"in_use"`
+	Available      int64 `json:"available"`
+	Waiting        int64 `json:"waiting"`
+	Checkouts      int64 `json:"checkouts_total"`
+	CheckoutFailed int64 `json:"checkout_failed_total"`
+}
+
+func (m *dbMonitor) pool() dbPoolStats {
+	open := m.created.Load() - m.closed.Load()
+	inUse := m.checkedOut.Load() - m.checkedIn.Load()
+	return dbPoolStats{
+		Max:            m.maxPool.Load(),
+		Open:           open,
+		InUse:          inUse,
+		Available:      max(open-inUse, 0),
+		Waiting:        m.waiting.Load(),
+		Checkouts:      m.checkouts.Load(),
+		CheckoutFailed: m.checkoutErr.Load(),
+	}
+}
+
+func (m *dbMonitor) topologyStats() gin.H {
+	m.mu.Lock()
+	t := m.topology
+	m.mu.Unlock()
+	servers := make([]gin.H, 0, len(t.Servers))
+	for _, s := range t.Servers {
+		servers = append(servers, gin.H{
+			"address": s.Addr.String(),
+			"kind":    s.Kind.String(),
+			"rtt_ms":  s.AverageRTT.Milliseconds(),
+		})
+	}
+	kind := "Unknown"
+	if t.Kind != 0 {
+		kind = t.Kind.String()
+	}
+	return gin.H{"kind": kind, "servers": servers}
+}
+
+func (m *dbMonitor) commandStats() gin.H {
+	return gin.H{
+		"failed_total":            m.cmdFailed.Load(),
+		"failed_recent":           m.recentFailures(time.Now()),
+		"recent_window_mins":      dbFailureWindow,
+		"heartbeats_failed_total": m.hbFailed.Load(),
+	}
+}
+
+func writeMongoProm(sb *strings.Builder) {
+	p := dbMon.pool()
+	fmt.Fprintf(sb, "# HELP mongo_pool_connections Driver pool connections by state.\n# TYPE mongo_pool_connections gauge\n")
+	fmt.Fprintf(sb, "mongo_pool_connections{state=\"open\"} %d\n", p.Open)
+	fmt.Fprintf(sb, "mongo_pool_connections{state=\"in_use\"} %d\n", p.InUse)
+	fmt.Fprintf(sb, "mongo_pool_connections{state=\"available\"} %d\n", p.Available)
+	fmt.Fprintf(sb, "# TYPE mongo_pool_max_connections gauge\nmongo_pool_max_connections %d\n", p.Max)
+	fmt.Fprintf(sb, "# HELP mongo_pool_waiting Checkouts waiting for a connection.\n# TYPE mongo_pool_waiting gauge\nmongo_pool_waiting %d\n", p.Waiting)
+	fmt.Fprintf(sb, "# TYPE mongo_pool_checkouts_total counter\nmongo_pool_checkouts_total %d\n", p.Checkouts)
+	fmt.Fprintf(sb, "# TYPE mongo_pool_checkout_failed_total counter\nmongo_pool_checkout_failed_total %d\n", p.CheckoutFailed)
+	fmt.Fprintf(sb, "# TYPE mongo_command_failed_total counter\nmongo_command_failed_total %d\n", dbMon.cmdFailed.Load())
+	fmt.Fprintf(sb, "# TYPE mongo_heartbeat_failed_total counter\nmongo_heartbeat_failed_total %d\n", dbMon.hbFailed.Load())
+}
+
+// callerIsAdmin reports whether the request carries an admin's valid
+// Bearer token, for public routes with an admin-only detail level.
+func callerIsAdmin(c *gin.Context) bool {
+	h := c.GetHeader("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return false
+	}
+	claims, err := parseToken(strings.TrimPrefix(h, "Bearer "))
+	return err == nil && isAdmin(claims.Username)
+}
+
+// GET /health/db
+// Returns: { ok, latency_ms } (500 with err when the ping fails); admins
+// also get { pool, topology, commands }, see above.
+func DBHealthHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		start := time.Now()
+		err := client.Ping(ctx, nil)
+		out := gin.H{"ok": err == nil, "latency_ms": time.Since(start).Milliseconds()}
+		if err != nil {
+			out["err"] = err.Error()
+		}
+		if callerIsAdmin(c) {
+			out["pool"] = dbMon.pool()
+			out["topology"] = dbMon.topologyStats()
+			out["commands"] = dbMon.commandStats()
+		}
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, out)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo/address"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestDBMonitorPool(t *testing.T) {
+	m := &dbMonitor{}
+	opts := m.attach(options.Client())
+	for _, typ := range []string{
+		event.ConnectionCreated, event.ConnectionCreated, event.ConnectionCreated, event.ConnectionClosed,
+		event.GetStarted, event.GetStarted, event.GetStarted, event.GetStarted,
+		event.GetSucceeded, event.GetSucceeded, event.GetFailed,
+		event.ConnectionReturned,
+	} {
+		opts.PoolMonitor.Event(&event.PoolEvent{Type: typ})
+	}
+	opts.PoolMonitor.Event(&event.PoolEvent{Type: event.PoolCreated, PoolOptions: &event.MonitorPoolOptions{MaxPoolSize: 50}})
+	want := dbPoolStats{Max: 50, Open: 2, InUse: 1, Available: 1, Waiting: 1, Checkouts: 2, CheckoutFailed: 1}
+	if got := m.pool(); got != want {
+		t.Fatalf("pool %+v, want %+v", got, want)
+	}
+}
+
+func TestDBMonitorFailures(t *testing.T) {
+	m := &dbMonitor{}
+	opts := m.attach(options.Client())
+	opts.Monitor.Failed(context.Background(), &event.CommandFailedEvent{})
+	opts.ServerMonitor.ServerHeartbeatFailed(&event.ServerHeartbeatFailedEvent{})
+	if s := m.commandStats(); s["failed_total"] != int64(1) || s["failed_recent"] != int64(1) || s["heartbeats_failed_total"] != int64(1) {
+		t.Fatalf("stats %v", s)
+	}
+
+	// per-minute slots: old minutes drop out, a reused slot starts over
+	m = &dbMonitor{}
+	now := time.Unix(1_800_000_000, 0)
+	for _, ago := range []time.Duration{10, 10, 10, 4, 4, 0} {
+		m.addRecent(now.Add(-ago * time.Minute))
+	}
+	for after, want := range map[time.Duration]int64{0: 3, 4 * time.Minute: 1, 5 * time.Minute: 0} {
+		if got := m.recentFailures(now.Add(after)); got != want {
+			t.Errorf("recent failures %s after: %d, want %d", after, got, want)
+		}
+	}
+}
+
+func TestDBMonitorTopology(t *testing.T) {
+	m := &dbMonitor{}
+	if got := m.topologyStats(); got["kind"] != "Unknown" || len(got["servers"].([]gin.H)) != 0 {
+		t.Fatalf("before any event: %v", got)
+	}
+	opts := m.attach(options.Client())
+	opts.ServerMonitor.TopologyDescriptionChanged(&event.TopologyDescriptionChangedEvent{
+		NewDescription: description.Topology{
+			Kind: description.ReplicaSetWithPrimary,
+			Servers: []description.Server{
+				{Addr: address.Address("db1:27017"), Kind: description.RSPrimary, AverageRTT: 3 * time.Millisecond},
+				{Addr: address.Address("db2:27017"), Kind: description.RSSecondary, AverageRTT: 7 * time.Millisecond},
+			},
+		},
+	})
+	got := m.topologyStats()
+	servers := got["servers"].([]gin.H)
+	if got["kind"] != "ReplicaSetWithPrimary" || len(servers) != 2 ||
+		servers[0]["address"] != "db1:27017" || servers[0]["kind"] != "RSPrimary" || servers[0]["rtt_ms"] != int64(3) ||
+		servers[1]["kind"] != "RSSecondary" {
+		t.Fatalf("topology %v", got)
+	}
+}
+
+func TestWriteMongoProm(t *testing.T) {
+	old := dbMon
+	dbMon = &dbMonitor{}
+	t.Cleanup(func() { dbMon = old })
+	dbMon.poolEvent(&event.PoolEvent{Type: event.ConnectionCreated})
+	dbMon.commandFailed(context.Background(), &event.CommandFailedEvent{})
+	var sb strings.Builder
+	writeMongoProm(&sb)
+	for _, line := range []string{
+		`mongo_pool_connections{state="open"} 1`,
+		`mongo_pool_connections{state="in_use"} 0`,
+		"mongo_command_failed_total 1",
+		"mongo_heartbeat_failed_total 0",
+	} {
+		if !strings.Contains(sb.String(), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, sb.String())
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  membership_tombstones:
+    - conversation_id (ObjectId)
+    - user_id         (ObjectId)
+    - removed_at      (int64, millis)
+    - created_at      (date, TTL DELTA_WINDOW_DAYS, default 30)
+Unique index on (conversation_id, user_id); index on (user_id, removed_at)
+
+One per removed member, written by removeMember in either membership
+layout. A re-added member keeps theirs until it expires; the delta drops
+conversations the caller is back in.
+
+A conversation is "changed" for GET /conversations/delta when, after since:
+  - its updated_at moved (membership, title/profile, settings), or
+  - a message was sent in it, or
+  - the caller's receipt there was written (receipts.updated_at), or
+  - a message in it was deleted (messages.deleted_at).
+Changed conversations come back as full list items; snoozed ones are left
+out, as in the list itself. A since older than the tombstone window gets
+410: removals may be gone, so the client has to refetch the whole list.
+
+Deleted messages are the message tombstones: soft-deleted and purged
+messages keep their row with deleted_at, and the delta lists their ids per
+conversation so offline clients can drop them. Conversations the caller
+left come back in removed instead; their messages aren't listed. More than
+maxDeltaDeletions deletions also gets 410 resync. Messages that expire
+(burn after reading) aren't listed; clients have their expires_at.
+*/
+
+// most deleted message ids one delta returns before asking for a resync
+const maxDeltaDeletions = 5000
+
+func deltaWindow() time.Duration {
+	return time.Duration(envInt("DELTA_WINDOW_DAYS", 30)) * 24 * time.Hour
+}
+
+func ensureDeltaIndexes(ctx context.Context, db *mongo.Database) error {
+	c := db.Collection("membership_tombstones")
+	if _, err := c.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "conversation_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "removed_at", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(deltaWindow().Seconds())),
+		},
+	}); err != nil {
+		return err
+	}
+	if _, err := db.Collection("receipts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: 1}},
+	}); err != nil {
+		return err
+	}
+	_, err := db.Collection("messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "conversation_id", Value: 1}, {Key: "deleted_at", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(
+			bson.M{"deleted_at": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+// recordRemoval leaves a tombstone so uid's delta sync learns they left cid.
+func recordRemoval(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID) error {
+	if err := ensureDeltaIndexes(ctx, db); err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err := db.Collection("membership_tombstones").UpdateOne(ctx,
+		bson.M{"conversation_id": cid, "user_id": uid},
+		bson.M{"$set": bson.M{"removed_at": now.UnixMilli(), "created_at": now}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// GET /conversations/delta?since=<ts>
+// Returns: { conversations: [...as in GET /conversations], removed: [cid],
+// deleted_messages: { cid: [mid] }, sync_ts }. Pass sync_ts as since next
+// time. 410 { code: "resync" } when since is older than DELTA_WINDOW_DAYS
+// or too many messages were deleted since.
+func ConversationDeltaHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		since, err := strconv.ParseInt(c.Query("since"), 10, 64)
+		if err != nil || since <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "since (millis) required"})
+			return
+		}
+		// taken first: anything written while we read shows up next time too
+		syncTs := time.Now().UnixMilli()
+		if since < syncTs-deltaWindow().Milliseconds() {
+			c.JSON(http.StatusGone, gin.H{"error": "since is older than the delta window; refetch the full list", "code": "resync"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+		if err := ensureDeltaIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		filter, err := memberConvFilter(ctx, db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		cur, err := db.Collection("conversations").Find(ctx, filter,
+			options.Find().SetProjection(bson.M{"updated_at": 1, "created_at": 1}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		var mine []struct {
+			ID        primitive.ObjectID `bson:"_id"`
+			UpdatedAt int64              `bson:"updated_at"`
+			CreatedAt int64              `bson:"created_at"`
+		}
+		if err := cur.All(ctx, &mine); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+
+		ids := make([]primitive.ObjectID, 0, len(mine))
+		member := make(map[primitive.ObjectID]bool, len(mine))
+		changed := map[primitive.ObjectID]bool{}
+		for _, x := range mine {
+			ids = append(ids, x.ID)
+			member[x.ID] = true
+			if max(x.UpdatedAt, x.CreatedAt) > since {
+				changed[x.ID] = true
+			}
+		}
+
+		// new messages
+		active, err := db.Collection("messages").Distinct(ctx, "conversation_id",
+			bson.M{"conversation_id": bson.M{"$in": ids}, "ts": bson.M{"$gt": since}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		for _, v := range active {
+			if id, ok := v.(primitive.ObjectID); ok {
+				changed[id] = true
+			}
+		}
+
+		// the caller's own read positions
+		read, err := db.Collection("receipts").Distinct(ctx, "conversation_id",
+			bson.M{"user_id": uid, "updated_at": bson.M{"$gt": since}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		for _, v := range read {
+			if id, ok := v.(primitive.ObjectID); ok && member[id] {
+				changed[id] = true
+			}
+		}
+
+		// deleted messages; their conversations' last_msg changed too
+		cur, err = db.Collection("messages").Find(ctx,
+			bson.M{"conversation_id": bson.M{"$in": ids}, "deleted_at": bson.M{"$gt": since}},
+			options.Find().
+				SetProjection(bson.M{"_id": 1, "conversation_id": 1}).
+				SetSort(bson.D{{Key: "deleted_at", Value: 1}}).
+				SetLimit(maxDeltaDeletions+1))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		var dels []struct {
+			ID             primitive.ObjectID `bson:"_id"`
+			ConversationID primitive.ObjectID `bson:"conversation_id"`
+		}
+		if err := cur.All(ctx, &dels); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+		if len(dels) > maxDeltaDeletions {
+			c.JSON(http.StatusGone, gin.H{"error": "too many deletions since; refetch", "code": "resync"})
+			return
+		}
+		deleted := map[string][]string{}
+		for _, d := range dels {
+			k := d.ConversationID.Hex()
+			deleted[k] = append(deleted[k], d.ID.Hex())
+			changed[d.ConversationID] = true
+		}
+
+		gone, err := db.Collection("membership_tombstones").Distinct(ctx, "conversation_id",
+			bson.M{"user_id": uid, "removed_at": bson.M{"$gt": since}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		removed := make([]string, 0, len(gone))
+		for _, v := range gone {
+			if id, ok := v.(primitive.ObjectID); ok && !member[id] {
+				removed = append(removed, id.Hex())
+			}
+		}
+
+		convs := []convListItem{}
+		if len(changed) > 0 {
+			page := convPage{IDs: make([]primitive.ObjectID, 0, len(changed))}
+			for id := range changed {
+				page.IDs = append(page.IDs, id)
+			}
+			convs, _, _, err = listConversations(ctx, db, uid, page)
+			if err != nil {
+				writeSvcError(c, err)
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"conversations":    convs,
+			"removed":          removed,
+			"deleted_messages": deleted,
+			"sync_ts":          syncTs,
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type deltaReply struct {
+	Conversations []convListItem      `json:"conversations"`
+	Removed       []string            `json:"removed"`
+	Deleted       map[string][]string `json:"deleted_messages"`
+	SyncTs        int64               `json:"sync_ts"`
+	Code          string              `json:"code"`
+}
+
+// TestConversationDelta renames one conversation, reads another and
+// removes bob from a third, then checks what each member's delta holds.
+func TestConversationDelta(t *testing.T) {
+	client, db := testDB(t)
+	ann, bob := seedUser(t, db, "ann"), seedUser(t, db, "bob")
+	renamed := seedConv(t, db, "ops", ann, bob)
+	read := seedConv(t, db, "misc", ann, bob)
+	left := seedConv(t, db, "old", ann, bob)
+	seedConv(t, db, "quiet", ann, bob)
+	r, api := testAPI()
+	api.GET("/conversations/delta", ConversationDeltaHandler(client))
+	api.PATCH("/conversations/:cid", RenameConverHandler(client))
+	api.POST("/conversations/:cid/read", MarkReadHandler(client))
+	api.DELETE("/conversations/:cid/members/:uid", RemoveMemberHandler(client))
+
+	delta := func(u User, since int64) deltaReply {
+		t.Helper()
+		w := serve(t, r, http.MethodGet, "/conversations/delta?since="+strconv.FormatInt(since, 10), &u, nil)
+		var d deltaReply
+		decode(t, w, &d)
+		if w.Code != http.StatusOK {
+			t.Fatalf("delta for %s: %d %s", u.Username, w.Code, w.Body)
+		}
+		return d
+	}
+	changed := func(d deltaReply) map[string]string {
+		out := map[string]string{}
+		for _, c := range d.Conversations {
+			out[c.ID.Hex()] = c.Title
+		}
+		return out
+	}
+
+	since := time.Now().UnixMilli()
+	time.Sleep(5 * time.Millisecond)
+	if w := serve(t, r, http.MethodPatch, "/conversations/"+renamed.ID.Hex(), &ann, gin.H{"title": "ops-2"}); w.Code != http.StatusOK {
+		t.Fatalf("rename: %d %s", w.Code, w.Body)
+	}
+	if w := serve(t, r, http.MethodPost, "/conversations/"+read.ID.Hex()+"/read", &bob, nil); w.Code != http.StatusOK {
+		t.Fatalf("read: %d %s", w.Code, w.Body)
+	}
+	if w := serve(t, r, http.MethodDelete, "/conversations/"+left.ID.Hex()+"/members/"+bob.ID.Hex(), &ann, nil); w.Code != http.StatusOK {
+		t.Fatalf("remove: %d %s", w.Code, w.Body)
+	}
+
+	d := delta(bob, since)
+	got := changed(d)
+	if len(got) != 2 || got[renamed.ID.Hex()] != "ops-2" || got[read.ID.Hex()] != "misc" {
+		t.Fatalf("bob's changed conversations: %v", got)
+	}
+	if !slices.Equal(d.Removed, []string{left.ID.Hex()}) {
+		t.Fatalf("bob's removed: %v", d.Removed)
+	}
+
+	// the receipt is bob's alone; ann sees the rename and the removal
+	d = delta(ann, since)
+	got = changed(d)
+	if len(got) != 2 || got[renamed.ID.Hex()] != "ops-2" || got[left.ID.Hex()] != "old" || len(d.Removed) != 0 {
+		t.Fatalf("ann's delta: %v, removed %v", got, d.Removed)
+	}
+
+	// nothing since the last sync
+	if d = delta(ann, d.SyncTs); len(d.Conversations) != 0 || len(d.Removed) != 0 {
+		t.Fatalf("delta from sync_ts: %d conversations, removed %v", len(d.Conversations), d.Removed)
+	}
+
+	old := time.Now().Add(-deltaWindow() - time.Hour).UnixMilli()
+	w := serve(t, r, http.MethodGet, "/conversations/delta?since="+strconv.FormatInt(old, 10), &bob, nil)
+	var gone deltaReply
+	decode(t, w, &gone)
+	if w.Code != http.StatusGone || gone.Code != "resync" {
+		t.Fatalf("since past the window: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  users.dnd (quiet hours; push notifications are not queued while active):
+    - enabled (bool)
+    - start   (string "HH:MM", local)
+    - end     (string "HH:MM", local; before start = runs past midnight)
+    - tz      (string, IANA)
+    - days    ([]string "mon".."sun", the days a window starts; empty = daily)
+Messages and unread counts are unaffected, only the notifications queue.
+*/
+
+type DNDSchedule struct {
+	Enabled bool     `bson:"enabled" json:"enabled"`
+	Start   string   `bson:"start" json:"start"`
+	End     string   `bson:"end" json:"end"`
+	TZ      string   `bson:"tz" json:"tz"`
+	Days    []string `bson:"days" json:"days"`
+}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// clockMinutes parses "HH:MM" into minutes after midnight.
+func clockMinutes(s string) (int, bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+func (d *DNDSchedule) onDay(w time.Weekday) bool {
+	if len(d.Days) == 0 {
+		return true
+	}
+	for _, day := range d.Days {
+		if day == weekdayNames[w] {
+			return true
+		}
+	}
+	return false
+}
+
+// activeAt reports whether the schedule silences notifications at t. A
+// window that crosses midnight belongs to the day it starts on; start ==
+// end means the whole day.
+func (d *DNDSchedule) activeAt(t time.Time) bool {
+	if d == nil || !d.Enabled {
+		return false
+	}
+	start, ok1 := clockMinutes(d.Start)
+	end, ok2 := clockMinutes(d.End)
+	if !ok1 || !ok2 {
+		return false
+	}
+	loc, _ := loadZone(d.TZ)
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	switch {
+	case start == end:
+		return d.onDay(today)
+	case start < end:
+		return d.onDay(today) && now >= start && now < end
+	default: // overnight
+		return (d.onDay(today) && now >= start) || (d.onDay(yesterday) && now < end)
+	}
+}
+
+// dndUsers returns which of uids are in quiet hours right now.
+func dndUsers(ctx context.Context, db *mongo.Database, uids []primitive.ObjectID) (map[primitive.ObjectID]struct{}, error) {
+	out := make(map[primitive.ObjectID]struct{})
+	cur, err := db.Collection("users").Find(ctx,
+		bson.M{"_id": bson.M{"$in": uids}, "dnd.enabled": true},
+		options.Find().SetProjection(bson.M{"dnd": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	now := time.Now()
+	for cur.Next(ctx) {
+		var u struct {
+			ID  primitive.ObjectID `bson:"_id"`
+			DND *DNDSchedule       `bson:"dnd"`
+		}
+		if err := cur.Decode(&u); err != nil {
+			return nil, err
+		}
+		if u.DND.activeAt(now) {
+			out[u.ID] = struct{}{}
+		}
+	}
+	return out, cur.Err()
+}
+
+// GET /me/dnd
+// Returns: { dnd: { enabled, start, end, tz, days }, active }
+func GetDNDHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, err := mustOID(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		var u struct {
+			DND *DNDSchedule `bson:"dnd"`
+		}
+		err = getDB(client).Collection("users").FindOne(ctx, bson.M{"_id": uid},
+			options.FindOne().SetProjection(bson.M{"dnd": 1})).Decode(&u)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if u.DND == nil {
+			u.DND = &DNDSchedule{TZ: "UTC", Days: []string{}}
+		}
+		c.JSON(http.StatusOK, gin.H{"dnd": u.DND, "active": u.DND.activeAt(time.Now())})
+	}
+}
+
+// PUT /me/dnd
+// Body: { "enabled": true, "start": "22:00", "end": "07:00",
+//
+//	"tz": "Europe/Berlin", "days": ["mon", "tue", "wed", "thu", "fri"] }
+func PutDNDHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, err := mustOID(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		var in DNDSchedule
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+			return
+		}
+		if _, ok := clockMinutes(in.Start); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start must be HH:MM"})
+			return
+		}
+		if _, ok := clockMinutes(in.End); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "end must be HH:MM"})
+			return
+		}
+		if in.TZ == "" {
+			in.TZ = "UTC"
+		}
+		if _, ok := loadZone(in.TZ); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown timezone"})
+			return
+		}
+		days := make([]string, 0, len(in.Days))
+		seen := map[string]bool{}
+		for _, d := range in.Days {
+			d = strings.ToLower(strings.TrimSpace(d))
+			if !validWeekday(d) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown day %q (use mon..sun)", d)})
+				return
+			}
+			if !seen[d] {
+				seen[d] = true
+				days = append(days, d)
+			}
+		}
+		in.Days = days
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		if _, err := getDB(client).Collection("users").UpdateByID(ctx, uid,
+			bson.M{"$set": bson.M{"dnd": in}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"dnd": in, "active": in.activeAt(time.Now())})
+	}
+}
+
+func validWeekday(d string) bool {
+	for _, n := range weekdayNames {
+		if d == n {
+			return true
+		}
+	}
+	return false
+}
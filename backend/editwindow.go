@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Edit window: a sender may edit a message (PATCH /messages/:cid/:mid) only
+within MESSAGE_EDIT_WINDOW_SECS of sending it (default 900); later edits
+get 403. MESSAGE_EDIT_WINDOW_SECS=off lifts the limit. With
+EDIT_WINDOW_EXEMPT_STAFF=on, conversation owners and ADMIN_USERS may still
+edit their own messages at any age.
+
+Message reads (GET /messages/:cid and /messages/:cid/:mid) carry
+editable_secs on the caller's own editable messages, so clients know when
+to hide the edit button: the seconds left, 0 once the window has passed,
+or -1 when there is no limit for them. Other messages have none.
+*/
+
+func editWindow() time.Duration {
+	if os.Getenv("MESSAGE_EDIT_WINDOW_SECS") == "off" {
+		return 0
+	}
+	return time.Duration(envInt("MESSAGE_EDIT_WINDOW_SECS", 900)) * time.Second
+}
+
+// editExempt reports whether uid (username uname) may edit past the window
+// in cid.
+func editExempt(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID, uname string) (bool, error) {
+	if editWindow() <= 0 || os.Getenv("EDIT_WINDOW_EXEMPT_STAFF") != "on" {
+		return false, nil
+	}
+	if isAdmin(uname) {
+		return true, nil
+	}
+	role, err := memberRole(ctx, db, cid, uid)
+	return role == "owner", err
+}
+
+// editableSecs is how long m stays editable at now (millis); -1 = no limit.
+func editableSecs(m *Message, now int64, exempt bool) int64 {
+	w := editWindow()
+	if w <= 0 || exempt {
+		return -1
+	}
+	left := m.Ts + w.Milliseconds() - now
+	if left <= 0 {
+		return 0
+	}
+	return (left + 999) / 1000 // round up: 0 means closed
+}
+
+// annotateEditable sets EditableSecs on uid's own editable messages.
+func annotateEditable(msgs []Message, uid primitive.ObjectID, exempt bool) {
+	now := time.Now().UnixMilli()
+	for i := range msgs {
+		m := &msgs[i]
+		// deleted ones are already tombstoned to type "deleted"
+		if m.SenderID != uid || m.Type != "text" {
+			continue
+		}
+		secs := editableSecs(m, now, exempt)
+		m.EditableSecs = &secs
+	}
+}
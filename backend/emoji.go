@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Emoji shortcodes (":thumbsup:").
+
+Message bodies have known shortcodes expanded to unicode at send time
+unless EMOJI_SHORTCODES=off; unknown ones and anything inside `code`
+spans are left alone. Reactions take either the emoji itself or a
+shortcode, and an unknown shortcode is rejected with close matches.
+GET /emoji/shortcodes serves the table for client autocomplete.
+
+Everything resolves through lookupShortcode, so other sources of names
+can be added there.
+*/
+
+// the common GitHub/Slack names; aliases map to the same emoji
+var emojiShortcodes = map[string]string{
+	"+1": "👍", "thumbsup": "👍", "-1": "👎", "thumbsdown": "👎",
+	"ok_hand": "👌", "clap": "👏", "wave": "👋", "raised_hands": "🙌",
+	"pray": "🙏", "muscle": "💪", "point_up": "☝️", "point_down": "👇",
+	"point_left": "👈", "point_right": "👉", "v": "✌️", "crossed_fingers": "🤞",
+	"handshake": "🤝", "fist": "✊", "punch": "👊", "facepunch": "👊",
+	"writing_hand": "✍️", "eyes": "👀", "brain": "🧠",
+
+	"smile": "😄", "smiley": "😃", "grinning": "😀", "grin": "😁",
+	"laughing": "😆", "satisfied": "😆", "sweat_smile": "😅", "joy": "😂",
+	"rofl": "🤣", "slightly_smiling_face": "🙂", "upside_down_face": "🙃",
+	"wink": "😉", "blush": "😊", "innocent": "😇", "heart_eyes": "😍",
+	"star_struck": "🤩", "kissing_heart": "😘", "yum": "😋",
+	"stuck_out_tongue": "😛", "stuck_out_tongue_winking_eye": "😜",
+	"zany_face": "🤪", "hugs": "🤗", "hugging_face": "🤗", "thinking": "🤔",
+	"shushing_face": "🤫", "zipper_mouth_face": "🤐", "raised_eyebrow": "🤨",
+	"neutral_face": "😐", "expressionless": "😑", "no_mouth": "😶",
+	"smirk": "😏", "unamused": "😒", "roll_eyes": "🙄", "grimacing": "😬",
+	"relieved": "😌", "pensive": "😔", "sleepy": "😪", "sleeping": "😴",
+	"mask": "😷", "nerd_face": "🤓", "sunglasses": "😎", "confused": "😕",
+	"worried": "😟", "slightly_frowning_face": "🙁", "frowning_face": "☹️",
+	"open_mouth": "😮", "hushed": "😯", "astonished": "😲", "flushed": "😳",
+	"pleading_face": "🥺", "cry": "😢", "sob": "😭", "scream": "😱",
+	"confounded": "😖", "persevere": "😣", "disappointed": "😞",
+	"sweat": "😓", "weary": "😩", "tired_face": "😫", "yawning_face": "🥱",
+	"triumph": "😤", "rage": "😡", "angry": "😠", "exploding_head": "🤯",
+	"partying_face": "🥳", "skull": "💀", "poop": "💩", "clown_face": "🤡",
+	"ghost": "👻", "alien": "👽", "robot": "🤖", "see_no_evil": "🙈",
+	"hear_no_evil": "🙉", "speak_no_evil": "🙊", "facepalm": "🤦",
+	"shrug": "🤷",
+
+	"heart": "❤️", "orange_heart": "🧡", "yellow_heart": "💛",
+	"green_heart": "💚", "blue_heart": "💙", "purple_heart": "💜",
+	"black_heart": "🖤", "white_heart": "🤍", "broken_heart": "💔",
+	"two_hearts": "💕", "sparkling_heart": "💖", "heartpulse": "💗",
+	"100": "💯", "fire": "🔥", "sparkles": "✨", "star": "⭐", "star2": "🌟",
+	"zap": "⚡", "boom": "💥", "collision": "💥", "tada": "🎉",
+	"confetti_ball": "🎊", "balloon": "🎈", "gift": "🎁", "trophy": "🏆",
+	"medal_sports": "🏅", "1st_place_medal": "🥇", "rocket": "🚀",
+	"dart": "🎯", "bulb": "💡", "bell": "🔔", "no_bell": "🔕",
+	"mega": "📣", "loudspeaker": "📢", "speech_balloon": "💬",
+	"thought_balloon": "💭", "zzz": "💤", "wave_dash": "〰️",
+
+	"white_check_mark": "✅", "heavy_check_mark": "✔️", "check": "✔️",
+	"x": "❌", "negative_squared_cross_mark": "❎", "warning": "⚠️",
+	"no_entry": "⛔", "no_entry_sign": "🚫", "question": "❓",
+	"grey_question": "❔", "exclamation": "❗", "heavy_exclamation_mark": "❗",
+	"bangbang": "‼️", "interrobang": "⁉️", "red_circle": "🔴",
+	"large_blue_circle": "🔵", "green_circle": "🟢", "yellow_circle": "🟡",
+	"arrow_up": "⬆️", "arrow_down": "⬇️", "arrow_left": "⬅️",
+	"arrow_right": "➡️", "repeat": "🔁", "lock": "🔒", "unlock": "🔓",
+	"key": "🔑", "link": "🔗", "pushpin": "📌", "paperclip": "📎",
+	"memo": "📝", "pencil": "📝", "calendar": "📆", "date": "📅",
+	"clock": "🕒", "hourglass": "⌛", "stopwatch": "⏱️", "alarm_clock": "⏰",
+	"email": "📧", "envelope": "✉️", "inbox_tray": "📥", "outbox_tray": "📤",
+	"package": "📦", "chart_with_upwards_trend": "📈",
+	"chart_with_downwards_trend": "📉", "bar_chart": "📊",
+	"clipboard": "📋", "file_folder": "📁", "mag": "🔍", "wrench": "🔧",
+	"hammer": "🔨", "gear": "⚙️", "bug": "🐛", "computer": "💻",
+	"iphone": "📱", "phone": "☎️", "camera": "📷", "books": "📚",
+	"money_with_wings": "💸", "moneybag": "💰", "shield": "🛡️",
+
+	"coffee": "☕", "tea": "🍵", "beer": "🍺", "beers": "🍻",
+	"wine_glass": "🍷", "champagne": "🍾", "pizza": "🍕", "hamburger": "🍔",
+	"taco": "🌮", "cake": "🍰", "birthday": "🎂", "cookie": "🍪",
+	"doughnut": "🍩", "apple": "🍎", "banana": "🍌", "popcorn": "🍿",
+
+	"sunny": "☀️", "cloud": "☁️", "umbrella": "☔", "snowflake": "❄️",
+	"rainbow": "🌈", "earth_americas": "🌎", "moon": "🌙", "seedling": "🌱",
+	"evergreen_tree": "🌲", "cactus": "🌵", "rose": "🌹", "sunflower": "🌻",
+	"tulip": "🌷", "four_leaf_clover": "🍀", "dog": "🐶", "cat": "🐱",
+	"mouse": "🐭", "rabbit": "🐰", "fox_face": "🦊", "bear": "🐻",
+	"panda_face": "🐼", "monkey_face": "🐵", "unicorn": "🦄", "bee": "🐝",
+	"turtle": "🐢", "snake": "🐍", "octopus": "🐙", "whale": "🐳",
+	"dolphin": "🐬", "fish": "🐟", "penguin": "🐧", "chicken": "🐔",
+	"owl": "🦉", "sloth": "🦥", "crab": "🦀", "snail": "🐌",
+}
+
+// lookupShortcode resolves a shortcode name (without colons).
+func lookupShortcode(name string) (string, bool) {
+	e, ok := emojiShortcodes[strings.ToLower(name)]
+	return e, ok
+}
+
+func emojiExpandEnabled() bool {
+	return os.Getenv("EMOJI_SHORTCODES") != "off"
+}
+
+func isShortcodeChar(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' ||
+		b == '_' || b == '+' || b == '-'
+}
+
+// expandShortcodes replaces known :name: shortcodes in s with their emoji.
+// Text between backticks is copied as is.
+func expandShortcodes(s string) string {
+	if !strings.Contains(s, ":") {
+		return s
+	}
+	var sb strings.Builder
+	code := false
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '`':
+			code = !code
+		case s[i] == ':' && !code:
+			j := i + 1
+			for j < len(s) && j-i <= 40 && isShortcodeChar(s[j]) {
+				j++
+			}
+			if j < len(s) && j > i+1 && s[j] == ':' {
+				if e, ok := lookupShortcode(s[i+1 : j]); ok {
+					sb.WriteString(e)
+					i = j + 1
+					continue
+				}
+			}
+		}
+		sb.WriteByte(s[i])
+		i++
+	}
+	return sb.String()
+}
+
+// shortcodeSuggestions returns up to five known names close to name:
+// sharing a prefix or within two edits.
+func shortcodeSuggestions(name string) []string {
+	name = strings.ToLower(name)
+	type cand struct {
+		name string
+		dist int
+	}
+	var cs []cand
+	for k := range emojiShortcodes {
+		d := editDistance(name, k)
+		if strings.HasPrefix(k, name) || strings.HasPrefix(name, k) {
+			d = min(d, 1)
+		}
+		if d <= 2 {
+			cs = append(cs, cand{k, d})
+		}
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].dist != cs[j].dist {
+			return cs[i].dist < cs[j].dist
+		}
+		return cs[i].name < cs[j].name
+	})
+	out := make([]string, 0, 5)
+	for _, c := range cs {
+		if len(out) == 5 {
+			break
+		}
+		out = append(out, ":"+c.name+":")
+	}
+	return out
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+var shortcodeDoc = sync.OnceValues(func() ([]byte, string) {
+	body, _ := json.Marshal(gin.H{"shortcodes": emojiShortcodes})
+	sum := sha1.Sum(body)
+	return body, `"` + hex.EncodeToString(sum[:]) + `"`
+})
+
+// GET /emoji/shortcodes
+// Returns: { shortcodes: { "thumbsup": "👍", ... } }; ETag/If-None-Match.
+func EmojiShortcodesHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, etag := shortcodeDoc()
+		c.Header("Cache-Control", "public, max-age=86400")
+		c.Header("ETag", etag)
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestExpandShortcodes(t *testing.T) {
+	for in, want := range map[string]string{
+		"ship it :thumbsup:":           "ship it 👍",
+		":FIRE: and :+1:":              "🔥 and 👍",
+		":nope: stays":                 ":nope: stays",
+		"at 12:30:fire:":               "at 12:30🔥",
+		"::fire::":                     ":🔥:",
+		"run `:fire:` then :fire:":     "run `:fire:` then 🔥",
+		"`a` :fire: `b :heart: c`":     "`a` 🔥 `b :heart: c`",
+		"```\nx := :fire:\n```\n:100:": "```\nx := :fire:\n```\n💯",
+		"unclosed `:fire: :heart:":     "unclosed `:fire: :heart:",
+	} {
+		if got := expandShortcodes(in); got != want {
+			t.Errorf("expandShortcodes(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
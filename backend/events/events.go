@@ -0,0 +1,66 @@
+// Package events defines the realtime events the server pushes to clients
+// over WebSockets, Socket.IO, widget SSE and gRPC: the envelope, the type
+// names and one payload struct per type.
+//
+// Build events with New so the type always matches the payload and the
+// envelope carries SchemaVersion. Field names here are the wire format;
+// renaming one is a breaking change for clients and needs a SchemaVersion
+// bump.
+package events
+
+// SchemaVersion is sent as schema_version on every event. Bump it when a
+// payload changes incompatibly (a field renamed, removed or retyped);
+// adding an optional field doesn't need it.
+const SchemaVersion = 1
+
+// Event is one frame as clients see it.
+type Event struct {
+	ID             string  `json:"event_id,omitempty"`
+	Type           string  `json:"type"`
+	SchemaVersion  int     `json:"schema_version"`
+	ConversationID string  `json:"conversation_id"`
+	Payload        Payload `json:"payload,omitempty"`
+	// per-socket numbering for resume; set on enqueue
+	StreamSeq int64 `json:"stream_seq,omitempty"`
+}
+
+// Payload is implemented by every payload struct; EventType is the "type"
+// it is sent under.
+type Payload interface {
+	EventType() string
+}
+
+// New wraps p for conversation cid (hex; "" for events stamped per room).
+func New(cid string, p Payload) Event {
+	return Event{
+		Type:           p.EventType(),
+		SchemaVersion:  SchemaVersion,
+		ConversationID: cid,
+		Payload:        p,
+	}
+}
+
+// type names
+const (
+	TypeHello               = "hello"
+	TypeBatch               = "batch"
+	TypeMaintenance         = "maintenance"
+	TypeMessageCreated      = "message.created"
+	TypeMessageUpdated      = "message.updated"
+	TypeMessageDeleted      = "message.deleted"
+	TypeMessagesPurged      = "messages.purged"
+	TypeMessagesImported    = "messages.imported"
+	TypeReceiptUpdated      = "receipt.updated"
+	TypeReceiptDelivered    = "receipt.delivered"
+	TypePinsUpdated         = "pins.updated"
+	TypeMessagePinned       = "message.pinned"
+	TypeMessageUnpinned     = "message.unpinned"
+	TypeConversationUpdated = "conversation.updated"
+	TypeConversationViewing = "conversation.viewing"
+	TypeReactionAdded       = "reaction.added"
+	TypeReactionRemoved     = "reaction.removed"
+	TypeReaction            = "reaction"
+	TypeUserUpdated         = "user.updated"
+	TypeMemberAdded         = "member.added"
+	TypeMemberRemoved       = "member.removed"
+)
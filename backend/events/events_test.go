@@ -0,0 +1,180 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// The JSON below is the wire format clients parse. If one of these fails
+// because a field was renamed, removed or retyped, that is a breaking
+// change: bump SchemaVersion and update the pinned output together.
+
+const cid = "65f0c0ffee0000000000000a"
+
+func strp(s string) *string { return &s }
+func boolp(b bool) *bool    { return &b }
+func intp(n int) *int       { return &n }
+
+func TestSchemaVersion(t *testing.T) {
+	if SchemaVersion != 1 {
+		t.Fatalf("SchemaVersion = %d; update the pinned payloads in this file along with it", SchemaVersion)
+	}
+	got, err := json.Marshal(New(cid, MessageDeleted{ID: "m1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"message.deleted","schema_version":1,"conversation_id":"` + cid + `","payload":{"id":"m1"}}`
+	if string(got) != want {
+		t.Fatalf("envelope:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestEnvelopeOptionalFields(t *testing.T) {
+	e := New("", MessageDeleted{ID: "m1"})
+	e.ID = "ev1"
+	e.StreamSeq = 7
+	got, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"event_id":"ev1","type":"message.deleted","schema_version":1,"conversation_id":"","payload":{"id":"m1"},"stream_seq":7}`
+	if string(got) != want {
+		t.Fatalf("envelope:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestPayloadJSON(t *testing.T) {
+	pin := Pin{MessageID: "m1", PinnedBy: "u1", PinnedAt: 1700000000000}
+	reaction := Reaction{MessageID: "m1", UserID: "u1", Emoji: "👍"}
+
+	tests := []struct {
+		payload Payload
+		typ     string
+		json    string
+	}{
+		{Hello{Version: "dev", Features: map[string]bool{"widgets": true}, ResumeToken: "r1"}, TypeHello,
+			`{"version":"dev","features":{"widgets":true},"resume_token":"r1","resumed":false}`},
+		{Batch{Events: []Event{New(cid, MessageDeleted{ID: "m1"})}}, TypeBatch,
+			`{"events":[{"type":"message.deleted","schema_version":1,"conversation_id":"` + cid + `","payload":{"id":"m1"}}]}`},
+		{Maintenance{Enabled: true, Message: "upgrading"}, TypeMaintenance,
+			`{"enabled":true,"message":"upgrading"}`},
+		{Maintenance{}, TypeMaintenance,
+			`{"enabled":false}`},
+		{MessageCreated{ID: "m1", SenderID: "u1", Type: "text", Body: "hi", Ts: 1, Seq: 2, ServerTime: 3}, TypeMessageCreated,
+			`{"id":"m1","sender_id":"u1","type":"text","body":"hi","ts":1,"seq":2,"server_time":3}`},
+		{MessageCreated{
+			ID: "m1", SenderID: "u1", Type: "image", Body: "a1", Ts: 1, Seq: 2, ServerTime: 3,
+			ExpiresAt: 4, ReplyTo: "m0", Mentions: []string{"u2"},
+			ForwardedFrom: &ForwardRef{ConversationID: "c2", MessageID: "m9"},
+			SplitTo:       "c3", Priority: "urgent", StickerID: "s1", Width: 640, Height: 480,
+		}, TypeMessageCreated,
+			`{"id":"m1","sender_id":"u1","type":"image","body":"a1","ts":1,"seq":2,"server_time":3,"expires_at":4,"reply_to":"m0","mentions":["u2"],"forwarded_from":{"conversation_id":"c2","message_id":"m9"},"split_to":"c3","priority":"urgent","sticker_id":"s1","width":640,"height":480}`},
+		{MessageCreated{ID: "m1", SenderID: "u1", Type: "text", Ts: 1, Seq: 2, ServerTime: 3, BodyLen: 90000, Truncated: true}, TypeMessageCreated,
+			`{"id":"m1","sender_id":"u1","type":"text","ts":1,"seq":2,"server_time":3,"body_len":90000,"truncated":true}`},
+		{MessageCreated{ID: "m1", SenderID: "u1", Type: "summary", Ts: 1, Seq: 2, ServerTime: 3, Card: &SummaryCard{
+			Week: "2026-W41", From: 10, To: 20, Messages: 5,
+			TopMembers: []SummaryMember{{UserID: "u1", Username: "ann", Messages: 5}},
+			BusiestDay: &SummaryDay{Day: "2026-10-06", Messages: 3},
+		}}, TypeMessageCreated,
+			`{"id":"m1","sender_id":"u1","type":"summary","ts":1,"seq":2,"server_time":3,"card":{"week":"2026-W41","from":10,"to":20,"messages":5,"top_members":[{"user_id":"u1","username":"ann","messages":5}],"busiest_day":{"day":"2026-10-06","messages":3}}}`},
+		{MessageUpdated{ID: "m1", Body: "hi!", EditedAt: 5}, TypeMessageUpdated,
+			`{"id":"m1","body":"hi!","edited_at":5}`},
+		{MessageUpdated{ID: "m1", EditedAt: 5, BodyLen: 90000, Truncated: true}, TypeMessageUpdated,
+			`{"id":"m1","edited_at":5,"body_len":90000,"truncated":true}`},
+		{MessageUpdated{ID: "m1", Body: "a1", AttachmentInfected: true}, TypeMessageUpdated,
+			`{"id":"m1","body":"a1","edited_at":0,"attachment_infected":true}`},
+		{MessageDeleted{ID: "m1"}, TypeMessageDeleted,
+			`{"id":"m1"}`},
+		{MessagesPurged{IDs: []string{"m1", "m2"}}, TypeMessagesPurged,
+			`{"ids":["m1","m2"]}`},
+		{MessagesImported{Count: 12}, TypeMessagesImported,
+			`{"count":12}`},
+		{ReceiptUpdated{UserID: "u1", LastReadTS: 9}, TypeReceiptUpdated,
+			`{"user_id":"u1","last_read_ts":9}`},
+		{ReceiptDelivered{UserID: "u1", LastDeliveredTS: 9}, TypeReceiptDelivered,
+			`{"user_id":"u1","last_delivered_ts":9}`},
+		{MessagePinned(pin), TypeMessagePinned,
+			`{"message_id":"m1","pinned_by":"u1","pinned_at":1700000000000}`},
+		{MessageUnpinned{MessageID: "m1", UnpinnedBy: "u2"}, TypeMessageUnpinned,
+			`{"message_id":"m1","unpinned_by":"u2"}`},
+		{PinsUpdated{Pins: []Pin{pin}}, TypePinsUpdated,
+			`{"pins":[{"message_id":"m1","pinned_by":"u1","pinned_at":1700000000000}]}`},
+		{ConversationUpdated{Title: strp("ops")}, TypeConversationUpdated,
+			`{"title":"ops"}`},
+		{ConversationUpdated{
+			Title: strp(""), Description: strp("d"), AvatarURL: strp("https://x/a.png"),
+			ReceiptsEnabled: boolp(false), SlowModeSecs: intp(0), WeeklySummary: boolp(true),
+			FloodGuard: &FloodGuard{Active: true, Mode: "slow", Until: 30, SlowModeSecs: 10},
+		}, TypeConversationUpdated,
+			`{"title":"","description":"d","avatar_url":"https://x/a.png","receipts_enabled":false,"slow_mode_secs":0,"weekly_summary":true,"flood_guard":{"active":true,"mode":"slow","until":30,"slow_mode_secs":10}}`},
+		{ConversationUpdated{FloodGuard: &FloodGuard{}}, TypeConversationUpdated,
+			`{"flood_guard":{"active":false}}`},
+		{ConversationViewing{UserIDs: []string{"u1"}}, TypeConversationViewing,
+			`{"user_ids":["u1"]}`},
+		{ReactionAdded(reaction), TypeReactionAdded,
+			`{"message_id":"m1","user_id":"u1","emoji":"👍"}`},
+		{ReactionRemoved(reaction), TypeReactionRemoved,
+			`{"message_id":"m1","user_id":"u1","emoji":"👍"}`},
+		{ReactionNotice(reaction), TypeReaction,
+			`{"message_id":"m1","user_id":"u1","emoji":"👍"}`},
+		{UserUpdated{UserID: "u1", Username: "ann"}, TypeUserUpdated,
+			`{"user_id":"u1","username":"ann"}`},
+		{MemberAdded{UserID: "u1", Role: "member"}, TypeMemberAdded,
+			`{"user_id":"u1","role":"member"}`},
+		{MemberRemoved{UserID: "u1"}, TypeMemberRemoved,
+			`{"user_id":"u1"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			if got := tt.payload.EventType(); got != tt.typ {
+				t.Fatalf("EventType() = %q, want %q", got, tt.typ)
+			}
+			got, err := json.Marshal(tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.json {
+				t.Fatalf("payload:\n got %s\nwant %s", got, tt.json)
+			}
+
+			env, err := json.Marshal(New(cid, tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := `{"type":"` + tt.typ + `","schema_version":1,"conversation_id":"` + cid + `","payload":` + tt.json + `}`
+			if string(env) != want {
+				t.Fatalf("envelope:\n got %s\nwant %s", env, want)
+			}
+		})
+	}
+}
+
+// Every type name has exactly one payload type.
+func TestEveryTypePinned(t *testing.T) {
+	all := []string{
+		TypeHello, TypeBatch, TypeMaintenance, TypeMessageCreated, TypeMessageUpdated,
+		TypeMessageDeleted, TypeMessagesPurged, TypeMessagesImported, TypeReceiptUpdated,
+		TypeReceiptDelivered, TypePinsUpdated, TypeMessagePinned, TypeMessageUnpinned,
+		TypeConversationUpdated, TypeConversationViewing, TypeReactionAdded,
+		TypeReactionRemoved, TypeReaction, TypeUserUpdated, TypeMemberAdded, TypeMemberRemoved,
+	}
+	seen := map[string]bool{}
+	for _, p := range []Payload{
+		Hello{}, Batch{}, Maintenance{}, MessageCreated{}, MessageUpdated{}, MessageDeleted{},
+		MessagesPurged{}, MessagesImported{}, ReceiptUpdated{}, ReceiptDelivered{}, PinsUpdated{},
+		MessagePinned{}, MessageUnpinned{}, ConversationUpdated{}, ConversationViewing{},
+		ReactionAdded{}, ReactionRemoved{}, ReactionNotice{}, UserUpdated{}, MemberAdded{},
+		MemberRemoved{},
+	} {
+		seen[p.EventType()] = true
+	}
+	for _, typ := range all {
+		if !seen[typ] {
+			t.Errorf("no payload for %q", typ)
+		}
+	}
+	if len(seen) != len(all) {
+		t.Errorf("%d payload types for %d type names", len(seen), len(all))
+	}
+}
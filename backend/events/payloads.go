@@ -0,0 +1,198 @@
+package events
+
+// Ids are hex strings and times are millis throughout.
+
+// Hello is the first frame on every socket.
+type Hello struct {
+	Version     string          `json:"version"`
+	Features    map[string]bool `json:"features"`
+	ResumeToken string          `json:"resume_token"`
+	Resumed     bool            `json:"resumed"`
+}
+
+// Batch carries events coalesced for a ?batch_ms socket.
+type Batch struct {
+	Events []Event `json:"events"`
+}
+
+// Maintenance is sent to every open room when read-only mode flips.
+type Maintenance struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
+
+type ForwardRef struct {
+	ConversationID string `json:"conversation_id"`
+	MessageID      string `json:"message_id"`
+}
+
+// MessageCreated is a new message. Body is left out (Truncated set) when
+// it is over the inline limit.
+type MessageCreated struct {
+	ID            string       `json:"id"`
+	SenderID      string       `json:"sender_id"`
+	Type          string       `json:"type"`
+	Body          string       `json:"body,omitempty"`
+	Ts            int64        `json:"ts"`
+	Seq           int64        `json:"seq"`
+	ServerTime    int64        `json:"server_time"`
+	ExpiresAt     int64        `json:"expires_at,omitempty"`
+	ReplyTo       string       `json:"reply_to,omitempty"`
+	Mentions      []string     `json:"mentions,omitempty"`
+	ForwardedFrom *ForwardRef  `json:"forwarded_from,omitempty"`
+	SplitTo       string       `json:"split_to,omitempty"`
+	Priority      string       `json:"priority,omitempty"`
+	StickerID     string       `json:"sticker_id,omitempty"`
+	Width         int          `json:"width,omitempty"`
+	Height        int          `json:"height,omitempty"`
+	BodyLen       int          `json:"body_len,omitempty"`
+	Truncated     bool         `json:"truncated,omitempty"`
+	Card          *SummaryCard `json:"card,omitempty"`
+}
+
+// SummaryCard is the structured part of a weekly "summary" message.
+type SummaryCard struct {
+	Week       string          `json:"week"`
+	From       int64           `json:"from"`
+	To         int64           `json:"to"`
+	Messages   int64           `json:"messages"`
+	TopMembers []SummaryMember `json:"top_members"`
+	BusiestDay *SummaryDay     `json:"busiest_day,omitempty"`
+}
+
+type SummaryMember struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username,omitempty"`
+	Messages int64  `json:"messages"`
+}
+
+type SummaryDay struct {
+	Day      string `json:"day"`
+	Messages int64  `json:"messages"`
+}
+
+// MessageUpdated is an edit by the sender, or an image message whose file
+// failed its malware scan; Body as in MessageCreated.
+type MessageUpdated struct {
+	ID                 string `json:"id"`
+	Body               string `json:"body,omitempty"`
+	EditedAt           int64  `json:"edited_at"`
+	BodyLen            int    `json:"body_len,omitempty"`
+	Truncated          bool   `json:"truncated,omitempty"`
+	AttachmentInfected bool   `json:"attachment_infected,omitempty"`
+}
+
+type MessageDeleted struct {
+	ID string `json:"id"`
+}
+
+type MessagesPurged struct {
+	IDs []string `json:"ids"`
+}
+
+type MessagesImported struct {
+	Count int64 `json:"count"`
+}
+
+type ReceiptUpdated struct {
+	UserID     string `json:"user_id"`
+	LastReadTS int64  `json:"last_read_ts"`
+}
+
+type ReceiptDelivered struct {
+	UserID          string `json:"user_id"`
+	LastDeliveredTS int64  `json:"last_delivered_ts"`
+}
+
+type Pin struct {
+	MessageID string `json:"message_id"`
+	PinnedBy  string `json:"pinned_by"`
+	PinnedAt  int64  `json:"pinned_at"`
+}
+
+// MessagePinned is the one pin just added; pins.updated follows with the
+// whole list.
+type MessagePinned Pin
+
+type MessageUnpinned struct {
+	MessageID  string `json:"message_id"`
+	UnpinnedBy string `json:"unpinned_by"`
+}
+
+// PinsUpdated is the whole pin list after a change.
+type PinsUpdated struct {
+	Pins []Pin `json:"pins"`
+}
+
+// ConversationUpdated carries only what changed; nil fields are left out.
+type ConversationUpdated struct {
+	Title           *string     `json:"title,omitempty"`
+	Description     *string     `json:"description,omitempty"`
+	AvatarURL       *string     `json:"avatar_url,omitempty"`
+	ReceiptsEnabled *bool       `json:"receipts_enabled,omitempty"`
+	SlowModeSecs    *int        `json:"slow_mode_secs,omitempty"`
+	WeeklySummary   *bool       `json:"weekly_summary,omitempty"`
+	FloodGuard      *FloodGuard `json:"flood_guard,omitempty"`
+}
+
+// FloodGuard is the flood breaker's state; only Active when it resets.
+type FloodGuard struct {
+	Active       bool   `json:"active"`
+	Mode         string `json:"mode,omitempty"`
+	Until        int64  `json:"until,omitempty"`
+	SlowModeSecs int    `json:"slow_mode_secs,omitempty"`
+}
+
+type ConversationViewing struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+// Reaction is shared by reaction.added, reaction.removed and the author's
+// "reaction" notice, which is also stored as a notification payload.
+type Reaction struct {
+	MessageID string `json:"message_id" bson:"message_id"`
+	UserID    string `json:"user_id"    bson:"user_id"`
+	Emoji     string `json:"emoji"      bson:"emoji"`
+}
+
+type (
+	ReactionAdded   Reaction
+	ReactionRemoved Reaction
+	ReactionNotice  Reaction
+)
+
+type UserUpdated struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+}
+
+type MemberAdded struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+type MemberRemoved struct {
+	UserID string `json:"user_id"`
+}
+
+func (Hello) EventType() string               { return TypeHello }
+func (Batch) EventType() string               { return TypeBatch }
+func (Maintenance) EventType() string         { return TypeMaintenance }
+func (MessageCreated) EventType() string      { return TypeMessageCreated }
+func (MessageUpdated) EventType() string      { return TypeMessageUpdated }
+func (MessageDeleted) EventType() string      { return TypeMessageDeleted }
+func (MessagesPurged) EventType() string      { return TypeMessagesPurged }
+func (MessagesImported) EventType() string    { return TypeMessagesImported }
+func (ReceiptUpdated) EventType() string      { return TypeReceiptUpdated }
+func (ReceiptDelivered) EventType() string    { return TypeReceiptDelivered }
+func (MessagePinned) EventType() string       { return TypeMessagePinned }
+func (MessageUnpinned) EventType() string     { return TypeMessageUnpinned }
+func (PinsUpdated) EventType() string         { return TypePinsUpdated }
+func (ConversationUpdated) EventType() string { return TypeConversationUpdated }
+func (ConversationViewing) EventType() string { return TypeConversationViewing }
+func (ReactionAdded) EventType() string       { return TypeReactionAdded }
+func (ReactionRemoved) EventType() string     { return TypeReactionRemoved }
+func (ReactionNotice) EventType() string      { return TypeReaction }
+func (UserUpdated) EventType() string         { return TypeUserUpdated }
+func (MemberAdded) EventType() string         { return TypeMemberAdded }
+func (MemberRemoved) EventType() string       { return TypeMemberRemoved }
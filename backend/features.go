@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Feature flags.
+
+Precedence (last wins):
+  1. built-in default (flagDefaults)
+  2. env FEATURE_<NAME> (e.g. FEATURE_MAINTENANCE=true)
+  3. Mongo override: settings { _id: "features", flags: { "<name>": bool } }
+
+Mongo overrides are cached for featureTTL, so an admin change applies
+within that window without a restart.
+*/
+
+type Flag string
+
+const (
+	FlagMaintenance Flag = "maintenance"
+	FlagWidgets     Flag = "widgets"
+	FlagSocketIO    Flag = "socketio"
+)
+
+var flagDefaults = map[Flag]bool{
+	FlagMaintenance: false,
+	FlagWidgets:     true,
+	FlagSocketIO:    false,
+}
+
+const featureTTL = 10 * time.Second
+
+type featureSet struct {
+	mu        sync.RWMutex
+	client    *mongo.Client
+	overrides map[Flag]bool
+	loadedAt  time.Time
+}
+
+// global feature flags, wired to Mongo by initFeatures
+var features = &featureSet{}
+
+func initFeatures(client *mongo.Client) {
+	features.mu.Lock()
+	features.client = client
+	features.loadedAt = time.Time{}
+	features.mu.Unlock()
+}
+
+func knownFlag(name string) bool {
+	_, ok := flagDefaults[Flag(name)]
+	return ok
+}
+
+func envFlag(f Flag) (bool, bool) {
+	v := os.Getenv("FEATURE_" + strings.ToUpper(string(f)))
+	if v == "" {
+		return false, false
+	}
+	b, err := strconv.ParseBool(v)
+	return b, err == nil
+}
+
+// refresh reloads Mongo overrides once the cache is stale. On error the
+// previous overrides stay in effect.
+func (f *featureSet) refresh() {
+	f.mu.RLock()
+	fresh := time.Since(f.loadedAt) < featureTTL
+	client := f.client
+	f.mu.RUnlock()
+	if fresh || client == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var doc struct {
+		Flags map[string]bool `bson:"flags"`
+	}
+	err := getDB(client).Collection("settings").FindOne(ctx, bson.M{"_id": "features"}).Decode(&doc)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loadedAt = time.Now()
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return
+	}
+	overrides := make(map[Flag]bool, len(doc.Flags))
+	for k, v := range doc.Flags {
+		if knownFlag(k) {
+			overrides[Flag(k)] = v
+		}
+	}
+	f.overrides = overrides
+}
+
+// Enabled reports the effective value of flag.
+func (f *featureSet) Enabled(flag Flag) bool {
+	f.refresh()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if v, ok := f.overrides[flag]; ok {
+		return v
+	}
+	if v, ok := envFlag(flag); ok {
+		return v
+	}
+	return flagDefaults[flag]
+}
+
+// All returns every known flag with its effective value.
+func (f *featureSet) All() map[Flag]bool {
+	out := make(map[Flag]bool, len(flagDefaults))
+	for flag := range flagDefaults {
+		out[flag] = f.Enabled(flag)
+	}
+	return out
+}
+
+// GET /admin/features
+func GetFeaturesHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		features.refresh()
+		features.mu.RLock()
+		overrides := make(map[Flag]bool, len(features.overrides))
+		for k, v := range features.overrides {
+			overrides[k] = v
+		}
+		features.mu.RUnlock()
+		c.JSON(http.StatusOK, gin.H{"flags": features.All(), "overrides": overrides})
+	}
+}
+
+// PUT /admin/features
+// Body: { "flags": { "maintenance": true, "widgets": null } }
+// null removes the override and falls back to env/default.
+func PutFeaturesHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Flags map[string]*bool `json:"flags"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil || len(in.Flags) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "flags required"})
+			return
+		}
+
+		set := bson.M{}
+		unset := bson.M{}
+		for name, v := range in.Flags {
+			if !knownFlag(name) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown flag: " + name})
+				return
+			}
+			if v == nil {
+				unset["flags."+name] = ""
+			} else {
+				set["flags."+name] = *v
+			}
+		}
+		update := bson.M{}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		_, err := getDB(client).Collection("settings").UpdateOne(ctx,
+			bson.M{"_id": "features"}, update, options.Update().SetUpsert(true))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		// apply locally right away; other instances pick it up within featureTTL
+		features.mu.Lock()
+		features.loadedAt = time.Time{}
+		features.mu.Unlock()
+		c.JSON(http.StatusOK, gin.H{"flags": features.All()})
+	}
+}
+
+// RequireFeature 404s the route while flag is off.
+func RequireFeature(flag Flag) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !features.Enabled(flag) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "feature disabled"})
+			return
+		}
+		c.Next()
+	}
+}
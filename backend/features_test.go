@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// useFeatures points the global flags at client with nothing cached.
+func useFeatures(t *testing.T, client *mongo.Client) {
+	t.Helper()
+	old := features
+	features = &featureSet{client: client}
+	t.Cleanup(func() { features = old })
+}
+
+func TestFeaturePrecedence(t *testing.T) {
+	client, _ := testDB(t)
+	useFeatures(t, client)
+	r := gin.New()
+	r.PUT("/admin/features", PutFeaturesHandler(client))
+	put := func(flags gin.H) {
+		t.Helper()
+		if w := serve(t, r, http.MethodPut, "/admin/features", nil, gin.H{"flags": flags}); w.Code != http.StatusOK {
+			t.Fatalf("put %v: %d %s", flags, w.Code, w.Body)
+		}
+	}
+	check := func(step string, flag Flag, want bool) {
+		t.Helper()
+		if got := features.Enabled(flag); got != want {
+			t.Fatalf("%s: %s = %v, want %v", step, flag, got, want)
+		}
+	}
+
+	check("default", FlagMaintenance, false)
+	t.Setenv("FEATURE_MAINTENANCE", "true")
+	check("env over default", FlagMaintenance, true)
+	put(gin.H{"maintenance": false})
+	check("override over env", FlagMaintenance, false)
+	put(gin.H{"maintenance": nil})
+	check("override removed", FlagMaintenance, true)
+
+	t.Setenv("FEATURE_WIDGETS", "maybe")
+	check("unparsable env", FlagWidgets, true)
+	t.Setenv("FEATURE_WIDGETS", "0")
+	check("env off", FlagWidgets, false)
+	put(gin.H{"widgets": true})
+	check("override on", FlagWidgets, true)
+}
+
+func TestPutFeaturesRejectsUnknownFlags(t *testing.T) {
+	client, db := testDB(t)
+	useFeatures(t, client)
+	r := gin.New()
+	r.PUT("/admin/features", PutFeaturesHandler(client))
+
+	for _, tt := range []struct {
+		body any
+		err  string
+	}{
+		{gin.H{"flags": gin.H{"maintenance": true, "darkmode": true}}, "unknown flag: darkmode"},
+		{gin.H{"flags": gin.H{"Maintenance": true}}, "unknown flag: Maintenance"},
+		{gin.H{"flags": gin.H{}}, "flags required"},
+		{[]byte("{"), "flags required"},
+	} {
+		w := serve(t, r, http.MethodPut, "/admin/features", nil, tt.body)
+		var out struct {
+			Error string `json:"error"`
+		}
+		decode(t, w, &out)
+		if w.Code != http.StatusBadRequest || out.Error != tt.err {
+			t.Errorf("%v: %d %q, want 400 %q", tt.body, w.Code, out.Error, tt.err)
+		}
+	}
+	// a rejected body writes nothing, not even its known flags
+	if n := countDocs(t, db, "settings", bson.M{}); n != 0 {
+		t.Fatalf("%d settings documents after rejected puts", n)
+	}
+	if features.Enabled(FlagMaintenance) {
+		t.Fatal("maintenance on after a rejected put")
+	}
+}
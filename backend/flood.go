@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Flood breaker: trips when a conversation gets more than FLOOD_MSGS_PER_SEC
+messages per second, averaged over FLOOD_WINDOW_SECS (default 5). Off unless
+FLOOD_MSGS_PER_SEC > 0. While tripped (FLOOD_COOLDOWN_SECS, default 60):
+  - FLOOD_MODE=slow (default): members get FLOOD_SLOW_SECS (default 10) of
+    slow mode on top of whatever the conversation has configured
+  - FLOOD_MODE=reject: member sends fail with 429 until the cooldown ends
+Owners and exempt senders (sendlimits.go) are exempt either way. State is in memory, per process; the breaker
+resets on its own once the cooldown passes. Trips land in audit_log as
+"conversation.flood" and are announced with conversation.updated.
+*/
+
+type floodBreaker struct {
+	mu       sync.Mutex
+	rate     int
+	counter  *windowLimiter
+	window   time.Duration
+	cooldown time.Duration
+	reject   bool
+	slowSecs int
+	open     map[primitive.ObjectID]time.Time // cid -> cooldown end
+}
+
+var floodGuard = newFloodBreaker(
+	envInt("FLOOD_MSGS_PER_SEC", 0),
+	time.Duration(envInt("FLOOD_WINDOW_SECS", 5))*time.Second,
+	time.Duration(envInt("FLOOD_COOLDOWN_SECS", 60))*time.Second,
+	os.Getenv("FLOOD_MODE") == "reject",
+	envInt("FLOOD_SLOW_SECS", 10),
+)
+
+func newFloodBreaker(rate int, window, cooldown time.Duration, reject bool, slowSecs int) *floodBreaker {
+	return &floodBreaker{
+		rate:     rate,
+		counter:  newWindowLimiter(rate*int(window/time.Second), window),
+		window:   window,
+		cooldown: cooldown,
+		reject:   reject,
+		slowSecs: slowSecs,
+		open:     make(map[primitive.ObjectID]time.Time),
+	}
+}
+
+func (f *floodBreaker) enabled() bool { return f != nil && f.rate > 0 }
+
+func (f *floodBreaker) mode() string {
+	if f.reject {
+		return "reject"
+	}
+	return "slow"
+}
+
+// remaining is how much of cid's cooldown is left (0 = not tripped).
+func (f *floodBreaker) remaining(cid primitive.ObjectID) time.Duration {
+	if !f.enabled() {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	until, ok := f.open[cid]
+	if !ok {
+		return 0
+	}
+	left := time.Until(until)
+	if left <= 0 {
+		delete(f.open, cid)
+		return 0
+	}
+	return left
+}
+
+// slowModeSecs is the slow mode the breaker imposes on cid right now.
+func (f *floodBreaker) slowModeSecs(cid primitive.ObjectID) int {
+	if f.reject || f.remaining(cid) == 0 {
+		return 0
+	}
+	return f.slowSecs
+}
+
+// blocked is how long member sends to cid are refused outright (reject mode).
+func (f *floodBreaker) blocked(cid primitive.ObjectID) time.Duration {
+	if !f.reject {
+		return 0
+	}
+	return f.remaining(cid)
+}
+
+// admit counts one member send to cid and returns how long the sender must
+// wait instead (0 = go ahead). The send that trips the breaker is refused.
+func (f *floodBreaker) admit(db *mongo.Database, cid primitive.ObjectID) time.Duration {
+	if !f.enabled() {
+		return 0
+	}
+	if wait := f.blocked(cid); wait > 0 {
+		return wait
+	}
+	if f.counter.Allow(cid.Hex()) {
+		return 0
+	}
+
+	f.mu.Lock()
+	if until, ok := f.open[cid]; ok && time.Now().Before(until) {
+		// already tripped (slow mode); slowModeWait does the gating
+		f.mu.Unlock()
+		return 0
+	}
+	until := time.Now().Add(f.cooldown)
+	f.open[cid] = until
+	f.mu.Unlock()
+
+	f.announce(db, cid, until)
+	time.AfterFunc(f.cooldown, func() { f.reset(cid) })
+	if f.reject {
+		return f.cooldown
+	}
+	return time.Duration(f.slowSecs) * time.Second
+}
+
+func (f *floodBreaker) announce(db *mongo.Database, cid primitive.ObjectID, until time.Time) {
+	fmt.Println("flood breaker tripped:", cid.Hex(), "mode", f.mode(), "until", until.Format(time.RFC3339))
+
+	status := &events.FloodGuard{Active: true, Mode: f.mode(), Until: until.UnixMilli()}
+	if !f.reject {
+		status.SlowModeSecs = f.slowSecs
+	}
+	broadcaster.Publish(events.New(cid.Hex(), events.ConversationUpdated{FloodGuard: status}))
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		writeAudit(ctx, db, AuditEntry{
+			Action:         "conversation.flood",
+			ConversationID: cid,
+			Details: gin.H{
+				"msgs_per_sec":  f.rate,
+				"window_secs":   int(f.window / time.Second),
+				"cooldown_secs": int(f.cooldown / time.Second),
+				"mode":          f.mode(),
+			},
+		})
+	}()
+}
+
+// reset closes the breaker once the cooldown is over, unless it was
+// re-tripped in the meantime.
+func (f *floodBreaker) reset(cid primitive.ObjectID) {
+	f.mu.Lock()
+	until, ok := f.open[cid]
+	if ok && time.Now().Before(until) {
+		f.mu.Unlock()
+		return
+	}
+	delete(f.open, cid)
+	f.mu.Unlock()
+
+	broadcaster.Publish(events.New(cid.Hex(), events.ConversationUpdated{FloodGuard: &events.FloodGuard{}}))
+}
+
+// floodWait wraps admit as a send error. Owners and exempt senders
+// (sendlimits.go) neither count nor wait.
+func floodWait(db *mongo.Database, cid primitive.ObjectID, role string, lim sendLimit) error {
+	if role == "owner" || lim.Exempt {
+		return nil
+	}
+	wait := floodGuard.admit(db, cid)
+	if wait <= 0 {
+		return nil
+	}
+	return rateLimited("conversation is flooded, try again later",
+		rateLimit{Limit: floodGuard.counter.limit, Reset: wait})
+}
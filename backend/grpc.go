@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"backend/pb"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+/*
+Optional gRPC API for server-to-server integrations (pb/im.proto).
+
+Enabled by GRPC_ADDR (e.g. ":9090"); off when unset. Calls authenticate with
+metadata, either
+  authorization: Bearer <jwt>      same validation as the HTTP API
+  x-api-key: <key>                 BOT_API_KEYS="key1:username1,key2:username2:notify"
+and act as that user. The optional third part of a bot key lists scopes
+("+" separated); notify lets SendMessage take "x-priority: high" metadata
+(see priority.go). Bot calls that name a conversation are recorded in
+integration_logs (integrations.go). Handlers delegate to the same helpers as HTTP
+(sendMessage, listMessages, listConversations) so behavior stays identical.
+*/
+
+type grpcUIDKey struct{}
+
+type grpcScopesKey struct{}
+
+type grpcBotKey struct{}
+
+type botKey struct {
+	username string
+	scopes   map[string]bool
+}
+
+type imServer struct {
+	pb.UnimplementedIMServer
+	client *mongo.Client
+}
+
+// startGRPC serves the gRPC API in the background when GRPC_ADDR is set.
+func startGRPC(client *mongo.Client) {
+	addr := strings.TrimSpace(os.Getenv("GRPC_ADDR"))
+	if addr == "" {
+		return
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Println("grpc listen error:", err)
+		return
+	}
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcUnaryAuth(client)),
+		grpc.StreamInterceptor(grpcStreamAuth(client)),
+	)
+	pb.RegisterIMServer(srv, &imServer{client: client})
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			fmt.Println("grpc serve error:", err)
+		}
+	}()
+}
+
+// botKeys parses BOT_API_KEYS into key -> bot user and scopes.
+func botKeys() map[string]botKey {
+	out := map[string]botKey{}
+	for _, entry := range strings.Split(os.Getenv("BOT_API_KEYS"), ",") {
+		k, rest, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		u, scopes, _ := strings.Cut(rest, ":")
+		if ok && k != "" && u != "" {
+			b := botKey{username: normalizeUsername(u), scopes: map[string]bool{}}
+			for _, sc := range strings.Split(scopes, "+") {
+				if sc = strings.TrimSpace(sc); sc != "" {
+					b.scopes[sc] = true
+				}
+			}
+			out[k] = b
+		}
+	}
+	return out
+}
+
+// grpcAuth resolves the calling user from request metadata and returns ctx
+// carrying the uid and, for bots, their scopes.
+func grpcAuth(ctx context.Context, client *mongo.Client) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("authorization"); len(v) > 0 && strings.HasPrefix(v[0], "Bearer ") {
+		cl, err := parseToken(v[0][7:])
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		uid, err := mustOID(cl.UserID)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		return context.WithValue(ctx, grpcUIDKey{}, uid), nil
+	}
+	if v := md.Get("x-api-key"); len(v) > 0 && v[0] != "" {
+		for key, bot := range botKeys() {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(v[0])) != 1 {
+				continue
+			}
+			lctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			ids, err := resolveUsernames(lctx, getDB(client), []string{bot.username})
+			if err != nil || len(ids) != 1 {
+				return nil, status.Error(codes.Unauthenticated, "bot user not found")
+			}
+			ctx = context.WithValue(ctx, grpcUIDKey{}, ids[0])
+			ctx = context.WithValue(ctx, grpcBotKey{}, bot.username)
+			return context.WithValue(ctx, grpcScopesKey{}, bot.scopes), nil
+		}
+		return nil, status.Error(codes.Unauthenticated, "invalid api key")
+	}
+	return nil, status.Error(codes.Unauthenticated, "missing credentials")
+}
+
+func grpcUnaryAuth(client *mongo.Client) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		actx, err := grpcAuth(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		resp, err := handler(actx, req)
+		logBotCall(actx, getDB(client), info.FullMethod, req, err, time.Since(start))
+		return resp, err
+	}
+}
+
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context { return s.ctx }
+
+func grpcStreamAuth(client *mongo.Client) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		actx, err := grpcAuth(ss.Context(), client)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authedStream{ss, actx})
+	}
+}
+
+func grpcUID(ctx context.Context) primitive.ObjectID {
+	uid, _ := ctx.Value(grpcUIDKey{}).(primitive.ObjectID)
+	return uid
+}
+
+// grpcHasScope reports whether the calling bot key carries scope.
+func grpcHasScope(ctx context.Context, scope string) bool {
+	scopes, _ := ctx.Value(grpcScopesKey{}).(map[string]bool)
+	return scopes[scope]
+}
+
+// grpcMeta returns the first value of a request metadata key, or "".
+func grpcMeta(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// logBotCall writes an integration log entry for a bot call scoped to one
+// conversation; other callers and requests are skipped.
+func logBotCall(ctx context.Context, db *mongo.Database, method string, req interface{}, err error, took time.Duration) {
+	bot, _ := ctx.Value(grpcBotKey{}).(string)
+	r, ok := req.(interface{ GetConversationId() string })
+	if bot == "" || !ok {
+		return
+	}
+	cid, perr := mustOID(r.GetConversationId())
+	if perr != nil {
+		return
+	}
+	e := IntegrationLog{
+		ConversationID: cid,
+		IntegrationID:  "bot:" + bot,
+		Kind:           "bot",
+		Action:         method[strings.LastIndex(method, "/")+1:],
+		Status:         grpcHTTPStatus(status.Code(err)),
+		LatencyMS:      took.Milliseconds(),
+	}
+	if err != nil {
+		e.Error = status.Convert(err).Message()
+	}
+	logIntegration(db, e)
+}
+
+// grpcHTTPStatus is the reverse of grpcErr, for logs shared with HTTP
+// integrations.
+func grpcHTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// grpcErr maps helper errors (svcError carries an HTTP status) to gRPC codes.
+func grpcErr(err error) error {
+	var se *svcError
+	if !errors.As(err, &se) {
+		return status.Error(codes.Internal, "db error")
+	}
+	code := codes.Internal
+	switch se.Status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	return status.Error(code, se.Msg)
+}
+
+func toPBMessage(m Message) *pb.Message {
+	out := &pb.Message{
+		Id:             m.ID.Hex(),
+		ConversationId: m.ConversationID.Hex(),
+		SenderId:       m.SenderID.Hex(),
+		Type:           m.Type,
+		Body:           m.Body,
+		Ts:             m.Ts,
+		ExpiresAt:      m.ExpiresAt,
+	}
+	if m.ReplyTo != nil {
+		out.ReplyTo = m.ReplyTo.Hex()
+	}
+	for _, id := range m.Mentions {
+		out.Mentions = append(out.Mentions, id.Hex())
+	}
+	return out
+}
+
+func (s *imServer) SendMessage(ctx context.Context, req *pb.SendMessageRequest) (*pb.Message, error) {
+	if features.Enabled(FlagMaintenance) {
+		return nil, status.Error(codes.Unavailable, maintenanceMessage())
+	}
+	cid, err := mustOID(req.GetConversationId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid conversation id")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	msg, _, err := sendMessage(ctx, getDB(s.client), grpcUID(ctx), cid, sendInput{
+		Type:      req.GetType(),
+		Body:      req.GetBody(),
+		ExpiresIn: req.GetExpiresInSeconds(),
+		ReplyTo:   req.GetReplyTo(),
+		Mentions:  req.GetMentions(),
+		// not in SendMessageRequest yet; bots pass them as metadata
+		Priority:    grpcMeta(ctx, "x-priority"),
+		StickerID:   grpcMeta(ctx, "x-sticker-id"),
+		NotifyScope: grpcHasScope(ctx, "notify"),
+	})
+	if err != nil {
+		return nil, grpcErr(err)
+	}
+	return toPBMessage(msg), nil
+}
+
+func (s *imServer) ListMessages(ctx context.Context, req *pb.ListMessagesRequest) (*pb.ListMessagesResponse, error) {
+	cid, err := mustOID(req.GetConversationId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid conversation id")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	msgs, err := listMessages(ctx, getDB(s.client), grpcUID(ctx), cid, msgQuery{
+		Limit:  int(req.GetLimit()),
+		Since:  req.GetSince(),
+		Before: req.GetBefore(),
+	})
+	if err != nil {
+		return nil, grpcErr(err)
+	}
+	out := &pb.ListMessagesResponse{Messages: make([]*pb.Message, 0, len(msgs))}
+	for _, m := range msgs {
+		out.Messages = append(out.Messages, toPBMessage(m))
+	}
+	return out, nil
+}
+
+func (s *imServer) ListConversations(ctx context.Context, _ *pb.ListConversationsRequest) (*pb.ListConversationsResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	convs, _, _, err := listConversations(ctx, getDB(s.client), grpcUID(ctx), convPage{})
+	if err != nil {
+		return nil, grpcErr(err)
+	}
+	out := &pb.ListConversationsResponse{Conversations: make([]*pb.Conversation, 0, len(convs))}
+	for _, x := range convs {
+		pc := &pb.Conversation{
+			Id:          x.ID.Hex(),
+			Title:       x.Title,
+			CreatedAt:   x.CreatedAt,
+			MemberCount: x.Count,
+			IsDm:        x.IsDM,
+			Unread:      x.Unread,
+		}
+		if lm := x.LastMsg; lm != nil {
+			pc.LastMessage = &pb.Message{
+				Id:             lm.ID.Hex(),
+				ConversationId: x.ID.Hex(),
+				SenderId:       lm.SenderID.Hex(),
+				Type:           lm.Type,
+				Body:           lm.Body,
+				Ts:             lm.Ts,
+			}
+		}
+		out.Conversations = append(out.Conversations, pc)
+	}
+	return out, nil
+}
+
+func (s *imServer) SubscribeEvents(req *pb.SubscribeEventsRequest, stream pb.IM_SubscribeEventsServer) error {
+	ctx := stream.Context()
+	uid := grpcUID(ctx)
+	db := getDB(s.client)
+
+	cids, err := s.subscriptionTargets(ctx, db, uid, req.GetConversationIds())
+	if err != nil {
+		return err
+	}
+
+	// fan every conversation's feed into one channel; a feed is closed
+	// when uid is removed from its conversation (CloseUserInRoom)
+	out := make(chan Event, 64)
+	removed := make(chan struct{}, len(cids))
+	for _, cid := range cids {
+		ch := broadcaster.SubscribeAs(uid, cid)
+		defer broadcaster.Unsubscribe(cid, ch)
+		go func(ch chan Event) {
+			for {
+				select {
+				case e, ok := <-ch:
+					if !ok {
+						removed <- struct{}{}
+						return
+					}
+					select {
+					case out <- e:
+					default: // slow consumer: drop, like the websocket feeds
+						wsMetrics.dropped.Add(1)
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ch)
+	}
+
+	for left := len(cids); ; {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-removed:
+			if left--; left == 0 {
+				return status.Error(codes.PermissionDenied, "removed from conversation")
+			}
+		case e := <-out:
+			payload, err := json.Marshal(e.Payload)
+			if err != nil {
+				continue
+			}
+			if err := stream.Send(&pb.Event{
+				Type:           e.Type,
+				ConversationId: e.ConversationID,
+				PayloadJson:    string(payload),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// subscriptionTargets validates requested conversation ids, or returns all
+// of uid's conversations when none were given.
+func (s *imServer) subscriptionTargets(ctx context.Context, db *mongo.Database, uid primitive.ObjectID, hexIDs []string) ([]primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if len(hexIDs) == 0 {
+		filter, err := memberConvFilter(ctx, db, uid)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "db error")
+		}
+		raw, err := db.Collection("conversations").Distinct(ctx, "_id", filter)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "db error")
+		}
+		out := make([]primitive.ObjectID, 0, len(raw))
+		for _, v := range raw {
+			if id, ok := v.(primitive.ObjectID); ok {
+				out = append(out, id)
+			}
+		}
+		return out, nil
+	}
+
+	out := make([]primitive.ObjectID, 0, len(hexIDs))
+	for _, h := range hexIDs {
+		cid, err := mustOID(h)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid conversation id")
+		}
+		ok, err := isMember(ctx, db, cid, uid)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "db error")
+		}
+		if !ok {
+			return nil, status.Error(codes.PermissionDenied, "not a member: "+h)
+		}
+		out = append(out, cid)
+	}
+	return out, nil
+}
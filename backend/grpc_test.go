@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"backend/events"
+	"backend/pb"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testGRPC serves the gRPC API in memory and returns a client for it.
+func testGRPC(t *testing.T, client *mongo.Client) pb.IMClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcUnaryAuth(client)),
+		grpc.StreamInterceptor(grpcStreamAuth(client)),
+	)
+	pb.RegisterIMServer(srv, &imServer{client: client})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewIMClient(conn)
+}
+
+func TestSubscribeEventsStopsAfterRemoval(t *testing.T) {
+	client, db := testDB(t)
+	owner := seedUser(t, db, "owner")
+	member := seedUser(t, db, "member")
+	conv := seedConv(t, db, "ops", owner, member)
+	cid := conv.ID.Hex()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+tokenFor(t, member))
+	stream, err := testGRPC(t, client).SubscribeEvents(ctx, &pb.SubscribeEventsRequest{ConversationIds: []string{cid}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the subscription is in place once an event gets through
+	got := make(chan *pb.Event, 16)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			e, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			got <- e
+		}
+	}()
+	for subscribed := false; !subscribed; {
+		broadcaster.Publish(events.New(cid, events.MessageDeleted{ID: "before"}))
+		select {
+		case <-got:
+			subscribed = true
+		case err := <-recvErr:
+			t.Fatalf("stream ended before removal: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	for len(got) > 0 {
+		<-got
+	}
+
+	r, api := testAPI()
+	api.DELETE("/conversations/:cid/members/:uid", RemoveMemberHandler(client))
+	if w := serve(t, r, http.MethodDelete, "/conversations/"+cid+"/members/"+member.ID.Hex(), &owner, nil); w.Code != http.StatusOK {
+		t.Fatalf("remove member: %d %s", w.Code, w.Body)
+	}
+	broadcaster.Publish(events.New(cid, events.MessageDeleted{ID: "after"}))
+
+	select {
+	case e := <-got:
+		t.Fatalf("removed member got %s %s", e.Type, e.PayloadJson)
+	case err := <-recvErr:
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("stream ended with %v, want PermissionDenied", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("stream still open after removal")
+	}
+	select {
+	case e := <-got:
+		t.Fatalf("removed member got %s %s", e.Type, e.PayloadJson)
+	default:
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Tests that need MongoDB go through testDB and are skipped unless
+MONGO_TEST_URI names a server they may create and drop databases on:
+
+  MONGO_TEST_URI=mongodb://localhost:27017 go test ./...
+
+Each such test gets a database of its own, which getDB returns for the
+length of the test, so handlers can be called as they are in main.go.
+*/
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+var testMongo struct {
+	once   sync.Once
+	client *mongo.Client
+	err    error
+}
+
+// testClient connects to MONGO_TEST_URI, once per run unless mon is given,
+// in which case the client is t's own and reports its commands to mon.
+func testClient(t *testing.T, mon *event.CommandMonitor) *mongo.Client {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	connect := func() (*mongo.Client, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMonitor(mon))
+		if err != nil {
+			return nil, err
+		}
+		return client, client.Ping(ctx, nil)
+	}
+	if mon != nil {
+		client, err := connect()
+		if err != nil {
+			t.Fatalf("mongo: %v", err)
+		}
+		t.Cleanup(func() { _ = client.Disconnect(context.Background()) })
+		return client
+	}
+	testMongo.once.Do(func() { testMongo.client, testMongo.err = connect() })
+	if testMongo.err != nil {
+		t.Fatalf("mongo: %v", testMongo.err)
+	}
+	return testMongo.client
+}
+
+// testDB points getDB at a fresh database for t and drops it afterwards.
+func testDB(t *testing.T) (*mongo.Client, *mongo.Database) {
+	t.Helper()
+	client := testClient(t, nil)
+	return client, useTestDB(t, client)
+}
+
+func useTestDB(t *testing.T, client *mongo.Client) *mongo.Database {
+	t.Helper()
+	name := "test_" + primitive.NewObjectID().Hex()
+	t.Setenv("MONGO_DB", name)
+	db := client.Database(name)
+	t.Cleanup(func() { _ = db.Drop(context.Background()) })
+	return db
+}
+
+func testCtx(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// seedUser inserts a user named name.
+func seedUser(t *testing.T, db *mongo.Database, name string) User {
+	t.Helper()
+	u := User{ID: primitive.NewObjectID(), Username: name, Display: name, CreatedAt: time.Now().UnixMilli()}
+	if _, err := db.Collection("users").InsertOne(testCtx(t), u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// seedConv inserts a conversation owned by owner with the others as
+// members, in the embedded layout.
+func seedConv(t *testing.T, db *mongo.Database, title string, owner User, others ...User) Conversation {
+	t.Helper()
+	now := time.Now().UnixMilli()
+	conv := Conversation{
+		ID:        primitive.NewObjectID(),
+		Title:     title,
+		Members:   []Member{{UserID: owner.ID, Role: "owner"}},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, u := range others {
+		conv.Members = append(conv.Members, Member{UserID: u.ID, Role: "member"})
+	}
+	if _, err := db.Collection("conversations").InsertOne(testCtx(t), conv); err != nil {
+		t.Fatal(err)
+	}
+	return conv
+}
+
+func tokenFor(t *testing.T, u User) string {
+	t.Helper()
+	tok, err := signJWT(u, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+// serve sends one request to h as u (nil for none), with body as JSON
+// unless it is already a []byte.
+func serve(t *testing.T, h http.Handler, method, path string, u *User, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		r = bytes.NewReader(b)
+	default:
+		buf, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(buf)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if u != nil {
+		req.Header.Set("Authorization", "Bearer "+tokenFor(t, *u))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// decode unmarshals a response body into v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+}
+
+// testAPI is the authenticated route group of main.go, for registering the
+// handlers under test.
+func testAPI() (*gin.Engine, *gin.RouterGroup) {
+	r := gin.New()
+	return r, r.Group("/", AuthRequired())
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  idempotency:
+    - user_id      (ObjectId)
+    - key          (string, Idempotency-Key header)
+    - route        (string, "METHOD /path")
+    - done         (bool)
+    - status       (int)
+    - content_type (string)
+    - body         (binary)
+    - created_at   (date, TTL 24h)
+Unique index on (user_id, key)
+*/
+
+type idemRecord struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `bson:"user_id"`
+	Key         string             `bson:"key"`
+	Route       string             `bson:"route"`
+	Done        bool               `bson:"done"`
+	Status      int                `bson:"status"`
+	ContentType string             `bson:"content_type"`
+	Body        []byte             `bson:"body"`
+	CreatedAt   time.Time          `bson:"created_at"`
+}
+
+const idemTTL = 24 * time.Hour
+
+func ensureIdemIndexes(ctx context.Context, db *mongo.Database) error {
+	c := db.Collection("idempotency")
+	if _, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+	_, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(idemTTL.Seconds())),
+	})
+	return err
+}
+
+// recordingWriter tees the response body so it can be stored for replay.
+type recordingWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.buf.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotent replays the stored response when a client repeats a request
+// with the same Idempotency-Key. Requests without the header pass through.
+// Must run after AuthRequired.
+func Idempotent(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 128 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key too long"})
+			return
+		}
+		uid, err := mustOID(c.GetString("uid"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		route := c.Request.Method + " " + c.Request.URL.Path
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+		coll := db.Collection("idempotency")
+		_ = ensureIdemIndexes(ctx, db)
+
+		// claim the key; the unique index serializes concurrent first attempts
+		_, err = coll.InsertOne(ctx, idemRecord{UserID: uid, Key: key, Route: route, CreatedAt: time.Now()})
+		if mongo.IsDuplicateKeyError(err) {
+			replayIdempotent(c, ctx, coll, uid, key, route)
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		rw := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = rw
+		c.Next()
+
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		filter := bson.M{"user_id": uid, "key": key}
+		if c.Writer.Status() >= 500 {
+			// let the client retry for real
+			_, _ = coll.DeleteOne(ctx, filter)
+			return
+		}
+		_, _ = coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+			"done":         true,
+			"status":       c.Writer.Status(),
+			"content_type": c.Writer.Header().Get("Content-Type"),
+			"body":         rw.buf.Bytes(),
+		}})
+	}
+}
+
+// replayIdempotent waits briefly for the first request to finish, then
+// writes its stored response.
+func replayIdempotent(c *gin.Context, ctx context.Context, coll *mongo.Collection, uid primitive.ObjectID, key, route string) {
+	for i := 0; i < 20; i++ {
+		var rec idemRecord
+		err := coll.FindOne(ctx, bson.M{"user_id": uid, "key": key}).Decode(&rec)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// first attempt failed and released the key
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "previous attempt failed, retry"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if rec.Route != route {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key reused on a different request"})
+			return
+		}
+		if rec.Done {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(rec.Status, rec.ContentType, rec.Body)
+			c.Abort()
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "request with this Idempotency-Key still in progress"})
+}
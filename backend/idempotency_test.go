@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIdempotentConcurrentCreate(t *testing.T) {
+	client, db := testDB(t)
+	ann := seedUser(t, db, "ann")
+	seedUser(t, db, "bob")
+	seedUser(t, db, "cat")
+
+	r, api := testAPI()
+	api.POST("/conversations", Idempotent(client), CreateConverHandler(client))
+	token := tokenFor(t, ann)
+
+	const n = 8
+	var (
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+		res   [n]*httptest.ResponseRecorder
+	)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/conversations", bytes.NewReader([]byte(`{"title":"ops","members":["bob","cat"]}`)))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("Idempotency-Key", "create-ops")
+			res[i] = httptest.NewRecorder()
+			<-start
+			r.ServeHTTP(res[i], req)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	var first struct {
+		ID string `json:"id"`
+	}
+	replayed := 0
+	for i, w := range res {
+		if w.Code != http.StatusCreated {
+			t.Fatalf("request %d: %d %s", i, w.Code, w.Body)
+		}
+		var got struct {
+			ID string `json:"id"`
+		}
+		decode(t, w, &got)
+		if first.ID == "" {
+			first = got
+		} else if got.ID != first.ID {
+			t.Fatalf("request %d created %s, another %s", i, got.ID, first.ID)
+		}
+		if w.Header().Get("Idempotent-Replayed") == "true" {
+			replayed++
+		}
+	}
+	if replayed != n-1 {
+		t.Fatalf("%d of %d responses replayed, want %d", replayed, n, n-1)
+	}
+	count, err := db.Collection("conversations").CountDocuments(testCtx(t), bson.M{"title": "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("%d conversations created, want 1", count)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Image messages. An image is uploaded first, as an attachment of the
+conversation it is meant for (attachments.go), then sent as a message of
+type "image" whose body is the attachment id:
+
+  POST /uploads            multipart: file=<image>, conversation_id=<cid>
+  POST /messages/:cid      { "type": "image", "body": "<attachment id>" }
+  GET  /files/:id          the bytes, to members (and watchers) of the
+                           attachment's conversation
+
+Only PNG, JPEG, WebP and GIF are taken, recognised by their bytes rather
+than the client's Content-Type, up to IMAGE_MAX_MB (default 10). Width
+and height are read at upload, kept on the attachment and copied onto
+the message, so message.created carries them and clients can reserve
+the space before the image loads.
+
+Uploads are scanned for malware in the background (scanner.go). GET
+/files/:id answers 403 for a file found infected, and 423 for one not yet
+scanned if SCAN_BLOCK_PENDING=true; an infected upload can't be sent.
+*/
+
+const maxUploadFilename = 255
+
+var imageContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+	"image/gif":  true,
+}
+
+func maxImageBytes() int64 {
+	return int64(envInt("IMAGE_MAX_MB", 10)) << 20
+}
+
+// imageSize reads the dimensions from the header of an image of type ct.
+func imageSize(r io.Reader, ct string) (int, int, error) {
+	if ct == "image/webp" {
+		return webpSize(r)
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	return cfg.Width, cfg.Height, err
+}
+
+// webpSize reads a WebP's canvas size from its first chunk (the standard
+// library has no WebP decoder).
+func webpSize(r io.Reader) (int, int, error) {
+	var h [30]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, 0, err
+	}
+	if string(h[0:4]) != "RIFF" || string(h[8:12]) != "WEBP" {
+		return 0, 0, errors.New("not a webp")
+	}
+	switch string(h[12:16]) {
+	case "VP8 ": // lossy: after the 3-byte frame tag and 9d 01 2a
+		if !bytes.Equal(h[23:26], []byte{0x9d, 0x01, 0x2a}) {
+			return 0, 0, errors.New("bad VP8 frame")
+		}
+		return int(binary.LittleEndian.Uint16(h[26:28]) & 0x3fff),
+			int(binary.LittleEndian.Uint16(h[28:30]) & 0x3fff), nil
+	case "VP8L": // lossless: 0x2f, then 14 bits each of width-1, height-1
+		if h[20] != 0x2f {
+			return 0, 0, errors.New("bad VP8L header")
+		}
+		bits := binary.LittleEndian.Uint32(h[21:25])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	case "VP8X": // extended: 24 bits each of canvas width-1, height-1
+		w := uint32(h[24]) | uint32(h[25])<<8 | uint32(h[26])<<16
+		ht := uint32(h[27]) | uint32(h[28])<<8 | uint32(h[29])<<16
+		return int(w) + 1, int(ht) + 1, nil
+	}
+	return 0, 0, errors.New("unknown webp chunk")
+}
+
+// imageAttachment loads attachment id of cid for an image message.
+func imageAttachment(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, id string) (Attachment, error) {
+	aid, err := mustOID(id)
+	if err != nil {
+		return Attachment{}, svcFail(http.StatusBadRequest, "image body must be an upload id")
+	}
+	var a Attachment
+	err = db.Collection("attachments").FindOne(ctx, bson.M{"_id": aid, "conversation_id": cid}).Decode(&a)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Attachment{}, svcFail(http.StatusBadRequest, "no such upload in this conversation")
+	}
+	if err != nil {
+		return Attachment{}, err
+	}
+	if !imageContentTypes[a.ContentType] {
+		return Attachment{}, svcFail(http.StatusBadRequest, "upload is not an image")
+	}
+	if a.ScanStatus == ScanInfected {
+		return Attachment{}, svcFail(http.StatusForbidden, "file failed malware scan")
+	}
+	return a, nil
+}
+
+// POST /uploads (multipart: file, conversation_id; members only)
+// Returns: { id, conversation_id, filename, content_type, size, width, height, ... }
+// 413 over IMAGE_MAX_MB, 415 for anything but png/jpeg/webp/gif.
+func UploadImageHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.PostForm("conversation_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		fh, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file required"})
+			return
+		}
+		if limit := maxImageBytes(); fh.Size > limit {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("images are limited to %d MB", limit>>20)})
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unreadable file"})
+			return
+		}
+		defer f.Close()
+
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(f, head)
+		ct := http.DetectContentType(head[:n])
+		if !imageContentTypes[ct] {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "only png, jpeg, webp and gif images"})
+			return
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "read error"})
+			return
+		}
+		w, h, err := imageSize(f, ct)
+		if err != nil || w <= 0 || h <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unreadable image"})
+			return
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "read error"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		member, err := isMember(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !member {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+
+		name := filepath.Base(fh.Filename)
+		if len(name) > maxUploadFilename {
+			name = name[:maxUploadFilename]
+		}
+		a, err := putAttachment(ctx, db, Attachment{
+			ConversationID: cid,
+			UploaderID:     uid,
+			Filename:       name,
+			ContentType:    ct,
+			Width:          w,
+			Height:         h,
+		}, f)
+		if err != nil {
+			writeSvcError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, a)
+	}
+}
+
+// GET /files/:id
+// Streams an attachment to members (and watchers) of its conversation.
+// 403 once it has failed its malware scan; 423 while it waits for one if
+// SCAN_BLOCK_PENDING=true.
+func GetFileHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		id, err := mustOID(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		var a Attachment
+		err = db.Collection("attachments").FindOne(ctx, bson.M{"_id": id}).Decode(&a)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		ok, err := canRead(ctx, db, a.ConversationID, uid, "files.get")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !ok {
+			// same answer as a missing file: ids don't leak what exists
+			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+			return
+		}
+		if code, msg, ok := scanGate(a.ScanStatus); !ok {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		bucket, err := attachmentBucket(ctx, db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		stream, err := bucket.OpenDownloadStream(a.BlobID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "file unavailable"})
+			return
+		}
+		defer stream.Close()
+		c.DataFromReader(http.StatusOK, a.Size, a.ContentType, stream, map[string]string{
+			"Cache-Control":          "private, max-age=86400",
+			"X-Content-Type-Options": "nosniff",
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxImportBatch = 1000
+
+// oldest ts an import may carry (2000-01-01); anything earlier is a unit bug
+const minImportTs = 946684800000
+
+func ensureImportIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "conversation_id", Value: 1}, {Key: "import_key", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"import_key": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+type importMsg struct {
+	ImportKey string `json:"import_key"`
+	SenderID  string `json:"sender_id"`
+	Type      string `json:"type"`
+	Body      string `json:"body"`
+	Ts        int64  `json:"ts"`
+}
+
+// POST /admin/conversations/:cid/import (admin only)
+// Body: { "messages": [{ "import_key": "slack:123", "sender_id": "<uid>",
+//
+//	"type": "text", "body": "...", "ts": 1712345678901 }, ...],
+//	"residency": "eu" }   (max 1000)
+//
+// residency is the source's tag and must equal the conversation's (both
+// empty when untagged), else 409 residency_mismatch (residency.go).
+// Writes history with its original timestamps in one unordered bulk write,
+// oldest first. Messages whose import_key is already stored (or repeated in
+// the batch) are skipped, so a migration can be re-run. No per-message
+// events; one messages.imported tells open clients to refetch.
+func ImportMessagesHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		var in struct {
+			Messages []importMsg `json:"messages"`
+			// where the source data lives; must match the conversation
+			Residency string `json:"residency"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil || len(in.Messages) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "messages required"})
+			return
+		}
+		if len(in.Messages) > maxImportBatch {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at most " + strconv.Itoa(maxImportBatch) + " messages per batch"})
+			return
+		}
+
+		// validate everything before writing anything
+		maxTs := time.Now().Add(time.Minute).UnixMilli()
+		msgs := make([]Message, 0, len(in.Messages))
+		senders := map[primitive.ObjectID]struct{}{}
+		seen := map[string]struct{}{}
+		skipped := 0
+		for i, m := range in.Messages {
+			bad := func(msg string) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg, "index": i})
+			}
+			if m.Type == "" {
+				m.Type = "text"
+			}
+			if m.Type != "text" {
+				bad("unsupported message type")
+				return
+			}
+			if l := len(m.Body); l == 0 || l > 2048 {
+				bad("body must be 1-2048 chars")
+				return
+			}
+			if m.Ts < minImportTs || m.Ts > maxTs {
+				bad("ts must be millis between 2000-01-01 and now")
+				return
+			}
+			sid, err := mustOID(m.SenderID)
+			if err != nil {
+				bad("invalid sender_id")
+				return
+			}
+			if m.ImportKey != "" {
+				if _, dup := seen[m.ImportKey]; dup {
+					skipped++
+					continue
+				}
+				seen[m.ImportKey] = struct{}{}
+			}
+			senders[sid] = struct{}{}
+			msgs = append(msgs, Message{
+				ConversationID: cid,
+				SenderID:       sid,
+				Type:           m.Type,
+				Body:           m.Body,
+				Ts:             m.Ts,
+				ImportKey:      m.ImportKey,
+			})
+		}
+		sort.SliceStable(msgs, func(a, b int) bool { return msgs[a].Ts < msgs[b].Ts })
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		residency, err := convResidency(ctx, db, cid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if err := checkResidency(strings.ToLower(strings.TrimSpace(in.Residency)), residency); err != nil {
+			writeSvcError(c, err)
+			return
+		}
+		for i := range msgs {
+			msgs[i].Residency = residency
+		}
+
+		for sid := range senders {
+			ok, err := isMember(ctx, db, cid, sid)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "sender is not a member", "sender_id": sid.Hex()})
+				return
+			}
+		}
+
+		if err := ensureMsgIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
+			return
+		}
+		if err := ensureImportIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
+			return
+		}
+
+		// keyed messages upsert on (conversation_id, import_key) so re-runs
+		// are no-ops; unkeyed ones are plain inserts
+		models := make([]mongo.WriteModel, 0, len(msgs))
+		for _, m := range msgs {
+			if m.ImportKey == "" {
+				models = append(models, mongo.NewInsertOneModel().SetDocument(m))
+				continue
+			}
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"conversation_id": cid, "import_key": m.ImportKey}).
+				SetUpdate(bson.M{"$setOnInsert": m}).
+				SetUpsert(true))
+		}
+		res, err := db.Collection("messages").BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		inserted := res.InsertedCount + res.UpsertedCount
+		skipped += len(msgs) - int(inserted)
+
+		writeAudit(ctx, db, AuditEntry{
+			Action:         "messages.import",
+			ActorID:        uid,
+			ConversationID: cid,
+			Details:        gin.H{"inserted": inserted, "skipped": skipped},
+		})
+		if inserted > 0 {
+			broadcaster.Publish(events.New(cid.Hex(), events.MessagesImported{Count: inserted}))
+		}
+		c.JSON(http.StatusOK, gin.H{"inserted": inserted, "skipped": skipped})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Concurrent request caps. Unlike the rate limiters (ratelimit.go) these
+bound how many requests a caller has in flight at once, so one client
+firing hundreds of parallel requests can't take the whole Mongo pool.
+
+Callers are keyed by uid once AuthRequired has run, otherwise by client IP.
+Each route group gets its own limiter (main.go):
+  api     INFLIGHT_PER_USER (default 16)
+  public  INFLIGHT_PER_IP   (default 8)  /claim and /widget
+  admin   INFLIGHT_PER_ADMIN (default 32)
+0 turns a limiter off. A WebSocket upgrade counts against "api" only
+while its handshake runs.
+
+Per-group gauges are exported on /metrics.
+*/
+
+type inflightLimiter struct {
+	name     string
+	max      int
+	mu       sync.Mutex
+	counts   map[string]int
+	total    atomic.Int64
+	rejected atomic.Int64
+}
+
+// every limiter, for /metrics
+var inflightLimiters []*inflightLimiter
+
+func newInflightLimiter(name string, max int) *inflightLimiter {
+	l := &inflightLimiter{name: name, max: max, counts: make(map[string]int)}
+	inflightLimiters = append(inflightLimiters, l)
+	return l
+}
+
+var (
+	apiInFlight    = newInflightLimiter("api", envInt("INFLIGHT_PER_USER", 16))
+	publicInFlight = newInflightLimiter("public", envInt("INFLIGHT_PER_IP", 8))
+	adminInFlight  = newInflightLimiter("admin", envInt("INFLIGHT_PER_ADMIN", 32))
+)
+
+// acquire takes a slot for key, reporting false when it already has max.
+// Keys are dropped from the map at zero, so it stays as small as the set of
+// callers with something in flight.
+func (l *inflightLimiter) acquire(key string) bool {
+	if l.max <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	n := l.counts[key]
+	if n >= l.max {
+		l.mu.Unlock()
+		l.rejected.Add(1)
+		return false
+	}
+	l.counts[key] = n + 1
+	l.mu.Unlock()
+	l.total.Add(1)
+	return true
+}
+
+func (l *inflightLimiter) release(key string) {
+	if l.max <= 0 {
+		return
+	}
+	l.mu.Lock()
+	if n := l.counts[key]; n > 1 {
+		l.counts[key] = n - 1
+	} else {
+		delete(l.counts, key)
+	}
+	l.mu.Unlock()
+	l.total.Add(-1)
+}
+
+// status is the 429 limit for a caller at the cap: no slots left, and a
+// second as a guess at when one frees up.
+func (l *inflightLimiter) status() rateLimit {
+	return rateLimit{Limit: l.max, Reset: time.Second}
+}
+
+// inflightKey is the uid for authenticated requests, the client IP otherwise.
+func inflightKey(c *gin.Context) string {
+	if uid := c.GetString("uid"); uid != "" {
+		return uid
+	}
+	return c.ClientIP()
+}
+
+// LimitInFlight rejects with 429 while the caller already has l.max
+// requests running. Put it after AuthRequired to key by uid.
+func LimitInFlight(l *inflightLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := inflightKey(c)
+		if !l.acquire(key) {
+			abortRateLimited(c, "too many concurrent requests", l.status())
+			return
+		}
+		defer l.release(key)
+		c.Next()
+	}
+}
+
+func writeInflightProm(sb *strings.Builder) {
+	sb.WriteString("# HELP http_inflight_requests Requests currently running, per limiter group.\n# TYPE http_inflight_requests gauge\n")
+	for _, l := range inflightLimiters {
+		fmt.Fprintf(sb, "http_inflight_requests{group=%q} %d\n", l.name, l.total.Load())
+	}
+	sb.WriteString("# HELP http_inflight_rejected_total Requests rejected by a concurrency cap.\n# TYPE http_inflight_rejected_total counter\n")
+	for _, l := range inflightLimiters {
+		fmt.Fprintf(sb, "http_inflight_rejected_total{group=%q} %d\n", l.name, l.rejected.Load())
+	}
+}
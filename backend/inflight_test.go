@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestInflightAcquireRelease(t *testing.T) {
+	l := &inflightLimiter{name: "test", max: 2, counts: make(map[string]int)}
+	if !l.acquire("a") || !l.acquire("a") {
+		t.Fatal("under the cap")
+	}
+	if l.acquire("a") {
+		t.Fatal("third slot for a")
+	}
+	if !l.acquire("b") {
+		t.Fatal("b is limited by a's requests")
+	}
+	l.release("a")
+	if !l.acquire("a") {
+		t.Fatal("released slot not reusable")
+	}
+	l.release("a")
+	l.release("a")
+	l.release("b")
+	if len(l.counts) != 0 || l.total.Load() != 0 || l.rejected.Load() != 1 {
+		t.Fatalf("counts %v, total %d, rejected %d", l.counts, l.total.Load(), l.rejected.Load())
+	}
+
+	off := &inflightLimiter{name: "off", counts: make(map[string]int)}
+	for range 100 {
+		if !off.acquire("a") {
+			t.Fatal("a limiter at 0 rejected")
+		}
+	}
+	if len(off.counts) != 0 || off.total.Load() != 0 {
+		t.Fatal("a limiter at 0 counted")
+	}
+}
+
+// TestLimitInFlight holds max requests per caller open and checks that the
+// next one from the same caller, and only that one, gets 429.
+func TestLimitInFlight(t *testing.T) {
+	const max = 3
+	l := &inflightLimiter{name: "test", max: max, counts: make(map[string]int)}
+	entered := make(chan struct{}, 2*max+2)
+	unblock := make(chan struct{})
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) {
+		if uid := c.GetHeader("X-Test-Uid"); uid != "" {
+			c.Set("uid", uid)
+		}
+	}, LimitInFlight(l), func(c *gin.Context) {
+		entered <- struct{}{}
+		<-unblock
+		c.Status(http.StatusOK)
+	})
+	do := func(uid, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		if uid != "" {
+			req.Header.Set("X-Test-Uid", uid)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	held := func(uid, ip string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := do(uid, ip); w.Code != http.StatusOK {
+				t.Errorf("held request (%s, %s): %d", uid, ip, w.Code)
+			}
+		}()
+		<-entered
+	}
+	for range max {
+		held("ann", "10.0.0.1")
+	}
+	for range max {
+		held("", "10.0.0.2")
+	}
+
+	if b := checkRateLimited(t, do("ann", "10.0.0.9")); b.Limit != max {
+		t.Fatalf("ann over the cap: %+v", b)
+	}
+	if w := do("", "10.0.0.2"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("anonymous IP over the cap: %d", w.Code)
+	}
+	// bob behind ann's IP is keyed by uid
+	held("bob", "10.0.0.1")
+
+	close(unblock)
+	wg.Wait()
+	if len(l.counts) != 0 || l.total.Load() != 0 {
+		t.Fatalf("slots left after the requests finished: %v", l.counts)
+	}
+	if w := do("ann", "10.0.0.1"); w.Code != http.StatusOK {
+		t.Fatalf("ann after release: %d", w.Code)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  integration_logs:
+    - conversation_id (ObjectId)
+    - integration_id  (string, "bot:<username>")
+    - kind            (string, "bot")
+    - action          (string, gRPC method)
+    - status          (int, HTTP status; bot calls map their gRPC code)
+    - error           (string, truncated to 300 bytes)
+    - latency_ms      (int64)
+    - ts              (int64, millis)
+    - created_at      (date, TTL INTEGRATION_LOG_TTL_DAYS, default 14)
+Index on (conversation_id, ts desc)
+
+Written off the request path by logIntegration; a failed write only loses
+the log line.
+*/
+
+type IntegrationLog struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	IntegrationID  string             `bson:"integration_id" json:"integration_id"`
+	Kind           string             `bson:"kind" json:"kind"`
+	Action         string             `bson:"action" json:"action"`
+	Status         int                `bson:"status" json:"status"`
+	Error          string             `bson:"error,omitempty" json:"error,omitempty"`
+	LatencyMS      int64              `bson:"latency_ms" json:"latency_ms"`
+	Ts             int64              `bson:"ts" json:"ts"`
+	CreatedAt      time.Time          `bson:"created_at" json:"-"`
+}
+
+const maxIntegrationErr = 300
+
+func ensureIntegrationLogIndexes(ctx context.Context, db *mongo.Database) error {
+	c := db.Collection("integration_logs")
+	if _, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "conversation_id", Value: 1}, {Key: "ts", Value: -1}},
+	}); err != nil {
+		return err
+	}
+	ttl := time.Duration(envInt("INTEGRATION_LOG_TTL_DAYS", 14)) * 24 * time.Hour
+	_, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())),
+	})
+	return err
+}
+
+// logIntegration records e in the background.
+func logIntegration(db *mongo.Database, e IntegrationLog) {
+	now := time.Now()
+	e.Ts, e.CreatedAt = now.UnixMilli(), now
+	if len(e.Error) > maxIntegrationErr {
+		e.Error = e.Error[:maxIntegrationErr]
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = ensureIntegrationLogIndexes(ctx, db)
+		if _, err := db.Collection("integration_logs").InsertOne(ctx, e); err != nil {
+			fmt.Println("integration log error:", err)
+		}
+	}()
+}
+
+// GET /conversations/:cid/integrations/logs?integration=bot:name&status=failed&before=<ts>&limit=50 (owner only)
+// status: "ok" (2xx), "failed" (anything else) or an exact code.
+// Returns: { logs: [...], next_before } newest first; next_before is absent
+// on the last page.
+func IntegrationLogsHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		filter := bson.M{"conversation_id": cid}
+		if id := c.Query("integration"); id != "" {
+			filter["integration_id"] = id
+		}
+		switch s := c.Query("status"); s {
+		case "":
+		case "ok":
+			filter["status"] = bson.M{"$gte": 200, "$lt": 300}
+		case "failed":
+			filter["$or"] = bson.A{
+				bson.M{"status": bson.M{"$lt": 200}},
+				bson.M{"status": bson.M{"$gte": 300}},
+			}
+		default:
+			code, err := strconv.Atoi(s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "status must be ok, failed or a status code"})
+				return
+			}
+			filter["status"] = code
+		}
+		if s := c.Query("before"); s != "" {
+			before, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before"})
+				return
+			}
+			filter["ts"] = bson.M{"$lt": before}
+		}
+		limit := 50
+		if s := c.Query("limit"); s != "" {
+			if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= 200 {
+				limit = n
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role != "owner" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+			return
+		}
+
+		cur, err := db.Collection("integration_logs").Find(ctx, filter,
+			options.Find().SetSort(bson.D{{Key: "ts", Value: -1}}).SetLimit(int64(limit)))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		logs := []IntegrationLog{}
+		if err := cur.All(ctx, &logs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+		out := gin.H{"logs": logs}
+		if len(logs) == limit {
+			out["next_before"] = logs[len(logs)-1].Ts
+		}
+		c.JSON(http.StatusOK, out)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// clock is the time source of a jobRunner. Tests swap in a fake one to
+// move time forward by hand.
+type clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, fn func()) stopper
+}
+
+type stopper interface{ Stop() bool }
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time { return time.Now() }
+
+func (wallClock) AfterFunc(d time.Duration, fn func()) stopper { return time.AfterFunc(d, fn) }
+
+// jobRunner runs keyed, cancellable delayed jobs in-process.
+// Scheduling a key that is already pending replaces the old job.
+type jobRunner struct {
+	mu    sync.Mutex
+	jobs  map[string]stopper
+	clock clock
+}
+
+func newJobRunner() *jobRunner {
+	return newJobRunnerWithClock(wallClock{})
+}
+
+func newJobRunnerWithClock(c clock) *jobRunner {
+	return &jobRunner{jobs: make(map[string]stopper), clock: c}
+}
+
+// Now is the runner's current time. Checks that tests need to carry
+// across a deadline read it here instead of time.Now: push suppression
+// (notifications.go), message expiry (messages.go), viewing heartbeats
+// (viewing.go) and the weekly summary schedule (weeklysummary.go).
+func (r *jobRunner) Now() time.Time { return r.clock.Now() }
+
+// Since is Now().Sub(t).
+func (r *jobRunner) Since(t time.Time) time.Duration { return r.Now().Sub(t) }
+
+// Schedule runs fn after delay unless Cancel(key) is called first.
+func (r *jobRunner) Schedule(key string, delay time.Duration, fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.jobs[key]; ok {
+		t.Stop()
+	}
+	var t stopper
+	t = r.clock.AfterFunc(delay, func() {
+		r.mu.Lock()
+		// only clear our own entry; a newer job may have replaced it
+		if r.jobs[key] == t {
+			delete(r.jobs, key)
+		}
+		r.mu.Unlock()
+		fn()
+	})
+	r.jobs[key] = t
+}
+
+// Cancel stops a pending job. Returns false if nothing was pending.
+func (r *jobRunner) Cancel(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.jobs[key]
+	if !ok {
+		return false
+	}
+	t.Stop()
+	delete(r.jobs, key)
+	return true
+}
+
+// global runner for delayed notifications etc.
+var jobs = newJobRunner()
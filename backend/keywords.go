@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Muted keywords live on the user document:
+  users.muted_keywords: [{ term, whole_word, count_unread }]
+
+A message whose body matches any keyword never triggers a push for that
+user. Keywords with count_unread=false also drop the message from the
+user's unread counts. WebSocket delivery is unaffected.
+*/
+
+type MutedKeyword struct {
+	Term        string `bson:"term" json:"term"`
+	WholeWord   bool   `bson:"whole_word" json:"whole_word"`
+	CountUnread bool   `bson:"count_unread" json:"count_unread"`
+}
+
+const maxMutedKeywords = 50
+
+// keywordMatcher is the compiled form of a user's muted keywords.
+type keywordMatcher struct {
+	all        *regexp.Regexp // every keyword, for notifications
+	skipUnread string         // Mongo regex for keywords excluded from unread ("" if none)
+}
+
+func keywordPattern(kws []MutedKeyword, onlyUncounted bool) string {
+	parts := make([]string, 0, len(kws))
+	for _, k := range kws {
+		if onlyUncounted && k.CountUnread {
+			continue
+		}
+		p := regexp.QuoteMeta(k.Term)
+		if k.WholeWord {
+			p = `\b` + p + `\b`
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(parts, "|")
+}
+
+func compileKeywords(kws []MutedKeyword) *keywordMatcher {
+	m := &keywordMatcher{}
+	if p := keywordPattern(kws, false); p != "" {
+		m.all = regexp.MustCompile("(?i)" + p)
+	}
+	m.skipUnread = keywordPattern(kws, true)
+	return m
+}
+
+// Matches reports whether body hits any muted keyword.
+func (m *keywordMatcher) Matches(body string) bool {
+	return m != nil && m.all != nil && m.all.MatchString(body)
+}
+
+// applyUnread narrows an unread-count filter on messages to skip bodies
+// matching keywords that shouldn't count, and weekly summary cards
+// (weeklysummary.go), which never do.
+func (m *keywordMatcher) applyUnread(filter bson.M) bson.M {
+	filter["type"] = bson.M{"$ne": msgTypeSummary}
+	if m != nil && m.skipUnread != "" {
+		filter["body"] = bson.M{"$not": primitive.Regex{Pattern: m.skipUnread, Options: "i"}}
+	}
+	return filter
+}
+
+// per-user compiled matchers; dropped on update
+var keywordCache = struct {
+	sync.RWMutex
+	m map[primitive.ObjectID]*keywordMatcher
+}{m: make(map[primitive.ObjectID]*keywordMatcher)}
+
+func loadKeywordMatcher(ctx context.Context, db *mongo.Database, uid primitive.ObjectID) (*keywordMatcher, error) {
+	ms, err := loadKeywordMatchers(ctx, db, []primitive.ObjectID{uid})
+	if err != nil {
+		return nil, err
+	}
+	return ms[uid], nil
+}
+
+// loadKeywordMatchers returns a matcher for every uid, loading those not yet
+// cached with one query.
+func loadKeywordMatchers(ctx context.Context, db *mongo.Database, uids []primitive.ObjectID) (map[primitive.ObjectID]*keywordMatcher, error) {
+	out := make(map[primitive.ObjectID]*keywordMatcher, len(uids))
+	var missing []primitive.ObjectID
+	keywordCache.RLock()
+	for _, uid := range uids {
+		if m, ok := keywordCache.m[uid]; ok {
+			out[uid] = m
+		} else {
+			missing = append(missing, uid)
+		}
+	}
+	keywordCache.RUnlock()
+	if len(missing) == 0 {
+		return out, nil
+	}
+
+	cur, err := db.Collection("users").Find(ctx,
+		bson.M{"_id": bson.M{"$in": missing}},
+		options.Find().SetProjection(bson.M{"muted_keywords": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ID            primitive.ObjectID `bson:"_id"`
+		MutedKeywords []MutedKeyword     `bson:"muted_keywords"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	// unknown users get an empty matcher, so they aren't looked up again
+	kws := make(map[primitive.ObjectID][]MutedKeyword, len(rows))
+	for _, r := range rows {
+		kws[r.ID] = r.MutedKeywords
+	}
+	keywordCache.Lock()
+	for _, uid := range missing {
+		m := compileKeywords(kws[uid])
+		keywordCache.m[uid] = m
+		out[uid] = m
+	}
+	keywordCache.Unlock()
+	return out, nil
+}
+
+// GET /me/muted-keywords
+func GetMutedKeywordsHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, err := mustOID(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		var u struct {
+			MutedKeywords []MutedKeyword `bson:"muted_keywords"`
+		}
+		err = getDB(client).Collection("users").FindOne(ctx, bson.M{"_id": uid}).Decode(&u)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if u.MutedKeywords == nil {
+			u.MutedKeywords = []MutedKeyword{}
+		}
+		c.JSON(http.StatusOK, gin.H{"keywords": u.MutedKeywords})
+	}
+}
+
+// PUT /me/muted-keywords
+// Body: { "keywords": [{ "term": "standup", "whole_word": true, "count_unread": true }] }
+// Replaces the whole list. count_unread defaults to true.
+func PutMutedKeywordsHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uid, err := mustOID(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		var in struct {
+			Keywords []struct {
+				Term        string `json:"term"`
+				WholeWord   bool   `json:"whole_word"`
+				CountUnread *bool  `json:"count_unread"`
+			} `json:"keywords"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+			return
+		}
+		if len(in.Keywords) > maxMutedKeywords {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at most 50 keywords"})
+			return
+		}
+
+		seen := make(map[string]struct{}, len(in.Keywords))
+		kws := make([]MutedKeyword, 0, len(in.Keywords))
+		for _, k := range in.Keywords {
+			term := strings.ToLower(strings.TrimSpace(k.Term))
+			if term == "" || len(term) > 64 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "keywords must be 1-64 chars"})
+				return
+			}
+			if _, dup := seen[term]; dup {
+				continue
+			}
+			seen[term] = struct{}{}
+			count := true
+			if k.CountUnread != nil {
+				count = *k.CountUnread
+			}
+			kws = append(kws, MutedKeyword{Term: term, WholeWord: k.WholeWord, CountUnread: count})
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		if _, err := getDB(client).Collection("users").UpdateByID(ctx, uid,
+			bson.M{"$set": bson.M{"muted_keywords": kws}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		keywordCache.Lock()
+		delete(keywordCache.m, uid)
+		keywordCache.Unlock()
+
+		invalidateConvList(uid)
+		c.JSON(http.StatusOK, gin.H{"keywords": kws})
+	}
+}
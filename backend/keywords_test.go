@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// keywordLoads counts finds on users that fetch muted keywords.
+type keywordLoads struct{ n atomic.Int64 }
+
+func (k *keywordLoads) monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{Started: func(_ context.Context, e *event.CommandStartedEvent) {
+		if e.CommandName != "find" || e.Command.Lookup("find").StringValue() != "users" {
+			return
+		}
+		if _, err := e.Command.LookupErr("projection", "muted_keywords"); err == nil {
+			k.n.Add(1)
+		}
+	}}
+}
+
+// useKeywordCache gives t an empty matcher cache.
+func useKeywordCache(t *testing.T) {
+	keywordCache.Lock()
+	old := keywordCache.m
+	keywordCache.m = make(map[primitive.ObjectID]*keywordMatcher)
+	keywordCache.Unlock()
+	t.Cleanup(func() {
+		keywordCache.Lock()
+		keywordCache.m = old
+		keywordCache.Unlock()
+	})
+}
+
+func muteKeywords(t *testing.T, db *mongo.Database, u User, kws ...MutedKeyword) {
+	t.Helper()
+	if _, err := db.Collection("users").UpdateByID(testCtx(t), u.ID, bson.M{"$set": bson.M{"muted_keywords": kws}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadKeywordMatchersOneQuery(t *testing.T) {
+	var loads keywordLoads
+	client := testClient(t, loads.monitor())
+	db := useTestDB(t, client)
+	useKeywordCache(t)
+	ann := seedUser(t, db, "ann")
+	bob := seedUser(t, db, "bob")
+	muteKeywords(t, db, ann, MutedKeyword{Term: "deploy", WholeWord: true})
+	ghost := primitive.NewObjectID()
+
+	ms, err := loadKeywordMatchers(testCtx(t), db, []primitive.ObjectID{ann.ID, bob.ID, ghost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := loads.n.Load(); n != 1 {
+		t.Fatalf("%d keyword queries for three users, want 1", n)
+	}
+	if len(ms) != 3 || !ms[ann.ID].Matches("Deploy at five") || ms[ann.ID].Matches("redeployed") || ms[bob.ID].Matches("deploy") || ms[ghost].Matches("deploy") {
+		t.Fatalf("matchers %v", ms)
+	}
+
+	// cached, unknown users included; only the new one is loaded
+	cat := seedUser(t, db, "cat")
+	muteKeywords(t, db, cat, MutedKeyword{Term: "lunch"})
+	ms, err = loadKeywordMatchers(testCtx(t), db, []primitive.ObjectID{ann.ID, bob.ID, ghost, cat.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := loads.n.Load(); n != 2 || !ms[cat.ID].Matches("LUNCH?") {
+		t.Fatalf("%d keyword queries, want 2; cat matches lunch: %v", n, ms[cat.ID].Matches("LUNCH?"))
+	}
+	if kw, err := loadKeywordMatcher(testCtx(t), db, ann.ID); err != nil || !kw.Matches("deploy") || loads.n.Load() != 2 {
+		t.Fatalf("single load: %v, %v, %d queries", kw, err, loads.n.Load())
+	}
+}
+
+func TestDispatchSkipsMutedKeywords(t *testing.T) {
+	var loads keywordLoads
+	client := testClient(t, loads.monitor())
+	db := useTestDB(t, client)
+	useKeywordCache(t)
+	ann := seedUser(t, db, "ann")
+	others := []User{seedUser(t, db, "bob"), seedUser(t, db, "cat"), seedUser(t, db, "dan")}
+	muteKeywords(t, db, others[0], MutedKeyword{Term: "deploy"})
+	conv := seedConv(t, db, "ops", ann, others...)
+
+	dispatchMessagePush(db, Message{
+		ID: primitive.NewObjectID(), ConversationID: conv.ID, SenderID: ann.ID,
+		Type: "text", Body: "deploy friday", Ts: time.Now().UnixMilli(),
+	})
+	if n := loads.n.Load(); n != 1 {
+		t.Fatalf("%d keyword queries for one message, want 1", n)
+	}
+	cur, err := db.Collection("notifications").Find(testCtx(t), bson.M{"conversation_id": conv.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queued []Notification
+	if err := cur.All(testCtx(t), &queued); err != nil {
+		t.Fatal(err)
+	}
+	got := map[primitive.ObjectID]bool{}
+	for _, n := range queued {
+		got[n.UserID] = true
+	}
+	if len(queued) != 2 || got[others[0].ID] || !got[others[1].ID] || !got[others[2].ID] {
+		t.Fatalf("pushes queued for %v; bob muted the keyword", got)
+	}
+}
@@ -2,70 +2,217 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
+// set at build time: go build -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
 	mongoURI := os.Getenv("MONGO_URI")
 	if mongoURI == "" {
 		mongoURI = "mongodb://localhost:27017"
 	}
 
-	client := connectMongo(mongoURI)
+	client, err := connectMongo(mongoURI)
+	if err != nil {
+		fmt.Println("❌ cannot start:", err)
+		os.Exit(1)
+	}
 	defer client.Disconnect(context.Background())
 
+	// data migrations (migrations.go); `backend migrate` runs them and exits
+	applied, err := runMigrations(getDB(client))
+	if err != nil {
+		fmt.Println("❌ cannot start:", err)
+		os.Exit(1)
+	}
+	if len(applied) > 0 {
+		fmt.Println("✅ Applied migrations:", applied)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		return
+	}
+	initFeatures(client)
+
 	r := gin.Default()
 	r.SetTrustedProxies(nil) // remove warning
 
-	// Add CORS middleware
-	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"http://localhost:3000", "http://localhost:5173", "http://127.0.0.1:3000", "http://127.0.0.1:5173", "http://localhost:8080", "http://127.0.0.1:8080", "http://127.0.0.1:5500", "https://gui-im.netlify.app"}
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
-	config.AllowCredentials = true
-	r.Use(cors.New(config))
+	// CORS policy per route group (cors.go)
+	r.Use(CORS())
+	r.Use(ReadOnlyGuard())
+	go watchMaintenance()
+	go viewing.expireLoop()
+	startWeeklySummaries(client)
 
 	// simple ping
 	r.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"msg": "pong"})
 	})
 
-	// ✅ add db health check
-	r.GET("/health/db", func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
-		if err := client.Ping(ctx, nil); err != nil {
-			c.JSON(500, gin.H{"ok": false, "err": err.Error()})
-			return
-		}
-		c.JSON(200, gin.H{"ok": true})
+	r.GET("/version", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"version": version, "features": features.All()})
+	})
+
+	// emoji shortcode table for autocomplete (emoji.go)
+	r.GET("/emoji/shortcodes", EmojiShortcodesHandler())
+
+	// Prometheus scrape endpoint (metrics.go)
+	r.GET("/metrics", PrometheusHandler())
+
+	// server clock, for client skew correction
+	r.GET("/time", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"now": time.Now().UnixMilli()})
 	})
 
-	// 🔐 auth (must be present)
-	r.POST("/claim", ClaimUsernameHandler(client))
-	r.GET("/me", AuthRequired(), MeHandler())
-	r.GET("/users", AuthRequired(), ListUsersHandler(client))
+	// ✅ add db health check (admins get pool/topology detail, dbmonitor.go)
+	r.GET("/health/db", DBHealthHandler(client))
+
+	// 🔐 auth (must be present); in-flight caps per group (inflight.go)
+	r.POST("/claim", LimitInFlight(publicInFlight), ClaimUsernameHandler(client))
+	api := r.Group("/", AuthRequired(), LimitInFlight(apiInFlight))
+	api.GET("/me", MeHandler())
+	api.PATCH("/me", RenameUserHandler(client))
+	api.GET("/users", ListUsersHandler(client))
+	api.GET("/me/conversations/ids", MyConversationIDsHandler(client))
+	api.GET("/me/roles", MyRolesHandler(client))
+	api.GET("/stickers", ListStickersHandler(client))
+	api.GET("/me/muted-keywords", GetMutedKeywordsHandler(client))
+	api.PUT("/me/muted-keywords", PutMutedKeywordsHandler(client))
+	api.GET("/me/dnd", GetDNDHandler(client))
+	api.PUT("/me/dnd", PutDNDHandler(client))
+
+	// offline client read-state sync (sync.go)
+	api.GET("/sync/read-state", GetReadStateHandler(client))
+	api.POST("/sync/read-state", PostReadStateHandler(client))
 
 	// Conversation endpoints
-	r.POST("/conversations", AuthRequired(), CreateConverHandler(client))
-	r.GET("/conversations", AuthRequired(), ListConverHandler(client))
+	api.POST("/conversations", Idempotent(client), CreateConverHandler(client))
+	api.GET("/conversations", ListConverHandler(client))
+	api.GET("/conversations/unread", UnreadCountsHandler(client))
+	api.GET("/conversations/delta", ConversationDeltaHandler(client))
+	api.GET("/conversations/:cid", GetConverHandler(client))
+	api.PATCH("/conversations/:cid", RenameConverHandler(client))
+	api.GET("/conversations/:cid/members", ListMembersHandler(client))
+	api.GET("/conversations/:cid/membership", MembershipHandler(client))
+	api.GET("/conversations/:cid/summary", ConversationSummaryHandler(client))
+	api.GET("/conversations/:cid/title-suggestions", TitleSuggestionsHandler(client))
+	api.GET("/conversations/:cid/integrations/logs", IntegrationLogsHandler(client))
+	api.POST("/conversations/:cid/members", Idempotent(client), AddMembersHandler(client))
+	api.DELETE("/conversations/:cid/members/:uid", RemoveMemberHandler(client))
 
 	// Messages
-	r.POST("/messages/:cid", AuthRequired(), SendMessageHandler(client))
-	r.GET("/messages/:cid", AuthRequired(), ListMessagesHandler(client))
+	api.POST("/messages/:cid", Idempotent(client), SendMessageHandler(client))
+	api.GET("/messages/:cid", ListMessagesHandler(client))
+	api.GET("/messages/:cid/search", SearchConversationHandler(client))
+	api.GET("/search", SearchAllHandler(client))
+
+	// image uploads (images.go)
+	api.POST("/uploads", UploadImageHandler(client))
+	api.GET("/files/:id", GetFileHandler(client))
+	api.GET("/messages/:cid/:mid", GetMessageHandler(client))
+	api.PATCH("/messages/:cid/:mid", EditMessageHandler(client))
+	api.DELETE("/messages/:cid/:mid", DeleteMessageHandler(client))
+	api.GET("/messages/:cid/:mid/body", GetMessageBodyHandler(client))
+	api.GET("/messages/:cid/:mid/replies", ListRepliesHandler(client))
+	api.POST("/messages/:cid/:mid/split", SplitConversationHandler(client))
+	api.POST("/conversations/:cid/messages/purge", PurgeMessagesHandler(client))
+	api.PUT("/messages/:cid/:mid/reactions/:emoji", AddReactionHandler(client))
+	api.DELETE("/messages/:cid/:mid/reactions/:emoji", RemoveReactionHandler(client))
+	api.POST("/messages/:cid/:mid/reactions", AddReactionHandler(client))
+	api.DELETE("/messages/:cid/:mid/reactions", RemoveReactionHandler(client))
 
 	// receipts
-	r.POST("/conversations/:cid/read", AuthRequired(), MarkReadHandler(client))
-	r.GET("/conversations/:cid/unread", AuthRequired(), UnreadCountHandler(client))
+	api.POST("/conversations/:cid/read", MarkReadHandler(client))
+	api.GET("/conversations/:cid/unread", UnreadCountHandler(client))
+	api.PUT("/conversations/:cid/receipts", SetReceiptsEnabledHandler(client))
+	api.PUT("/conversations/:cid/slow-mode", SetSlowModeHandler(client))
+	api.PUT("/conversations/:cid/rate-limit", SetSendRateHandler(client))
+	api.PUT("/conversations/:cid/weekly-summary", SetWeeklySummaryHandler(client))
+	api.PUT("/conversations/:cid/send-limits/:uid", SetConvSendLimitHandler(client))
+	api.GET("/conversations/:cid/send-status", SendStatusHandler(client))
+
+	// pins & per-user conversation prefs
+	api.GET("/conversations/:cid/pins", ListPinsHandler(client))
+	api.POST("/conversations/:cid/pins/:mid", PinMessageHandler(client))
+	api.DELETE("/conversations/:cid/pins/:mid", UnpinMessageHandler(client))
+	api.PUT("/conversations/:cid/mute", SetMuteHandler(client))
+	api.POST("/conversations/:cid/snooze", SnoozeHandler(client))
+
+	// canned responses
+	api.GET("/me/canned", ListCannedHandler(client))
+	api.POST("/me/canned", CreateCannedHandler(client))
+	api.PATCH("/me/canned/:id", UpdateCannedHandler(client))
+	api.DELETE("/me/canned/:id", DeleteCannedHandler(client))
+	api.GET("/conversations/:cid/canned", ListCannedHandler(client))
+	api.GET("/conversations/:cid/canned/effective", EffectiveCannedHandler(client))
+	api.POST("/conversations/:cid/canned", CreateCannedHandler(client))
+	api.PATCH("/conversations/:cid/canned/:id", UpdateCannedHandler(client))
+	api.DELETE("/conversations/:cid/canned/:id", DeleteCannedHandler(client))
+	api.POST("/canned/:id/used", UseCannedHandler(client))
+
+	// public embed widgets
+	api.POST("/conversations/:cid/widgets", CreateWidgetHandler(client))
+	api.DELETE("/conversations/:cid/widgets/:token", RevokeWidgetHandler(client))
+	// frozen read-only history (snapshots.go)
+	api.POST("/conversations/:cid/snapshots", CreateSnapshotHandler(client))
+	api.DELETE("/conversations/:cid/snapshots/:sid", RevokeSnapshotHandler(client))
+	r.GET("/snapshots/:token", RateLimitByIP(snapshotLimiter), LimitInFlight(publicInFlight), GetSnapshotHandler(client))
+	widget := r.Group("/widget", RequireFeature(FlagWidgets), RateLimitByIP(widgetLimiter), LimitInFlight(publicInFlight))
+	widget.GET("/:token/feed", WidgetFeedHandler(client))
+	widget.GET("/:token/stream", WidgetStreamHandler(client))
+
+	// admin
+	admin := r.Group("/admin", AuthRequired(), AdminRequired(), LimitInFlight(adminInFlight))
+	admin.GET("/features", GetFeaturesHandler())
+	admin.PUT("/features", PutFeaturesHandler(client))
+	admin.GET("/jwt-keys", JWTKeysHandler())
+	admin.GET("/metrics", MetricsHandler())
+	admin.GET("/stats", AdminStatsHandler(client))
+	admin.POST("/conversations/:cid/import", ImportMessagesHandler(client))
+	admin.POST("/watch/:cid", AddWatcherHandler(client))
+	admin.DELETE("/watch/:cid/:uid", RemoveWatcherHandler(client))
+	admin.PUT("/stickers/:id", PutStickerHandler(client))
+	admin.DELETE("/stickers/:id", DeleteStickerHandler(client))
+	admin.PUT("/users/:username/send-limit", SetUserSendLimitHandler(client))
 
 	// websockets
 	r.GET("/ws/:cid", WSHandler(client))
 
+	// socket.io compatibility adapter (see socketio.go)
+	sio := newSocketIOServer(client)
+	go sio.Serve()
+	defer sio.Close()
+	r.Any("/socket.io/*any", RequireFeature(FlagSocketIO), gin.WrapH(sio))
+
+	// server-to-server API, off unless GRPC_ADDR is set
+	startGRPC(client)
+
 	// Local Port
-	r.Run(":8080")
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Println("❌ server error:", err)
+			os.Exit(1)
+		}
+	}()
+
+	// on SIGINT/SIGTERM: stop taking requests, then write coalesced read marks
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Println("shutdown error:", err)
+	}
+	readMarks.flushAll()
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Maintenance mode = the "maintenance" feature flag (FEATURE_MAINTENANCE env,
+or PUT /admin/features). While on, every write returns 503 and reads keep
+working. Admin routes stay writable so the flag can be turned back off.
+*/
+
+func maintenanceMessage() string {
+	if m := strings.TrimSpace(os.Getenv("MAINTENANCE_MESSAGE")); m != "" {
+		return m
+	}
+	return "server is in maintenance mode; writes are temporarily disabled"
+}
+
+func isWriteMethod(m string) bool {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// ReadOnlyGuard rejects writes with 503 while maintenance mode is on.
+func ReadOnlyGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// socket.io polling POSTs are transport traffic, not writes
+		if !isWriteMethod(c.Request.Method) || strings.HasPrefix(c.Request.URL.Path, "/admin/") ||
+			strings.HasPrefix(c.Request.URL.Path, "/socket.io/") {
+			c.Next()
+			return
+		}
+		if features.Enabled(FlagMaintenance) {
+			c.Header("Retry-After", "60")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error":       maintenanceMessage(),
+				"maintenance": true,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// watchMaintenance polls the flag and tells every connected socket when it
+// flips, whichever instance or env change caused it.
+func watchMaintenance() {
+	on := features.Enabled(FlagMaintenance)
+	t := time.NewTicker(featureTTL / 2)
+	defer t.Stop()
+	for range t.C {
+		now := features.Enabled(FlagMaintenance)
+		if now == on {
+			continue
+		}
+		on = now
+		payload := events.Maintenance{Enabled: on}
+		if on {
+			payload.Message = maintenanceMessage()
+		}
+		broadcaster.PublishAll(events.New("", payload))
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Membership is stored in one of two layouts:
+
+  small (default): conversations.members embedded array
+  large:           conversations.members_external = true, members = []
+                   memberships:
+                     - conversation_id (ObjectId)
+                     - user_id         (ObjectId)
+                     - role            (string)
+                     - joined_at       (int64, millis)
+                   Unique index on (conversation_id, user_id)
+
+A conversation moves to the large layout once it grows past
+memberInlineLimit(). Everything membership-related goes through the
+helpers below so callers never care which layout is in use.
+*/
+
+type Membership struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Role           string             `bson:"role" json:"role"`
+	JoinedAt       int64              `bson:"joined_at" json:"joined_at"`
+}
+
+// memberInlineLimit reads MEMBER_INLINE_LIMIT (default 500).
+func memberInlineLimit() int {
+	return envInt("MEMBER_INLINE_LIMIT", 500)
+}
+
+func ensureMembershipIndexes(ctx context.Context, db *mongo.Database) error {
+	c := db.Collection("memberships")
+	if _, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "conversation_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+	_, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+	return err
+}
+
+// check if uid is in the conversation's member
+func isMember(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID) (bool, error) {
+	role, err := memberRole(ctx, db, cid, uid)
+	return role != "", err
+}
+
+// memberRole returns uid's role in the conversation, or "" if not a member
+func memberRole(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID) (string, error) {
+	var conv struct {
+		External bool     `bson:"members_external"`
+		Members  []Member `bson:"members"`
+	}
+	err := db.Collection("conversations").FindOne(ctx,
+		bson.M{"_id": cid},
+		options.FindOne().SetProjection(bson.M{
+			"members_external": 1,
+			"members":          bson.M{"$elemMatch": bson.M{"user_id": uid}},
+		}),
+	).Decode(&conv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(conv.Members) > 0 {
+		return conv.Members[0].Role, nil
+	}
+	if !conv.External {
+		return "", nil
+	}
+
+	var m Membership
+	err = db.Collection("memberships").FindOne(ctx, bson.M{"conversation_id": cid, "user_id": uid}).Decode(&m)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return m.Role, nil
+}
+
+// listMembers returns every member of conv regardless of layout.
+func listMembers(ctx context.Context, db *mongo.Database, conv *Conversation) ([]Member, error) {
+	if !conv.MembersExternal {
+		return conv.Members, nil
+	}
+	cur, err := db.Collection("memberships").Find(ctx,
+		bson.M{"conversation_id": conv.ID},
+		options.Find().SetSort(bson.D{{Key: "joined_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]Member, 0, 64)
+	for cur.Next(ctx) {
+		var m Membership
+		if err := cur.Decode(&m); err != nil {
+			return nil, err
+		}
+		out = append(out, Member{UserID: m.UserID, Role: m.Role})
+	}
+	return out, nil
+}
+
+// isDM: a conversation with exactly two members, the same rule DM reuse uses.
+func isDM(count int64) bool {
+	return count == 2
+}
+
+// memberConvFilter builds a conversations filter matching every
+// conversation uid belongs to, in either layout.
+func memberConvFilter(ctx context.Context, db *mongo.Database, uid primitive.ObjectID) (bson.M, error) {
+	ids, err := db.Collection("memberships").Distinct(ctx, "conversation_id", bson.M{"user_id": uid})
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return bson.M{"members.user_id": uid}, nil
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"members.user_id": uid},
+		bson.M{"_id": bson.M{"$in": ids}},
+	}}, nil
+}
+
+// memberWriteRetries bounds the re-reads when a concurrent membership change
+// invalidates what addMembers or migrateMembersExternal read.
+const memberWriteRetries = 5
+
+// errMembersChanged means the embedded member array changed between the
+// read and the conditional write; the caller re-reads and tries again.
+var errMembersChanged = errors.New("conversation members changed concurrently")
+
+// addMembers adds new members (existing ones are left untouched) and
+// migrates the conversation to the large layout if it crosses the limit.
+// Returns the members that were actually added.
+func addMembers(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, members []Member) ([]Member, error) {
+	for attempt := 1; ; attempt++ {
+		added, err := tryAddMembers(ctx, db, cid, members)
+		if !errors.Is(err, errMembersChanged) || attempt == memberWriteRetries {
+			return added, err
+		}
+	}
+}
+
+func tryAddMembers(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, members []Member) ([]Member, error) {
+	var conv Conversation
+	if err := db.Collection("conversations").FindOne(ctx, bson.M{"_id": cid}).Decode(&conv); err != nil {
+		return nil, err
+	}
+
+	added := make([]Member, 0, len(members))
+	for _, m := range members {
+		role, err := memberRole(ctx, db, cid, m.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if role == "" {
+			added = append(added, m)
+		}
+	}
+	if len(added) == 0 {
+		return added, nil
+	}
+
+	if conv.MembersExternal {
+		if err := insertMemberships(ctx, db, cid, added); err != nil {
+			return nil, err
+		}
+		return added, touchConversation(ctx, db, cid)
+	}
+
+	if len(conv.Members)+len(added) <= memberInlineLimit() {
+		ids := make([]primitive.ObjectID, len(added))
+		for i, m := range added {
+			ids[i] = m.UserID
+		}
+		// "not already a member" is part of the filter, so two concurrent
+		// adds of the same user can't both push them
+		res, err := db.Collection("conversations").UpdateOne(ctx, bson.M{
+			"_id":              cid,
+			"members_external": bson.M{"$ne": true},
+			"members.user_id":  bson.M{"$nin": ids},
+		}, bson.M{
+			"$push": bson.M{"members": bson.M{"$each": added}},
+			"$set":  bson.M{"updated_at": time.Now().UnixMilli()},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			return nil, errMembersChanged
+		}
+		return added, nil
+	}
+
+	// crossing the threshold: move everyone to the memberships collection
+	if err := migrateMembersExternal(ctx, db, &conv); err != nil {
+		return nil, err
+	}
+	if err := insertMemberships(ctx, db, cid, added); err != nil {
+		return nil, err
+	}
+	return added, touchConversation(ctx, db, cid)
+}
+
+// removeMember deletes uid from the conversation in whichever layout it uses.
+// Returns false if uid wasn't a member.
+func removeMember(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID) (bool, error) {
+	res, err := db.Collection("conversations").UpdateOne(ctx,
+		bson.M{"_id": cid, "members.user_id": uid},
+		bson.M{
+			"$pull": bson.M{"members": bson.M{"user_id": uid}},
+			"$set":  bson.M{"updated_at": time.Now().UnixMilli()},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	if res.ModifiedCount > 0 {
+		return true, recordRemoval(ctx, db, cid, uid)
+	}
+	del, err := db.Collection("memberships").DeleteOne(ctx, bson.M{"conversation_id": cid, "user_id": uid})
+	if err != nil {
+		return false, err
+	}
+	if del.DeletedCount == 0 {
+		return false, nil
+	}
+	if err := recordRemoval(ctx, db, cid, uid); err != nil {
+		return true, err
+	}
+	return true, touchConversation(ctx, db, cid)
+}
+
+// touchConversation bumps updated_at after a membership change.
+func touchConversation(ctx context.Context, db *mongo.Database, cid primitive.ObjectID) error {
+	_, err := db.Collection("conversations").UpdateByID(ctx, cid,
+		bson.M{"$set": bson.M{"updated_at": time.Now().UnixMilli()}})
+	return err
+}
+
+func insertMemberships(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, members []Member) error {
+	if err := ensureMembershipIndexes(ctx, db); err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	docs := make([]interface{}, 0, len(members))
+	for _, m := range members {
+		docs = append(docs, Membership{ConversationID: cid, UserID: m.UserID, Role: m.Role, JoinedAt: now})
+	}
+	_, err := db.Collection("memberships").InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if mongo.IsDuplicateKeyError(err) {
+		// raced with another add; the member is in either way
+		return nil
+	}
+	return err
+}
+
+// migrateMembersExternal copies the embedded array into memberships, then
+// flips the flag and clears the array. Safe to re-run. The array is only
+// cleared if it still holds exactly what was copied; when a member was
+// added or removed in between, conv is re-read and the copy redone, so
+// nobody is lost.
+func migrateMembersExternal(ctx context.Context, db *mongo.Database, conv *Conversation) error {
+	for attempt := 1; !conv.MembersExternal; attempt++ {
+		if conv.Members == nil {
+			conv.Members = []Member{} // match a stored [], not null
+		}
+		if len(conv.Members) > 0 {
+			if err := insertMemberships(ctx, db, conv.ID, conv.Members); err != nil {
+				return err
+			}
+		}
+		res, err := db.Collection("conversations").UpdateOne(ctx, bson.M{
+			"_id":              conv.ID,
+			"members_external": bson.M{"$ne": true},
+			"members":          conv.Members,
+		}, bson.M{
+			"$set": bson.M{"members_external": true, "members": []Member{}},
+		})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount > 0 {
+			conv.MembersExternal = true
+			conv.Members = []Member{}
+			return nil
+		}
+		if attempt == memberWriteRetries {
+			return errMembersChanged
+		}
+		// the array moved on (or another caller migrated it): start over
+		// from what is stored now, dropping copies of anyone removed since
+		var fresh Conversation
+		if err := db.Collection("conversations").FindOne(ctx, bson.M{"_id": conv.ID}).Decode(&fresh); err != nil {
+			return err
+		}
+		*conv = fresh
+		if conv.MembersExternal {
+			break
+		}
+		ids := make([]primitive.ObjectID, len(conv.Members))
+		for i, m := range conv.Members {
+			ids[i] = m.UserID
+		}
+		if _, err := db.Collection("memberships").DeleteMany(ctx, bson.M{
+			"conversation_id": conv.ID,
+			"user_id":         bson.M{"$nin": ids},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAddMembersConcurrentSameUser(t *testing.T) {
+	_, db := testDB(t)
+	owner := seedUser(t, db, "owner")
+	bob := seedUser(t, db, "bob")
+	conv := seedConv(t, db, "ops", owner)
+	ctx := testCtx(t)
+
+	const n = 8
+	var wg sync.WaitGroup
+	addedBy := make([]int, n)
+	errs := make([]error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			added, err := addMembers(ctx, db, conv.ID, []Member{{UserID: bob.ID, Role: "member"}})
+			addedBy[i], errs[i] = len(added), err
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for i := range n {
+		if errs[i] != nil {
+			t.Fatalf("add %d: %v", i, errs[i])
+		}
+		total += addedBy[i]
+	}
+	if total != 1 {
+		t.Errorf("%d adds reported adding bob, want 1", total)
+	}
+	var got Conversation
+	if err := db.Collection("conversations").FindOne(ctx, bson.M{"_id": conv.ID}).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Members) != 2 {
+		t.Fatalf("members = %+v, want owner and bob once", got.Members)
+	}
+}
+
+func TestMigrateMembersExternalStaleRead(t *testing.T) {
+	_, db := testDB(t)
+	owner := seedUser(t, db, "owner")
+	bob := seedUser(t, db, "bob")
+	carol := seedUser(t, db, "carol")
+	dave := seedUser(t, db, "dave")
+	conv := seedConv(t, db, "ops", owner, bob, carol)
+	ctx := testCtx(t)
+
+	// conv is what the migrating request read; then dave joins and carol
+	// leaves before it writes
+	col := db.Collection("conversations")
+	if _, err := col.UpdateByID(ctx, conv.ID, bson.M{"$push": bson.M{"members": Member{UserID: dave.ID, Role: "member"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := col.UpdateByID(ctx, conv.ID, bson.M{"$pull": bson.M{"members": bson.M{"user_id": carol.ID}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateMembersExternal(ctx, db, &conv); err != nil {
+		t.Fatal(err)
+	}
+	if !conv.MembersExternal || len(conv.Members) != 0 {
+		t.Fatalf("conv not flipped: %+v", conv)
+	}
+
+	members, err := listMembers(ctx, db, &conv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, m := range members {
+		got[m.UserID.Hex()] = true
+	}
+	for _, u := range []User{owner, bob, dave} {
+		if !got[u.ID.Hex()] {
+			t.Errorf("%s lost in the migration", u.Username)
+		}
+	}
+	if got[carol.ID.Hex()] {
+		t.Error("carol left but was migrated")
+	}
+	if len(members) != 3 {
+		t.Errorf("members = %+v", members)
+	}
+}
+
+// TestMembersHTTP adds, lists and removes members through the handlers,
+// once in the embedded layout and once with a limit low enough that the
+// first add moves the conversation to the memberships collection.
+func TestMembersHTTP(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		limit    string
+		external bool
+	}{
+		{"inline", "", false},
+		{"external", "3", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MEMBER_INLINE_LIMIT", tt.limit)
+			client, db := testDB(t)
+			owner, bob := seedUser(t, db, "owner"), seedUser(t, db, "bob")
+			carol, dave := seedUser(t, db, "carol"), seedUser(t, db, "dave")
+			eve := seedUser(t, db, "eve")
+			conv := seedConv(t, db, "ops", owner, bob)
+			r, api := testAPI()
+			api.GET("/conversations/:cid/members", ListMembersHandler(client))
+			api.POST("/conversations/:cid/members", AddMembersHandler(client))
+			api.DELETE("/conversations/:cid/members/:uid", RemoveMemberHandler(client))
+			path := "/conversations/" + conv.ID.Hex() + "/members"
+
+			add := func(as User, names []string, code, added int) {
+				t.Helper()
+				w := serve(t, r, http.MethodPost, path, &as, gin.H{"members": names})
+				var out struct {
+					Added []Member `json:"added"`
+				}
+				decode(t, w, &out)
+				if w.Code != code || len(out.Added) != added {
+					t.Fatalf("%s adds %v: %d %s, want %d with %d added", as.Username, names, w.Code, w.Body, code, added)
+				}
+			}
+			remove := func(as, who User, code int) {
+				t.Helper()
+				if w := serve(t, r, http.MethodDelete, path+"/"+who.ID.Hex(), &as, nil); w.Code != code {
+					t.Fatalf("%s removes %s: %d %s, want %d", as.Username, who.Username, w.Code, w.Body, code)
+				}
+			}
+			list := func(as User, want ...User) {
+				t.Helper()
+				w := serve(t, r, http.MethodGet, path, &as, nil)
+				var out struct {
+					Members []Member `json:"members"`
+				}
+				decode(t, w, &out)
+				if w.Code != http.StatusOK {
+					t.Fatalf("%s lists: %d %s", as.Username, w.Code, w.Body)
+				}
+				var got, exp []string
+				for _, m := range out.Members {
+					got = append(got, m.UserID.Hex()+":"+m.Role)
+				}
+				for _, u := range want {
+					role := "member"
+					if u.ID == owner.ID {
+						role = "owner"
+					}
+					exp = append(exp, u.ID.Hex()+":"+role)
+				}
+				slices.Sort(got)
+				slices.Sort(exp)
+				if !slices.Equal(got, exp) {
+					t.Fatalf("%s lists %v, want %v", as.Username, got, exp)
+				}
+			}
+
+			add(bob, []string{"eve"}, http.StatusForbidden, 0)
+			add(owner, []string{"carol", "Dave", "bob"}, http.StatusOK, 2)
+			var stored Conversation
+			if err := db.Collection("conversations").FindOne(testCtx(t), bson.M{"_id": conv.ID}).Decode(&stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.MembersExternal != tt.external {
+				t.Fatalf("members_external = %v, want %v", stored.MembersExternal, tt.external)
+			}
+			list(bob, owner, bob, carol, dave)
+			if w := serve(t, r, http.MethodGet, path, &eve, nil); w.Code != http.StatusForbidden {
+				t.Fatalf("outsider lists: %d, want 403", w.Code)
+			}
+
+			remove(bob, carol, http.StatusForbidden)
+			remove(eve, eve, http.StatusForbidden)
+			remove(owner, dave, http.StatusOK)
+			remove(owner, dave, http.StatusNotFound)
+			remove(carol, carol, http.StatusOK)
+			list(owner, owner, bob)
+			if w := serve(t, r, http.MethodGet, path, &carol, nil); w.Code != http.StatusForbidden {
+				t.Fatalf("carol lists after leaving: %d, want 403", w.Code)
+			}
+
+			add(owner, []string{"dave"}, http.StatusOK, 1)
+			list(dave, owner, bob, dave)
+		})
+	}
+}
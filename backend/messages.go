@@ -8,6 +8,8 @@ import (
 	"strconv"
 	"time"
 
+	"backend/events"
+
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -24,6 +26,122 @@ type Message struct {
 	Type           string             `bson:"type"            json:"type"`
 	Body           string             `bson:"body"            json:"body"`
 	Ts             int64              `bson:"ts"              json:"ts"`
+	Deleted        bool               `bson:"deleted,omitempty" json:"deleted,omitempty"`
+	// when it was deleted (millis), for delta sync (delta.go); 0 on older deletes
+	DeletedAt int64 `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	// per-conversation insertion order, from 1 (seq.go); 0 on imports
+	Seq int64 `bson:"seq,omitempty" json:"seq,omitempty"`
+	// last edit by the sender (millis); 0 = never edited
+	EditedAt int64 `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+	// type "sticker": the sticker's id (stickers.go); Body is its name
+	StickerID string `bson:"sticker_id,omitempty" json:"sticker_id,omitempty"`
+	// type "image": Body is the upload id; its size in pixels (images.go)
+	Width  int `bson:"width,omitempty" json:"width,omitempty"`
+	Height int `bson:"height,omitempty" json:"height,omitempty"`
+	// type "image": the file failed its malware scan (scanner.go)
+	AttachmentInfected bool `bson:"attachment_infected,omitempty" json:"attachment_infected,omitempty"`
+	// burn after reading: ExpiresAt (millis) is what clients and filters use,
+	// ExpiresDate is the same instant as a BSON date for the TTL index
+	ExpiresAt   int64      `bson:"expires_at_ms,omitempty" json:"expires_at,omitempty"`
+	ExpiresDate *time.Time `bson:"expires_at,omitempty"    json:"-"`
+	// message this one answers, and users it @mentions (see refs.go)
+	ReplyTo  *primitive.ObjectID  `bson:"reply_to,omitempty" json:"reply_to,omitempty"`
+	Mentions []primitive.ObjectID `bson:"mentions,omitempty" json:"mentions,omitempty"`
+	// lists with ?with_reply_previews=true: the quoted parent
+	ReplyPreview *replyPreview `bson:"-" json:"reply_preview,omitempty"`
+	// lists only: visible replies to this message (threads.go)
+	ReplyCount int64 `bson:"-" json:"reply_count,omitempty"`
+	// copies made by a thread split (split.go), and the system message
+	// left in the source pointing at the new conversation
+	ForwardedFrom *forwardRef         `bson:"forwarded_from,omitempty" json:"forwarded_from,omitempty"`
+	SplitTo       *primitive.ObjectID `bson:"split_to,omitempty" json:"split_to,omitempty"`
+	// type "summary": the weekly activity card (weeklysummary.go)
+	Card *summaryCard `bson:"card,omitempty" json:"card,omitempty"`
+	// "high" for pager-style alerts (priority.go); empty = normal
+	Priority string `bson:"priority,omitempty" json:"priority,omitempty"`
+	// reactions across all emoji, kept in step by reactions.go
+	ReactionCount int64 `bson:"reaction_count,omitempty" json:"reaction_count,omitempty"`
+	// message lists only: emoji -> count, and the caller's own emoji
+	// (annotateReactions); empty on deleted messages
+	Reactions   map[string]int64 `bson:"-" json:"reactions,omitzero"`
+	MyReactions []string         `bson:"-" json:"my_reactions,omitzero"`
+	// large bodies live in GridFS; Body is then a preview (blobs.go)
+	BodyRef   *primitive.ObjectID `bson:"body_ref,omitempty" json:"-"`
+	BodyLen   int                 `bson:"body_len,omitempty" json:"body_len,omitempty"`
+	Truncated bool                `bson:"truncated,omitempty" json:"truncated,omitempty"`
+	// source id for imported history; (conversation_id, import_key) is unique
+	ImportKey string `bson:"import_key,omitempty" json:"-"`
+	// the conversation's residency tag, copied at write time (residency.go)
+	Residency string `bson:"residency,omitempty" json:"-"`
+	// viewer-local day (YYYY-MM-DD): day_bucket on every listed message
+	// (UTC unless ?tz / X-Timezone), day_key only when a zone was asked for
+	DayBucket string `bson:"-" json:"day_bucket,omitempty"`
+	DayKey    string `bson:"-" json:"day_key,omitempty"`
+	// the caller's own messages only, with ?with_receipts=true (receipts.go)
+	Receipts *msgReceipts `bson:"-" json:"receipts,omitempty"`
+	// the caller's own editable messages only: seconds left to edit, -1 =
+	// no limit (editwindow.go)
+	EditableSecs *int64 `bson:"-" json:"editable_secs,omitempty"`
+}
+
+const (
+	minMessageTTL = 60 * time.Second
+	maxMessageTTL = 7 * 24 * time.Hour
+)
+
+// message types a sender may mark as expiring
+var expirableTypes = map[string]bool{"text": true, "image": true}
+
+// tombstone blanks a deleted message for readers: type "deleted", no body.
+// Id, sender, ts and seq stay so the timeline keeps its place.
+func (m *Message) tombstone() {
+	if !m.Deleted {
+		return
+	}
+	m.Type, m.Body, m.StickerID = "deleted", "", ""
+	m.Width, m.Height = 0, 0
+	m.BodyLen, m.Truncated = 0, false
+	m.Mentions = nil
+}
+
+// Expired reports whether m has passed its expiry; the TTL reaper may not
+// have removed it yet.
+func (m *Message) Expired(now int64) bool {
+	return m.ExpiresAt > 0 && m.ExpiresAt <= now
+}
+
+// unexpired narrows a messages filter to messages that haven't expired,
+// whether or not the TTL reaper has run.
+func unexpired(filter bson.M) bson.M {
+	filter["expires_at_ms"] = bson.M{"$not": bson.M{"$lte": jobs.Now().UnixMilli()}}
+	return filter
+}
+
+// visible narrows a messages filter to messages that are neither deleted
+// nor expired. Unread counts and delivered positions all use it: unread
+// is the visible messages with ts > last_read_ts.
+func visible(filter bson.M) bson.M {
+	filter["deleted"] = bson.M{"$ne": true}
+	return unexpired(filter)
+}
+
+// nextExpiry returns the earliest future expires_at (millis) among visible
+// messages in cids, or 0. Unread counts and last messages computed now go
+// stale at that moment without any event.
+func nextExpiry(ctx context.Context, db *mongo.Database, cids []primitive.ObjectID) (int64, error) {
+	filter := visible(bson.M{"conversation_id": bson.M{"$in": cids}})
+	// replaces visible's expiry clause, which also matches messages that never expire
+	filter["expires_at_ms"] = bson.M{"$gt": jobs.Now().UnixMilli()}
+	var m Message
+	err := db.Collection("messages").FindOne(ctx, filter,
+		options.FindOne().
+			SetSort(bson.D{{Key: "expires_at_ms", Value: 1}}).
+			SetProjection(bson.M{"expires_at_ms": 1}),
+	).Decode(&m)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return m.ExpiresAt, err
 }
 
 // === Indexes ===
@@ -40,6 +158,17 @@ func ensureMsgIndexes(ctx context.Context, db *mongo.Database) error {
 	_, _ = c.Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys: bson.D{{Key: "sender_id", Value: 1}},
 	})
+	// 3. one message per number (seq.go)
+	_, _ = c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "conversation_id", Value: 1}, {Key: "seq", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"seq": bson.M{"$exists": true}}),
+	})
+	// 4. reap expiring messages (documents without expires_at are untouched)
+	_, _ = c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
 	return nil
 }
 
@@ -48,25 +177,67 @@ func mustOID(hex string) (primitive.ObjectID, error) {
 	return primitive.ObjectIDFromHex(hex)
 }
 
-// check if uid is in the conversation's member
-func isMember(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID) (bool, error) {
-	filter := bson.M{
-		"_id":             cid,
-		"members.user_id": uid,
+// eventInlineMax reads EVENT_BODY_INLINE_MAX: bodies longer than this many
+// bytes are left out of broadcast events. 0 (default) always inlines.
+func eventInlineMax() int {
+	return envInt("EVENT_BODY_INLINE_MAX", 0)
+}
+
+// eventBodyTooLong reports whether body must be left out of an event
+// payload, which then has body_len + truncated so clients know to
+// GET /messages/:cid/:mid.
+func eventBodyTooLong(body string) bool {
+	max := eventInlineMax()
+	return max > 0 && len(body) > max
+}
+
+// messageCreatedPayload is msg as announced in message.created.
+func messageCreatedPayload(msg Message, serverTime int64) events.MessageCreated {
+	p := events.MessageCreated{
+		ID:         msg.ID.Hex(),
+		SenderID:   msg.SenderID.Hex(),
+		Type:       msg.Type,
+		Body:       msg.Body,
+		Ts:         msg.Ts,
+		Seq:        msg.Seq,
+		ServerTime: serverTime,
+		ExpiresAt:  msg.ExpiresAt,
+		Priority:   msg.Priority,
+		StickerID:  msg.StickerID,
+		Width:      msg.Width,
+		Height:     msg.Height,
 	}
-	var x struct {
-		ID primitive.ObjectID `bson:"_id"`
+	if msg.ReplyTo != nil {
+		p.ReplyTo = msg.ReplyTo.Hex()
 	}
-	err := db.Collection("conversations").FindOne(ctx, filter).Decode(&x)
-	if errors.Is(err, mongo.ErrNoDocuments) {
-		return false, nil
+	for _, id := range msg.Mentions {
+		p.Mentions = append(p.Mentions, id.Hex())
+	}
+	if f := msg.ForwardedFrom; f != nil {
+		p.ForwardedFrom = &events.ForwardRef{ConversationID: f.ConversationID.Hex(), MessageID: f.MessageID.Hex()}
+	}
+	if msg.SplitTo != nil {
+		p.SplitTo = msg.SplitTo.Hex()
+	}
+	if msg.Truncated {
+		p.BodyLen, p.Truncated = msg.BodyLen, true
 	}
-	return err == nil, err
+	if msg.Card != nil {
+		p.Card = msg.Card.event()
+	}
+	if eventBodyTooLong(msg.Body) {
+		p.Body, p.BodyLen, p.Truncated = "", len(msg.Body), true
+	}
+	return p
 }
 
 // === Handlers ===
 // POST /messages/:cid
-// Body: { "type": "text", "body": "Hello" }
+// Body: { "type": "text", "body": "Hello" }   (optional "priority": "high", owners only)
+// or { "type": "sticker", "sticker_id": "party_parrot" } (see GET /stickers)
+// or { "type": "image", "body": "<id from POST /uploads>" } (see images.go)
+// @username tokens in a text body are stored in mentions along with the
+// explicit "mentions"; ones that aren't members are dropped silently.
 func SendMessageHandler(client *mongo.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		uidHex, _ := c.Get("uid")
@@ -83,79 +254,208 @@ func SendMessageHandler(client *mongo.Client) gin.HandlerFunc {
 			return
 		}
 
-		var in struct {
-			Type string `json:"type"`
-			Body string `json:"body"`
-		}
+		var in sendInput
 		if err := c.ShouldBindJSON(&in); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
 			return
 		}
-		if in.Type == "" {
-			in.Type = "text"
-		}
-		// minimal validation
-		if in.Type != "text" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported message type"})
-			return
-		}
-		if l := len(in.Body); l == 0 || l > 2048 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "body must be 1-2048 chars"})
-			return
-		}
 
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 		defer cancel()
 		db := getDB(client)
 
-		ok, err := isMember(ctx, db, cid, uid)
+		msg, serverTime, err := sendMessage(ctx, db, uid, cid, in)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			writeSvcError(c, err)
 			return
 		}
-		if !ok {
-			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
-			return
+
+		c.JSON(http.StatusCreated, struct {
+			Message
+			ServerTime int64 `json:"server_time"`
+		}{msg, serverTime})
+	}
+}
+
+type sendInput struct {
+	Type      string   `json:"type"`
+	Body      string   `json:"body"`
+	ExpiresIn int64    `json:"expires_in_seconds"`
+	ReplyTo   string   `json:"reply_to"` // message id
+	Mentions  []string `json:"mentions"` // usernames; @names in the body are added
+	Priority  string   `json:"priority"` // "" / "normal" / "high" (see priority.go)
+	// type "sticker" only (stickers.go)
+	StickerID string `json:"sticker_id"`
+	// set by the gRPC path for bots whose key has the notify scope
+	NotifyScope bool `json:"-"`
+}
+
+// sendMessage validates, stores and fans out one message from uid. Shared by
+// the HTTP and gRPC send paths. Returns the stored message and server_time.
+func sendMessage(ctx context.Context, db *mongo.Database, uid, cid primitive.ObjectID, in sendInput) (Message, int64, error) {
+	if in.Type == "" {
+		in.Type = "text"
+	}
+	// minimal validation
+	var width, height int // images only
+	switch in.Type {
+	case "text":
+		if in.StickerID != "" {
+			return Message{}, 0, svcFail(http.StatusBadRequest, "sticker_id is only for sticker messages")
+		}
+		if !validBodyLen(in.Body) {
+			return Message{}, 0, svcFail(http.StatusBadRequest, "body must be 1-16384 characters")
+		}
+		if emojiExpandEnabled() {
+			in.Body = expandShortcodes(in.Body)
+		}
+	case "sticker":
+		s, err := lookupSticker(ctx, db, in.StickerID)
+		if err != nil {
+			return Message{}, 0, err
+		}
+		in.Body = s.Name
+	case "image":
+		if in.StickerID != "" {
+			return Message{}, 0, svcFail(http.StatusBadRequest, "sticker_id is only for sticker messages")
+		}
+		a, err := imageAttachment(ctx, db, cid, in.Body)
+		if err != nil {
+			return Message{}, 0, err
 		}
+		in.Body, width, height = a.ID.Hex(), a.Width, a.Height
+	default:
+		return Message{}, 0, svcFail(http.StatusBadRequest, "unsupported message type")
+	}
+	ttl := time.Duration(in.ExpiresIn) * time.Second
+	if in.ExpiresIn != 0 {
+		if !expirableTypes[in.Type] {
+			return Message{}, 0, svcFail(http.StatusBadRequest, "only text and image messages can expire")
+		}
+		if ttl < minMessageTTL || ttl > maxMessageTTL {
+			return Message{}, 0, svcFail(http.StatusBadRequest, "expires_in_seconds must be 60-604800")
+		}
+	}
 
-		if err := ensureMsgIndexes(ctx, db); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
-			return
+	role, err := memberRole(ctx, db, cid, uid)
+	if err != nil {
+		return Message{}, 0, err
+	}
+	if role == "" {
+		return Message{}, 0, svcFail(http.StatusForbidden, "not a member")
+	}
+
+	lim, err := sendLimitFor(ctx, db, cid, uid)
+	if err != nil {
+		return Message{}, 0, err
+	}
+	wait, _, err := slowModeWait(ctx, db, cid, uid, role, lim)
+	if err != nil {
+		return Message{}, 0, err
+	}
+	if wait > 0 {
+		// one message per slow mode interval
+		return Message{}, 0, rateLimited("slow mode", rateLimit{Limit: 1, Reset: wait})
+	}
+	if err := floodWait(db, cid, role, lim); err != nil {
+		return Message{}, 0, err
+	}
+	if err := sendRateWait(ctx, db, cid, uid, lim); err != nil {
+		return Message{}, 0, err
+	}
+
+	priority, err := checkPriority(uid, role, in)
+	if err != nil {
+		return Message{}, 0, err
+	}
+
+	replyTo, mentions, err := validateRefs(ctx, db, cid, in.ReplyTo, in.Mentions)
+	if err != nil {
+		return Message{}, 0, err
+	}
+	if in.Type == "text" {
+		if mentions, err = addBodyMentions(ctx, db, cid, in.Body, mentions); err != nil {
+			return Message{}, 0, err
 		}
+	}
 
-		msg := Message{
-			ConversationID: cid,
-			SenderID:       uid,
-			Type:           in.Type,
-			Body:           in.Body,
-			Ts:             time.Now().UnixMilli(),
+	msg := Message{
+		ConversationID: cid,
+		SenderID:       uid,
+		Type:           in.Type,
+		Body:           in.Body,
+		Ts:             time.Now().UnixMilli(),
+		ReplyTo:        replyTo,
+		Mentions:       mentions,
+		Priority:       priority,
+		StickerID:      in.StickerID,
+		Width:          width,
+		Height:         height,
+	}
+	if ttl > 0 {
+		exp := time.UnixMilli(msg.Ts).Add(ttl)
+		msg.ExpiresAt = exp.UnixMilli()
+		msg.ExpiresDate = &exp
+	}
+	return storeMessage(ctx, db, msg)
+}
+
+// storeMessage inserts an already validated msg and fans it out
+// (message.created, push; summary cards get no push). Every path that creates messages ends here.
+func storeMessage(ctx context.Context, db *mongo.Database, msg Message) (Message, int64, error) {
+	if err := ensureMsgIndexes(ctx, db); err != nil {
+		return Message{}, 0, svcFail(http.StatusInternalServerError, "index error")
+	}
+	residency, err := convResidency(ctx, db, msg.ConversationID)
+	if err != nil {
+		return Message{}, 0, err
+	}
+	msg.Residency = residency
+	if err := externalizeBody(ctx, db, &msg); err != nil {
+		fmt.Println("store body error:", err)
+		return Message{}, 0, err
+	}
+	seq, err := nextSeq(ctx, db, msg.ConversationID)
+	if err != nil {
+		fmt.Println("message seq error:", err)
+		if msg.BodyRef != nil {
+			dropBodies(ctx, db, []primitive.ObjectID{*msg.BodyRef})
 		}
-		res, err := db.Collection("messages").InsertOne(ctx, msg)
-		if err != nil {
-			fmt.Println("insert message error:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
-			return
+		return Message{}, 0, err
+	}
+	msg.Seq = seq
+	res, err := db.Collection("messages").InsertOne(ctx, msg)
+	if err != nil {
+		fmt.Println("insert message error:", err)
+		if msg.BodyRef != nil {
+			dropBodies(ctx, db, []primitive.ObjectID{*msg.BodyRef})
 		}
-		msg.ID = res.InsertedID.(primitive.ObjectID)
+		return Message{}, 0, err
+	}
+	msg.ID = res.InsertedID.(primitive.ObjectID)
 
-		// boradcast to connected clients in this conversation
-		broadcaster.Publish(Event{
-			Type:           "message.created",
-			ConversationID: cid.Hex(),
-			Payload: gin.H{
-				"id":        msg.ID.Hex(),
-				"sender_id": uid.Hex(),
-				"type":      msg.Type,
-				"body":      msg.Body,
-				"ts":        msg.Ts,
-			},
-		})
-		c.JSON(http.StatusCreated, msg)
+	// server_time lets clients measure their clock skew against ts
+	serverTime := time.Now().UnixMilli()
+
+	// boradcast to connected clients in this conversation
+	broadcaster.Publish(events.New(msg.ConversationID.Hex(), messageCreatedPayload(msg, serverTime)))
+	if msg.Type != msgTypeSummary {
+		go dispatchMessagePush(db, msg)
 	}
+
+	return msg, serverTime, nil
 }
 
-// GET/messages/:cid?before=<ts>&limit=50
+// GET/messages/:cid?before=<ts>&limit=50[&include=senders][&tz=Area/City]  (or X-Timezone header, see timezone.go)
 // Returns newest -> oldest (reverse-chronological)
+// ?min_reactions=N keeps only messages with at least N reactions ("top
+// reactions" view); paging works the same on the narrowed list.
+// ?with_receipts=true adds receipts: { read, delivered, recipients } to the
+// caller's own messages (not when the conversation's receipts are off).
+// Every message has reactions: { emoji: count } and my_reactions: [emoji],
+// both empty on deleted messages.
+// ?with_reply_previews=true adds reply_preview to replies (refs.go).
+// Messages with replies carry reply_count (threads.go).
 func ListMessagesHandler(client *mongo.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// auth & params
@@ -172,39 +472,216 @@ func ListMessagesHandler(client *mongo.Client) gin.HandlerFunc {
 			return
 		}
 
+		var q msgQuery
 		// pagination
-		limit := 50
 		if s := c.Query("limit"); s != "" {
-			if n, err := strconv.Atoi(s); err == nil && n > 0 {
-				if n > 200 {
-					n = 200
-				}
-				limit = n
+			if n, err := strconv.Atoi(s); err == nil {
+				q.Limit = n
 			}
 		}
-
 		// NEW: since (optional) — fetch only newer than this ts
-		var since *int64
 		if s := c.Query("since"); s != "" {
-			if n, err := strconv.ParseInt(s, 10, 64); err == nil && n > 0 {
-				since = &n
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				q.Since = n
 			}
 		}
-
 		// existing: before (for reverse-chron paging)
-		var before int64 = time.Now().UnixMilli() + 1
 		if s := c.Query("before"); s != "" {
-			if n, err := strconv.ParseInt(s, 10, 64); err == nil && n > 0 {
-				before = n
+			if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				q.Before = n
+			}
+		}
+		if s := c.Query("min_reactions"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "min_reactions must be a positive integer"})
+				return
+			}
+			q.MinReactions = n
+		}
+
+		// day grouping zone: ?tz must be a real IANA name, the X-Timezone
+		// header falls back to UTC (see timezone.go)
+		loc, zoned := time.UTC, false
+		if tz := c.Query("tz"); tz != "" {
+			l, ok := loadZone(tz)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown timezone"})
+				return
 			}
+			loc, zoned = l, true
+		} else if tz := c.GetHeader("X-Timezone"); tz != "" {
+			l, ok := loadZone(tz)
+			if !ok {
+				c.Header("X-Timezone-Fallback", "UTC")
+			}
+			loc, zoned = l, true
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		out, err := listMessages(ctx, db, uid, cid, q)
+		if err != nil {
+			writeSvcError(c, err)
+			return
+		}
+		if c.Query("with_receipts") == "true" {
+			if err := annotateReceipts(ctx, db, uid, cid, out); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+		}
+		if c.Query("with_reply_previews") == "true" {
+			if err := annotateReplyPreviews(ctx, db, cid, out); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+		}
+		if err := annotateReplyCounts(ctx, db, cid, out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if err := annotateReactions(ctx, db, uid, out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		exempt, err := editExempt(ctx, db, cid, uid, c.GetString("uname"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		annotateEditable(out, uid, exempt)
+		days := tagDays(out, loc, zoned)
+
+		// a requested zone and ?include=senders wrap the page as
+		// { messages, senders: { uid: username }, timezone, day_boundaries }
+		wrapped := gin.H{}
+		if zoned {
+			wrapped["timezone"] = loc.String()
+			wrapped["day_boundaries"] = days
+		}
+		if c.Query("include") == "senders" {
+			ids := make([]primitive.ObjectID, 0, len(out))
+			for _, m := range out {
+				ids = append(ids, m.SenderID)
+			}
+			names, err := usernamesByID(ctx, db, ids)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			senders := make(map[string]string, len(names))
+			for id, n := range names {
+				senders[id.Hex()] = n
+			}
+			wrapped["senders"] = senders
+		}
+		if len(wrapped) > 0 {
+			wrapped["messages"] = out
+			c.JSON(http.StatusOK, wrapped)
+			return
+		}
+		c.JSON(http.StatusOK, out)
+	}
+}
+
+// msgQuery: non-positive values mean "not given".
+type msgQuery struct {
+	Limit  int   // default 50, max 200
+	Since  int64 // ts > since; wins over Before
+	Before int64 // ts < before; default now
+
+	MinReactions int // only messages with reaction_count >= this
+}
+
+// listMessages returns a page of cid's messages, newest first, for member uid.
+func listMessages(ctx context.Context, db *mongo.Database, uid, cid primitive.ObjectID, q msgQuery) ([]Message, error) {
+	limit := 50
+	if q.Limit > 0 {
+		limit = q.Limit
+		if limit > 200 {
+			limit = 200
+		}
+	}
+	before := time.Now().UnixMilli() + 1
+	if q.Before > 0 {
+		before = q.Before
+	}
+
+	// membership gate (watchers may read too)
+	ok, err := canRead(ctx, db, cid, uid, "messages.list")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, svcFail(http.StatusForbidden, "not a member")
+	}
+
+	// Build filter:
+	// - if since provided, use ts > since (to get *new* messages)
+	// - else use ts < before (your original reverse-chron window)
+	filter := unexpired(bson.M{"conversation_id": cid})
+	if q.Since > 0 {
+		filter["ts"] = bson.M{"$gt": q.Since}
+	} else {
+		filter["ts"] = bson.M{"$lt": before}
+	}
+	if q.MinReactions > 0 {
+		filter["reaction_count"] = bson.M{"$gte": q.MinReactions}
+	}
+
+	cur, err := db.Collection("messages").Find(
+		ctx,
+		filter,
+		options.Find().
+			SetSort(bson.D{{Key: "ts", Value: -1}}).
+			SetLimit(int64(limit)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := make([]Message, 0, limit)
+	for cur.Next(ctx) {
+		var m Message
+		if err := cur.Decode(&m); err != nil {
+			return nil, svcFail(http.StatusInternalServerError, "decode error")
+		}
+		m.tombstone()
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// GET /messages/:cid/:mid
+// Single message, e.g. to fetch a body left out of a trimmed event.
+func GetMessageHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		mid, err := mustOID(c.Param("mid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
 		}
 
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 		defer cancel()
 		db := getDB(client)
 
-		// membership gate
-		ok, err := isMember(ctx, db, cid, uid)
+		ok, err := canRead(ctx, db, cid, uid, "messages.get")
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 			return
@@ -214,38 +691,252 @@ func ListMessagesHandler(client *mongo.Client) gin.HandlerFunc {
 			return
 		}
 
-		// Build filter:
-		// - if since provided, use ts > since (to get *new* messages)
-		// - else use ts < before (your original reverse-chron window)
-		filter := bson.M{"conversation_id": cid}
-		if since != nil {
-			filter["ts"] = bson.M{"$gt": *since}
-		} else {
-			filter["ts"] = bson.M{"$lt": before}
+		var m Message
+		err = db.Collection("messages").FindOne(ctx, unexpired(bson.M{"_id": mid, "conversation_id": cid})).Decode(&m)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
 		}
+		m.tombstone()
+		exempt, err := editExempt(ctx, db, cid, uid, c.GetString("uname"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		msgs := []Message{m}
+		annotateEditable(msgs, uid, exempt)
+		c.JSON(http.StatusOK, msgs[0])
+	}
+}
 
-		cur, err := db.Collection("messages").Find(
-			ctx,
-			filter,
-			options.Find().
-				SetSort(bson.D{{Key: "ts", Value: -1}}).
-				SetLimit(int64(limit)),
-		)
+// PATCH /messages/:cid/:mid   (sender only, text messages)
+// Body: { "body": "corrected text" }
+// Only within the edit window (editwindow.go); 403 after it.
+// Returns the updated message and publishes message.updated.
+func EditMessageHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		mid, err := mustOID(c.Param("mid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+		var in struct {
+			Body string `json:"body"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+			return
+		}
+		if !validBodyLen(in.Body) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "body must be 1-16384 characters"})
+			return
+		}
+		if emojiExpandEnabled() {
+			in.Body = expandShortcodes(in.Body)
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		member, err := isMember(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !member {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+
+		var m Message
+		err = db.Collection("messages").FindOne(ctx, visible(bson.M{"_id": mid, "conversation_id": cid})).Decode(&m)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if m.SenderID != uid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the sender can edit a message"})
+			return
+		}
+		if m.Type != "text" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only text messages can be edited"})
+			return
+		}
+		exempt, err := editExempt(ctx, db, cid, uid, c.GetString("uname"))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 			return
 		}
-		defer cur.Close(ctx)
+		if editableSecs(&m, time.Now().UnixMilli(), exempt) == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "edit window has passed"})
+			return
+		}
 
-		out := make([]Message, 0, limit)
-		for cur.Next(ctx) {
-			var m Message
-			if err := cur.Decode(&m); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+		// the new body may cross the inline limit either way (blobs.go)
+		oldRef := m.BodyRef
+		m.Body, m.BodyRef, m.BodyLen, m.Truncated = in.Body, nil, 0, false
+		if err := externalizeBody(ctx, db, &m); err != nil {
+			fmt.Println("edit body error:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		m.EditedAt = time.Now().UnixMilli()
+		set := bson.M{"body": m.Body, "edited_at": m.EditedAt}
+		update := bson.M{"$set": set}
+		if m.BodyRef != nil {
+			set["body_ref"], set["body_len"], set["truncated"] = m.BodyRef, m.BodyLen, true
+		} else {
+			update["$unset"] = bson.M{"body_ref": "", "body_len": "", "truncated": ""}
+		}
+		res, err := db.Collection("messages").UpdateOne(ctx,
+			bson.M{"_id": mid, "sender_id": uid, "deleted": bson.M{"$ne": true}}, update)
+		if err == nil && res.MatchedCount == 0 {
+			err = mongo.ErrNoDocuments // deleted or purged meanwhile
+		}
+		if err != nil {
+			if m.BodyRef != nil {
+				dropBodies(ctx, db, []primitive.ObjectID{*m.BodyRef})
+			}
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
 				return
 			}
-			out = append(out, m)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
 		}
-		c.JSON(http.StatusOK, out)
+		if oldRef != nil {
+			dropBodies(ctx, db, []primitive.ObjectID{*oldRef})
+		}
+
+		payload := events.MessageUpdated{ID: m.ID.Hex(), Body: m.Body, EditedAt: m.EditedAt}
+		if m.Truncated {
+			payload.BodyLen, payload.Truncated = m.BodyLen, true
+		}
+		if eventBodyTooLong(m.Body) {
+			payload.Body, payload.BodyLen, payload.Truncated = "", len(m.Body), true
+		}
+		broadcaster.Publish(events.New(cid.Hex(), payload))
+		c.JSON(http.StatusOK, m)
+	}
+}
+
+// DELETE /messages/:cid/:mid   (sender or conversation owner)
+// Soft delete: the message stays, with deleted: true and no body, so paging
+// by ts is unaffected; readers get a tombstone. Publishes message.deleted.
+// Deleting an already deleted message is a no-op 200.
+func DeleteMessageHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		mid, err := mustOID(c.Param("mid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+
+		var m Message
+		err = db.Collection("messages").FindOne(ctx, unexpired(bson.M{"_id": mid, "conversation_id": cid}),
+			options.FindOne().SetProjection(bson.M{"sender_id": 1, "deleted": 1, "body_ref": 1}),
+		).Decode(&m)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if m.SenderID != uid && role != "owner" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only the sender or an owner can delete a message"})
+			return
+		}
+		if m.Deleted {
+			c.JSON(http.StatusOK, gin.H{"ok": true, "id": mid.Hex()})
+			return
+		}
+
+		res, err := db.Collection("messages").UpdateOne(ctx,
+			bson.M{"_id": mid, "deleted": bson.M{"$ne": true}},
+			bson.M{
+				"$set":   bson.M{"deleted": true, "deleted_at": time.Now().UnixMilli(), "body": ""},
+				"$unset": bson.M{"body_ref": "", "body_len": "", "truncated": ""},
+			},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if res.ModifiedCount == 0 {
+			// deleted meanwhile; that request sent the event
+			c.JSON(http.StatusOK, gin.H{"ok": true, "id": mid.Hex()})
+			return
+		}
+		if m.BodyRef != nil {
+			dropBodies(ctx, db, []primitive.ObjectID{*m.BodyRef})
+		}
+
+		// a queued push would still deliver the preview
+		if _, err := db.Collection("notifications").DeleteMany(ctx, bson.M{
+			"kind":               "message",
+			"delivered":          false,
+			"payload.message_id": mid.Hex(),
+		}); err != nil {
+			fmt.Println("delete notifications error:", err)
+		}
+
+		if m.SenderID != uid {
+			writeAudit(ctx, db, AuditEntry{
+				Action:         "message.delete",
+				ActorID:        uid,
+				ConversationID: cid,
+				Details:        gin.H{"message_id": mid.Hex(), "sender_id": m.SenderID.Hex()},
+			})
+		}
+
+		broadcaster.Publish(events.New(cid.Hex(), events.MessageDeleted{ID: mid.Hex()}))
+		c.JSON(http.StatusOK, gin.H{"ok": true, "id": mid.Hex()})
 	}
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestMessageExpiry moves the clock past a message's expiry and checks
+// every reader at once: the list, the message itself, unread, the next
+// expiry and the preview quoted by a reply.
+func TestMessageExpiry(t *testing.T) {
+	client, db := testDB(t)
+	clk := useJobClock(t)
+	ann, bob := seedUser(t, db, "ann"), seedUser(t, db, "bob")
+	conv := seedConv(t, db, "ops", ann, bob)
+	r, api := testAPI()
+	api.POST("/messages/:cid", SendMessageHandler(client))
+	api.GET("/messages/:cid", ListMessagesHandler(client))
+	api.GET("/messages/:cid/:mid", GetMessageHandler(client))
+	api.GET("/conversations/:cid/unread", UnreadCountHandler(client))
+	base := "/messages/" + conv.ID.Hex()
+
+	send := func(in gin.H) Message {
+		t.Helper()
+		w := serve(t, r, http.MethodPost, base, &ann, in)
+		var m Message
+		decode(t, w, &m)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("send: %d %s", w.Code, w.Body)
+		}
+		return m
+	}
+	list := func() map[primitive.ObjectID]Message {
+		t.Helper()
+		var msgs []Message
+		decode(t, serve(t, r, http.MethodGet, base+"?with_reply_previews=true", &bob, nil), &msgs)
+		out := make(map[primitive.ObjectID]Message, len(msgs))
+		for _, m := range msgs {
+			out[m.ID] = m
+		}
+		return out
+	}
+	unread := func() int64 {
+		t.Helper()
+		var out struct {
+			Unread int64 `json:"unread"`
+		}
+		decode(t, serve(t, r, http.MethodGet, "/conversations/"+conv.ID.Hex()+"/unread", &bob, nil), &out)
+		return out.Unread
+	}
+	nextExp := func() int64 {
+		t.Helper()
+		exp, err := nextExpiry(testCtx(t), db, []primitive.ObjectID{conv.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return exp
+	}
+
+	send(gin.H{"body": "kept"})
+	parent := send(gin.H{"body": "secret", "expires_in_seconds": 60})
+	reply := send(gin.H{"body": "noted", "reply_to": parent.ID.Hex()})
+
+	msgs := list()
+	if len(msgs) != 3 {
+		t.Fatalf("before expiry: %d messages", len(msgs))
+	}
+	if p := msgs[reply.ID].ReplyPreview; p == nil || p.Body != "secret" || p.Expired {
+		t.Fatalf("before expiry: reply preview %+v", p)
+	}
+	if n := unread(); n != 3 {
+		t.Fatalf("before expiry: %d unread", n)
+	}
+	if exp := nextExp(); exp != parent.ExpiresAt {
+		t.Fatalf("next expiry %d, want %d", exp, parent.ExpiresAt)
+	}
+
+	clk.Advance(61 * time.Second)
+	msgs = list()
+	if _, ok := msgs[parent.ID]; ok || len(msgs) != 2 {
+		t.Fatalf("after expiry: %d messages, parent listed %v", len(msgs), ok)
+	}
+	p := msgs[reply.ID].ReplyPreview
+	if p == nil || !p.Expired || p.Body != "" || p.SenderID != ann.ID || p.Ts != parent.Ts {
+		t.Fatalf("after expiry: reply preview %+v", p)
+	}
+	if w := serve(t, r, http.MethodGet, base+"/"+parent.ID.Hex(), &bob, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expired message: %d, want 404", w.Code)
+	}
+	if n := unread(); n != 2 {
+		t.Fatalf("after expiry: %d unread", n)
+	}
+	if exp := nextExp(); exp != 0 {
+		t.Fatalf("next expiry %d after the only expiring message", exp)
+	}
+
+	// the TTL reaper's turn
+	if _, err := db.Collection("messages").DeleteOne(testCtx(t), bson.M{"_id": parent.ID}); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(t, r, http.MethodGet, base+"?with_reply_previews=true", &bob, nil)
+	var raw []struct {
+		ID           string         `json:"id"`
+		ReplyPreview map[string]any `json:"reply_preview"`
+	}
+	decode(t, w, &raw)
+	for _, m := range raw {
+		if m.ID == reply.ID.Hex() && (len(m.ReplyPreview) != 1 || m.ReplyPreview["expired"] != true) {
+			t.Fatalf("after reaping: reply preview %v", m.ReplyPreview)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+WS pipeline metrics. Every event is stamped when Publish is called
+(envelope.at) and measured again once the frame has been written to a
+socket, so the histogram covers queueing in the send channel plus the
+write itself. Mongo and handler time are before Publish and not included.
+
+Exported in Prometheus text format on GET /metrics (Bearer METRICS_TOKEN
+when that is set) and as JSON under /admin/metrics.
+*/
+
+// delivery buckets, seconds
+var lagBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64 // per bucket, not cumulative
+	sum     float64
+	n       uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.n++
+}
+
+// writeProm renders h in the Prometheus text exposition format.
+func (h *histogram) writeProm(sb *strings.Builder, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cum uint64
+	for i, b := range h.buckets {
+		cum += h.counts[i]
+		fmt.Fprintf(sb, "%s_bucket{le=\"%g\"} %d\n", name, b, cum)
+	}
+	fmt.Fprintf(sb, "%s_bucket{le=\"+Inf\"} %d\n", name, h.n)
+	fmt.Fprintf(sb, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.n)
+}
+
+var wsMetrics = struct {
+	lag     *histogram
+	dropped atomic.Int64
+}{lag: newHistogram(lagBuckets)}
+
+// slowDeliveryThreshold reads WS_SLOW_DELIVERY_MS (default 1000).
+var slowDeliveryThreshold = time.Duration(envInt("WS_SLOW_DELIVERY_MS", 1000)) * time.Millisecond
+
+func debugEnabled() bool {
+	return strings.EqualFold(os.Getenv("LOG_LEVEL"), "debug")
+}
+
+// observeDelivery records one frame written to a socket.
+func observeDelivery(env envelope, cid, uid primitive.ObjectID) {
+	lag := time.Since(env.at)
+	wsMetrics.lag.Observe(lag.Seconds())
+	if lag > slowDeliveryThreshold && debugEnabled() {
+		fmt.Printf("debug: slow ws delivery %s type=%s conversation=%s uid=%s\n",
+			lag.Round(time.Millisecond), env.Type, cid.Hex(), uid.Hex())
+	}
+}
+
+func wsStats() gin.H {
+	h := wsMetrics.lag
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	avg := 0.0
+	if h.n > 0 {
+		avg = h.sum / float64(h.n)
+	}
+	return gin.H{"delivered": h.n, "avg_lag_seconds": avg, "dropped": wsMetrics.dropped.Load()}
+}
+
+// GET /metrics
+func PrometheusHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tok := os.Getenv("METRICS_TOKEN"); tok != "" && c.GetHeader("Authorization") != "Bearer "+tok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		var sb strings.Builder
+		wsMetrics.lag.writeProm(&sb, "ws_event_delivery_seconds", "Time from Publish to the frame being written to a socket.")
+		fmt.Fprintf(&sb, "# HELP ws_events_dropped_total Events dropped because a consumer's buffer was full.\n# TYPE ws_events_dropped_total counter\nws_events_dropped_total %d\n", wsMetrics.dropped.Load())
+		writeInflightProm(&sb)
+		writeMongoProm(&sb)
+		if convCache.enabled() {
+			fmt.Fprintf(&sb, "# TYPE conv_list_cache_hits_total counter\nconv_list_cache_hits_total %d\n", convCache.hits.Load())
+			fmt.Fprintf(&sb, "# TYPE conv_list_cache_misses_total counter\nconv_list_cache_misses_total %d\n", convCache.misses.Load())
+		}
+		c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(sb.String()))
+	}
+}
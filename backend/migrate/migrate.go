@@ -0,0 +1,132 @@
+// Package migrate runs one-off data migrations exactly once per database.
+//
+// Applied ids are recorded in the schema_migrations collection. Run takes an
+// advisory lock document in the same collection first, so when several
+// instances start together one migrates and the others wait, then find
+// nothing left to do.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	collection = "schema_migrations"
+	lockID     = "__lock"
+	// a crashed holder's lock is taken over after this long
+	lockLease = 10 * time.Minute
+)
+
+// Migration is one data change. Up must be safe to re-run if it fails
+// halfway: the id is only recorded after it returns nil.
+type Migration struct {
+	ID          string
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+}
+
+// Run applies every migration in ms not yet recorded, in order, and returns
+// the ids it applied. It stops at the first failure, naming the migration.
+func Run(ctx context.Context, db *mongo.Database, ms []Migration) ([]string, error) {
+	seen := make(map[string]bool, len(ms))
+	for _, m := range ms {
+		if m.ID == "" || m.ID == lockID || seen[m.ID] {
+			return nil, fmt.Errorf("migrate: bad or duplicate id %q", m.ID)
+		}
+		seen[m.ID] = true
+	}
+
+	col := db.Collection(collection)
+	owner := lockOwner()
+	if err := acquire(ctx, col, owner); err != nil {
+		return nil, err
+	}
+	defer release(col, owner)
+
+	done, err := appliedIDs(ctx, col)
+	if err != nil {
+		return nil, err
+	}
+	var applied []string
+	for _, m := range ms {
+		if done[m.ID] {
+			continue
+		}
+		fmt.Printf("migration id=%s running: %s\n", m.ID, m.Description)
+		if err := m.Up(ctx, db); err != nil {
+			return applied, fmt.Errorf("migration %s (%s) failed: %w", m.ID, m.Description, err)
+		}
+		if _, err := col.InsertOne(ctx, bson.M{
+			"_id":         m.ID,
+			"description": m.Description,
+			"applied_at":  time.Now().UnixMilli(),
+		}); err != nil {
+			return applied, fmt.Errorf("migration %s: recording: %w", m.ID, err)
+		}
+		applied = append(applied, m.ID)
+	}
+	return applied, nil
+}
+
+func appliedIDs(ctx context.Context, col *mongo.Collection) (map[string]bool, error) {
+	ids, err := col.Distinct(ctx, "_id", bson.M{"_id": bson.M{"$ne": lockID}})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if s, ok := id.(string); ok {
+			out[s] = true
+		}
+	}
+	return out, nil
+}
+
+func lockOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+}
+
+// acquire takes the lock, polling while another live instance holds it.
+func acquire(ctx context.Context, col *mongo.Collection, owner string) error {
+	for {
+		now := time.Now().UnixMilli()
+		_, err := col.UpdateOne(ctx,
+			bson.M{"_id": lockID, "$or": bson.A{
+				bson.M{"expires_at": bson.M{"$lt": now}},
+				bson.M{"owner": owner},
+			}},
+			bson.M{"$set": bson.M{"owner": owner, "expires_at": now + lockLease.Milliseconds()}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			return nil
+		}
+		// the upsert collides with the live lock document: someone has it
+		if !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("migrate: lock: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.New("migrate: timed out waiting for another instance's migration lock")
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func release(col *mongo.Collection, owner string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := col.DeleteOne(ctx, bson.M{"_id": lockID, "owner": owner}); err != nil {
+		fmt.Println("migrate: lock release error:", err)
+	}
+}
@@ -0,0 +1,171 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testDB returns a fresh database on MONGO_TEST_URI, dropped afterwards.
+func testDB(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database("test_" + primitive.NewObjectID().Hex())
+	t.Cleanup(func() {
+		_ = db.Drop(context.Background())
+		_ = client.Disconnect(context.Background())
+	})
+	return db
+}
+
+func testCtx(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// counted returns migrations that count their runs in runs.
+func counted(runs map[string]int, mu *sync.Mutex, ids ...string) []Migration {
+	ms := make([]Migration, 0, len(ids))
+	for _, id := range ids {
+		ms = append(ms, Migration{ID: id, Description: "test " + id, Up: func(ctx context.Context, db *mongo.Database) error {
+			mu.Lock()
+			runs[id]++
+			mu.Unlock()
+			// long enough for the other runners to pile up on the lock
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		}})
+	}
+	return ms
+}
+
+func TestRunConcurrentAppliesOnce(t *testing.T) {
+	db := testDB(t)
+	var mu sync.Mutex
+	runs := map[string]int{}
+	ms := counted(runs, &mu, "001_a", "002_b", "003_c")
+
+	const n = 4
+	var (
+		wg      sync.WaitGroup
+		start   = make(chan struct{})
+		applied [n][]string
+		errs    [n]error
+	)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			applied[i], errs[i] = Run(testCtx(t), db, ms)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	var all []string
+	for i := range n {
+		if errs[i] != nil {
+			t.Fatalf("runner %d: %v", i, errs[i])
+		}
+		all = append(all, applied[i]...)
+	}
+	slices.Sort(all)
+	if want := []string{"001_a", "002_b", "003_c"}; !slices.Equal(all, want) {
+		t.Fatalf("applied across runners: %v, want %v", all, want)
+	}
+	for id, k := range runs {
+		if k != 1 {
+			t.Errorf("%s ran %d times", id, k)
+		}
+	}
+
+	col := db.Collection(collection)
+	if n, err := col.CountDocuments(testCtx(t), bson.M{"_id": lockID}); err != nil || n != 0 {
+		t.Fatalf("lock left behind: %d, %v", n, err)
+	}
+	if n, err := col.CountDocuments(testCtx(t), bson.M{}); err != nil || n != 3 {
+		t.Fatalf("%d recorded migrations, want 3 (%v)", n, err)
+	}
+
+	// a later start finds nothing to do
+	got, err := Run(testCtx(t), db, ms)
+	if err != nil || len(got) != 0 {
+		t.Fatalf("rerun applied %v, %v", got, err)
+	}
+}
+
+func TestRunTakesOverExpiredLock(t *testing.T) {
+	db := testDB(t)
+	col := db.Collection(collection)
+	if _, err := col.InsertOne(testCtx(t), bson.M{
+		"_id": lockID, "owner": "crashed", "expires_at": time.Now().Add(-time.Minute).UnixMilli(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	runs := map[string]int{}
+	got, err := Run(testCtx(t), db, counted(runs, &mu, "001_a"))
+	if err != nil || !slices.Equal(got, []string{"001_a"}) {
+		t.Fatalf("Run = %v, %v", got, err)
+	}
+}
+
+func TestRunWaitsForLiveLock(t *testing.T) {
+	db := testDB(t)
+	col := db.Collection(collection)
+	if _, err := col.InsertOne(testCtx(t), bson.M{
+		"_id": lockID, "owner": "other", "expires_at": time.Now().Add(time.Minute).UnixMilli(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	runs := map[string]int{}
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	if _, err := Run(ctx, db, counted(runs, &mu, "001_a")); err == nil {
+		t.Fatal("Run went ahead under another instance's lock")
+	}
+	if runs["001_a"] != 0 {
+		t.Fatal("migration ran under another instance's lock")
+	}
+}
+
+func TestRunFailureNotRecorded(t *testing.T) {
+	db := testDB(t)
+	fail := errors.New("boom")
+	calls := 0
+	ms := []Migration{{ID: "001_a", Up: func(context.Context, *mongo.Database) error {
+		calls++
+		if calls == 1 {
+			return fail
+		}
+		return nil
+	}}}
+	if _, err := Run(testCtx(t), db, ms); !errors.Is(err, fail) {
+		t.Fatalf("first run: %v, want %v", err, fail)
+	}
+	got, err := Run(testCtx(t), db, ms)
+	if err != nil || !slices.Equal(got, []string{"001_a"}) {
+		t.Fatalf("second run = %v, %v; the failed migration should run again", got, err)
+	}
+}
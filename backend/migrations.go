@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"backend/migrate"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrations run in this order on startup and with `backend migrate`.
+// Append only; never edit or reorder one that has shipped.
+var migrations = []migrate.Migration{
+	{
+		ID:          "0001_receipts_dedupe",
+		Description: "merge duplicate receipts per (conversation, user) and add the unique index",
+		Up:          dedupeReceipts,
+	},
+	{
+		ID:          "0002_conversations_updated_at",
+		Description: "backfill conversations.updated_at from created_at",
+		Up:          backfillUpdatedAt,
+	},
+	{
+		ID:          "0003_messages_reaction_count",
+		Description: "backfill messages.reaction_count from the reactions collection",
+		Up:          backfillReactionCounts,
+	},
+	{
+		ID:          "0004_users_username_display",
+		Description: "copy username into username_display",
+		Up:          backfillUsernameDisplay,
+	},
+	{
+		ID:          "0005_messages_seq",
+		Description: "number existing messages per conversation and seed message_seq",
+		Up:          backfillMessageSeq,
+	},
+}
+
+// runMigrations applies pending migrations, waiting up to two minutes for
+// another instance that is already migrating.
+func runMigrations(db *mongo.Database) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	return migrate.Run(ctx, db, migrations)
+}
+
+// Receipts written before the unique index existed may have duplicates,
+// which also make ensureReceiptIndexes fail. Keep the furthest position.
+func dedupeReceipts(ctx context.Context, db *mongo.Database) error {
+	col := db.Collection("receipts")
+	cur, err := col.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "last_read_ts", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"c": "$conversation_id", "u": "$user_id"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+			"read":  bson.M{"$max": "$last_read_ts"},
+			"dlv":   bson.M{"$max": "$last_delivered_ts"},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var g struct {
+			IDs  []interface{} `bson:"ids"`
+			Read int64         `bson:"read"`
+			Dlv  int64         `bson:"dlv"`
+		}
+		if err := cur.Decode(&g); err != nil {
+			return err
+		}
+		keep, drop := g.IDs[0], g.IDs[1:]
+		pos := bson.M{"last_read_ts": g.Read}
+		if g.Dlv > 0 {
+			pos["last_delivered_ts"] = g.Dlv
+		}
+		if _, err := col.UpdateByID(ctx, keep, bson.M{"$max": pos}); err != nil {
+			return err
+		}
+		if _, err := col.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": drop}}); err != nil {
+			return err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	return ensureReceiptIndexes(ctx, db)
+}
+
+// Older conversations have no updated_at; the ?since sync has to special
+// case them until this runs.
+func backfillUpdatedAt(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("conversations").UpdateMany(ctx,
+		bson.M{"updated_at": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"updated_at": "$created_at"}}}},
+	)
+	return err
+}
+
+// reaction_count is maintained on add/remove from here on; count what
+// existed before it was.
+func backfillReactionCounts(ctx context.Context, db *mongo.Database) error {
+	cur, err := db.Collection("reactions").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$message_id", "n": bson.M{"$sum": 1}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	var batch []mongo.WriteModel
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := db.Collection("messages").BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false))
+		batch = batch[:0]
+		return err
+	}
+	for cur.Next(ctx) {
+		var g struct {
+			ID primitive.ObjectID `bson:"_id"`
+			N  int64              `bson:"n"`
+		}
+		if err := cur.Decode(&g); err != nil {
+			return err
+		}
+		batch = append(batch, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": g.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"reaction_count": g.N}}))
+		if len(batch) == 500 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// Names were stored lowercased before username_display existed, so the
+// original casing is gone; start display from the stored name.
+func backfillUsernameDisplay(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("users").UpdateMany(ctx,
+		bson.M{"username_display": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"username_display": "$username"}}}},
+	)
+	return err
+}
+
+// Messages stored before seq existed are numbered by (ts, _id) within their
+// conversation; message_seq then continues from the highest number.
+// Imported messages stay unnumbered, as they are from here on.
+func backfillMessageSeq(ctx context.Context, db *mongo.Database) error {
+	opts := options.Aggregate().SetAllowDiskUse(true)
+	cur, err := db.Collection("messages").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"import_key": bson.M{"$exists": false}}}},
+		{{Key: "$setWindowFields", Value: bson.M{
+			"partitionBy": "$conversation_id",
+			"sortBy":      bson.D{{Key: "ts", Value: 1}, {Key: "_id", Value: 1}},
+			"output":      bson.M{"seq": bson.M{"$documentNumber": bson.M{}}},
+		}}},
+		{{Key: "$project", Value: bson.M{"seq": 1}}},
+		{{Key: "$merge", Value: bson.M{"into": "messages", "on": "_id", "whenMatched": "merge", "whenNotMatched": "discard"}}},
+	}, opts)
+	if err != nil {
+		return err
+	}
+	_ = cur.Close(ctx)
+
+	cur, err = db.Collection("messages").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"seq": bson.M{"$exists": true}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$conversation_id", "seq": bson.M{"$max": "$seq"}}}},
+		{{Key: "$merge", Value: bson.M{"into": "message_seq", "on": "_id", "whenMatched": "replace", "whenNotMatched": "insert"}}},
+	}, opts)
+	if err != nil {
+		return err
+	}
+	return cur.Close(ctx)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxPurgeIDs = 500
+
+// POST /conversations/:cid/messages/purge (owner or admin)
+// Body: { "message_ids": ["<mid>", ...] }                      (max 500)
+//
+//	or { "sender_id": "<uid>", "from": <ts>, "to": <ts> }      (inclusive range)
+//
+// Soft-deletes every match in one UpdateMany and emits one messages.purged event.
+func PurgeMessagesHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		var in struct {
+			MessageIDs []string `json:"message_ids"`
+			SenderID   string   `json:"sender_id"`
+			From       int64    `json:"from"`
+			To         int64    `json:"to"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+			return
+		}
+
+		filter := bson.M{"conversation_id": cid, "deleted": bson.M{"$ne": true}}
+		criteria := gin.H{}
+		switch {
+		case len(in.MessageIDs) > 0 && in.SenderID == "":
+			if len(in.MessageIDs) > maxPurgeIDs {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "at most 500 message ids"})
+				return
+			}
+			ids := make([]primitive.ObjectID, 0, len(in.MessageIDs))
+			for _, h := range in.MessageIDs {
+				id, err := mustOID(h)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+					return
+				}
+				ids = append(ids, id)
+			}
+			filter["_id"] = bson.M{"$in": ids}
+			criteria["message_ids"] = len(ids)
+		case in.SenderID != "" && len(in.MessageIDs) == 0:
+			sender, err := mustOID(in.SenderID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sender id"})
+				return
+			}
+			if in.From <= 0 || in.To < in.From {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "from/to must form a valid range"})
+				return
+			}
+			filter["sender_id"] = sender
+			filter["ts"] = bson.M{"$gte": in.From, "$lte": in.To}
+			criteria["sender_id"] = sender.Hex()
+			criteria["from"] = in.From
+			criteria["to"] = in.To
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "give either message_ids or sender_id with from/to"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role != "owner" && !isAdmin(c.GetString("uname")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner or admin only"})
+			return
+		}
+
+		// resolve the exact ids first so the event and audit report what changed
+		raw, err := db.Collection("messages").Distinct(ctx, "_id", filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		ids := make([]primitive.ObjectID, 0, len(raw))
+		hexIDs := make([]string, 0, len(raw))
+		for _, v := range raw {
+			if id, ok := v.(primitive.ObjectID); ok {
+				ids = append(ids, id)
+				hexIDs = append(hexIDs, id.Hex())
+			}
+		}
+		if len(ids) == 0 {
+			c.JSON(http.StatusOK, gin.H{"purged": 0, "ids": []string{}})
+			return
+		}
+
+		// large bodies (blobs.go) go too
+		rawRefs, err := db.Collection("messages").Distinct(ctx, "body_ref",
+			bson.M{"_id": bson.M{"$in": ids}, "body_ref": bson.M{"$exists": true}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		// already deleted ones keep their deleted_at
+		res, err := db.Collection("messages").UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": ids}, "deleted": bson.M{"$ne": true}},
+			bson.M{
+				"$set":   bson.M{"deleted": true, "deleted_at": time.Now().UnixMilli(), "body": ""},
+				"$unset": bson.M{"body_ref": "", "body_len": "", "truncated": ""},
+			},
+			options.Update(),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		refs := make([]primitive.ObjectID, 0, len(rawRefs))
+		for _, v := range rawRefs {
+			if id, ok := v.(primitive.ObjectID); ok {
+				refs = append(refs, id)
+			}
+		}
+		dropBodies(ctx, db, refs)
+
+		// queued pushes would otherwise still deliver previews of purged bodies
+		if _, err := db.Collection("notifications").DeleteMany(ctx, bson.M{
+			"kind":               "message",
+			"delivered":          false,
+			"payload.message_id": bson.M{"$in": hexIDs},
+		}); err != nil {
+			fmt.Println("purge notifications error:", err)
+		}
+
+		criteria["count"] = res.ModifiedCount
+		writeAudit(ctx, db, AuditEntry{
+			Action:         "messages.purge",
+			ActorID:        uid,
+			ConversationID: cid,
+			Details:        criteria,
+		})
+
+		// unread counts skip deleted messages and last_msg shows them as
+		// tombstones, so both are correct as of the next read
+		broadcaster.Publish(events.New(cid.Hex(), events.MessagesPurged{IDs: hexIDs}))
+		c.JSON(http.StatusOK, gin.H{"purged": res.ModifiedCount, "ids": hexIDs})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPurgeBySenderAndRange(t *testing.T) {
+	client, db := testDB(t)
+	ann, bob, sam := seedUser(t, db, "ann"), seedUser(t, db, "bob"), seedUser(t, db, "sam")
+	conv := seedConv(t, db, "ops", ann, bob, sam)
+	other := seedConv(t, db, "elsewhere", ann, sam)
+	r, api := testAPI()
+	api.POST("/conversations/:cid/messages/purge", PurgeMessagesHandler(client))
+
+	base := time.Now().Add(-time.Hour).UnixMilli()
+	seed := func(cid primitive.ObjectID, from User, at int64) primitive.ObjectID {
+		t.Helper()
+		m := Message{ID: primitive.NewObjectID(), ConversationID: cid, SenderID: from.ID, Type: "text", Body: "spam", Ts: base + at}
+		if _, err := db.Collection("messages").InsertOne(testCtx(t), m); err != nil {
+			t.Fatal(err)
+		}
+		return m.ID
+	}
+	before := seed(conv.ID, sam, 0)
+	first := seed(conv.ID, sam, 10)
+	mid := seed(conv.ID, sam, 20)
+	last := seed(conv.ID, sam, 30)
+	after := seed(conv.ID, sam, 40)
+	bobs := seed(conv.ID, bob, 20)
+	elsewhere := seed(other.ID, sam, 20)
+	body := gin.H{"sender_id": sam.ID.Hex(), "from": base + 10, "to": base + 30}
+
+	if w := serve(t, r, http.MethodPost, "/conversations/"+conv.ID.Hex()+"/messages/purge", &bob, body); w.Code != http.StatusForbidden {
+		t.Fatalf("purge by a member: %d, want 403", w.Code)
+	}
+
+	ch := broadcaster.Subscribe(conv.ID)
+	defer broadcaster.Unsubscribe(conv.ID, ch)
+	w := serve(t, r, http.MethodPost, "/conversations/"+conv.ID.Hex()+"/messages/purge", &ann, body)
+	var out struct {
+		Purged int64    `json:"purged"`
+		IDs    []string `json:"ids"`
+	}
+	decode(t, w, &out)
+	want := []string{first.Hex(), mid.Hex(), last.Hex()}
+	slices.Sort(out.IDs)
+	slices.Sort(want)
+	if w.Code != http.StatusOK || out.Purged != 3 || !slices.Equal(out.IDs, want) {
+		t.Fatalf("purge: %d %s", w.Code, w.Body)
+	}
+	select {
+	case e := <-ch:
+		p, ok := e.Payload.(events.MessagesPurged)
+		if !ok || len(p.IDs) != 3 {
+			t.Fatalf("event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no messages.purged event")
+	}
+
+	for id, deleted := range map[primitive.ObjectID]bool{
+		first: true, mid: true, last: true,
+		before: false, after: false, bobs: false, elsewhere: false,
+	} {
+		var m Message
+		if err := db.Collection("messages").FindOne(testCtx(t), bson.M{"_id": id}).Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Deleted != deleted || (m.Body == "") != deleted {
+			t.Errorf("message at %d from %s: deleted %v, body %q", m.Ts-base, m.SenderID.Hex(), m.Deleted, m.Body)
+		}
+	}
+	if n := countDocs(t, db, "audit_log", bson.M{"action": "messages.purge", "details.count": 3}); n != 1 {
+		t.Fatalf("%d purge audit entries", n)
+	}
+}
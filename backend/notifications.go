@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Schema:
+  notifications:
+    - user_id         (ObjectId)
+    - conversation_id (ObjectId)
+    - kind            (string, "pin" | "message" | "reaction")
+    - payload         (object)
+    - created_at      (int64, millis)
+    - delivered       (bool)
+Queue consumed by whatever push delivery the deployment runs.
+*/
+
+type Notification struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	Kind           string             `bson:"kind" json:"kind"`
+	Payload        interface{}        `bson:"payload" json:"payload"`
+	CreatedAt      int64              `bson:"created_at" json:"created_at"`
+	Delivered      bool               `bson:"delivered" json:"delivered"`
+}
+
+// pushPreviewRunes is how much of a body a push payload carries.
+const pushPreviewRunes = 120
+
+func ensureNotificationIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("notifications").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "delivered", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	return err
+}
+
+// notifyOffline enqueues a notification for every member of conv that has no
+// open socket on it, skipping members who muted or snoozed the conversation
+// or are in their quiet hours (dnd.go).
+// Returns how many notifications were queued.
+func notifyOffline(ctx context.Context, db *mongo.Database, conv *Conversation, kind string, payload interface{}) (int, error) {
+	online := broadcaster.ConnectedUsers(conv.ID)
+	members, err := listMembers(ctx, db, conv)
+	if err != nil {
+		return 0, err
+	}
+
+	uids := make([]primitive.ObjectID, 0, len(members))
+	for _, m := range members {
+		if _, ok := online[m.UserID]; !ok {
+			uids = append(uids, m.UserID)
+		}
+	}
+	if len(uids) == 0 {
+		return 0, nil
+	}
+
+	muted, err := quietUsers(ctx, db, conv.ID, uids)
+	if err != nil {
+		return 0, err
+	}
+	dnd, err := dndUsers(ctx, db, uids)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UnixMilli()
+	docs := make([]interface{}, 0, len(uids))
+	for _, uid := range uids {
+		if _, ok := muted[uid]; ok {
+			continue
+		}
+		if _, ok := dnd[uid]; ok {
+			continue
+		}
+		docs = append(docs, Notification{
+			UserID:         uid,
+			ConversationID: conv.ID,
+			Kind:           kind,
+			Payload:        payload,
+			CreatedAt:      now,
+		})
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	_ = ensureNotificationIndexes(ctx, db)
+	if _, err := db.Collection("notifications").InsertMany(ctx, docs); err != nil {
+		return 0, err
+	}
+	return len(docs), nil
+}
+
+// === Message push suppression ===
+//
+// A member who has the conversation open (socket connected and a receipt
+// written within readActiveWindow) probably just saw the message. Their push
+// is held for pushGrace() and dropped if their receipt passes the message
+// ts before then; everyone else is notified immediately. Time here is the
+// job runner's clock, so the window and the grace timer move together.
+
+const readActiveWindow = 20 * time.Second
+
+func pushGrace() time.Duration {
+	return time.Duration(envInt("PUSH_GRACE_SECS", 30)) * time.Second
+}
+
+type userConv struct {
+	uid, cid primitive.ObjectID
+}
+
+func (k userConv) key() string { return "push:" + k.uid.Hex() + ":" + k.cid.Hex() }
+
+var pushState = struct {
+	sync.Mutex
+	lastRead map[userConv]time.Time // last receipt write
+	pending  map[userConv]int64     // ts of the message a held push is for
+}{
+	lastRead: make(map[userConv]time.Time),
+	pending:  make(map[userConv]int64),
+}
+
+// noteReceipt is called from the receipt write path. It records read
+// activity and cancels a held push the new position covers.
+func noteReceipt(uid, cid primitive.ObjectID, lastReadTs int64) {
+	k := userConv{uid, cid}
+	pushState.Lock()
+	defer pushState.Unlock()
+	if len(pushState.lastRead) > 10000 {
+		for old, t := range pushState.lastRead {
+			if jobs.Since(t) >= readActiveWindow {
+				delete(pushState.lastRead, old)
+			}
+		}
+	}
+	pushState.lastRead[k] = jobs.Now()
+	if ts, ok := pushState.pending[k]; ok && lastReadTs >= ts {
+		jobs.Cancel(k.key())
+		delete(pushState.pending, k)
+	}
+}
+
+func readRecently(k userConv) bool {
+	pushState.Lock()
+	defer pushState.Unlock()
+	t, ok := pushState.lastRead[k]
+	return ok && jobs.Since(t) < readActiveWindow
+}
+
+// dispatchMessagePush queues message notifications for every other member,
+// holding back those who appear to be reading the conversation right now.
+// Runs detached from the request.
+func dispatchMessagePush(db *mongo.Database, msg Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var conv Conversation
+	if err := db.Collection("conversations").FindOne(ctx, bson.M{"_id": msg.ConversationID}).Decode(&conv); err != nil {
+		fmt.Println("push dispatch error:", err)
+		return
+	}
+	members, err := listMembers(ctx, db, &conv)
+	if err != nil {
+		fmt.Println("push dispatch error:", err)
+		return
+	}
+	uids := make([]primitive.ObjectID, 0, len(members))
+	for _, m := range members {
+		if m.UserID != msg.SenderID {
+			uids = append(uids, m.UserID)
+		}
+	}
+	if len(uids) == 0 {
+		return
+	}
+	muted, err := quietUsers(ctx, db, conv.ID, uids)
+	if err != nil {
+		fmt.Println("push dispatch error:", err)
+		return
+	}
+	dnd, err := dndUsers(ctx, db, uids)
+	if err != nil {
+		fmt.Println("push dispatch error:", err)
+		return
+	}
+	keywords, err := loadKeywordMatchers(ctx, db, uids)
+	if err != nil {
+		fmt.Println("push dispatch error:", err)
+		return
+	}
+
+	online := broadcaster.ConnectedUsers(conv.ID)
+	payload := messagePushPayload(msg)
+	queue := make([]interface{}, 0, len(uids))
+	// high priority goes through mute, snooze and quiet hours (priority.go)
+	loud := msg.Priority == priorityHigh && highPriorityBypassQuiet()
+	for _, uid := range uids {
+		if _, ok := muted[uid]; ok && !loud {
+			continue
+		}
+		if _, ok := dnd[uid]; ok && !loud {
+			continue
+		}
+		if keywords[uid].Matches(msg.Body) {
+			continue
+		}
+		k := userConv{uid, conv.ID}
+		if _, ok := online[uid]; ok && readRecently(k) {
+			holdPush(db, k, msg.Ts, payload)
+			continue
+		}
+		queue = append(queue, Notification{
+			UserID:         uid,
+			ConversationID: conv.ID,
+			Kind:           "message",
+			Payload:        payload,
+			CreatedAt:      jobs.Now().UnixMilli(),
+		})
+	}
+	if len(queue) == 0 {
+		return
+	}
+	_ = ensureNotificationIndexes(ctx, db)
+	if _, err := db.Collection("notifications").InsertMany(ctx, queue); err != nil {
+		fmt.Println("push dispatch error:", err)
+	}
+}
+
+func messagePushPayload(msg Message) interface{} {
+	preview := msg.Body
+	if msg.Type == "image" {
+		preview = "[image]" // the body is an upload id
+	}
+	out := map[string]interface{}{
+		"message_id": msg.ID.Hex(),
+		"sender_id":  msg.SenderID.Hex(),
+		"preview":    previewRunes(preview, pushPreviewRunes),
+		"ts":         msg.Ts,
+	}
+	if msg.Priority != "" {
+		out["priority"] = msg.Priority
+	}
+	return out
+}
+
+// holdPush schedules the push for k after the grace period. A newer message
+// replaces an already-held one, so at most one push per user+conversation waits.
+func holdPush(db *mongo.Database, k userConv, ts int64, payload interface{}) {
+	pushState.Lock()
+	pushState.pending[k] = ts
+	pushState.Unlock()
+
+	jobs.Schedule(k.key(), pushGrace(), func() {
+		pushState.Lock()
+		if pushState.pending[k] != ts {
+			pushState.Unlock()
+			return
+		}
+		delete(pushState.pending, k)
+		pushState.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := db.Collection("notifications").InsertOne(ctx, Notification{
+			UserID:         k.uid,
+			ConversationID: k.cid,
+			Kind:           "message",
+			Payload:        payload,
+			CreatedAt:      jobs.Now().UnixMilli(),
+		})
+		if err != nil {
+			fmt.Println("delayed push error:", err)
+		}
+	})
+}
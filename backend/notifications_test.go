@@ -0,0 +1,220 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestPreviewRunes(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"", 3, ""},
+		{"abc", 3, "abc"},
+		{"abcd", 3, "abc"},
+		{"héllo", 2, "hé"},
+		{"日本語です", 3, "日本語"},
+		{"👋🏽 hi", 1, "👋"},
+		{"abc", 0, ""},
+	} {
+		if got := previewRunes(tt.in, tt.n); got != tt.want {
+			t.Errorf("previewRunes(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestMessagePushPayloadPreview(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		msg  Message
+		want string
+	}{
+		{"short", Message{Type: "text", Body: "hi"}, "hi"},
+		{"ascii", Message{Type: "text", Body: strings.Repeat("a", 200)}, strings.Repeat("a", pushPreviewRunes)},
+		// byte 120 falls inside an é
+		{"accents", Message{Type: "text", Body: "x" + strings.Repeat("é", 200)}, "x" + strings.Repeat("é", pushPreviewRunes-1)},
+		{"cjk", Message{Type: "text", Body: strings.Repeat("語", 200)}, strings.Repeat("語", pushPreviewRunes)},
+		{"image", Message{Type: "image", Body: "65f0c0ffee0000000000beef"}, "[image]"},
+	} {
+		p := messagePushPayload(tt.msg).(map[string]interface{})
+		got := p["preview"].(string)
+		if got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("%s: preview %q (%d runes), want %d runes", tt.name, got, utf8.RuneCountInString(got), utf8.RuneCountInString(tt.want))
+		}
+	}
+}
+
+// fakeClock only moves when Advance is called, and runs the jobs that
+// fall due on the caller's goroutine.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	c    *fakeClock
+	at   time.Time
+	fn   func()
+	done bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, fn func()) stopper {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, at: c.now.Add(d), fn: fn}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	was := !t.done
+	t.done = true
+	return was
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	for _, t := range c.timers {
+		if !t.done && !t.at.After(c.now) {
+			t.done = true
+			due = append(due, t)
+		}
+	}
+	c.mu.Unlock()
+	sort.Slice(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, t := range due {
+		t.fn()
+	}
+}
+
+// useJobClock swaps the global job runner for one on a fake clock.
+func useJobClock(t *testing.T) *fakeClock {
+	t.Helper()
+	c := &fakeClock{now: time.Now()}
+	old := jobs
+	jobs = newJobRunnerWithClock(c)
+	t.Cleanup(func() { jobs = old })
+	return c
+}
+
+// heldPushFixture is ops with bob online and just past a receipt, so a
+// message from ann holds his push.
+func heldPushFixture(t *testing.T) (*fakeClock, *mongo.Database, User, Message) {
+	t.Helper()
+	_, db := testDB(t)
+	clk := useJobClock(t)
+	ann, bob := seedUser(t, db, "ann"), seedUser(t, db, "bob")
+	conv := seedConv(t, db, "ops", ann, bob)
+	k := userConv{bob.ID, conv.ID}
+	t.Cleanup(func() {
+		pushState.Lock()
+		delete(pushState.lastRead, k)
+		delete(pushState.pending, k)
+		pushState.Unlock()
+	})
+	sock := &wsClient{uid: bob.ID, cid: conv.ID}
+	broadcaster.Join(sock)
+	t.Cleanup(func() { broadcaster.Leave(sock) })
+
+	noteReceipt(bob.ID, conv.ID, clk.Now().UnixMilli())
+	clk.Advance(time.Second)
+	msg := Message{ID: primitive.NewObjectID(), ConversationID: conv.ID, SenderID: ann.ID, Type: "text", Body: "hi", Ts: clk.Now().UnixMilli()}
+	dispatchMessagePush(db, msg)
+	if n := countDocs(t, db, "notifications", bson.M{}); n != 0 {
+		t.Fatalf("%d notifications queued for a reader", n)
+	}
+	return clk, db, bob, msg
+}
+
+func TestHeldPushCancelledByReceipt(t *testing.T) {
+	clk, db, bob, msg := heldPushFixture(t)
+	clk.Advance(5 * time.Second)
+	noteReceipt(bob.ID, msg.ConversationID, msg.Ts)
+	clk.Advance(pushGrace())
+	if n := countDocs(t, db, "notifications", bson.M{}); n != 0 {
+		t.Fatalf("%d notifications after the receipt covered the message", n)
+	}
+}
+
+func TestHeldPushDeliveredAfterGrace(t *testing.T) {
+	t.Setenv("PUSH_GRACE_SECS", "10")
+	clk, db, bob, msg := heldPushFixture(t)
+	// a receipt short of the message keeps the push held
+	clk.Advance(5 * time.Second)
+	noteReceipt(bob.ID, msg.ConversationID, msg.Ts-1)
+	if n := countDocs(t, db, "notifications", bson.M{}); n != 0 {
+		t.Fatalf("%d notifications inside the grace period", n)
+	}
+	clk.Advance(5 * time.Second)
+	var n Notification
+	if err := db.Collection("notifications").FindOne(testCtx(t), bson.M{"user_id": bob.ID}).Decode(&n); err != nil {
+		t.Fatalf("no push after the grace period: %v", err)
+	}
+	if n.Kind != "message" || n.CreatedAt != clk.Now().UnixMilli() {
+		t.Fatalf("push %+v", n)
+	}
+	if c := countDocs(t, db, "notifications", bson.M{}); c != 1 {
+		t.Fatalf("%d notifications, want 1", c)
+	}
+}
+
+func TestHighPriorityPushBypassesMute(t *testing.T) {
+	client, db := testDB(t)
+	ann, bob, cat := seedUser(t, db, "ann"), seedUser(t, db, "bob"), seedUser(t, db, "cat")
+	conv := seedConv(t, db, "ops", ann, bob, cat)
+	r, api := testAPI()
+	api.PUT("/conversations/:cid/mute", SetMuteHandler(client))
+	if w := serve(t, r, http.MethodPut, "/conversations/"+conv.ID.Hex()+"/mute", &bob, gin.H{"muted": true}); w.Code != http.StatusOK {
+		t.Fatalf("mute: %d %s", w.Code, w.Body)
+	}
+	push := func(priority string) primitive.ObjectID {
+		t.Helper()
+		m := Message{ID: primitive.NewObjectID(), ConversationID: conv.ID, SenderID: ann.ID, Type: "text", Body: "disk full", Ts: time.Now().UnixMilli(), Priority: priority}
+		dispatchMessagePush(db, m)
+		return m.ID
+	}
+	pushed := func(to User, mid primitive.ObjectID) bool {
+		t.Helper()
+		return countDocs(t, db, "notifications", bson.M{"user_id": to.ID, "payload.message_id": mid.Hex()}) == 1
+	}
+
+	normal := push("")
+	if pushed(bob, normal) || !pushed(cat, normal) {
+		t.Fatal("normal message: bob muted, cat not")
+	}
+	high := push(priorityHigh)
+	if !pushed(bob, high) || !pushed(cat, high) {
+		t.Fatal("high priority message: every other member")
+	}
+	if n := countDocs(t, db, "notifications", bson.M{"user_id": bob.ID, "payload.priority": priorityHigh}); n != 1 {
+		t.Fatalf("bob's push carries no priority (%d)", n)
+	}
+
+	t.Setenv("HIGH_PRIORITY_BYPASS_QUIET", "false")
+	if off := push(priorityHigh); pushed(bob, off) || !pushed(cat, off) {
+		t.Fatal("HIGH_PRIORITY_BYPASS_QUIET=false: mute holds")
+	}
+}
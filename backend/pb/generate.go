@@ -0,0 +1,5 @@
+// Package pb holds the generated gRPC API (see im.proto).
+package pb
+
+// needs buf, protoc-gen-go and protoc-gen-go-grpc on PATH
+//go:generate buf generate
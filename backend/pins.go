@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxPins caps the pins per conversation (PINS_MAX, default 20).
+func maxPins() int { return envInt("PINS_MAX", 20) }
+
+// POST /conversations/:cid/pins/:mid (owner only)
+// Body (optional): { "notify": true }
+// notify also queues a notification for members who are not connected.
+// 409 once the conversation has PINS_MAX pins.
+func PinMessageHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		mid, err := mustOID(c.Param("mid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+
+		var in struct {
+			Notify bool `json:"notify"`
+		}
+		_ = c.ShouldBindJSON(&in) // allow empty body
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role != "owner" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+			return
+		}
+
+		var msg Message
+		err = db.Collection("messages").FindOne(ctx, visible(bson.M{"_id": mid, "conversation_id": cid})).Decode(&msg)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		// push only if not pinned yet and under the cap, then read back
+		// the current list
+		pin := Pin{MessageID: mid, PinnedBy: uid, PinnedAt: time.Now().UnixMilli()}
+		var conv Conversation
+		err = db.Collection("conversations").FindOneAndUpdate(ctx,
+			bson.M{
+				"_id":             cid,
+				"pins.message_id": bson.M{"$ne": mid},
+				"$expr": bson.M{"$lt": bson.A{
+					bson.M{"$size": bson.M{"$ifNull": bson.A{"$pins", bson.A{}}}}, maxPins(),
+				}},
+			},
+			bson.M{"$push": bson.M{"pins": pin}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&conv)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// already pinned, or full
+			n, err := db.Collection("conversations").CountDocuments(ctx,
+				bson.M{"_id": cid, "pins.message_id": mid})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			if n > 0 {
+				c.JSON(http.StatusOK, gin.H{"ok": true, "already_pinned": true})
+				return
+			}
+			c.JSON(http.StatusConflict, gin.H{"error": "pin limit reached", "max": maxPins()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		broadcaster.Publish(events.New(cid.Hex(), events.MessagePinned{
+			MessageID: mid.Hex(), PinnedBy: uid.Hex(), PinnedAt: pin.PinnedAt,
+		}))
+		pins := pinsUpdatedPayload(conv.Pins)
+		broadcaster.Publish(events.New(cid.Hex(), pins))
+
+		queued := 0
+		if in.Notify {
+			queued, err = notifyOffline(ctx, db, &conv, "pin", gin.H{
+				"message_id": mid.Hex(),
+				"pinned_by":  uid.Hex(),
+				"preview":    previewRunes(msg.Body, pushPreviewRunes),
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "notify error"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"ok": true, "pins": pins.Pins, "notified": queued})
+	}
+}
+
+// pinsUpdatedPayload is the pin list as pins.updated (and the pin response)
+// carries it.
+func pinsUpdatedPayload(pins []Pin) events.PinsUpdated {
+	out := events.PinsUpdated{Pins: make([]events.Pin, 0, len(pins))}
+	for _, p := range pins {
+		out.Pins = append(out.Pins, events.Pin{
+			MessageID: p.MessageID.Hex(),
+			PinnedBy:  p.PinnedBy.Hex(),
+			PinnedAt:  p.PinnedAt,
+		})
+	}
+	return out
+}
+
+// DELETE /conversations/:cid/pins/:mid (owner only)
+// Unpinning a message that isn't pinned is a no-op 200.
+func UnpinMessageHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		mid, err := mustOID(c.Param("mid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role != "owner" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+			return
+		}
+
+		var conv Conversation
+		err = db.Collection("conversations").FindOneAndUpdate(ctx,
+			bson.M{"_id": cid, "pins.message_id": mid},
+			bson.M{"$pull": bson.M{"pins": bson.M{"message_id": mid}}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&conv)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusOK, gin.H{"ok": true, "was_pinned": false})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		broadcaster.Publish(events.New(cid.Hex(), events.MessageUnpinned{
+			MessageID: mid.Hex(), UnpinnedBy: uid.Hex(),
+		}))
+		pins := pinsUpdatedPayload(conv.Pins)
+		broadcaster.Publish(events.New(cid.Hex(), pins))
+		c.JSON(http.StatusOK, gin.H{"ok": true, "was_pinned": true, "pins": pins.Pins})
+	}
+}
+
+type pinnedMessage struct {
+	Pin
+	Message *Message `json:"message"`
+}
+
+// GET /conversations/:cid/pins (members)
+// Returns: { pins: [{ message_id, pinned_by, pinned_at, message }] } in pin
+// order. message is the pinned message (a tombstone if deleted since), or
+// null once it has expired.
+func ListPinsHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		member, err := isMember(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !member {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+
+		var conv Conversation
+		err = db.Collection("conversations").FindOne(ctx, bson.M{"_id": cid},
+			options.FindOne().SetProjection(bson.M{"pins": 1})).Decode(&conv)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		out := make([]pinnedMessage, 0, len(conv.Pins))
+		if len(conv.Pins) == 0 {
+			c.JSON(http.StatusOK, gin.H{"pins": out})
+			return
+		}
+
+		ids := make([]primitive.ObjectID, len(conv.Pins))
+		for i, p := range conv.Pins {
+			ids[i] = p.MessageID
+		}
+		cur, err := db.Collection("messages").Find(ctx,
+			unexpired(bson.M{"_id": bson.M{"$in": ids}, "conversation_id": cid}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		var msgs []Message
+		if err := cur.All(ctx, &msgs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+		byID := make(map[primitive.ObjectID]*Message, len(msgs))
+		for i := range msgs {
+			msgs[i].tombstone()
+			byID[msgs[i].ID] = &msgs[i]
+		}
+		for _, p := range conv.Pins {
+			out = append(out, pinnedMessage{Pin: p, Message: byID[p.MessageID]})
+		}
+		c.JSON(http.StatusOK, gin.H{"pins": out})
+	}
+}
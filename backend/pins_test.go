@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPinNotifyPreview(t *testing.T) {
+	client, db := testDB(t)
+	owner := seedUser(t, db, "owner")
+	bob := seedUser(t, db, "bob")
+	conv := seedConv(t, db, "ops", owner, bob)
+	// byte 120 falls inside an é
+	body := "x" + strings.Repeat("é", 200)
+	msg := Message{ID: primitive.NewObjectID(), ConversationID: conv.ID, SenderID: bob.ID, Type: "text", Body: body, Ts: 1}
+	if _, err := db.Collection("messages").InsertOne(testCtx(t), msg); err != nil {
+		t.Fatal(err)
+	}
+	r, api := testAPI()
+	api.POST("/conversations/:cid/pins/:mid", PinMessageHandler(client))
+
+	if w := serve(t, r, http.MethodPost, "/conversations/"+conv.ID.Hex()+"/pins/"+msg.ID.Hex(), &owner, gin.H{"notify": true}); w.Code != http.StatusOK {
+		t.Fatalf("pin: %d %s", w.Code, w.Body)
+	}
+	var n struct {
+		Payload struct {
+			Preview string `bson:"preview"`
+		} `bson:"payload"`
+	}
+	if err := db.Collection("notifications").FindOne(testCtx(t), bson.M{"user_id": bob.ID, "kind": "pin"}).Decode(&n); err != nil {
+		t.Fatal(err)
+	}
+	if want := "x" + strings.Repeat("é", pushPreviewRunes-1); n.Payload.Preview != want || !utf8.ValidString(n.Payload.Preview) {
+		t.Fatalf("preview %q (%d runes)", n.Payload.Preview, utf8.RuneCountInString(n.Payload.Preview))
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// === Profanity filter ===
+// Word list comes from PROFANITY_WORDS (comma separated, case-insensitive).
+// Empty list disables the filter.
+
+func profanityWords() []string {
+	var out []string
+	for _, w := range strings.Split(os.Getenv("PROFANITY_WORDS"), ",") {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+func containsProfanity(s string) bool {
+	s = strings.ToLower(s)
+	for _, w := range profanityWords() {
+		if strings.Contains(s, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// === Conversation titles ===
+
+const defaultTitle = "New Conversation"
+
+// title rejection codes returned to clients as "code"
+const (
+	TitleEmpty     = "title_empty"
+	TitleTooLong   = "title_too_long"
+	TitleProfanity = "title_profanity"
+)
+
+// titleError carries a client-facing code; description and avatar
+// validation below use it too, so titleErrCode works for all three.
+type titleError struct {
+	Code string
+	Msg  string
+}
+
+func (e *titleError) Error() string { return e.Msg }
+
+func titleMaxLen() int {
+	return envInt("TITLE_MAX_LEN", 100)
+}
+
+// cleanTitle strips control characters and surrounding whitespace, then
+// enforces length and profanity rules. An empty result is a TitleEmpty error
+// so callers decide whether to default it.
+func cleanTitle(s string) (string, error) {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return "", &titleError{TitleEmpty, "title can't be empty"}
+	}
+	if max := titleMaxLen(); utf8.RuneCountInString(s) > max {
+		return "", &titleError{TitleTooLong, "title is too long"}
+	}
+	if containsProfanity(s) {
+		return "", &titleError{TitleProfanity, "title contains blocked words"}
+	}
+	return s, nil
+}
+
+// normalizeTitle is the title rule for create, rename and split: cleanTitle,
+// except that an empty or whitespace-only title becomes defaultTitle.
+func normalizeTitle(s string) (string, error) {
+	title, err := cleanTitle(s)
+	if titleErrCode(err) == TitleEmpty {
+		return defaultTitle, nil
+	}
+	return title, err
+}
+
+// titleErrCode extracts the client-facing code from a cleanTitle error.
+func titleErrCode(err error) string {
+	var te *titleError
+	if errors.As(err, &te) {
+		return te.Code
+	}
+	return ""
+}
+
+// === Conversation description & avatar ===
+
+const (
+	DescriptionTooLong   = "description_too_long"
+	DescriptionProfanity = "description_profanity"
+	AvatarInvalid        = "avatar_invalid"
+)
+
+func descriptionMaxLen() int {
+	return envInt("DESCRIPTION_MAX_LEN", 500)
+}
+
+// cleanDescription strips control characters (newlines are kept) and
+// surrounding whitespace. Empty is allowed and clears the description.
+func cleanDescription(s string) (string, error) {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+
+	if max := descriptionMaxLen(); utf8.RuneCountInString(s) > max {
+		return "", &titleError{DescriptionTooLong, "description is too long"}
+	}
+	if containsProfanity(s) {
+		return "", &titleError{DescriptionProfanity, "description contains blocked words"}
+	}
+	return s, nil
+}
+
+// cleanAvatarURL accepts an absolute http(s) URL of at most 2048 bytes.
+// Empty is allowed and removes the avatar.
+func cleanAvatarURL(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || len(s) > 2048 || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", &titleError{AvatarInvalid, "avatar_url must be an http(s) URL"}
+	}
+	return s, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNormalizeTitle(t *testing.T) {
+	t.Setenv("TITLE_MAX_LEN", "5")
+	t.Setenv("PROFANITY_WORDS", "darn")
+	for _, tt := range []struct {
+		in, want, code string
+	}{
+		{"ops", "ops", ""},
+		{"  ops\t", "ops", ""},
+		{"o\x00p\x1bs", "ops", ""},
+		{"", defaultTitle, ""},
+		{" \t\n ", defaultTitle, ""},
+		{"\x00\x07", defaultTitle, ""},
+		{"héllo", "héllo", ""}, // five runes, six bytes
+		{"héllos", "", TitleTooLong},
+		{"DARN", "", TitleProfanity},
+	} {
+		got, err := normalizeTitle(tt.in)
+		if got != tt.want || titleErrCode(err) != tt.code {
+			t.Errorf("normalizeTitle(%q) = %q, %q; want %q, %q", tt.in, got, titleErrCode(err), tt.want, tt.code)
+		}
+	}
+
+	// cleanTitle itself still reports the blank title
+	if _, err := cleanTitle("  "); titleErrCode(err) != TitleEmpty {
+		t.Errorf("cleanTitle blank: %v", err)
+	}
+}
+
+func TestCleanDescription(t *testing.T) {
+	t.Setenv("DESCRIPTION_MAX_LEN", "8")
+	t.Setenv("PROFANITY_WORDS", "darn")
+	for _, tt := range []struct {
+		in, want, code string
+	}{
+		{"", "", ""},
+		{"  a\nb\r\x00 ", "a\nb", ""},
+		{"ééééééé\n", "ééééééé", ""},
+		{"123456789", "", DescriptionTooLong},
+		{"oh darn", "", DescriptionProfanity},
+	} {
+		got, err := cleanDescription(tt.in)
+		if got != tt.want || titleErrCode(err) != tt.code {
+			t.Errorf("cleanDescription(%q) = %q, %q; want %q, %q", tt.in, got, titleErrCode(err), tt.want, tt.code)
+		}
+	}
+}
+
+func TestTitleErrCode(t *testing.T) {
+	if code := titleErrCode(nil); code != "" {
+		t.Errorf("nil: %q", code)
+	}
+	if code := titleErrCode(errors.New("db down")); code != "" {
+		t.Errorf("plain error: %q", code)
+	}
+	_, err := cleanAvatarURL("ftp://example.com/a.png")
+	if code := titleErrCode(errors.Join(errors.New("rename"), err)); code != AvatarInvalid {
+		t.Errorf("wrapped avatar error: %q", code)
+	}
+}
+
+func TestTitleRuleOnCreateAndRename(t *testing.T) {
+	client, db := testDB(t)
+	t.Setenv("TITLE_MAX_LEN", "10")
+	owner := seedUser(t, db, "owner")
+	bob := seedUser(t, db, "bob")
+	r, api := testAPI()
+	api.POST("/conversations", CreateConverHandler(client))
+	api.PATCH("/conversations/:cid", RenameConverHandler(client))
+
+	title := func(cid primitive.ObjectID) string {
+		t.Helper()
+		var conv Conversation
+		if err := db.Collection("conversations").FindOne(testCtx(t), bson.M{"_id": cid}).Decode(&conv); err != nil {
+			t.Fatal(err)
+		}
+		return conv.Title
+	}
+
+	w := serve(t, r, http.MethodPost, "/conversations", &owner, gin.H{"title": " \t ", "members": []string{bob.Username}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	var conv Conversation
+	decode(t, w, &conv)
+	if got := title(conv.ID); got != defaultTitle {
+		t.Fatalf("blank title created as %q", got)
+	}
+
+	for _, tt := range []struct {
+		in   string
+		code int
+		want string
+	}{
+		{"  ops  ", http.StatusOK, "ops"},
+		{"   ", http.StatusOK, defaultTitle},
+		{strings.Repeat("x", 11), http.StatusBadRequest, defaultTitle},
+	} {
+		w := serve(t, r, http.MethodPatch, "/conversations/"+conv.ID.Hex(), &owner, gin.H{"title": tt.in})
+		if w.Code != tt.code {
+			t.Fatalf("rename to %q: %d %s", tt.in, w.Code, w.Body)
+		}
+		if tt.code == http.StatusBadRequest && !strings.Contains(w.Body.String(), TitleTooLong) {
+			t.Fatalf("rename to %q: %s", tt.in, w.Body)
+		}
+		if got := title(conv.ID); got != tt.want {
+			t.Fatalf("after rename to %q: title %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  conv_prefs:
+    - conversation_id (ObjectId)
+    - user_id         (ObjectId)
+    - muted           (bool)
+    - snooze_until    (int64, millis; hidden from the list and silent until then)
+    - snooze_bump     (bool, float to the top of the list when the snooze ends)
+Unique index on (conversation_id, user_id)
+Per-user settings for one conversation. No document means defaults.
+*/
+
+type ConvPrefs struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Muted          bool               `bson:"muted" json:"muted"`
+	SnoozeUntil    int64              `bson:"snooze_until,omitempty" json:"snooze_until,omitempty"`
+	SnoozeBump     bool               `bson:"snooze_bump,omitempty" json:"snooze_bump,omitempty"`
+}
+
+func (p ConvPrefs) Snoozed(now int64) bool {
+	return p.SnoozeUntil > now
+}
+
+func ensurePrefsIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("conv_prefs").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "conversation_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// quietUsers returns which of uids should not be notified about the
+// conversation right now (muted or snoozed).
+func quietUsers(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, uids []primitive.ObjectID) (map[primitive.ObjectID]struct{}, error) {
+	out := make(map[primitive.ObjectID]struct{})
+	cur, err := db.Collection("conv_prefs").Find(ctx, bson.M{
+		"conversation_id": cid,
+		"user_id":         bson.M{"$in": uids},
+		"$or": bson.A{
+			bson.M{"muted": true},
+			bson.M{"snooze_until": bson.M{"$gt": time.Now().UnixMilli()}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var p ConvPrefs
+		if err := cur.Decode(&p); err != nil {
+			return nil, err
+		}
+		out[p.UserID] = struct{}{}
+	}
+	return out, nil
+}
+
+// userPrefs loads all of uid's per-conversation prefs keyed by conversation.
+func userPrefs(ctx context.Context, db *mongo.Database, uid primitive.ObjectID) (map[primitive.ObjectID]ConvPrefs, error) {
+	cur, err := db.Collection("conv_prefs").Find(ctx, bson.M{"user_id": uid})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	out := make(map[primitive.ObjectID]ConvPrefs)
+	for cur.Next(ctx) {
+		var p ConvPrefs
+		if err := cur.Decode(&p); err != nil {
+			return nil, err
+		}
+		out[p.ConversationID] = p
+	}
+	return out, nil
+}
+
+// PUT /conversations/:cid/mute
+// Body: { "muted": true }
+func SetMuteHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		var in struct {
+			Muted *bool `json:"muted"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil || in.Muted == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "muted (bool) required"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		ok, err := isMember(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+
+		if err := ensurePrefsIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
+			return
+		}
+
+		_, err = db.Collection("conv_prefs").UpdateOne(ctx,
+			bson.M{"conversation_id": cid, "user_id": uid},
+			bson.M{"$set": bson.M{"muted": *in.Muted}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		invalidateConvList(uid)
+		c.JSON(http.StatusOK, gin.H{"ok": true, "muted": *in.Muted})
+	}
+}
+
+// POST /conversations/:cid/snooze
+// Body: { "until": <int64 millis>, "bump": true }
+// until <= now (or 0) ends the snooze. bump floats it to the top when it wakes.
+func SnoozeHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		var in struct {
+			Until int64 `json:"until"`
+			Bump  bool  `json:"bump"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+			return
+		}
+		if in.Until <= time.Now().UnixMilli() {
+			in.Until = 0
+			in.Bump = false
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		ok, err := isMember(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+
+		if err := ensurePrefsIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
+			return
+		}
+
+		_, err = db.Collection("conv_prefs").UpdateOne(ctx,
+			bson.M{"conversation_id": cid, "user_id": uid},
+			bson.M{"$set": bson.M{"snooze_until": in.Until, "snooze_bump": in.Bump}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		invalidateConvList(uid)
+		c.JSON(http.StatusOK, gin.H{"ok": true, "snooze_until": in.Until, "bump": in.Bump})
+	}
+}
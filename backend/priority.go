@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+High-priority messages (pager-style alerts). A send may carry
+priority: "high" if the sender owns the conversation or is a bot whose
+BOT_API_KEYS entry has the notify scope ("key:username:notify"). Such
+messages:
+  - carry "priority": "high" in message.created, so clients can use a
+    distinct sound
+  - are pushed even to members who muted/snoozed the conversation or are in
+    quiet hours, unless HIGH_PRIORITY_BYPASS_QUIET=false
+  - are counted separately as unread_high
+Each sender may send HIGH_PRIORITY_PER_HOUR (default 20) of them per hour.
+*/
+
+const priorityHigh = "high"
+
+var highPriorityLimiter = newWindowLimiter(envInt("HIGH_PRIORITY_PER_HOUR", 20), time.Hour)
+
+func highPriorityBypassQuiet() bool {
+	return os.Getenv("HIGH_PRIORITY_BYPASS_QUIET") != "false"
+}
+
+// checkPriority validates in.Priority for uid (with role in the target
+// conversation) and returns the value to store ("" for normal).
+func checkPriority(uid primitive.ObjectID, role string, in sendInput) (string, error) {
+	switch in.Priority {
+	case "", "normal":
+		return "", nil
+	case priorityHigh:
+	default:
+		return "", svcFail(http.StatusBadRequest, "priority must be normal or high")
+	}
+	if role != "owner" && !in.NotifyScope {
+		return "", svcFail(http.StatusForbidden, "high priority needs the notify scope or ownership")
+	}
+	if !highPriorityLimiter.Allow(uid.Hex()) {
+		return "", rateLimited("too many high priority messages, try again later",
+			highPriorityLimiter.status(uid.Hex()))
+	}
+	return priorityHigh, nil
+}
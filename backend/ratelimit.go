@@ -0,0 +1,232 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimit describes the limit behind a 429: Limit requests per window,
+// Remaining of them left, and Reset until the next is allowed. Every 429
+// goes out through rateLimited (or abortRateLimited) so clients always get
+// the same headers and body:
+//
+//	Retry-After, X-RateLimit-Reset: seconds until retrying can work (>= 1)
+//	X-RateLimit-Limit, X-RateLimit-Remaining
+//	{ "error": "...", "code": "rate_limited", "retry_after_secs",
+//	  "limit", "remaining", "reset_secs" }
+//
+// The body repeats the headers for clients that can't read them.
+type rateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Duration
+}
+
+// rateLimited is the 429 for rl.
+func rateLimited(msg string, rl rateLimit) *svcError {
+	return &svcError{Status: http.StatusTooManyRequests, Msg: msg, RateLimit: &rl}
+}
+
+// abortRateLimited writes the 429 for rl from a middleware.
+func abortRateLimited(c *gin.Context, msg string, rl rateLimit) {
+	writeSvcError(c, rateLimited(msg, rl))
+	c.Abort()
+}
+
+// apply sets the headers and adds the body fields.
+func (rl rateLimit) apply(c *gin.Context, body gin.H) {
+	secs := max(waitSecs(rl.Reset), 1)
+	remaining := max(rl.Remaining, 0)
+	c.Header("Retry-After", strconv.Itoa(secs))
+	c.Header("X-RateLimit-Limit", strconv.Itoa(rl.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("X-RateLimit-Reset", strconv.Itoa(secs))
+	body["code"] = "rate_limited"
+	body["retry_after_secs"] = secs
+	body["limit"] = rl.Limit
+	body["remaining"] = remaining
+	body["reset_secs"] = secs
+}
+
+// rateLimiter is a small in-memory token bucket keyed by an arbitrary string
+// (client IP, user id, ...). It is per-process only.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens refilled per second
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow consumes one token for key and reports whether it was available.
+func (l *rateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		// keep memory bounded: drop buckets that have fully refilled
+		if len(l.buckets) > 10000 {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// status is key's limit as of now: the burst, whole tokens left, and how
+// long until the next token.
+func (l *rateLimiter) status(key string) rateLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rl := rateLimit{Limit: int(l.burst), Remaining: int(l.burst)}
+	b, ok := l.buckets[key]
+	if !ok {
+		return rl
+	}
+	tokens := math.Min(l.burst, b.tokens+time.Since(b.last).Seconds()*l.rate)
+	rl.Remaining = int(tokens)
+	if tokens < 1 && l.rate > 0 {
+		rl.Reset = time.Duration((1 - tokens) / l.rate * float64(time.Second))
+	}
+	return rl
+}
+
+func (l *rateLimiter) prune(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// RateLimitByIP rejects requests with 429 once the client IP runs out of tokens.
+func RateLimitByIP(l *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.Allow(c.ClientIP()) {
+			abortRateLimited(c, "rate limited", l.status(c.ClientIP()))
+			return
+		}
+		c.Next()
+	}
+}
+
+// windowLimiter allows at most limit events per key within a sliding window,
+// tracking the individual event timestamps.
+type windowLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[string][]time.Time
+}
+
+func newWindowLimiter(limit int, window time.Duration) *windowLimiter {
+	return &windowLimiter{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string][]time.Time),
+	}
+}
+
+// Allow records an event for key if it is under the limit.
+func (l *windowLimiter) Allow(key string) bool {
+	return l.AllowLimit(key, l.limit)
+}
+
+// AllowLimit is Allow with limit in place of l's own, for budgets that
+// differ per key (sendrate.go).
+func (l *windowLimiter) AllowLimit(key string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-l.window)
+	ts, ok := l.hits[key]
+	if !ok && len(l.hits) > 10000 {
+		// keep memory bounded: drop keys with nothing left in the window
+		for k, v := range l.hits {
+			if len(v) == 0 || !v[len(v)-1].After(cutoff) {
+				delete(l.hits, k)
+			}
+		}
+	}
+	i := 0
+	for i < len(ts) && !ts[i].After(cutoff) {
+		i++
+	}
+	ts = ts[i:]
+
+	if len(ts) >= limit {
+		l.hits[key] = ts
+		return false
+	}
+	l.hits[key] = append(ts, now)
+	return true
+}
+
+// status is key's limit as of now.
+func (l *windowLimiter) status(key string) rateLimit {
+	return l.statusLimit(key, l.limit)
+}
+
+func (l *windowLimiter) statusLimit(key string, limit int) rateLimit {
+	l.mu.Lock()
+	n := 0
+	cutoff := time.Now().Add(-l.window)
+	for _, t := range l.hits[key] {
+		if t.After(cutoff) {
+			n++
+		}
+	}
+	l.mu.Unlock()
+	return rateLimit{Limit: limit, Remaining: limit - n, Reset: l.WaitLimit(key, limit)}
+}
+
+// Wait is how long until key may record another event (0 = now).
+func (l *windowLimiter) Wait(key string) time.Duration {
+	return l.WaitLimit(key, l.limit)
+}
+
+func (l *windowLimiter) WaitLimit(key string, limit int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ts := l.hits[key]
+	if len(ts) < limit {
+		return 0
+	}
+	wait := time.Until(ts[len(ts)-limit].Add(l.window))
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
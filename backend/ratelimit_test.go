@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitReply is the 429 body every limiter sends.
+type rateLimitReply struct {
+	Error      string `json:"error"`
+	Code       string `json:"code"`
+	RetryAfter int    `json:"retry_after_secs"`
+	Limit      int    `json:"limit"`
+	Remaining  int    `json:"remaining"`
+	Reset      int    `json:"reset_secs"`
+}
+
+// checkRateLimited checks w against the 429 contract in ratelimit.go: status,
+// the four headers, and a body that repeats them.
+func checkRateLimited(t *testing.T, w *httptest.ResponseRecorder) rateLimitReply {
+	t.Helper()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("%d %s, want 429", w.Code, w.Body)
+	}
+	header := func(k string) int {
+		t.Helper()
+		n, err := strconv.Atoi(w.Header().Get(k))
+		if err != nil {
+			t.Fatalf("%s: %q", k, w.Header().Get(k))
+		}
+		return n
+	}
+	var b rateLimitReply
+	decode(t, w, &b)
+	retry, reset := header("Retry-After"), header("X-RateLimit-Reset")
+	if b.Code != "rate_limited" || b.Error == "" || retry < 1 || reset != retry ||
+		b.RetryAfter != retry || b.Reset != reset ||
+		b.Limit != header("X-RateLimit-Limit") || b.Remaining != header("X-RateLimit-Remaining") {
+		t.Fatalf("429 headers %v disagree with body %s", w.Header(), w.Body)
+	}
+	return b
+}
+
+func TestRateLimitByIPContract(t *testing.T) {
+	r := gin.New()
+	r.GET("/", RateLimitByIP(newRateLimiter(60, 2)), func(c *gin.Context) { c.Status(http.StatusOK) })
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	for i := range 2 {
+		if w := do(); w.Code != http.StatusOK {
+			t.Fatalf("request %d: %d", i+1, w.Code)
+		}
+	}
+	if b := checkRateLimited(t, do()); b.Limit != 2 || b.Remaining != 0 || b.Reset != 1 {
+		t.Fatalf("3rd request: %+v", b)
+	}
+}
+
+func TestSlowModeContract(t *testing.T) {
+	client, db := testDB(t)
+	ann, bob := seedUser(t, db, "ann"), seedUser(t, db, "bob")
+	conv := seedConv(t, db, "ops", ann, bob)
+	r, api := testAPI()
+	api.POST("/messages/:cid", SendMessageHandler(client))
+	api.PUT("/conversations/:cid/slow-mode", SetSlowModeHandler(client))
+	if w := serve(t, r, http.MethodPut, "/conversations/"+conv.ID.Hex()+"/slow-mode", &ann, gin.H{"secs": 30}); w.Code != http.StatusOK {
+		t.Fatalf("slow mode: %d %s", w.Code, w.Body)
+	}
+	send := func() *httptest.ResponseRecorder {
+		return serve(t, r, http.MethodPost, "/messages/"+conv.ID.Hex(), &bob, gin.H{"body": "hi"})
+	}
+	if w := send(); w.Code != http.StatusCreated {
+		t.Fatalf("first send: %d %s", w.Code, w.Body)
+	}
+	if b := checkRateLimited(t, send()); b.Limit != 1 || b.Remaining != 0 || b.Reset < 29 || b.Reset > 30 {
+		t.Fatalf("second send: %+v", b)
+	}
+}
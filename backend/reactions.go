@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  reactions:
+    - message_id      (ObjectId)
+    - conversation_id (ObjectId)
+    - user_id         (ObjectId)
+    - emoji           (string)
+    - ts              (int64, millis)
+Unique index on (message_id, user_id, emoji)
+
+messages.reaction_count mirrors the number of reactions on each message so
+lists can filter on it (GET /messages/:cid?min_reactions=N).
+*/
+
+type Reaction struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	MessageID      primitive.ObjectID `bson:"message_id" json:"message_id"`
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Emoji          string             `bson:"emoji" json:"emoji"`
+	Ts             int64              `bson:"ts" json:"ts"`
+}
+
+func ensureReactionIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("reactions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "message_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "emoji", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func validEmoji(s string) bool {
+	return s != "" && len(s) <= 32 && utf8.ValidString(s) && !strings.ContainsAny(s, " \t\r\n")
+}
+
+// reactionEmoji is the emoji a reaction request names: the :emoji path
+// segment, else "emoji" from the JSON body (POST) or query (DELETE).
+func reactionEmoji(c *gin.Context, body string) string {
+	if e := c.Param("emoji"); e != "" {
+		return e
+	}
+	if body != "" {
+		return body
+	}
+	return c.Query("emoji")
+}
+
+// reactionTarget parses :cid/:mid, checks emoji, membership and loads the
+// message. On failure it has already written the response.
+func reactionTarget(c *gin.Context, ctx context.Context, db *mongo.Database, emoji string) (uid primitive.ObjectID, msg Message, _ string, ok bool) {
+	uidHex, _ := c.Get("uid")
+	uid, err := mustOID(uidHex.(string))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	cid, err := mustOID(c.Param("cid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+		return
+	}
+	mid, err := mustOID(c.Param("mid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+		return
+	}
+	if !validEmoji(emoji) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid emoji"})
+		return
+	}
+	// :shortcode: is stored as the emoji itself (emoji.go)
+	if name, isCode := strings.CutPrefix(emoji, ":"); isCode && len(name) > 1 && strings.HasSuffix(name, ":") {
+		name = strings.TrimSuffix(name, ":")
+		e, known := lookupShortcode(name)
+		if !known {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown shortcode", "suggestions": shortcodeSuggestions(name)})
+			return
+		}
+		emoji = e
+	}
+
+	member, err := isMember(ctx, db, cid, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	if !member {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+		return
+	}
+	err = db.Collection("messages").FindOne(ctx, visible(bson.M{"_id": mid, "conversation_id": cid})).Decode(&msg)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	return uid, msg, emoji, true
+}
+
+// PUT /messages/:cid/:mid/reactions/:emoji   (emoji or :shortcode:)
+// Body (optional): { "notify": true }
+// POST /messages/:cid/:mid/reactions does the same with the emoji in the
+// body: { "emoji": "👍", "notify": true }. Adding one you already have is a
+// no-op ("added": false).
+// notify also tells the message author, unless they muted or snoozed the
+// conversation: a targeted "reaction" event on their open sockets, or a
+// queued notification when they have none.
+func AddReactionHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in struct {
+			Emoji  string `json:"emoji"`
+			Notify bool   `json:"notify"`
+		}
+		_ = c.ShouldBindJSON(&in) // allow empty body
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		uid, msg, emoji, ok := reactionTarget(c, ctx, db, reactionEmoji(c, in.Emoji))
+		if !ok {
+			return
+		}
+		if err := ensureReactionIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
+			return
+		}
+
+		r := Reaction{
+			MessageID:      msg.ID,
+			ConversationID: msg.ConversationID,
+			UserID:         uid,
+			Emoji:          emoji,
+			Ts:             time.Now().UnixMilli(),
+		}
+		_, err := db.Collection("reactions").InsertOne(ctx, r)
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusOK, gin.H{"ok": true, "added": false})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		bumpReactionCount(ctx, db, msg.ID, 1)
+
+		payload := events.Reaction{MessageID: msg.ID.Hex(), UserID: uid.Hex(), Emoji: emoji}
+		broadcaster.Publish(events.New(msg.ConversationID.Hex(), events.ReactionAdded(payload)))
+
+		if in.Notify && msg.SenderID != uid {
+			if err := notifyReaction(ctx, db, msg, payload); err != nil {
+				fmt.Println("reaction notify error:", err)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true, "added": true})
+	}
+}
+
+// notifyReaction tells the author of msg about a reaction, honouring their
+// mute/snooze for the conversation.
+func notifyReaction(ctx context.Context, db *mongo.Database, msg Message, payload events.Reaction) error {
+	author := msg.SenderID
+	quiet, err := quietUsers(ctx, db, msg.ConversationID, []primitive.ObjectID{author})
+	if err != nil {
+		return err
+	}
+	if _, ok := quiet[author]; ok {
+		return nil
+	}
+
+	e := events.New(msg.ConversationID.Hex(), events.ReactionNotice(payload))
+	if broadcaster.PublishToUser(author, e) > 0 {
+		return nil
+	}
+
+	_ = ensureNotificationIndexes(ctx, db)
+	_, err = db.Collection("notifications").InsertOne(ctx, Notification{
+		UserID:         author,
+		ConversationID: msg.ConversationID,
+		Kind:           "reaction",
+		Payload:        payload,
+		CreatedAt:      time.Now().UnixMilli(),
+	})
+	return err
+}
+
+// bumpReactionCount adjusts messages.reaction_count after a reaction was
+// stored or removed. A failure only skews the min_reactions filter, so it
+// is logged rather than failing the request.
+func bumpReactionCount(ctx context.Context, db *mongo.Database, mid primitive.ObjectID, delta int) {
+	_, err := db.Collection("messages").UpdateByID(ctx, mid, bson.M{"$inc": bson.M{"reaction_count": delta}})
+	if err != nil {
+		fmt.Println("reaction count error:", err)
+	}
+}
+
+// DELETE /messages/:cid/:mid/reactions/:emoji
+// DELETE /messages/:cid/:mid/reactions?emoji=👍
+// Removes only the caller's own reaction.
+func RemoveReactionHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		uid, msg, emoji, ok := reactionTarget(c, ctx, db, reactionEmoji(c, ""))
+		if !ok {
+			return
+		}
+		res, err := db.Collection("reactions").DeleteOne(ctx, bson.M{
+			"message_id": msg.ID,
+			"user_id":    uid,
+			"emoji":      emoji,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if res.DeletedCount > 0 {
+			bumpReactionCount(ctx, db, msg.ID, -1)
+			broadcaster.Publish(events.New(msg.ConversationID.Hex(),
+				events.ReactionRemoved{MessageID: msg.ID.Hex(), UserID: uid.Hex(), Emoji: emoji}))
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true, "removed": res.DeletedCount > 0})
+	}
+}
+
+// annotateReactions fills Reactions and MyReactions on every message in
+// msgs with one aggregation over the page. Deleted messages get empty ones.
+func annotateReactions(ctx context.Context, db *mongo.Database, uid primitive.ObjectID, msgs []Message) error {
+	ids := make([]primitive.ObjectID, 0, len(msgs))
+	idx := make(map[primitive.ObjectID]int, len(msgs))
+	for i := range msgs {
+		msgs[i].Reactions, msgs[i].MyReactions = map[string]int64{}, []string{}
+		if !msgs[i].Deleted {
+			ids = append(ids, msgs[i].ID)
+			idx[msgs[i].ID] = i
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	cur, err := db.Collection("reactions").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"message_id": bson.M{"$in": ids}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":  bson.M{"m": "$message_id", "e": "$emoji"},
+			"n":    bson.M{"$sum": 1},
+			"mine": bson.M{"$max": bson.M{"$eq": bson.A{"$user_id", uid}}},
+		}}},
+		// by emoji, so my_reactions comes out in a stable order
+		{{Key: "$sort", Value: bson.D{{Key: "_id.m", Value: 1}, {Key: "_id.e", Value: 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var rows []struct {
+		ID struct {
+			MessageID primitive.ObjectID `bson:"m"`
+			Emoji     string             `bson:"e"`
+		} `bson:"_id"`
+		N    int64 `bson:"n"`
+		Mine bool  `bson:"mine"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		m := &msgs[idx[r.ID.MessageID]]
+		m.Reactions[r.ID.Emoji] = r.N
+		if r.Mine {
+			m.MyReactions = append(m.MyReactions, r.ID.Emoji)
+		}
+	}
+	return nil
+}
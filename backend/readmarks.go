@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Read-mark coalescing. Clients that mark read on every scroll event would
+cost a receipts write (and a receipt.updated broadcast) each time. Marks for
+the same (user, conversation) arriving within READ_COALESCE_MS (default 2000)
+of the first are merged: the request is answered at once, and one write with
+the highest ts goes out when the window closes. A mark that leaves nothing
+unread is written immediately (with anything pending), so badges never lag,
+and pending marks are flushed on shutdown. READ_COALESCE_MS=off writes every
+mark straight away.
+*/
+
+type readKey struct {
+	uid, cid primitive.ObjectID
+}
+
+type pendingRead struct {
+	db    *mongo.Database
+	ts    int64
+	timer *time.Timer
+}
+
+type readCoalescer struct {
+	mu      sync.Mutex
+	window  time.Duration
+	pending map[readKey]*pendingRead
+}
+
+var readMarks = newReadCoalescer(readCoalesceWindow())
+
+func readCoalesceWindow() time.Duration {
+	if os.Getenv("READ_COALESCE_MS") == "off" {
+		return 0
+	}
+	return time.Duration(envInt("READ_COALESCE_MS", 2000)) * time.Millisecond
+}
+
+func newReadCoalescer(window time.Duration) *readCoalescer {
+	return &readCoalescer{window: window, pending: make(map[readKey]*pendingRead)}
+}
+
+func (r *readCoalescer) enabled() bool { return r.window > 0 }
+
+// add merges ts into the pending mark for (uid, cid), starting the window
+// if there is none.
+func (r *readCoalescer) add(db *mongo.Database, uid, cid primitive.ObjectID, ts int64) {
+	k := readKey{uid, cid}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.pending[k]; ok {
+		if ts > p.ts {
+			p.ts = ts
+		}
+		return
+	}
+	r.pending[k] = &pendingRead{
+		db:    db,
+		ts:    ts,
+		timer: time.AfterFunc(r.window, func() { r.flush(k) }),
+	}
+}
+
+// take removes and returns the pending mark for k, stopping its timer.
+func (r *readCoalescer) take(k readKey) (*pendingRead, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.pending[k]
+	if ok {
+		p.timer.Stop()
+		delete(r.pending, k)
+	}
+	return p, ok
+}
+
+func (r *readCoalescer) flush(k readKey) {
+	p, ok := r.take(k)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := applyRead(ctx, p.db, k.uid, k.cid, p.ts); err != nil {
+		fmt.Println("read mark flush error:", err)
+	}
+}
+
+// flushNow writes ts for (uid, cid) right away, folding in anything pending.
+func (r *readCoalescer) flushNow(ctx context.Context, db *mongo.Database, uid, cid primitive.ObjectID, ts int64) error {
+	if p, ok := r.take(readKey{uid, cid}); ok && p.ts > ts {
+		ts = p.ts
+	}
+	return applyRead(ctx, db, uid, cid, ts)
+}
+
+// flushAll writes every pending mark; called on shutdown.
+func (r *readCoalescer) flushAll() {
+	r.mu.Lock()
+	keys := make([]readKey, 0, len(r.pending))
+	for k := range r.pending {
+		keys = append(keys, k)
+	}
+	r.mu.Unlock()
+	for _, k := range keys {
+		r.flush(k)
+	}
+}
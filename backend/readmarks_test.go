@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// receiptWrites counts update commands on receipts seen by the client it
+// monitors.
+type receiptWrites struct{ n atomic.Int64 }
+
+func (w *receiptWrites) monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{Started: func(_ context.Context, e *event.CommandStartedEvent) {
+		if e.CommandName == "update" && e.Command.Lookup("update").StringValue() == "receipts" {
+			w.n.Add(1)
+		}
+	}}
+}
+
+func TestReadMarksOneWritePerFlush(t *testing.T) {
+	var writes receiptWrites
+	client := testClient(t, writes.monitor())
+	db := useTestDB(t, client)
+	old := readMarks
+	readMarks = newReadCoalescer(300 * time.Millisecond)
+	t.Cleanup(func() { readMarks = old })
+
+	ann := seedUser(t, db, "ann")
+	bob := seedUser(t, db, "bob")
+	conv := seedConv(t, db, "ops", ann, bob)
+	cid := conv.ID.Hex()
+
+	base := time.Now().UnixMilli()
+	var last Message
+	for i := range 3 {
+		last = Message{ID: primitive.NewObjectID(), ConversationID: conv.ID, SenderID: ann.ID, Type: "text", Body: "hi", Ts: base + int64(i)}
+		if _, err := db.Collection("messages").InsertOne(testCtx(t), last); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, api := testAPI()
+	api.POST("/conversations/:cid/read", MarkReadHandler(client))
+	mark := func(body gin.H) {
+		t.Helper()
+		if w := serve(t, r, http.MethodPost, "/conversations/"+cid+"/read", &bob, body); w.Code != http.StatusOK {
+			t.Fatalf("read: %d %s", w.Code, w.Body)
+		}
+	}
+	readTS := func() int64 {
+		t.Helper()
+		var rc Receipt
+		if err := db.Collection("receipts").FindOne(testCtx(t), bson.M{"conversation_id": conv.ID, "user_id": bob.ID}).Decode(&rc); err != nil {
+			t.Fatal(err)
+		}
+		return rc.LastReadTS
+	}
+
+	// a scroll storm short of the last message: one write when the window closes
+	for i := range 20 {
+		mark(gin.H{"ts": base + int64(i%2)})
+	}
+	if n := writes.n.Load(); n != 0 {
+		t.Fatalf("%d receipt writes inside the window, want 0", n)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for writes.n.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := writes.n.Load(); n != 1 {
+		t.Fatalf("%d receipt writes for one window, want 1", n)
+	}
+	if ts := readTS(); ts != base+1 {
+		t.Fatalf("last_read_ts = %d, want the highest mark %d", ts, base+1)
+	}
+
+	// reaching the end is written at once, merged with what is pending
+	writes.n.Store(0)
+	mark(gin.H{"ts": base})
+	mark(gin.H{"up_to_message": last.ID.Hex()})
+	if n := writes.n.Load(); n != 1 {
+		t.Fatalf("%d receipt writes for a mark that clears unread, want 1", n)
+	}
+	if ts := readTS(); ts != last.Ts {
+		t.Fatalf("last_read_ts = %d, want %d", ts, last.Ts)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if n := writes.n.Load(); n != 1 {
+		t.Fatalf("%d receipt writes after the window, want 1: the pending mark was folded in", n)
+	}
+
+	// shutdown writes what is pending, once
+	writes.n.Store(0)
+	readMarks.window = time.Hour
+	mark(gin.H{"ts": base + 1})
+	mark(gin.H{"ts": base + 1})
+	readMarks.flushAll()
+	if n := writes.n.Load(); n != 1 {
+		t.Fatalf("%d receipt writes on flushAll, want 1", n)
+	}
+}
@@ -3,9 +3,14 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"sort"
 	"time"
 
+	"backend/events"
+
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -19,14 +24,18 @@ Schema:
     - conversation_id (ObjectId)
     - user_id        (ObjectId)
     - last_read_ts   (int64, millis)
+    - last_delivered_ts (int64, millis, set when the user's client comes online)
+    - source         (string, "join" when seeded on member add)
+    - updated_at     (int64, millis, last read-position write; for /conversations/delta)
 Unique index on (conversation_id, user_id)
 */
 
 type Receipt struct {
-	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
-	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
-	LastReadTS     int64              `bson:"last_read_ts" json:"last_read_ts"`
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ConversationID  primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	UserID          primitive.ObjectID `bson:"user_id" json:"user_id"`
+	LastReadTS      int64              `bson:"last_read_ts" json:"last_read_ts"`
+	LastDeliveredTS int64              `bson:"last_delivered_ts,omitempty" json:"last_delivered_ts,omitempty"`
 }
 
 func ensureReceiptIndexes(ctx context.Context, db *mongo.Database) error {
@@ -37,9 +46,107 @@ func ensureReceiptIndexes(ctx context.Context, db *mongo.Database) error {
 	return err
 }
 
+// initJoinReceipts starts newly added members' read position at ts so they
+// don't inherit the whole history as unread. Existing receipts only move forward.
+func initJoinReceipts(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, uids []primitive.ObjectID, ts int64) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	if err := ensureReceiptIndexes(ctx, db); err != nil {
+		return err
+	}
+	models := make([]mongo.WriteModel, 0, len(uids))
+	for _, uid := range uids {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"conversation_id": cid, "user_id": uid}).
+			SetUpdate(bson.M{
+				"$max": bson.M{"last_read_ts": ts},
+				"$setOnInsert": bson.M{
+					"conversation_id": cid,
+					"user_id":         uid,
+					"source":          "join", // written on join, not by a client read
+				},
+			}).
+			SetUpsert(true))
+	}
+	_, err := db.Collection("receipts").BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// markAllDelivered advances uid's delivered position to the newest message in
+// every conversation they belong to (one aggregation + one bulk write), then
+// announces it with receipt.delivered.
+func markAllDelivered(db *mongo.Database, uid primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter, err := memberConvFilter(ctx, db, uid)
+	if err != nil {
+		return err
+	}
+	cids, err := db.Collection("conversations").Distinct(ctx, "_id", filter)
+	if err != nil || len(cids) == 0 {
+		return err
+	}
+
+	cur, err := db.Collection("messages").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: visible(bson.M{"conversation_id": bson.M{"$in": cids}})}},
+		{{Key: "$group", Value: bson.M{"_id": "$conversation_id", "ts": bson.M{"$max": "$ts"}}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	type latest struct {
+		CID primitive.ObjectID `bson:"_id"`
+		Ts  int64              `bson:"ts"`
+	}
+	var rows []latest
+	if err := cur.All(ctx, &rows); err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	if err := ensureReceiptIndexes(ctx, db); err != nil {
+		return err
+	}
+	models := make([]mongo.WriteModel, 0, len(rows))
+	for _, r := range rows {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"conversation_id": r.CID, "user_id": uid}).
+			SetUpdate(bson.M{
+				"$max": bson.M{"last_delivered_ts": r.Ts},
+				"$setOnInsert": bson.M{
+					"conversation_id": r.CID,
+					"user_id":         uid,
+				},
+			}).
+			SetUpsert(true))
+	}
+	if _, err := db.Collection("receipts").BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+
+	private, err := receiptsDisabledIn(ctx, db, cids)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if _, ok := private[r.CID]; ok {
+			continue
+		}
+		broadcaster.Publish(events.New(r.CID.Hex(), events.ReceiptDelivered{UserID: uid.Hex(), LastDeliveredTS: r.Ts}))
+	}
+	return nil
+}
+
 // POST/conversation/:cid/read
-// Body (optional): { "ts": <int64 millis> }
-// If ts is omitted, uses now. Only moves forward (never decreases).
+// Body (optional): { "ts": <int64 millis> } or { "up_to_message": "<mid>" }
+// up_to_message reads up to that message's ts. If neither is given, uses
+// now. Only moves forward (never decreases).
 func MarkReadHandler(client *mongo.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		uidHex, _ := c.Get("uid")
@@ -56,17 +163,30 @@ func MarkReadHandler(client *mongo.Client) gin.HandlerFunc {
 		}
 
 		var in struct {
-			Ts *int64 `json:"ts"`
+			Ts          *int64 `json:"ts"`
+			UpToMessage string `json:"up_to_message"`
 		}
 		if err := c.ShouldBindJSON(&in); err != nil {
 			// allow empty body
 			in.Ts = nil
+			in.UpToMessage = ""
+		}
+		if in.Ts != nil && in.UpToMessage != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "give ts or up_to_message, not both"})
+			return
 		}
 		now := time.Now().UnixMilli()
 		newTs := now
 		if in.Ts != nil && *in.Ts > 0 {
 			newTs = *in.Ts
 		}
+		var upTo primitive.ObjectID
+		if in.UpToMessage != "" {
+			if upTo, err = mustOID(in.UpToMessage); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+				return
+			}
+		}
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 		defer cancel()
 		db := getDB(client)
@@ -81,45 +201,103 @@ func MarkReadHandler(client *mongo.Client) gin.HandlerFunc {
 			return
 		}
 
+		if !upTo.IsZero() {
+			var m Message
+			err := db.Collection("messages").FindOne(ctx,
+				bson.M{"_id": upTo, "conversation_id": cid},
+				options.FindOne().SetProjection(bson.M{"ts": 1}),
+			).Decode(&m)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			newTs = m.Ts
+		}
+
 		if err := ensureReceiptIndexes(ctx, db); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
 			return
 		}
 
-		// upsert and only move forward
-		_, err = db.Collection("receipts").UpdateOne(
-			ctx,
-			bson.M{"conversation_id": cid, "user_id": uid},
-			bson.M{
-				"$max": bson.M{"last_read_ts": newTs}, // move forward only
-				"$setOnInsert": bson.M{
-					"conversation_id": cid,
-					"user_id":         uid,
-				},
-			},
-			options.Update().SetUpsert(true),
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
-			return
+		// let any held message push for this reader go
+		noteReceipt(uid, cid, newTs)
+
+		// scroll storms: merge rapid marks into one write per window, but
+		// never hold back the mark that clears the badge
+		if !readMarks.enabled() {
+			if err := applyRead(ctx, db, uid, cid, newTs); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+		} else {
+			kw, err := loadKeywordMatcher(ctx, db, uid)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			// anything still unread after this mark? (same filter as unread)
+			left, err := db.Collection("messages").CountDocuments(ctx,
+				kw.applyUnread(visible(bson.M{"conversation_id": cid, "ts": bson.M{"$gt": newTs}})),
+				options.Count().SetLimit(1),
+			)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			if left > 0 {
+				readMarks.add(db, uid, cid, newTs)
+			} else if err := readMarks.flushNow(ctx, db, uid, cid, newTs); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
 		}
 
-		// broadcast that this user advanced their read position
-		broadcaster.Publish(Event{
-			Type:           "receipt.updated",
-			ConversationID: cid.Hex(),
-			Payload: gin.H{
-				"user_id":      uid.Hex(),
-				"last_read_ts": newTs,
+		c.JSON(http.StatusOK, gin.H{"ok": true, "last_read_ts": newTs})
+	}
+}
+
+// applyRead writes uid's read position on cid (forward only) and tells the
+// conversation, or just drops uid's cached list if receipts are private.
+func applyRead(ctx context.Context, db *mongo.Database, uid, cid primitive.ObjectID, ts int64) error {
+	_, err := db.Collection("receipts").UpdateOne(
+		ctx,
+		bson.M{"conversation_id": cid, "user_id": uid},
+		bson.M{
+			"$max": bson.M{"last_read_ts": ts}, // move forward only
+			"$set": bson.M{"updated_at": time.Now().UnixMilli()},
+			"$setOnInsert": bson.M{
+				"conversation_id": cid,
+				"user_id":         uid,
 			},
-		})
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
 
-		c.JSON(http.StatusOK, gin.H{"ok": true, "last_read_ts": newTs})
+	// broadcast that this user advanced their read position, unless the
+	// conversation keeps positions private
+	on, err := receiptsEnabled(ctx, db, cid)
+	if err != nil {
+		return err
+	}
+	if on {
+		broadcaster.Publish(events.New(cid.Hex(), events.ReceiptUpdated{UserID: uid.Hex(), LastReadTS: ts}))
+	} else {
+		// no event to invalidate through; the reader's unread still moved
+		invalidateConvList(uid)
 	}
+	return nil
 }
 
 // GET /conversations/:cid/unread
-// Returns : { unread: <int>, last_read_ts: <int64> }
+// Returns : { unread: <int>, mention_count: <int>, last_read_ts: <int64> [, receipts_degraded: true] }
+// mention_count is the unread messages that @mention the caller.
 func UnreadCountHandler(client *mongo.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		uidHex, _ := c.Get("uid")
@@ -149,28 +327,317 @@ func UnreadCountHandler(client *mongo.Client) gin.HandlerFunc {
 			return
 		}
 
-		// find last_read_ts (default 0 if none)
+		// find last_read_ts (default 0 if none); if receipts can't be read,
+		// count from 0 and say so rather than failing
 		var rc Receipt
 		err = db.Collection("receipts").FindOne(ctx,
 			bson.M{"conversation_id": cid, "user_id": uid},
 		).Decode(&rc)
 		var last int64 = 0
+		degraded := false
 		if err == nil {
 			last = rc.LastReadTS
 		} else if !errors.Is(err, mongo.ErrNoDocuments) {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
-			return
+			fmt.Println("unread: receipts unavailable, counting from 0:", err)
+			degraded = true
 		}
 
 		// count msg newer than last_read_ts
-		n, err := db.Collection("messages").CountDocuments(ctx, bson.M{
+		kw, err := loadKeywordMatcher(ctx, db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		n, err := db.Collection("messages").CountDocuments(ctx, kw.applyUnread(visible(bson.M{
 			"conversation_id": cid,
 			"ts":              bson.M{"$gt": last},
-		})
+		})))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"unread": n, "last_read_ts": last})
+		mentioned, err := db.Collection("messages").CountDocuments(ctx, kw.applyUnread(visible(bson.M{
+			"conversation_id": cid,
+			"ts":              bson.M{"$gt": last},
+			"mentions":        uid,
+		})))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		out := gin.H{"unread": n, "mention_count": mentioned, "last_read_ts": last}
+		if degraded {
+			out["receipts_degraded"] = true
+		}
+		c.JSON(http.StatusOK, out)
+	}
+}
+
+// GET /conversations/unread
+// Returns: [{ cid, unread, unread_high?, mention_count }] for every conversation the caller is in.
+// Badge poll: no last message, members or receipts flags, just counts,
+// computed in one aggregation (conversation -> receipt -> messages).
+func UnreadCountsHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		convFilter, err := memberConvFilter(ctx, db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		kw, err := loadKeywordMatcher(ctx, db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		pipeline := append(unreadPipeline(convFilter, uid, kw), bson.D{{Key: "$project", Value: bson.M{
+			"_id":           0,
+			"cid":           "$_id",
+			"unread":        bson.M{"$ifNull": bson.A{bson.M{"$first": "$unread.n"}, 0}},
+			"unread_high":   bson.M{"$ifNull": bson.A{bson.M{"$first": "$unread.high"}, 0}},
+			"mention_count": bson.M{"$ifNull": bson.A{bson.M{"$first": "$unread.mentions"}, 0}},
+		}}})
+		cur, err := db.Collection("conversations").Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		var rows []struct {
+			CID      primitive.ObjectID `bson:"cid" json:"cid"`
+			Unread   int64              `bson:"unread" json:"unread"`
+			High     int64              `bson:"unread_high" json:"unread_high,omitempty"`
+			Mentions int64              `bson:"mention_count" json:"mention_count"`
+		}
+		if err := cur.All(ctx, &rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+		if rows == nil {
+			c.JSON(http.StatusOK, []gin.H{})
+			return
+		}
+		c.JSON(http.StatusOK, rows)
+	}
+}
+
+// unreadPipeline runs over the conversations matching convFilter and leaves
+// on each: since (uid's last_read_ts, 0 if none) and unread, an array of at
+// most one {n, high, mentions} over the messages after since that uid would count.
+func unreadPipeline(convFilter bson.M, uid primitive.ObjectID, kw *keywordMatcher) mongo.Pipeline {
+	// same message filter as the per-conversation count
+	msgMatch := kw.applyUnread(visible(bson.M{}))
+	msgMatch["$expr"] = bson.M{"$and": bson.A{
+		bson.M{"$eq": bson.A{"$conversation_id", "$$cid"}},
+		bson.M{"$gt": bson.A{"$ts", "$$since"}},
+	}}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: convFilter}},
+		{{Key: "$project", Value: bson.M{"_id": 1}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": "receipts",
+			"let":  bson.M{"cid": "$_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{
+					"user_id": uid,
+					"$expr":   bson.M{"$eq": bson.A{"$conversation_id", "$$cid"}},
+				}},
+				bson.M{"$project": bson.M{"last_read_ts": 1}},
+			},
+			"as": "rc",
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"since": bson.M{"$ifNull": bson.A{bson.M{"$first": "$rc.last_read_ts"}, 0}},
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": "messages",
+			"let":  bson.M{"cid": "$_id", "since": "$since"},
+			"pipeline": bson.A{
+				bson.M{"$match": msgMatch},
+				bson.M{"$group": bson.M{
+					"_id": nil,
+					"n":   bson.M{"$sum": 1},
+					"high": bson.M{"$sum": bson.M{"$cond": bson.A{
+						bson.M{"$eq": bson.A{"$priority", priorityHigh}}, 1, 0,
+					}}},
+					"mentions": bson.M{"$sum": bson.M{"$cond": bson.A{
+						bson.M{"$in": bson.A{uid, bson.M{"$ifNull": bson.A{"$mentions", bson.A{}}}}}, 1, 0,
+					}}},
+				}},
+			},
+			"as": "unread",
+		}}},
+	}
+}
+
+// msgReceipts is how many of the other current members have a message,
+// for the sender's ticks (?with_receipts=true on GET /messages/:cid).
+// Read implies delivered.
+type msgReceipts struct {
+	Read       int `json:"read"`
+	Delivered  int `json:"delivered"`
+	Recipients int `json:"recipients"`
+}
+
+// annotateReceipts fills Receipts on uid's own messages in msgs from the
+// other members' receipts. Nothing is filled when cid keeps receipts
+// private.
+func annotateReceipts(ctx context.Context, db *mongo.Database, uid, cid primitive.ObjectID, msgs []Message) error {
+	if !slices.ContainsFunc(msgs, func(m Message) bool { return m.SenderID == uid }) {
+		return nil
+	}
+	var conv Conversation
+	err := db.Collection("conversations").FindOne(ctx, bson.M{"_id": cid},
+		options.FindOne().SetProjection(bson.M{"members": 1, "members_external": 1, "receipts_enabled": 1}),
+	).Decode(&conv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !conv.ReceiptsOn() {
+		return nil
+	}
+	conv.ID = cid
+	members, err := listMembers(ctx, db, &conv)
+	if err != nil {
+		return err
+	}
+	others := make([]primitive.ObjectID, 0, len(members))
+	for _, m := range members {
+		if m.UserID != uid {
+			others = append(others, m.UserID)
+		}
+	}
+
+	// receipts of people who left stay behind; only members count
+	cur, err := db.Collection("receipts").Find(ctx,
+		bson.M{"conversation_id": cid, "user_id": bson.M{"$in": others}},
+		options.Find().SetProjection(bson.M{"last_read_ts": 1, "last_delivered_ts": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var rs []Receipt
+	if err := cur.All(ctx, &rs); err != nil {
+		return err
+	}
+	read := make([]int64, 0, len(rs))
+	delivered := make([]int64, 0, len(rs))
+	for _, r := range rs {
+		read = append(read, r.LastReadTS)
+		delivered = append(delivered, max(r.LastReadTS, r.LastDeliveredTS))
+	}
+	slices.Sort(read)
+	slices.Sort(delivered)
+	// how many positions are at or past ts
+	atLeast := func(pos []int64, ts int64) int {
+		return len(pos) - sort.Search(len(pos), func(i int) bool { return pos[i] >= ts })
+	}
+	for i := range msgs {
+		if msgs[i].SenderID != uid {
+			continue
+		}
+		msgs[i].Receipts = &msgReceipts{
+			Read:       atLeast(read, msgs[i].Ts),
+			Delivered:  atLeast(delivered, msgs[i].Ts),
+			Recipients: len(others),
+		}
+	}
+	return nil
+}
+
+// receiptsEnabled reports whether cid shares read/delivered positions.
+func receiptsEnabled(ctx context.Context, db *mongo.Database, cid primitive.ObjectID) (bool, error) {
+	var conv Conversation
+	err := db.Collection("conversations").FindOne(ctx,
+		bson.M{"_id": cid},
+		options.FindOne().SetProjection(bson.M{"receipts_enabled": 1}),
+	).Decode(&conv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return conv.ReceiptsOn(), nil
+}
+
+// receiptsDisabledIn returns which of cids have receipts turned off.
+func receiptsDisabledIn(ctx context.Context, db *mongo.Database, cids []interface{}) (map[primitive.ObjectID]struct{}, error) {
+	ids, err := db.Collection("conversations").Distinct(ctx, "_id", bson.M{
+		"_id":              bson.M{"$in": cids},
+		"receipts_enabled": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[primitive.ObjectID]struct{}, len(ids))
+	for _, v := range ids {
+		if id, ok := v.(primitive.ObjectID); ok {
+			out[id] = struct{}{}
+		}
+	}
+	return out, nil
+}
+
+// PUT /conversations/:cid/receipts (owner only)
+// Body: { "enabled": false }
+// Turns read/delivered receipts off (or back on) for every member.
+func SetReceiptsEnabledHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		var in struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil || in.Enabled == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "enabled required"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role != "owner" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+			return
+		}
+
+		if _, err := db.Collection("conversations").UpdateByID(ctx, cid,
+			bson.M{"$set": bson.M{"receipts_enabled": *in.Enabled, "updated_at": time.Now().UnixMilli()}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		broadcaster.Publish(events.New(cid.Hex(), events.ConversationUpdated{ReceiptsEnabled: in.Enabled}))
+		c.JSON(http.StatusOK, gin.H{"ok": true, "receipts_enabled": *in.Enabled})
 	}
 }
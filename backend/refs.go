@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxMentions = 50
+
+// characters of the parent's body quoted in a reply_preview
+const replyPreviewChars = 120
+
+// replyPreview is enough of a reply's parent to render the quote. A deleted
+// or expired parent is a tombstone: Deleted or Expired set and no body. One
+// the TTL reaper already removed has nothing but Expired.
+type replyPreview struct {
+	SenderID primitive.ObjectID `json:"sender_id,omitzero"`
+	Body     string             `json:"body,omitempty"`
+	Ts       int64              `json:"ts,omitzero"`
+	Deleted  bool               `json:"deleted,omitempty"`
+	Expired  bool               `json:"expired,omitempty"`
+}
+
+// validateRefs checks a message's reply_to and mentions together so the
+// sender gets one report covering both. Mentions are usernames and must
+// belong to members of cid. On failure the svcError carries
+// { "errors": { "reply_to": "...", "mentions": { "<name>": "..." } } }.
+func validateRefs(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, replyTo string, mentions []string) (*primitive.ObjectID, []primitive.ObjectID, error) {
+	problems := gin.H{}
+
+	var parent *primitive.ObjectID
+	if replyTo != "" {
+		if mid, err := mustOID(replyTo); err != nil {
+			problems["reply_to"] = "invalid message id"
+		} else {
+			err := db.Collection("messages").FindOne(ctx,
+				visible(bson.M{"_id": mid, "conversation_id": cid}),
+				options.FindOne().SetProjection(bson.M{"_id": 1}),
+			).Err()
+			switch {
+			case errors.Is(err, mongo.ErrNoDocuments):
+				problems["reply_to"] = "message not found in this conversation"
+			case err != nil:
+				return nil, nil, err
+			default:
+				parent = &mid
+			}
+		}
+	}
+
+	var ids []primitive.ObjectID
+	if len(mentions) > maxMentions {
+		problems["mentions"] = "at most 50 mentions"
+	} else if len(mentions) > 0 {
+		names := make([]string, 0, len(mentions))
+		seen := map[string]bool{}
+		for _, m := range mentions {
+			if n := normalizeUsername(m); n != "" && !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+		byName, err := userIDsByName(ctx, db, names)
+		if err != nil {
+			return nil, nil, err
+		}
+		bad := gin.H{}
+		for _, n := range names {
+			uid, ok := byName[n]
+			if !ok {
+				bad[n] = "unknown user"
+				continue
+			}
+			member, err := isMember(ctx, db, cid, uid)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !member {
+				bad[n] = "not a member"
+				continue
+			}
+			ids = append(ids, uid)
+		}
+		if len(bad) > 0 {
+			problems["mentions"] = bad
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, nil, &svcError{
+			Status: http.StatusBadRequest,
+			Msg:    "invalid reply_to or mentions",
+			Extra:  gin.H{"errors": problems},
+		}
+	}
+	return parent, ids, nil
+}
+
+// an @ not glued to a word, so bob@example.com isn't a mention
+var mentionRe = regexp.MustCompile(`(?:^|[^\w@.])@(\w+)`)
+
+// bodyMentions is the distinct usernames @mentioned in body, normalized,
+// in order of first use. Tokens that can't be usernames are skipped.
+func bodyMentions(body string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, m := range mentionRe.FindAllStringSubmatch(body, -1) {
+		n := normalizeUsername(m[1])
+		if !usernameRe.MatchString(n) || seen[n] {
+			continue
+		}
+		seen[n] = true
+		out = append(out, n)
+	}
+	return out
+}
+
+// addBodyMentions adds the members of cid @mentioned in body to ids, up to
+// maxMentions in all. Unlike the explicit mentions list, unknown names and
+// non-members are dropped without an error: "@" in prose is not a request.
+func addBodyMentions(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, body string, ids []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	names := bodyMentions(body)
+	if len(names) == 0 {
+		return ids, nil
+	}
+	byName, err := userIDsByName(ctx, db, names)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range names {
+		if len(ids) >= maxMentions {
+			break
+		}
+		uid, ok := byName[n]
+		if !ok || slices.Contains(ids, uid) {
+			continue
+		}
+		member, err := isMember(ctx, db, cid, uid)
+		if err != nil {
+			return nil, err
+		}
+		if member {
+			ids = append(ids, uid)
+		}
+	}
+	return ids, nil
+}
+
+// userIDsByName maps usernames, in any case, to user ids keyed by their
+// normalized form; unknown names are simply absent.
+func userIDsByName(ctx context.Context, db *mongo.Database, names []string) (map[string]primitive.ObjectID, error) {
+	out := make(map[string]primitive.ObjectID, len(names))
+	norm := make([]string, len(names))
+	for i, n := range names {
+		norm[i] = normalizeUsername(n)
+	}
+	cur, err := db.Collection("users").Find(ctx,
+		bson.M{"username": bson.M{"$in": norm}},
+		options.Find().SetProjection(bson.M{"username": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var u struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Username string             `bson:"username"`
+		}
+		if err := cur.Decode(&u); err != nil {
+			return nil, err
+		}
+		out[u.Username] = u.ID
+	}
+	return out, nil
+}
+
+// annotateReplyPreviews fills ReplyPreview on the replies in msgs with one
+// query for all their parents.
+func annotateReplyPreviews(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, msgs []Message) error {
+	ids := make([]primitive.ObjectID, 0, len(msgs))
+	for _, m := range msgs {
+		if m.ReplyTo != nil {
+			ids = append(ids, *m.ReplyTo)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	cur, err := db.Collection("messages").Find(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "conversation_id": cid},
+		options.Find().SetProjection(bson.M{"sender_id": 1, "body": 1, "ts": 1, "deleted": 1, "expires_at_ms": 1}),
+	)
+	if err != nil {
+		return err
+	}
+	var parents []Message
+	if err := cur.All(ctx, &parents); err != nil {
+		return err
+	}
+	now := jobs.Now().UnixMilli()
+	byID := make(map[primitive.ObjectID]*replyPreview, len(parents))
+	for _, p := range parents {
+		rp := &replyPreview{SenderID: p.SenderID, Ts: p.Ts, Deleted: p.Deleted, Expired: p.Expired(now)}
+		if !rp.Deleted && !rp.Expired {
+			rp.Body = previewRunes(p.Body, replyPreviewChars)
+		}
+		byID[p.ID] = rp
+	}
+	for i := range msgs {
+		if msgs[i].ReplyTo == nil {
+			continue
+		}
+		// validateRefs only accepts parents in cid and messages are only
+		// ever soft-deleted, so a missing parent was reaped after expiring
+		rp, ok := byID[*msgs[i].ReplyTo]
+		if !ok {
+			rp = &replyPreview{Expired: true}
+		}
+		msgs[i].ReplyPreview = rp
+	}
+	return nil
+}
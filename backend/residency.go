@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Data residency tags. A conversation is tagged when it is created, from
+the deployment's DATA_RESIDENCY (e.g. "eu"; unset = untagged, ""), and
+the tag never changes afterwards: no endpoint writes it. A split inherits
+its source's tag. Messages and attachment rows copy their conversation's
+tag at write time, and large bodies and attachment bytes carry it in
+their GridFS metadata, so a later per-region store can find them without
+a join.
+
+Data never moves across tags:
+  - import: the body declares the source's residency, which must equal
+    the conversation's; otherwise 409 { code: "residency_mismatch" }
+  - split: the copies land in a conversation with the same tag
+  - attachments: identical bytes are shared within a tag only
+GET /admin/stats breaks conversations, messages and attachments down by
+tag. This is the tagging and enforcement layer only; every tag still
+lives in the same database.
+*/
+
+func deploymentResidency() string {
+	return strings.ToLower(strings.TrimSpace(os.Getenv("DATA_RESIDENCY")))
+}
+
+// convResidency is cid's residency tag ("" = untagged or no such
+// conversation).
+func convResidency(ctx context.Context, db *mongo.Database, cid primitive.ObjectID) (string, error) {
+	var conv struct {
+		Residency string `bson:"residency"`
+	}
+	err := db.Collection("conversations").FindOne(ctx, bson.M{"_id": cid},
+		options.FindOne().SetProjection(bson.M{"residency": 1}),
+	).Decode(&conv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	return conv.Residency, err
+}
+
+// checkResidency refuses moving data tagged from into a conversation
+// tagged to.
+func checkResidency(from, to string) error {
+	if from == to {
+		return nil
+	}
+	return &svcError{
+		Status: http.StatusConflict,
+		Msg:    "data can't move across residency tags",
+		Extra:  gin.H{"code": "residency_mismatch", "from": from, "to": to},
+	}
+}
+
+// residencyMetadata is the GridFS metadata for bytes tagged r.
+func residencyMetadata(r string) *options.UploadOptions {
+	if r == "" {
+		return nil
+	}
+	return options.GridFSUpload().SetMetadata(bson.M{"residency": r})
+}
+
+type residencyStats struct {
+	Conversations   int64 `json:"conversations"`
+	Messages        int64 `json:"messages"`
+	AttachmentFiles int64 `json:"attachment_files"`
+	AttachmentBytes int64 `json:"attachment_bytes"`
+}
+
+// loadResidencyStats counts documents per residency tag ("" = untagged).
+func loadResidencyStats(ctx context.Context, db *mongo.Database) (map[string]*residencyStats, error) {
+	out := map[string]*residencyStats{}
+	at := func(tag string) *residencyStats {
+		if out[tag] == nil {
+			out[tag] = &residencyStats{}
+		}
+		return out[tag]
+	}
+	count := func(coll string, sum any, add func(s *residencyStats, n, bytes int64)) error {
+		cur, err := db.Collection(coll).Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$group", Value: bson.M{
+				"_id":   bson.M{"$ifNull": bson.A{"$residency", ""}},
+				"n":     bson.M{"$sum": 1},
+				"bytes": bson.M{"$sum": sum},
+			}}},
+		})
+		if err != nil {
+			return err
+		}
+		var rows []struct {
+			Tag   string `bson:"_id"`
+			N     int64  `bson:"n"`
+			Bytes int64  `bson:"bytes"`
+		}
+		if err := cur.All(ctx, &rows); err != nil {
+			return err
+		}
+		for _, r := range rows {
+			add(at(r.Tag), r.N, r.Bytes)
+		}
+		return nil
+	}
+	if err := count("conversations", 0, func(s *residencyStats, n, _ int64) { s.Conversations = n }); err != nil {
+		return nil, err
+	}
+	if err := count("messages", 0, func(s *residencyStats, n, _ int64) { s.Messages = n }); err != nil {
+		return nil, err
+	}
+	if err := count("attachments", "$size", func(s *residencyStats, n, b int64) {
+		s.AttachmentFiles, s.AttachmentBytes = n, b
+	}); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestCheckResidency(t *testing.T) {
+	if err := checkResidency("eu", "eu"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkResidency("", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range [][2]string{{"eu", "us"}, {"", "eu"}, {"eu", ""}} {
+		err := checkResidency(tt[0], tt[1])
+		if se, _ := err.(*svcError); se == nil || se.Status != http.StatusConflict || se.Extra["code"] != "residency_mismatch" {
+			t.Errorf("checkResidency(%q, %q) = %v", tt[0], tt[1], err)
+		}
+	}
+}
+
+// tagConv sets conv's residency directly, as no endpoint changes it.
+func tagConv(t *testing.T, db *mongo.Database, conv Conversation, r string) {
+	t.Helper()
+	if _, err := db.Collection("conversations").UpdateByID(testCtx(t), conv.ID, bson.M{"$set": bson.M{"residency": r}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResidencyTaggedOnCreateAndSend(t *testing.T) {
+	client, db := testDB(t)
+	t.Setenv("DATA_RESIDENCY", " EU ")
+	ann := seedUser(t, db, "ann")
+	seedUser(t, db, "bob")
+	r, api := testAPI()
+	api.POST("/conversations", CreateConverHandler(client))
+	api.POST("/messages/:cid", SendMessageHandler(client))
+
+	w := serve(t, r, http.MethodPost, "/conversations", &ann, gin.H{"title": "ops", "members": []string{"bob"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	var conv Conversation
+	decode(t, w, &conv)
+	if err := db.Collection("conversations").FindOne(testCtx(t), bson.M{"_id": conv.ID}).Decode(&conv); err != nil {
+		t.Fatal(err)
+	}
+	if conv.Residency != "eu" {
+		t.Fatalf("created with residency %q", conv.Residency)
+	}
+
+	// the deployment's tag applies to new conversations only
+	t.Setenv("DATA_RESIDENCY", "us")
+	if w := serve(t, r, http.MethodPost, "/messages/"+conv.ID.Hex(), &ann, gin.H{"body": "hi"}); w.Code != http.StatusCreated {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	if n := countDocs(t, db, "messages", bson.M{"conversation_id": conv.ID, "residency": "eu"}); n != 1 {
+		t.Fatalf("%d messages tagged eu, want 1", n)
+	}
+}
+
+func TestImportResidencyMismatch(t *testing.T) {
+	client, db := testDB(t)
+	ann := seedUser(t, db, "ann")
+	conv := seedConv(t, db, "ops", ann)
+	tagConv(t, db, conv, "eu")
+	r, api := testAPI()
+	api.POST("/admin/conversations/:cid/import", ImportMessagesHandler(client))
+
+	ts := time.Now().Add(-time.Hour).UnixMilli()
+	body := func(r string) gin.H {
+		return gin.H{"residency": r, "messages": []gin.H{
+			{"import_key": "src:1", "sender_id": ann.ID.Hex(), "type": "text", "body": "old", "ts": ts},
+		}}
+	}
+	for _, src := range []string{"us", ""} {
+		w := serve(t, r, http.MethodPost, "/admin/conversations/"+conv.ID.Hex()+"/import", &ann, body(src))
+		var res struct{ Code, From, To string }
+		decode(t, w, &res)
+		if w.Code != http.StatusConflict || res.Code != "residency_mismatch" || res.From != src || res.To != "eu" {
+			t.Fatalf("import from %q: %d %s", src, w.Code, w.Body)
+		}
+	}
+	if n := countDocs(t, db, "messages", bson.M{}); n != 0 {
+		t.Fatalf("%d messages written by refused imports", n)
+	}
+
+	if w := serve(t, r, http.MethodPost, "/admin/conversations/"+conv.ID.Hex()+"/import", &ann, body(" EU")); w.Code != http.StatusOK {
+		t.Fatalf("import from eu: %d %s", w.Code, w.Body)
+	}
+	if n := countDocs(t, db, "messages", bson.M{"residency": "eu"}); n != 1 {
+		t.Fatalf("%d imported messages tagged eu, want 1", n)
+	}
+}
+
+func TestAttachmentDedupWithinResidency(t *testing.T) {
+	_, db := testDB(t)
+	ann := seedUser(t, db, "ann")
+	eu1 := seedConv(t, db, "eu1", ann)
+	eu2 := seedConv(t, db, "eu2", ann)
+	tagConv(t, db, eu1, "eu")
+	tagConv(t, db, eu2, "eu")
+	plain := seedConv(t, db, "plain", ann)
+	data := bytes.Repeat([]byte("same bytes "), 100)
+
+	for _, conv := range []Conversation{eu1, eu2, plain, plain} {
+		if _, err := putAttachment(testCtx(t), db, Attachment{
+			ConversationID: conv.ID, UploaderID: ann.ID, Filename: "f.txt", ContentType: "text/plain",
+		}, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countDocs(t, db, "attachment_blobs", bson.M{}); n != 2 {
+		t.Fatalf("%d blobs, want one per tag", n)
+	}
+	for _, tt := range []struct {
+		filter bson.M
+		refs   int64
+	}{
+		{bson.M{"residency": "eu"}, 2},
+		{bson.M{"residency": bson.M{"$exists": false}}, 2},
+	} {
+		var blob struct{ Refs int64 }
+		if err := db.Collection("attachment_blobs").FindOne(testCtx(t), tt.filter).Decode(&blob); err != nil || blob.Refs != tt.refs {
+			t.Fatalf("blob %v: refs %d, %v; want %d", tt.filter, blob.Refs, err, tt.refs)
+		}
+	}
+	if n := countDocs(t, db, "attachments.files", bson.M{"metadata.residency": "eu"}); n != 1 {
+		t.Fatalf("%d GridFS files tagged eu, want 1", n)
+	}
+}
+
+func TestResidencyStats(t *testing.T) {
+	_, db := testDB(t)
+	ann := seedUser(t, db, "ann")
+	var eu Conversation
+	for i, r := range []string{"eu", "eu", "", ""} {
+		conv := seedConv(t, db, "c", ann)
+		if r != "" {
+			tagConv(t, db, conv, r)
+			eu = conv
+		}
+		if _, err := db.Collection("attachments").InsertOne(testCtx(t), Attachment{
+			ConversationID: conv.ID, Size: int64(10 * (i + 1)), Residency: r,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := storeMessage(testCtx(t), db, Message{ConversationID: eu.ID, SenderID: ann.ID, Type: "text", Body: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := loadResidencyStats(testCtx(t), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]residencyStats{
+		"eu": {Conversations: 2, Messages: 1, AttachmentFiles: 2, AttachmentBytes: 30},
+		"":   {Conversations: 2, AttachmentFiles: 2, AttachmentBytes: 70},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("stats for %d tags, want %d", len(stats), len(want))
+	}
+	for tag, w := range want {
+		if got := stats[tag]; got == nil || *got != w {
+			t.Errorf("stats[%q] = %+v, want %+v", tag, got, w)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"backend/events"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Malware scanning for user uploads.
+
+The Scanner is picked by CLAMAV_ADDR: empty means noopScanner (everything
+is clean), otherwise "host:port" of a clamd that speaks INSTREAM.
+putAttachment saves every upload as scan_status "pending" and queues
+scanAttachment, which scans the bytes on the job runner (scanAsync) and
+records the outcome. A file found infected is flagged on the image
+messages that show it, each of which gets a message.updated with
+"attachment_infected": true. Image messages can't be sent with a file
+already found infected.
+
+Download gate (scanGate):
+  - infected -> 403
+  - pending  -> 423 if SCAN_BLOCK_PENDING=true, otherwise served
+  - clean    -> served
+*/
+
+// scan_status values
+const (
+	ScanPending  = "pending"
+	ScanClean    = "clean"
+	ScanInfected = "infected"
+)
+
+// Scanner inspects one file. A non-nil error means the scan couldn't be
+// done (the status stays pending), not that the file is bad.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (status string, signature string, err error)
+}
+
+type noopScanner struct{}
+
+func (noopScanner) Scan(context.Context, io.Reader) (string, string, error) {
+	return ScanClean, "", nil
+}
+
+// clamavScanner streams files to clamd with the INSTREAM command.
+type clamavScanner struct {
+	addr    string
+	timeout time.Duration
+}
+
+// clamd rejects streams over its StreamMaxLength; chunks stay well below it
+const clamChunk = 64 << 10
+
+func (s clamavScanner) Scan(ctx context.Context, r io.Reader) (string, string, error) {
+	d := net.Dialer{Timeout: s.timeout}
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return ScanPending, "", err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(s.timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	_ = conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanPending, "", err
+	}
+	buf := make([]byte, clamChunk)
+	var size [4]byte
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, err := conn.Write(size[:]); err != nil {
+				return ScanPending, "", err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return ScanPending, "", err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return ScanPending, "", rerr
+		}
+	}
+	// zero-length chunk ends the stream
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := conn.Write(size[:]); err != nil {
+		return ScanPending, "", err
+	}
+
+	reply, err := io.ReadAll(io.LimitReader(conn, 4096))
+	if err != nil {
+		return ScanPending, "", err
+	}
+	return parseClamReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamReply reads "stream: OK", "stream: <sig> FOUND" or
+// "<msg> ERROR".
+func parseClamReply(reply string) (string, string, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return ScanClean, "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return ScanInfected, strings.TrimSuffix(reply, " FOUND"), nil
+	default:
+		return ScanPending, "", fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+func newScanner() Scanner {
+	addr := os.Getenv("CLAMAV_ADDR")
+	if addr == "" {
+		return noopScanner{}
+	}
+	return clamavScanner{addr: addr, timeout: time.Duration(envInt("CLAMAV_TIMEOUT_SECS", 30)) * time.Second}
+}
+
+var fileScanner = newScanner()
+
+// scanAsync scans open() in the background and hands the outcome to done.
+// Failed scans are retried a few times with backoff, then left pending.
+func scanAsync(key string, open func() (io.ReadCloser, error), done func(status, signature string)) {
+	var attempt func(n int)
+	attempt = func(n int) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		status, sig, err := scanOnce(ctx, open)
+		if err != nil {
+			fmt.Println("scan error:", key, err)
+			if n < 3 {
+				jobs.Schedule("scan:"+key, time.Duration(n+1)*30*time.Second, func() { attempt(n + 1) })
+			}
+			return
+		}
+		done(status, sig)
+	}
+	jobs.Schedule("scan:"+key, 0, func() { attempt(0) })
+}
+
+// scanAttachment queues the scan of a's bytes and records its outcome.
+func scanAttachment(db *mongo.Database, a Attachment) {
+	open := func() (io.ReadCloser, error) {
+		bucket, err := attachmentBucket(context.Background(), db)
+		if err != nil {
+			return nil, err
+		}
+		return bucket.OpenDownloadStream(a.BlobID)
+	}
+	scanAsync(a.ID.Hex(), open, func(status, signature string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := recordScan(ctx, db, a.ID, status, signature); err != nil {
+			fmt.Println("scan record error:", a.ID.Hex(), err)
+		}
+	})
+}
+
+// recordScan stores the outcome of a pending scan and, for an infected
+// file, flags the messages showing it.
+func recordScan(ctx context.Context, db *mongo.Database, id primitive.ObjectID, status, signature string) error {
+	set := bson.M{"scan_status": status, "scanned_at": time.Now().UnixMilli()}
+	if signature != "" {
+		set["scan_signature"] = signature
+	}
+	res, err := db.Collection("attachments").UpdateOne(ctx,
+		bson.M{"_id": id, "scan_status": ScanPending}, bson.M{"$set": set})
+	if err != nil || res.ModifiedCount == 0 || status != ScanInfected {
+		return err
+	}
+	return flagInfectedMessages(ctx, db, id)
+}
+
+// flagInfectedMessages marks the image messages whose body is attachment
+// id and tells their conversations.
+func flagInfectedMessages(ctx context.Context, db *mongo.Database, id primitive.ObjectID) error {
+	filter := bson.M{"type": "image", "body": id.Hex(), "attachment_infected": bson.M{"$ne": true}}
+	cur, err := db.Collection("messages").Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"conversation_id": 1, "body": 1, "edited_at": 1}))
+	if err != nil {
+		return err
+	}
+	var flagged []Message
+	if err := cur.All(ctx, &flagged); err != nil {
+		return err
+	}
+	if len(flagged) == 0 {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, len(flagged))
+	for i, m := range flagged {
+		ids[i] = m.ID
+	}
+	if _, err := db.Collection("messages").UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$set": bson.M{"attachment_infected": true}}); err != nil {
+		return err
+	}
+	for _, m := range flagged {
+		broadcaster.Publish(events.New(m.ConversationID.Hex(), events.MessageUpdated{
+			ID: m.ID.Hex(), Body: m.Body, EditedAt: m.EditedAt, AttachmentInfected: true,
+		}))
+	}
+	return nil
+}
+
+func scanOnce(ctx context.Context, open func() (io.ReadCloser, error)) (string, string, error) {
+	rc, err := open()
+	if err != nil {
+		return ScanPending, "", err
+	}
+	defer rc.Close()
+	return fileScanner.Scan(ctx, rc)
+}
+
+// scanGate maps a scan_status to the download response: ok, or the status
+// code and error to send instead.
+func scanGate(status string) (code int, msg string, ok bool) {
+	switch status {
+	case ScanInfected:
+		return http.StatusForbidden, "file failed malware scan", false
+	case ScanPending:
+		if os.Getenv("SCAN_BLOCK_PENDING") == "true" {
+			return http.StatusLocked, "file is still being scanned", false
+		}
+	}
+	return 0, "", true
+}
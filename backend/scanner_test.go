@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeScanner answers every scan with status and signature, or err. With
+// hold set, each scan waits for a value on it first.
+type fakeScanner struct {
+	status, signature string
+	err               error
+	hold              chan struct{}
+	scanned           chan []byte
+}
+
+func (f *fakeScanner) Scan(_ context.Context, r io.Reader) (string, string, error) {
+	b, _ := io.ReadAll(r)
+	if f.hold != nil {
+		<-f.hold
+	}
+	f.scanned <- b
+	if f.err != nil {
+		return ScanPending, "", f.err
+	}
+	return f.status, f.signature, nil
+}
+
+func useScanner(t *testing.T, s Scanner) {
+	t.Helper()
+	old := fileScanner
+	fileScanner = s
+	t.Cleanup(func() { fileScanner = old })
+}
+
+func TestScanGate(t *testing.T) {
+	for _, tt := range []struct {
+		status, block string
+		code          int
+	}{
+		{ScanClean, "", 0},
+		{ScanClean, "true", 0},
+		{"", "true", 0},
+		{ScanInfected, "", http.StatusForbidden},
+		{ScanPending, "", 0},
+		{ScanPending, "true", http.StatusLocked},
+	} {
+		t.Setenv("SCAN_BLOCK_PENDING", tt.block)
+		code, _, ok := scanGate(tt.status)
+		if code != tt.code || ok != (tt.code == 0) {
+			t.Errorf("scanGate(%q), SCAN_BLOCK_PENDING=%q: %d %v", tt.status, tt.block, code, ok)
+		}
+	}
+}
+
+func TestParseClamReply(t *testing.T) {
+	for reply, want := range map[string]string{
+		"stream: OK":                          ScanClean,
+		"stream: Eicar-Test-Signature FOUND":  ScanInfected,
+		"INSTREAM size limit exceeded. ERROR": ScanPending,
+	} {
+		status, sig, err := parseClamReply(reply)
+		if status != want || (want == ScanInfected) != (sig == "Eicar-Test-Signature") || (want == ScanPending) != (err != nil) {
+			t.Errorf("parseClamReply(%q) = %q, %q, %v", reply, status, sig, err)
+		}
+	}
+}
+
+func testPNG(t *testing.T, shade uint8) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.Gray{Y: shade})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// scanFixture is ops, with ann uploading through POST /uploads and
+// fetching through GET /files/:id.
+type scanFixture struct {
+	r    *gin.Engine
+	db   *mongo.Database
+	ann  User
+	conv Conversation
+}
+
+func newScanFixture(t *testing.T) *scanFixture {
+	t.Helper()
+	client, db := testDB(t)
+	f := &scanFixture{db: db, ann: seedUser(t, db, "ann")}
+	f.conv = seedConv(t, db, "ops", f.ann)
+	r, api := testAPI()
+	api.POST("/uploads", UploadImageHandler(client))
+	api.GET("/files/:id", GetFileHandler(client))
+	api.POST("/messages/:cid", SendMessageHandler(client))
+	f.r = r
+	return f
+}
+
+func (f *scanFixture) upload(t *testing.T, data []byte) Attachment {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("conversation_id", f.conv.ID.Hex())
+	fw, _ := mw.CreateFormFile("file", "pic.png")
+	fw.Write(data)
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/uploads", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+tokenFor(t, f.ann))
+	w := httptest.NewRecorder()
+	f.r.ServeHTTP(w, req)
+	var a Attachment
+	decode(t, w, &a)
+	if w.Code != http.StatusCreated || a.ScanStatus != ScanPending {
+		t.Fatalf("upload: %d %s", w.Code, w.Body)
+	}
+	// a retry after a failed scan would outlive the test
+	t.Cleanup(func() { jobs.Cancel("scan:" + a.ID.Hex()) })
+	return a
+}
+
+// scanned waits for the fake scanner to be handed a's bytes, then for
+// the outcome to be stored. Returns the bytes scanned.
+func (f *scanFixture) scanned(t *testing.T, s *fakeScanner, a Attachment, want string) []byte {
+	t.Helper()
+	var b []byte
+	select {
+	case b = <-s.scanned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload never scanned")
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		var got Attachment
+		if err := f.db.Collection("attachments").FindOne(testCtx(t), bson.M{"_id": a.ID}).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ScanStatus == want {
+			return b
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scan_status %q, want %q", got.ScanStatus, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUploadScanGatesDownload(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		scanner *fakeScanner
+		block   string
+		status  string
+		code    int
+	}{
+		{"clean", &fakeScanner{status: ScanClean}, "true", ScanClean, http.StatusOK},
+		{"infected", &fakeScanner{status: ScanInfected, signature: "Eicar"}, "", ScanInfected, http.StatusForbidden},
+		{"pending served", &fakeScanner{err: errors.New("clamd down")}, "", ScanPending, http.StatusOK},
+		{"pending locked", &fakeScanner{err: errors.New("clamd down")}, "true", ScanPending, http.StatusLocked},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newScanFixture(t)
+			tt.scanner.scanned = make(chan []byte, 1)
+			useScanner(t, tt.scanner)
+			t.Setenv("SCAN_BLOCK_PENDING", tt.block)
+			data := testPNG(t, 1)
+
+			a := f.upload(t, data)
+			if b := f.scanned(t, tt.scanner, a, tt.status); !bytes.Equal(b, data) {
+				t.Fatal("scanned bytes differ from the upload")
+			}
+			w := serve(t, f.r, http.MethodGet, "/files/"+a.ID.Hex(), &f.ann, nil)
+			if w.Code != tt.code {
+				t.Fatalf("download: %d %s, want %d", w.Code, w.Body, tt.code)
+			}
+			if tt.code == http.StatusOK && !bytes.Equal(w.Body.Bytes(), data) {
+				t.Fatal("downloaded bytes differ from the upload")
+			}
+		})
+	}
+}
+
+func TestInfectedUploadFlagsMessages(t *testing.T) {
+	f := newScanFixture(t)
+	s := &fakeScanner{status: ScanInfected, signature: "Eicar", hold: make(chan struct{}), scanned: make(chan []byte, 1)}
+	useScanner(t, s)
+	a := f.upload(t, testPNG(t, 2))
+
+	// sent while the scan is still running
+	w := serve(t, f.r, http.MethodPost, "/messages/"+f.conv.ID.Hex(), &f.ann, gin.H{"type": "image", "body": a.ID.Hex()})
+	var sent Message
+	decode(t, w, &sent)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+	ch := broadcaster.Subscribe(f.conv.ID)
+	defer broadcaster.Unsubscribe(f.conv.ID, ch)
+
+	s.hold <- struct{}{}
+	f.scanned(t, s, a, ScanInfected)
+	select {
+	case e := <-ch:
+		p, ok := e.Payload.(events.MessageUpdated)
+		if !ok || p.ID != sent.ID.Hex() || !p.AttachmentInfected || p.Body != a.ID.Hex() {
+			t.Fatalf("event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message.updated for the infected file")
+	}
+	var stored Message
+	if err := f.db.Collection("messages").FindOne(testCtx(t), bson.M{"_id": sent.ID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if !stored.AttachmentInfected {
+		t.Fatal("message not flagged")
+	}
+
+	if w := serve(t, f.r, http.MethodPost, "/messages/"+f.conv.ID.Hex(), &f.ann, gin.H{"type": "image", "body": a.ID.Hex()}); w.Code != http.StatusForbidden {
+		t.Fatalf("send of an infected upload: %d, want 403", w.Code)
+	}
+	if n := countDocs(t, f.db, "messages", bson.M{"attachment_infected": true}); n != 1 {
+		t.Fatalf("%d flagged messages", n)
+	}
+	// a late result for a file already decided changes nothing
+	if err := recordScan(testCtx(t), f.db, a.ID, ScanClean, ""); err != nil {
+		t.Fatal(err)
+	}
+	if n := countDocs(t, f.db, "attachments", bson.M{"_id": a.ID, "scan_status": ScanInfected}); n != 1 {
+		t.Fatal("infected status overwritten")
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Message search. A text index on messages.body ("body_text") serves the
+$text queries; it isn't scoped, so a cross-conversation search can use it
+too. Large bodies are indexed by their inline preview only (blobs.go).
+*/
+
+const (
+	snippetRadius = 60 // runes either side of the first hit
+	maxSearchQ    = 200
+)
+
+func ensureSearchIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "body", Value: "text"}},
+		Options: options.Index().SetName("body_text"),
+	})
+	return err
+}
+
+type searchHit struct {
+	Message
+	Snippet string `json:"snippet"`
+}
+
+// GET /messages/:cid/search?q=<text>&before=<ts>&limit=20
+// Returns: { results: [{ ...message, snippet }], next_before } newest first;
+// next_before is absent on the last page. Members only.
+func SearchConversationHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		q := strings.TrimSpace(c.Query("q"))
+		if q == "" || len(q) > maxSearchQ {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q must be 1-200 chars"})
+			return
+		}
+		limit := 20
+		if s := c.Query("limit"); s != "" {
+			if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= 100 {
+				limit = n
+			}
+		}
+
+		filter := unexpired(visible(bson.M{
+			"conversation_id": cid,
+			"$text":           bson.M{"$search": q},
+		}))
+		if s := c.Query("before"); s != "" {
+			before, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before"})
+				return
+			}
+			filter["ts"] = bson.M{"$lt": before}
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		ok, err := canRead(ctx, db, cid, uid, "messages.search")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+		if err := ensureSearchIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
+			return
+		}
+
+		cur, err := db.Collection("messages").Find(ctx, filter,
+			options.Find().SetSort(bson.D{{Key: "ts", Value: -1}}).SetLimit(int64(limit)))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		var msgs []Message
+		if err := cur.All(ctx, &msgs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+
+		terms := searchTerms(q)
+		results := make([]searchHit, 0, len(msgs))
+		for _, m := range msgs {
+			results = append(results, searchHit{Message: m, Snippet: snippet(m.Body, terms)})
+		}
+		out := gin.H{"results": results}
+		if len(msgs) == limit {
+			out["next_before"] = msgs[len(msgs)-1].Ts
+		}
+		c.JSON(http.StatusOK, out)
+	}
+}
+
+// searchTerms splits q into lowercase words, dropping $text syntax
+// (quotes, negations).
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// snippet cuts body around the first occurrence of any term, with "…"
+// where it was shortened. The whole body is returned when it's short or
+// no term appears verbatim (stemmed matches).
+func snippet(body string, terms []string) string {
+	runes := []rune(body)
+	if len(runes) <= 2*snippetRadius {
+		return body
+	}
+	// per-rune lowering keeps indexes aligned with runes
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	hit := -1
+	for _, t := range terms {
+		if i := runeIndex(lower, []rune(t)); i >= 0 && (hit < 0 || i < hit) {
+			hit = i
+		}
+	}
+	if hit < 0 {
+		hit = 0
+	}
+	start, end := max(hit-snippetRadius, 0), min(hit+snippetRadius, len(runes))
+	s := string(runes[start:end])
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(runes) {
+		s += "…"
+	}
+	return s
+}
+
+func runeIndex(s, sub []rune) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		match := true
+		for j := range sub {
+			if s[i+j] != sub[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+type searchGroup struct {
+	ConversationID primitive.ObjectID `bson:"_id" json:"conversation_id"`
+	Title          string             `bson:"title" json:"title"`
+	Matches        int64              `bson:"matches" json:"total_matches"`
+	LastTs         int64              `bson:"last_ts" json:"last_ts"`
+	Messages       []Message          `bson:"messages" json:"-"`
+	Results        []searchHit        `bson:"-" json:"messages"`
+}
+
+// GET /search?q=<text>&per_conversation=3&limit=20&cursor=<next_cursor>
+// Returns: { results: [{ conversation_id, title, total_matches, last_ts,
+// messages: [{ ...message, snippet }] }], next_cursor }
+// Searches every conversation the caller is in. Groups are ordered by
+// their newest hit, messages newest first; next_cursor is absent on the
+// last page. One query for the caller's conversations, one aggregation
+// for the hits.
+func SearchAllHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		q := strings.TrimSpace(c.Query("q"))
+		if q == "" || len(q) > maxSearchQ {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "q must be 1-200 chars"})
+			return
+		}
+		limit, per := 20, 3
+		if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 50 {
+			limit = n
+		}
+		if n, err := strconv.Atoi(c.Query("per_conversation")); err == nil && n > 0 && n <= 20 {
+			per = n
+		}
+		var after bson.M
+		if s := c.Query("cursor"); s != "" {
+			ts, id, err := decodeConvCursor(s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+				return
+			}
+			after = bson.M{"$or": bson.A{
+				bson.M{"last_ts": bson.M{"$lt": ts}},
+				bson.M{"last_ts": ts, "_id": bson.M{"$lt": id}},
+			}}
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		convFilter, err := memberConvFilter(ctx, db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		cids, err := db.Collection("conversations").Distinct(ctx, "_id", convFilter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if len(cids) == 0 {
+			c.JSON(http.StatusOK, gin.H{"results": []searchGroup{}})
+			return
+		}
+		if err := ensureSearchIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
+			return
+		}
+
+		pipeline := mongo.Pipeline{
+			// $text has to be in the first stage
+			{{Key: "$match", Value: unexpired(visible(bson.M{
+				"$text":           bson.M{"$search": q},
+				"conversation_id": bson.M{"$in": cids},
+			}))}},
+			{{Key: "$group", Value: bson.M{
+				"_id":     "$conversation_id",
+				"matches": bson.M{"$sum": 1},
+				"last_ts": bson.M{"$max": "$ts"},
+				"messages": bson.M{"$topN": bson.M{
+					"n":      per,
+					"sortBy": bson.M{"ts": -1},
+					"output": "$$ROOT",
+				}},
+			}}},
+		}
+		if after != nil {
+			pipeline = append(pipeline, bson.D{{Key: "$match", Value: after}})
+		}
+		pipeline = append(pipeline,
+			bson.D{{Key: "$sort", Value: bson.D{{Key: "last_ts", Value: -1}, {Key: "_id", Value: -1}}}},
+			bson.D{{Key: "$limit", Value: limit + 1}},
+			bson.D{{Key: "$lookup", Value: bson.M{
+				"from":         "conversations",
+				"localField":   "_id",
+				"foreignField": "_id",
+				"pipeline":     bson.A{bson.M{"$project": bson.M{"title": 1}}},
+				"as":           "conv",
+			}}},
+			bson.D{{Key: "$set", Value: bson.M{"title": bson.M{"$first": "$conv.title"}}}},
+			bson.D{{Key: "$project", Value: bson.M{"conv": 0}}},
+		)
+		cur, err := db.Collection("messages").Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		groups := []searchGroup{}
+		if err := cur.All(ctx, &groups); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+
+		out := gin.H{}
+		if len(groups) > limit {
+			groups = groups[:limit]
+			last := groups[limit-1]
+			out["next_cursor"] = encodeConvCursor(last.LastTs, last.ConversationID)
+		}
+		terms := searchTerms(q)
+		for i := range groups {
+			g := &groups[i]
+			g.Results = make([]searchHit, 0, len(g.Messages))
+			for _, m := range g.Messages {
+				g.Results = append(g.Results, searchHit{Message: m, Snippet: snippet(m.Body, terms)})
+			}
+		}
+		out["results"] = groups
+		c.JSON(http.StatusOK, out)
+	}
+}
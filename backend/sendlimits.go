@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  users.send_limit:               { exempt, min_interval_secs }   (admins)
+  conversations.send_limits:      [{ user_id, exempt, min_interval_secs }]  (owners)
+
+Per-sender overrides of the send limits, meant for bots (BOT_API_KEYS
+users) but usable for anyone:
+  - exempt: skips slow mode and the flood breaker, as owners do, so e.g. an
+    announcements bot can post bursts
+  - min_interval_secs: a personal slow mode, at least that long between the
+    sender's messages in a conversation even where slow mode is off (the
+    longer of the two applies, owners included)
+A conversation's entry for a sender replaces their user-level one. With
+neither, the normal limits apply.
+*/
+
+type sendLimit struct {
+	UserID          primitive.ObjectID `bson:"user_id,omitempty" json:"-"`
+	Exempt          bool               `bson:"exempt,omitempty" json:"exempt"`
+	MinIntervalSecs int                `bson:"min_interval_secs,omitempty" json:"min_interval_secs"`
+}
+
+func (l sendLimit) isZero() bool { return !l.Exempt && l.MinIntervalSecs == 0 }
+
+// sendLimitFor returns the override for uid sending to cid, if any.
+func sendLimitFor(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID) (sendLimit, error) {
+	var conv struct {
+		Limits []sendLimit `bson:"send_limits"`
+	}
+	err := db.Collection("conversations").FindOne(ctx, bson.M{"_id": cid},
+		options.FindOne().SetProjection(bson.M{"send_limits": bson.M{"$elemMatch": bson.M{"user_id": uid}}}),
+	).Decode(&conv)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return sendLimit{}, err
+	}
+	if len(conv.Limits) > 0 {
+		return conv.Limits[0], nil
+	}
+
+	var u struct {
+		Limit sendLimit `bson:"send_limit"`
+	}
+	err = db.Collection("users").FindOne(ctx, bson.M{"_id": uid},
+		options.FindOne().SetProjection(bson.M{"send_limit": 1}),
+	).Decode(&u)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return sendLimit{}, err
+	}
+	return u.Limit, nil
+}
+
+// putConvSendLimit replaces uid's entry in cid's send_limits, or removes it
+// when l is zero. Each step is one atomic update, so concurrent calls never
+// leave two entries for uid.
+func putConvSendLimit(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID, l sendLimit) error {
+	convs := db.Collection("conversations")
+	if l.isZero() {
+		_, err := convs.UpdateByID(ctx, cid, bson.M{"$pull": bson.M{"send_limits": bson.M{"user_id": uid}}})
+		return err
+	}
+	l.UserID = uid
+	res, err := convs.UpdateOne(ctx,
+		bson.M{"_id": cid, "send_limits.user_id": uid},
+		bson.M{"$set": bson.M{"send_limits.$": l}})
+	if err != nil || res.MatchedCount > 0 {
+		return err
+	}
+	_, err = convs.UpdateOne(ctx,
+		bson.M{"_id": cid, "send_limits.user_id": bson.M{"$ne": uid}},
+		bson.M{"$push": bson.M{"send_limits": l}})
+	return err
+}
+
+// bindSendLimit reads { exempt, min_interval_secs } from the body, writing
+// the 400 itself when it is invalid.
+func bindSendLimit(c *gin.Context) (sendLimit, bool) {
+	var in sendLimit
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+		return in, false
+	}
+	if in.MinIntervalSecs < 0 || in.MinIntervalSecs > maxSlowModeSecs {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_interval_secs must be 0-3600"})
+		return in, false
+	}
+	if in.Exempt && in.MinIntervalSecs > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "give exempt or min_interval_secs, not both"})
+		return in, false
+	}
+	return in, true
+}
+
+// PUT /admin/users/:username/send-limit (admin only)
+// Body: { "exempt": true } or { "min_interval_secs": 30 }; both off clears it.
+func SetUserSendLimitHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		actor, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		in, ok := bindSendLimit(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		update := bson.M{"$set": bson.M{"send_limit": in}}
+		if in.isZero() {
+			update = bson.M{"$unset": bson.M{"send_limit": ""}}
+		}
+		var u User
+		err = db.Collection("users").FindOneAndUpdate(ctx,
+			bson.M{"username": normalizeUsername(c.Param("username"))}, update,
+		).Decode(&u)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		writeAudit(ctx, db, AuditEntry{
+			Action:  "user.send_limit",
+			ActorID: actor,
+			Details: gin.H{"user_id": u.ID.Hex(), "exempt": in.Exempt, "min_interval_secs": in.MinIntervalSecs},
+		})
+		c.JSON(http.StatusOK, gin.H{"user_id": u.ID.Hex(), "send_limit": in})
+	}
+}
+
+// PUT /conversations/:cid/send-limits/:uid (owner only)
+// Body as for the user-level override; applies in this conversation only and
+// wins over the user-level one. Both off removes the entry.
+func SetConvSendLimitHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		target, err := mustOID(c.Param("uid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+		in, ok := bindSendLimit(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role != "owner" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+			return
+		}
+		member, err := isMember(ctx, db, cid, target)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !member {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not a member"})
+			return
+		}
+
+		if err := putConvSendLimit(ctx, db, cid, target, in); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		writeAudit(ctx, db, AuditEntry{
+			Action:         "conversation.send_limit",
+			ActorID:        uid,
+			ConversationID: cid,
+			Details:        gin.H{"user_id": target.Hex(), "exempt": in.Exempt, "min_interval_secs": in.MinIntervalSecs},
+		})
+		c.JSON(http.StatusOK, gin.H{"user_id": target.Hex(), "send_limit": in})
+	}
+}
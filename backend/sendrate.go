@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  conversations.send_rate: { multiplier } or { per_min }   (owners, admins)
+
+Send budget: each sender may post SEND_RATE_PER_MIN messages a minute in
+a conversation (default 30), and SEND_RATE_GLOBAL_PER_MIN across all of
+them (default 120). The per-conversation budget is keyed by (sender,
+conversation), so busy channels don't eat into each other; the global
+one is the backstop and applies everywhere, overrides included.
+
+Channels that need more (incident response, support) get an override:
+a multiplier of the default, or an explicit per_min. Either is capped at
+SEND_RATE_MAX_MULTIPLIER x the default (default 10); a larger override is
+stored as given but clamped when used, so lowering the cap takes effect
+at once. Overrides may also tighten the budget. Senders exempt from send
+limits (sendlimits.go) skip the per-conversation budget, not the global
+one.
+
+GET /conversations/:cid carries the effective numbers as send_rate, so
+clients can warn before a 429.
+
+Both are per-process, like the other in-memory limiters.
+*/
+
+const maxSendRateMultiplier = 100
+
+type sendRate struct {
+	Multiplier float64 `bson:"multiplier,omitempty" json:"multiplier,omitempty"`
+	PerMin     int     `bson:"per_min,omitempty" json:"per_min,omitempty"`
+}
+
+// sendRateInfo is the effective budget for a conversation.
+type sendRateInfo struct {
+	PerMin       int  `json:"per_min"`
+	GlobalPerMin int  `json:"global_per_min"`
+	MaxPerMin    int  `json:"max_per_min"`
+	Override     bool `json:"override"`
+	Clamped      bool `json:"clamped,omitempty"`
+}
+
+var (
+	convSendLimiter   = newWindowLimiter(envInt("SEND_RATE_PER_MIN", 30), time.Minute)
+	globalSendLimiter = newWindowLimiter(envInt("SEND_RATE_GLOBAL_PER_MIN", 120), time.Minute)
+)
+
+// effectiveSendRate applies o (nil = none) to the default and the cap.
+func effectiveSendRate(o *sendRate) sendRateInfo {
+	base := convSendLimiter.limit
+	info := sendRateInfo{
+		PerMin:       base,
+		GlobalPerMin: globalSendLimiter.limit,
+		MaxPerMin:    base * envInt("SEND_RATE_MAX_MULTIPLIER", 10),
+	}
+	if o == nil {
+		return info
+	}
+	switch {
+	case o.PerMin > 0:
+		info.PerMin, info.Override = o.PerMin, true
+	case o.Multiplier > 0:
+		info.PerMin, info.Override = max(int(math.Round(float64(base)*o.Multiplier)), 1), true
+	}
+	if info.PerMin > info.MaxPerMin {
+		info.PerMin, info.Clamped = info.MaxPerMin, true
+	}
+	return info
+}
+
+// convSendRate is cid's override, nil when it has none.
+func convSendRate(ctx context.Context, db *mongo.Database, cid primitive.ObjectID) (*sendRate, error) {
+	var conv struct {
+		SendRate *sendRate `bson:"send_rate"`
+	}
+	err := db.Collection("conversations").FindOne(ctx, bson.M{"_id": cid},
+		options.FindOne().SetProjection(bson.M{"send_rate": 1}),
+	).Decode(&conv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return conv.SendRate, err
+}
+
+// sendRateWait spends one send from uid's budgets in cid, or returns the
+// 429 for whichever is used up. Nothing is spent on a 429.
+func sendRateWait(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID, lim sendLimit) error {
+	o, err := convSendRate(ctx, db, cid)
+	if err != nil {
+		return err
+	}
+	info := effectiveSendRate(o)
+	convKey := uid.Hex() + "|" + cid.Hex()
+
+	if !lim.Exempt && convSendLimiter.WaitLimit(convKey, info.PerMin) > 0 {
+		return rateLimited("sending too fast in this conversation", convSendLimiter.statusLimit(convKey, info.PerMin))
+	}
+	if !globalSendLimiter.Allow(uid.Hex()) {
+		return rateLimited("sending too fast", globalSendLimiter.status(uid.Hex()))
+	}
+	if !lim.Exempt {
+		convSendLimiter.AllowLimit(convKey, info.PerMin)
+	}
+	return nil
+}
+
+// PUT /conversations/:cid/rate-limit (owner or admin)
+// Body: { "multiplier": 5 } or { "per_min": 200 }; neither clears the
+// override. Returns: { send_rate: <as stored>, effective: <sendRateInfo> }
+func SetSendRateHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		var in sendRate
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+			return
+		}
+		if in.Multiplier < 0 || in.Multiplier > maxSendRateMultiplier || in.PerMin < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "multiplier must be 0-100 and per_min positive"})
+			return
+		}
+		if in.Multiplier > 0 && in.PerMin > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "give multiplier or per_min, not both"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		if !isAdmin(c.GetString("uname")) {
+			role, err := memberRole(ctx, db, cid, uid)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			if role != "owner" {
+				c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+				return
+			}
+		}
+
+		var o *sendRate
+		update := bson.M{"$unset": bson.M{"send_rate": ""}, "$set": bson.M{"updated_at": time.Now().UnixMilli()}}
+		if in.Multiplier > 0 || in.PerMin > 0 {
+			o = &in
+			update = bson.M{"$set": bson.M{"send_rate": in, "updated_at": time.Now().UnixMilli()}}
+		}
+		res, err := db.Collection("conversations").UpdateByID(ctx, cid, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "conversation not found"})
+			return
+		}
+		writeAudit(ctx, db, AuditEntry{
+			Action:         "conversation.send_rate",
+			ActorID:        uid,
+			ConversationID: cid,
+			Details:        gin.H{"multiplier": in.Multiplier, "per_min": in.PerMin},
+		})
+		c.JSON(http.StatusOK, gin.H{"send_rate": o, "effective": effectiveSendRate(o)})
+	}
+}
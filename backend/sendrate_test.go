@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// useSendLimiters swaps in fresh send budgets of perMin and globalPerMin
+// for the length of t.
+func useSendLimiters(t *testing.T, perMin, globalPerMin int) {
+	t.Helper()
+	conv, global := convSendLimiter, globalSendLimiter
+	convSendLimiter = newWindowLimiter(perMin, time.Minute)
+	globalSendLimiter = newWindowLimiter(globalPerMin, time.Minute)
+	t.Cleanup(func() { convSendLimiter, globalSendLimiter = conv, global })
+}
+
+func TestEffectiveSendRate(t *testing.T) {
+	useSendLimiters(t, 30, 120)
+	t.Setenv("SEND_RATE_MAX_MULTIPLIER", "10")
+	for _, tt := range []struct {
+		name string
+		o    *sendRate
+		want sendRateInfo
+	}{
+		{"none", nil, sendRateInfo{PerMin: 30, GlobalPerMin: 120, MaxPerMin: 300}},
+		{"empty", &sendRate{}, sendRateInfo{PerMin: 30, GlobalPerMin: 120, MaxPerMin: 300}},
+		{"multiplier", &sendRate{Multiplier: 2.5}, sendRateInfo{PerMin: 75, GlobalPerMin: 120, MaxPerMin: 300, Override: true}},
+		{"tighter", &sendRate{Multiplier: 0.001}, sendRateInfo{PerMin: 1, GlobalPerMin: 120, MaxPerMin: 300, Override: true}},
+		{"per_min", &sendRate{PerMin: 200}, sendRateInfo{PerMin: 200, GlobalPerMin: 120, MaxPerMin: 300, Override: true}},
+		{"clamped", &sendRate{Multiplier: 50}, sendRateInfo{PerMin: 300, GlobalPerMin: 120, MaxPerMin: 300, Override: true, Clamped: true}},
+		{"per_min wins", &sendRate{PerMin: 10, Multiplier: 5}, sendRateInfo{PerMin: 10, GlobalPerMin: 120, MaxPerMin: 300, Override: true}},
+	} {
+		if got := effectiveSendRate(tt.o); got != tt.want {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// lowering the cap clamps a stored override at once
+	t.Setenv("SEND_RATE_MAX_MULTIPLIER", "2")
+	if got := effectiveSendRate(&sendRate{PerMin: 200}); got.PerMin != 60 || !got.Clamped {
+		t.Errorf("after lowering the cap: %+v", got)
+	}
+}
+
+func TestSendRateWait(t *testing.T) {
+	_, db := testDB(t)
+	useSendLimiters(t, 3, 5)
+	ann := seedUser(t, db, "ann")
+	plain := seedConv(t, db, "plain", ann).ID
+	busy := seedConv(t, db, "busy", ann).ID
+	if _, err := db.Collection("conversations").UpdateByID(testCtx(t), busy, bson.M{"$set": bson.M{"send_rate": sendRate{PerMin: 10}}}); err != nil {
+		t.Fatal(err)
+	}
+	send := func(cid primitive.ObjectID, lim sendLimit) int {
+		t.Helper()
+		err := sendRateWait(testCtx(t), db, cid, ann.ID, lim)
+		var se *svcError
+		switch {
+		case err == nil:
+			return http.StatusOK
+		case errors.As(err, &se):
+			return se.Status
+		}
+		t.Fatal(err)
+		return 0
+	}
+
+	for i := range 3 {
+		if code := send(plain, sendLimit{}); code != http.StatusOK {
+			t.Fatalf("send %d in plain: %d", i, code)
+		}
+	}
+	if code := send(plain, sendLimit{}); code != http.StatusTooManyRequests {
+		t.Fatalf("4th send in plain: %d, want 429", code)
+	}
+	// the 429 spent nothing: busy still has the two global sends left
+	for i := range 2 {
+		if code := send(busy, sendLimit{}); code != http.StatusOK {
+			t.Fatalf("send %d in busy: %d", i, code)
+		}
+	}
+	// busy's override is under its own 10, but the backstop is spent
+	if code := send(busy, sendLimit{}); code != http.StatusTooManyRequests {
+		t.Fatalf("send past the global budget: %d, want 429", code)
+	}
+	if code := send(plain, sendLimit{Exempt: true}); code != http.StatusTooManyRequests {
+		t.Fatalf("exempt sender past the global budget: %d, want 429", code)
+	}
+
+	// an exempt sender skips the per-conversation budget only
+	useSendLimiters(t, 1, 5)
+	for i := range 5 {
+		if code := send(plain, sendLimit{Exempt: true}); code != http.StatusOK {
+			t.Fatalf("exempt send %d: %d", i, code)
+		}
+	}
+	if code := send(plain, sendLimit{Exempt: true}); code != http.StatusTooManyRequests {
+		t.Fatalf("exempt send past the global budget: %d, want 429", code)
+	}
+}
+
+func TestSetSendRate(t *testing.T) {
+	client, db := testDB(t)
+	useSendLimiters(t, 30, 120)
+	t.Setenv("SEND_RATE_MAX_MULTIPLIER", "10")
+	owner := seedUser(t, db, "owner")
+	bob := seedUser(t, db, "bob")
+	conv := seedConv(t, db, "ops", owner, bob)
+	r, api := testAPI()
+	api.PUT("/conversations/:cid/rate-limit", SetSendRateHandler(client))
+	path := "/conversations/" + conv.ID.Hex() + "/rate-limit"
+	stored := func() *sendRate {
+		t.Helper()
+		o, err := convSendRate(testCtx(t), db, conv.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return o
+	}
+
+	for _, body := range []gin.H{
+		{"multiplier": -1},
+		{"multiplier": 101},
+		{"per_min": -5},
+		{"multiplier": 2, "per_min": 10},
+	} {
+		if w := serve(t, r, http.MethodPut, path, &owner, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%v: %d, want 400", body, w.Code)
+		}
+	}
+	if w := serve(t, r, http.MethodPut, path, &bob, gin.H{"multiplier": 2}); w.Code != http.StatusForbidden {
+		t.Fatalf("member: %d, want 403", w.Code)
+	}
+	if o := stored(); o != nil {
+		t.Fatalf("refused requests stored %+v", o)
+	}
+
+	// stored as given, clamped when used
+	w := serve(t, r, http.MethodPut, path, &owner, gin.H{"multiplier": 20})
+	var res struct {
+		SendRate  *sendRate    `json:"send_rate"`
+		Effective sendRateInfo `json:"effective"`
+	}
+	decode(t, w, &res)
+	if w.Code != http.StatusOK || res.SendRate == nil || res.SendRate.Multiplier != 20 || res.Effective.PerMin != 300 || !res.Effective.Clamped {
+		t.Fatalf("set: %d %s", w.Code, w.Body)
+	}
+	if o := stored(); o == nil || o.Multiplier != 20 {
+		t.Fatalf("stored %+v", o)
+	}
+
+	// an empty body clears it
+	if w := serve(t, r, http.MethodPut, path, &owner, gin.H{}); w.Code != http.StatusOK {
+		t.Fatalf("clear: %d %s", w.Code, w.Body)
+	}
+	if o := stored(); o != nil {
+		t.Fatalf("override left after clearing: %+v", o)
+	}
+}
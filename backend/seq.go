@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  message_seq:
+    - _id (ObjectId, the conversation)
+    - seq (int64, last number handed out)
+
+Every message stored through storeMessage gets the next seq of its
+conversation, so seq orders a conversation's messages by insertion even
+when ts collides or a clock drifts. Numbers start at 1 and never repeat.
+They can still skip: an insert that fails after taking one, a purge, or
+an expired message that was reaped. Imported history (import.go) keeps
+its original ts and has no seq.
+*/
+
+// nextSeq reserves the next message number of cid.
+func nextSeq(ctx context.Context, db *mongo.Database, cid primitive.ObjectID) (int64, error) {
+	var c struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := db.Collection("message_seq").FindOneAndUpdate(ctx,
+		bson.M{"_id": cid},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&c)
+	return c.Seq, err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// svcError is a failure from the shared helpers that back both the HTTP
+// handlers and the gRPC server. Status is the HTTP status; grpc.go maps it
+// to a gRPC code.
+type svcError struct {
+	Status int
+	Msg    string
+	Extra  gin.H // merged into the HTTP error body
+	// set on 429s (rateLimited); adds the rate limit headers
+	RateLimit *rateLimit
+}
+
+func (e *svcError) Error() string { return e.Msg }
+
+func svcFail(status int, msg string) *svcError {
+	return &svcError{Status: status, Msg: msg}
+}
+
+// writeSvcError writes err in the usual { "error": ... } shape; anything
+// that isn't a svcError is a 500.
+func writeSvcError(c *gin.Context, err error) {
+	var se *svcError
+	if !errors.As(err, &se) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return
+	}
+	body := gin.H{"error": se.Msg}
+	for k, v := range se.Extra {
+		body[k] = v
+	}
+	if se.RateLimit != nil {
+		se.RateLimit.apply(c, body)
+	}
+	c.JSON(se.Status, body)
+}
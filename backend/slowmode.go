@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// slow mode: members may send at most one message every slow_mode_secs.
+// Owners are exempt, and per-sender overrides can exempt or throttle others
+// (sendlimits.go). 0 (or missing) means off. A tripped flood breaker
+// (flood.go) can raise it temporarily.
+const maxSlowModeSecs = 3600
+
+// slowModeWait returns how long uid must wait before sending to cid
+// (0 = can send now) and the conversation's slow_mode_secs. lim is uid's
+// override (sendlimits.go).
+func slowModeWait(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID, role string, lim sendLimit) (time.Duration, int, error) {
+	var conv Conversation
+	err := db.Collection("conversations").FindOne(ctx,
+		bson.M{"_id": cid},
+		options.FindOne().SetProjection(bson.M{"slow_mode_secs": 1}),
+	).Decode(&conv)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if s := floodGuard.slowModeSecs(cid); s > conv.SlowModeSecs {
+		conv.SlowModeSecs = s
+	}
+	secs := conv.SlowModeSecs
+	if role == "owner" || lim.Exempt {
+		secs = 0
+	}
+	secs = max(secs, lim.MinIntervalSecs)
+	if secs <= 0 {
+		return 0, conv.SlowModeSecs, nil
+	}
+
+	var last Message
+	err = db.Collection("messages").FindOne(ctx,
+		bson.M{"conversation_id": cid, "sender_id": uid},
+		options.FindOne().
+			SetSort(bson.D{{Key: "ts", Value: -1}}).
+			SetProjection(bson.M{"ts": 1}),
+	).Decode(&last)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, conv.SlowModeSecs, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	next := time.UnixMilli(last.Ts).Add(time.Duration(secs) * time.Second)
+	wait := time.Until(next)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, conv.SlowModeSecs, nil
+}
+
+// whole seconds, rounded up so clients never retry a moment too early
+func waitSecs(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// PUT /conversations/:cid/slow-mode (owner only)
+// Body: { "secs": 30 }   (0 turns it off, max 3600)
+func SetSlowModeHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		var in struct {
+			Secs *int `json:"secs"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil || in.Secs == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "secs required"})
+			return
+		}
+		if *in.Secs < 0 || *in.Secs > maxSlowModeSecs {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "secs must be 0-" + strconv.Itoa(maxSlowModeSecs)})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role != "owner" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+			return
+		}
+
+		if _, err := db.Collection("conversations").UpdateByID(ctx, cid,
+			bson.M{"$set": bson.M{"slow_mode_secs": *in.Secs, "updated_at": time.Now().UnixMilli()}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		broadcaster.Publish(events.New(cid.Hex(), events.ConversationUpdated{SlowModeSecs: in.Secs}))
+		c.JSON(http.StatusOK, gin.H{"ok": true, "slow_mode_secs": *in.Secs})
+	}
+}
+
+// GET /conversations/:cid/send-status
+// Returns: { can_send, retry_after_secs, slow_mode_secs }
+// Lets the composer show a countdown instead of discovering slow mode via 429.
+func SendStatusHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+
+		lim, err := sendLimitFor(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		wait, secs, err := slowModeWait(ctx, db, cid, uid, role, lim)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if b := floodGuard.blocked(cid); role != "owner" && !lim.Exempt && b > wait {
+			wait = b
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"can_send":         wait == 0,
+			"retry_after_secs": waitSecs(wait),
+			"slow_mode_secs":   secs,
+		})
+	}
+}
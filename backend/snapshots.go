@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Read-only historical snapshots: "everything said in this conversation
+between March 1 and March 15", frozen and shareable.
+
+  snapshots:
+    - _id, token      ("snp_" + 48 hex chars, unique)
+    - conversation_id, title (the title when taken)
+    - from, to        (int64 millis, from <= ts < to)
+    - message_count
+    - created_by, created_by_name, created_at
+    - revoked, revoked_at
+
+  snapshot_messages:
+    - snapshot_id, i  (0-based order, by ts), unique together
+    - message_id, sender_id, sender_name, type, body (in full), ts,
+      edited_at, reply_to, sticker_id
+    - attachment      ({ sha256, content_type, size, width, height } for
+                       image messages; the bytes are referenced by hash,
+                       not copied)
+
+POST /conversations/:cid/snapshots (owners, admins) copies the visible,
+unexpired messages in the range into snapshot_messages. Later edits,
+deletes and purges of the originals don't reach the copies, so the same
+token always serves the same pages. More than SNAPSHOT_MAX_MESSAGES
+(default 5000) is refused with 413.
+
+The token is the credential, as with widget tokens: GET
+/snapshots/:token needs no login. Owners see their conversation's
+snapshots, tokens included, on GET /conversations/:cid; DELETE
+/conversations/:cid/snapshots/:sid revokes one and drops its copies.
+*/
+
+type Snapshot struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	Token          string             `bson:"token" json:"token,omitempty"`
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	Title          string             `bson:"title" json:"title"`
+	From           int64              `bson:"from" json:"from"`
+	To             int64              `bson:"to" json:"to"`
+	MessageCount   int                `bson:"message_count" json:"message_count"`
+	CreatedBy      primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedByName  string             `bson:"created_by_name" json:"created_by_name"`
+	CreatedAt      int64              `bson:"created_at" json:"created_at"`
+	Revoked        bool               `bson:"revoked" json:"revoked"`
+	RevokedAt      int64              `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+type snapshotMessage struct {
+	SnapshotID primitive.ObjectID  `bson:"snapshot_id" json:"-"`
+	I          int                 `bson:"i" json:"i"`
+	MessageID  primitive.ObjectID  `bson:"message_id" json:"message_id"`
+	SenderID   primitive.ObjectID  `bson:"sender_id" json:"sender_id"`
+	SenderName string              `bson:"sender_name,omitempty" json:"sender_name,omitempty"`
+	Type       string              `bson:"type" json:"type"`
+	Body       string              `bson:"body" json:"body"`
+	Ts         int64               `bson:"ts" json:"ts"`
+	EditedAt   int64               `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+	ReplyTo    *primitive.ObjectID `bson:"reply_to,omitempty" json:"reply_to,omitempty"`
+	StickerID  string              `bson:"sticker_id,omitempty" json:"sticker_id,omitempty"`
+	Attachment *snapshotAttachment `bson:"attachment,omitempty" json:"attachment,omitempty"`
+}
+
+type snapshotAttachment struct {
+	SHA256      string `bson:"sha256" json:"sha256"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	Size        int64  `bson:"size" json:"size"`
+	Width       int    `bson:"width,omitempty" json:"width,omitempty"`
+	Height      int    `bson:"height,omitempty" json:"height,omitempty"`
+}
+
+const (
+	snapshotTokenPrefix = "snp_"
+	snapshotInsertBatch = 500
+)
+
+// per-IP limiter for the public snapshot pages
+var snapshotLimiter = newRateLimiter(60, 20)
+
+func maxSnapshotMessages() int {
+	return envInt("SNAPSHOT_MAX_MESSAGES", 5000)
+}
+
+func ensureSnapshotIndexes(ctx context.Context, db *mongo.Database) error {
+	if _, err := db.Collection("snapshots").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "conversation_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	}); err != nil {
+		return err
+	}
+	_, err := db.Collection("snapshot_messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "snapshot_id", Value: 1}, {Key: "i", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func newSnapshotToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return snapshotTokenPrefix + hex.EncodeToString(b), nil
+}
+
+// canManageSnapshots: owners of cid and admins.
+func canManageSnapshots(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID, uname string) (bool, error) {
+	if isAdmin(uname) {
+		return true, nil
+	}
+	role, err := memberRole(ctx, db, cid, uid)
+	return role == "owner", err
+}
+
+// listSnapshots is cid's snapshots, newest first, tokens included.
+func listSnapshots(ctx context.Context, db *mongo.Database, cid primitive.ObjectID) ([]Snapshot, error) {
+	cur, err := db.Collection("snapshots").Find(ctx, bson.M{"conversation_id": cid},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	out := []Snapshot{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// copySnapshotMessages materializes msgs (in order) under snapshot sid.
+func copySnapshotMessages(ctx context.Context, db *mongo.Database, sid primitive.ObjectID, msgs []Message) error {
+	senders := make([]primitive.ObjectID, 0, len(msgs))
+	var images []primitive.ObjectID
+	for _, m := range msgs {
+		senders = append(senders, m.SenderID)
+		if m.Type == "image" {
+			if id, err := mustOID(m.Body); err == nil {
+				images = append(images, id)
+			}
+		}
+	}
+	names, err := usernamesByID(ctx, db, senders)
+	if err != nil {
+		return err
+	}
+	files := map[string]*snapshotAttachment{}
+	if len(images) > 0 {
+		cur, err := db.Collection("attachments").Find(ctx, bson.M{"_id": bson.M{"$in": images}})
+		if err != nil {
+			return err
+		}
+		var rows []Attachment
+		if err := cur.All(ctx, &rows); err != nil {
+			return err
+		}
+		for _, a := range rows {
+			files[a.ID.Hex()] = &snapshotAttachment{
+				SHA256: a.SHA256, ContentType: a.ContentType, Size: a.Size, Width: a.Width, Height: a.Height,
+			}
+		}
+	}
+
+	batch := make([]interface{}, 0, snapshotInsertBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := db.Collection("snapshot_messages").InsertMany(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+	for i, m := range msgs {
+		body, err := loadFullBody(ctx, db, m)
+		if err != nil {
+			return err
+		}
+		sm := snapshotMessage{
+			SnapshotID: sid,
+			I:          i,
+			MessageID:  m.ID,
+			SenderID:   m.SenderID,
+			SenderName: names[m.SenderID],
+			Type:       m.Type,
+			Body:       body,
+			Ts:         m.Ts,
+			EditedAt:   m.EditedAt,
+			ReplyTo:    m.ReplyTo,
+			StickerID:  m.StickerID,
+		}
+		if m.Type == "image" {
+			sm.Attachment = files[m.Body]
+		}
+		batch = append(batch, sm)
+		if len(batch) == snapshotInsertBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// POST /conversations/:cid/snapshots (owner or admin)
+// Body: { "from": <ms>, "to": <ms> }   (from <= ts < to)
+// Returns: 201 { snapshot }, with its token; 413 { max, messages } when the
+// range holds more than SNAPSHOT_MAX_MESSAGES.
+func CreateSnapshotHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		var in struct {
+			From int64 `json:"from"`
+			To   int64 `json:"to"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+			return
+		}
+		if in.From < 0 || in.To <= in.From {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "need 0 <= from < to (millis)"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		ok, err := canManageSnapshots(ctx, db, cid, uid, c.GetString("uname"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+			return
+		}
+		var conv Conversation
+		err = db.Collection("conversations").FindOne(ctx, bson.M{"_id": cid},
+			options.FindOne().SetProjection(bson.M{"title": 1})).Decode(&conv)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "conversation not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if err := ensureSnapshotIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
+			return
+		}
+
+		filter := unexpired(visible(bson.M{
+			"conversation_id": cid,
+			"ts":              bson.M{"$gte": in.From, "$lt": in.To},
+		}))
+		limit := maxSnapshotMessages()
+		n, err := db.Collection("messages").CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if n > int64(limit) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error":    fmt.Sprintf("snapshots are limited to %d messages; narrow the range", limit),
+				"max":      limit,
+				"messages": n,
+			})
+			return
+		}
+		cur, err := db.Collection("messages").Find(ctx, filter, options.Find().
+			SetSort(bson.D{{Key: "ts", Value: 1}, {Key: "_id", Value: 1}}).
+			SetLimit(int64(limit)))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		var msgs []Message
+		if err := cur.All(ctx, &msgs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+
+		token, err := newSnapshotToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+			return
+		}
+		snap := Snapshot{
+			ID:             primitive.NewObjectID(),
+			Token:          token,
+			ConversationID: cid,
+			Title:          conv.Title,
+			From:           in.From,
+			To:             in.To,
+			MessageCount:   len(msgs),
+			CreatedBy:      uid,
+			CreatedByName:  c.GetString("udisplay"),
+			CreatedAt:      time.Now().UnixMilli(),
+		}
+		// copies first: the snapshot row is what makes the token work, so a
+		// half-written snapshot is never served
+		if err := copySnapshotMessages(ctx, db, snap.ID, msgs); err != nil {
+			fmt.Println("snapshot copy error:", err)
+			_, _ = db.Collection("snapshot_messages").DeleteMany(ctx, bson.M{"snapshot_id": snap.ID})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if _, err := db.Collection("snapshots").InsertOne(ctx, snap); err != nil {
+			_, _ = db.Collection("snapshot_messages").DeleteMany(ctx, bson.M{"snapshot_id": snap.ID})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		writeAudit(ctx, db, AuditEntry{
+			Action:         "snapshot.create",
+			ActorID:        uid,
+			ConversationID: cid,
+			Details:        gin.H{"snapshot_id": snap.ID.Hex(), "from": in.From, "to": in.To, "messages": len(msgs)},
+		})
+		c.JSON(http.StatusCreated, snap)
+	}
+}
+
+// DELETE /conversations/:cid/snapshots/:sid (owner or admin)
+// The token stops working at once; the copied messages are dropped and
+// the snapshot row stays, revoked, as the record.
+func RevokeSnapshotHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		sid, err := mustOID(c.Param("sid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid snapshot id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		ok, err := canManageSnapshots(ctx, db, cid, uid, c.GetString("uname"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+			return
+		}
+		res, err := db.Collection("snapshots").UpdateOne(ctx,
+			bson.M{"_id": sid, "conversation_id": cid},
+			bson.M{"$set": bson.M{"revoked": true, "revoked_at": time.Now().UnixMilli()}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "snapshot not found"})
+			return
+		}
+		if _, err := db.Collection("snapshot_messages").DeleteMany(ctx, bson.M{"snapshot_id": sid}); err != nil {
+			fmt.Println("snapshot drop error:", err)
+		}
+		writeAudit(ctx, db, AuditEntry{
+			Action:         "snapshot.revoke",
+			ActorID:        uid,
+			ConversationID: cid,
+			Details:        gin.H{"snapshot_id": sid.Hex()},
+		})
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	}
+}
+
+// GET /snapshots/:token?after=<i>&limit=100   (no login; the token is the credential)
+// Returns: { snapshot: { conversation_id, title, from, to, message_count,
+// created_by, created_by_name, created_at }, messages: [...], next_after }
+// Messages are oldest first; page on with after = next_after, which is
+// absent on the last page. Unknown and revoked tokens are 404.
+func GetSnapshotHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Param("token")
+		if !strings.HasPrefix(token, snapshotTokenPrefix) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "snapshot not found"})
+			return
+		}
+		limit := 100
+		if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 {
+			limit = min(n, 500)
+		}
+		after := -1
+		if n, err := strconv.Atoi(c.Query("after")); err == nil && n >= 0 {
+			after = n
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		var snap Snapshot
+		err := db.Collection("snapshots").FindOne(ctx, bson.M{"token": token, "revoked": false}).Decode(&snap)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "snapshot not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		cur, err := db.Collection("snapshot_messages").Find(ctx,
+			bson.M{"snapshot_id": snap.ID, "i": bson.M{"$gt": after}},
+			options.Find().SetSort(bson.D{{Key: "i", Value: 1}}).SetLimit(int64(limit)))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		msgs := []snapshotMessage{}
+		if err := cur.All(ctx, &msgs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+
+		snap.Token = "" // the caller has it
+		out := gin.H{"snapshot": snap, "messages": msgs}
+		if len(msgs) == limit && msgs[len(msgs)-1].I < snap.MessageCount-1 {
+			out["next_after"] = msgs[len(msgs)-1].I
+		}
+		c.Header("Cache-Control", "private, max-age=300")
+		c.JSON(http.StatusOK, out)
+	}
+}
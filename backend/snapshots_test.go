@@ -0,0 +1,188 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// snapshotFixture is ops, owned by owner, with messages m0..m5 a second
+// apart from base; m2 is deleted, m3's body is long enough for GridFS, and
+// m5 is past the range [base, base+5s) the tests snapshot.
+type snapshotFixture struct {
+	r     *gin.Engine
+	db    *mongo.Database
+	owner User
+	bob   User
+	conv  Conversation
+	base  int64
+	msgs  []Message
+	long  string
+}
+
+func newSnapshotFixture(t *testing.T) *snapshotFixture {
+	t.Helper()
+	client, db := testDB(t)
+	f := &snapshotFixture{db: db, owner: seedUser(t, db, "owner"), bob: seedUser(t, db, "bob")}
+	f.conv = seedConv(t, db, "ops", f.owner, f.bob)
+	f.base = time.Now().Add(-time.Hour).UnixMilli()
+	f.long = strings.Repeat("long body ", bodyInlineMax/5)
+	for i := range 6 {
+		m := Message{ConversationID: f.conv.ID, SenderID: f.bob.ID, Type: "text", Body: "m" + strconv.Itoa(i), Ts: f.base + int64(i)*1000}
+		if i == 3 {
+			m.Body = f.long
+		}
+		stored, _, err := storeMessage(testCtx(t), db, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.msgs = append(f.msgs, stored)
+	}
+	if _, err := db.Collection("messages").UpdateByID(testCtx(t), f.msgs[2].ID, bson.M{"$set": bson.M{"deleted": true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, api := testAPI()
+	api.POST("/conversations/:cid/snapshots", CreateSnapshotHandler(client))
+	api.DELETE("/conversations/:cid/snapshots/:sid", RevokeSnapshotHandler(client))
+	r.GET("/snapshots/:token", GetSnapshotHandler(client))
+	f.r = r
+	return f
+}
+
+func (f *snapshotFixture) create(t *testing.T, as User, from, to int64) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(t, f.r, http.MethodPost, "/conversations/"+f.conv.ID.Hex()+"/snapshots", &as, gin.H{"from": from, "to": to})
+}
+
+type snapshotPage struct {
+	Snapshot  Snapshot          `json:"snapshot"`
+	Messages  []snapshotMessage `json:"messages"`
+	NextAfter *int              `json:"next_after"`
+}
+
+// read fetches every page of token, limit at a time, or returns the status
+// of the first page that isn't 200.
+func (f *snapshotFixture) read(t *testing.T, token string, limit int) ([]snapshotMessage, int) {
+	t.Helper()
+	var all []snapshotMessage
+	after := "-1"
+	for {
+		w := serve(t, f.r, http.MethodGet, "/snapshots/"+token+"?limit="+strconv.Itoa(limit)+"&after="+after, nil, nil)
+		if w.Code != http.StatusOK {
+			return nil, w.Code
+		}
+		var p snapshotPage
+		decode(t, w, &p)
+		if p.Snapshot.Token != "" {
+			t.Fatal("token echoed on the public page")
+		}
+		all = append(all, p.Messages...)
+		if p.NextAfter == nil {
+			return all, http.StatusOK
+		}
+		after = strconv.Itoa(*p.NextAfter)
+	}
+}
+
+func TestSnapshotCreateAndRead(t *testing.T) {
+	f := newSnapshotFixture(t)
+	from, to := f.base, f.base+5000
+
+	if w := f.create(t, f.bob, from, to); w.Code != http.StatusForbidden {
+		t.Fatalf("member: %d, want 403", w.Code)
+	}
+	for _, rng := range [][2]int64{{-1, to}, {to, to}, {to, from}} {
+		if w := f.create(t, f.owner, rng[0], rng[1]); w.Code != http.StatusBadRequest {
+			t.Fatalf("range %v: %d, want 400", rng, w.Code)
+		}
+	}
+	t.Setenv("SNAPSHOT_MAX_MESSAGES", "3")
+	w := f.create(t, f.owner, from, to)
+	var tooBig struct{ Max, Messages int }
+	decode(t, w, &tooBig)
+	if w.Code != http.StatusRequestEntityTooLarge || tooBig.Max != 3 || tooBig.Messages != 4 {
+		t.Fatalf("over the max: %d %s", w.Code, w.Body)
+	}
+	t.Setenv("SNAPSHOT_MAX_MESSAGES", "")
+
+	w = f.create(t, f.owner, from, to)
+	var snap Snapshot
+	decode(t, w, &snap)
+	if w.Code != http.StatusCreated || !strings.HasPrefix(snap.Token, snapshotTokenPrefix) || len(snap.Token) != len(snapshotTokenPrefix)+48 || snap.MessageCount != 4 || snap.Title != "ops" {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+
+	// later changes to the originals don't reach the copies
+	if _, err := f.db.Collection("messages").UpdateByID(testCtx(t), f.msgs[0].ID, bson.M{"$set": bson.M{"body": "edited"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.db.Collection("messages").DeleteMany(testCtx(t), bson.M{"conversation_id": f.conv.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, limit := range []int{1, 3, 100} {
+		got, code := f.read(t, snap.Token, limit)
+		if code != http.StatusOK {
+			t.Fatalf("read with limit %d: %d", limit, code)
+		}
+		var bodies []string
+		for i, m := range got {
+			if m.I != i || m.SenderName != "bob" {
+				t.Fatalf("limit %d: message %d is %+v", limit, i, m)
+			}
+			bodies = append(bodies, m.Body)
+		}
+		if want := []string{"m0", "m1", f.long, "m4"}; strings.Join(bodies, "|") != strings.Join(want, "|") {
+			t.Fatalf("limit %d: bodies %.40q", limit, bodies)
+		}
+	}
+}
+
+func TestSnapshotRevoke(t *testing.T) {
+	f := newSnapshotFixture(t)
+	w := f.create(t, f.owner, f.base, f.base+5000)
+	var snap Snapshot
+	decode(t, w, &snap)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	path := "/conversations/" + f.conv.ID.Hex() + "/snapshots/" + snap.ID.Hex()
+
+	if w := serve(t, f.r, http.MethodDelete, path, &f.bob, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("member revoke: %d, want 403", w.Code)
+	}
+	other := seedConv(t, f.db, "other", f.owner)
+	if w := serve(t, f.r, http.MethodDelete, "/conversations/"+other.ID.Hex()+"/snapshots/"+snap.ID.Hex(), &f.owner, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("revoke through another conversation: %d, want 404", w.Code)
+	}
+	if _, code := f.read(t, snap.Token, 100); code != http.StatusOK {
+		t.Fatalf("read before revoking: %d", code)
+	}
+
+	if w := serve(t, f.r, http.MethodDelete, path, &f.owner, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body)
+	}
+	if _, code := f.read(t, snap.Token, 100); code != http.StatusNotFound {
+		t.Fatalf("read after revoking: %d, want 404", code)
+	}
+	if n := countDocs(t, f.db, "snapshot_messages", bson.M{"snapshot_id": snap.ID}); n != 0 {
+		t.Fatalf("%d copies left after revoking", n)
+	}
+	if n := countDocs(t, f.db, "snapshots", bson.M{"_id": snap.ID, "revoked": true}); n != 1 {
+		t.Fatal("revoked snapshot row not kept")
+	}
+
+	for _, token := range []string{snapshotTokenPrefix + strings.Repeat("0", 48), "wdg_x", "x"} {
+		if _, code := f.read(t, token, 100); code != http.StatusNotFound {
+			t.Errorf("token %q: %d, want 404", token, code)
+		}
+	}
+}
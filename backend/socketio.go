@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"backend/events"
+
+	"github.com/golang-jwt/jwt/v5"
+	socketio "github.com/googollee/go-socket.io"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Socket.IO compatibility adapter for the older frontend prototype
+(Socket.IO v2 protocol, behind FEATURE_SOCKETIO).
+
+  connect: io(url), then emit("auth", { token: "<jwt>" }, ack)
+           -> ack("ok") or ack("unauthorized"); same claims validation
+           as /ws. Socket.IO v2, which this adapter speaks, carries no
+           payload on CONNECT, so this first packet is the handshake auth
+           payload (v3+'s io(url, { auth: { token } })). Sockets still
+           unauthenticated after sioAuthTimeout are closed.
+           Legacy: an "Authorization: Bearer <jwt>" header or ?token= on
+           the handshake URL authenticates at connect, for clients from
+           before the auth packet; an auth packet for another user is
+           refused.
+  emit("join", "<cid>", ack)  -> ack("ok") or ack("<error>")
+  emit("leave", "<cid>")
+
+Every Broadcaster event for a joined conversation is emitted under its
+type name (message.created, receipt.updated, ...) with the same Event JSON
+/ws sends. /ws stays the primary transport; this only forwards. A user
+removed from a conversation is taken out of its room, after which they
+receive nothing more from it (member.removed included).
+*/
+
+type sioSession struct {
+	claims *Claims
+	uid    primitive.ObjectID
+}
+
+// sioConnState is a socket's context; sess stays nil until it authenticates.
+type sioConnState struct {
+	sess atomic.Pointer[sioSession]
+}
+
+type sioAuthPayload struct {
+	Token string `json:"token"`
+}
+
+var sioAuthTimeout = 10 * time.Second
+
+// sioSessionOf is s's session, or nil before it authenticates.
+func sioSessionOf(s socketio.Conn) *sioSession {
+	if st, ok := s.Context().(*sioConnState); ok {
+		return st.sess.Load()
+	}
+	return nil
+}
+
+type sioBridge struct {
+	srv    *socketio.Server
+	client *mongo.Client
+
+	mu   sync.Mutex
+	subs map[primitive.ObjectID]chan Event // one Broadcaster feed per joined room
+}
+
+func newSocketIOServer(client *mongo.Client) *socketio.Server {
+	b := &sioBridge{
+		srv:    socketio.NewServer(nil),
+		client: client,
+		subs:   make(map[primitive.ObjectID]chan Event),
+	}
+
+	b.srv.OnConnect("/", func(s socketio.Conn) error {
+		st := &sioConnState{}
+		if tok := sioLegacyToken(s); tok != "" {
+			sess, err := newSioSession(tok)
+			if err != nil {
+				return fmt.Errorf("unauthorized")
+			}
+			st.sess.Store(sess)
+		}
+		s.SetContext(st)
+		if st.sess.Load() == nil {
+			time.AfterFunc(sioAuthTimeout, func() {
+				if st.sess.Load() == nil {
+					s.Close()
+				}
+			})
+		}
+		return nil
+	})
+
+	b.srv.OnEvent("/", "auth", func(s socketio.Conn, p sioAuthPayload) string {
+		st, ok := s.Context().(*sioConnState)
+		if !ok {
+			return "unauthorized"
+		}
+		sess, err := newSioSession(p.Token)
+		if err != nil {
+			return "unauthorized"
+		}
+		// rooms already joined were checked against the legacy identity
+		if cur := st.sess.Load(); cur != nil && cur.uid != sess.uid {
+			return "unauthorized"
+		}
+		st.sess.Store(sess)
+		return "ok"
+	})
+
+	b.srv.OnEvent("/", "join", func(s socketio.Conn, cidHex string) string {
+		sess := sioSessionOf(s)
+		if sess == nil {
+			return "unauthorized"
+		}
+		cid, err := mustOID(cidHex)
+		if err != nil {
+			return "invalid conversation id"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		member, err := isMember(ctx, getDB(b.client), cid, sess.uid)
+		if err != nil {
+			return "db error"
+		}
+		if !member {
+			return "not a member"
+		}
+		s.Join(cid.Hex())
+		b.ensureFeed(cid)
+		return "ok"
+	})
+
+	b.srv.OnEvent("/", "leave", func(s socketio.Conn, cidHex string) {
+		s.Leave(cidHex)
+		if cid, err := mustOID(cidHex); err == nil {
+			b.dropFeedIfEmpty(cid)
+		}
+	})
+
+	b.srv.OnDisconnect("/", func(s socketio.Conn, _ string) {
+		// the library has already left every room by now
+		b.mu.Lock()
+		cids := make([]primitive.ObjectID, 0, len(b.subs))
+		for cid := range b.subs {
+			cids = append(cids, cid)
+		}
+		b.mu.Unlock()
+		for _, cid := range cids {
+			b.dropFeedIfEmpty(cid)
+		}
+	})
+
+	b.srv.OnError("/", func(_ socketio.Conn, err error) {
+		fmt.Println("socket.io error:", err)
+	})
+
+	return b.srv
+}
+
+// ensureFeed subscribes to cid on the Broadcaster once and forwards events
+// to the matching Socket.IO room.
+func (b *sioBridge) ensureFeed(cid primitive.ObjectID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[cid]; ok {
+		return
+	}
+	ch := broadcaster.Subscribe(cid)
+	b.subs[cid] = ch
+	go func() {
+		for e := range ch {
+			if p, ok := e.Payload.(events.MemberRemoved); ok {
+				b.evict(e.ConversationID, p.UserID)
+			}
+			b.srv.BroadcastToRoom("/", e.ConversationID, e.Type, e)
+		}
+	}()
+}
+
+// evict takes uidHex's connections out of room cidHex, the Socket.IO side
+// of Broadcaster.CloseUserInRoom. The connections stay open for their other
+// rooms.
+func (b *sioBridge) evict(cidHex, uidHex string) {
+	var gone []socketio.Conn
+	b.srv.ForEach("/", cidHex, func(s socketio.Conn) {
+		if sess := sioSessionOf(s); sess != nil && sess.uid.Hex() == uidHex {
+			gone = append(gone, s)
+		}
+	})
+	if len(gone) == 0 {
+		return
+	}
+	for _, s := range gone {
+		b.srv.LeaveRoom("/", cidHex, s)
+	}
+	if cid, err := mustOID(cidHex); err == nil {
+		// not from the feed goroutine: this may close its channel
+		go b.dropFeedIfEmpty(cid)
+	}
+}
+
+func (b *sioBridge) dropFeedIfEmpty(cid primitive.ObjectID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch, ok := b.subs[cid]
+	// checked under the lock so a concurrent join's ensureFeed can't be lost
+	if !ok || b.srv.RoomLen("/", cid.Hex()) > 0 {
+		return
+	}
+	delete(b.subs, cid)
+	broadcaster.Unsubscribe(cid, ch)
+	close(ch)
+}
+
+// newSioSession validates tok the way /ws does.
+func newSioSession(tok string) (*sioSession, error) {
+	if tok == "" {
+		return nil, jwt.ErrTokenMalformed
+	}
+	cl, err := parseToken(tok)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := mustOID(cl.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &sioSession{claims: cl, uid: uid}, nil
+}
+
+// sioLegacyToken is a JWT sent on the handshake request itself, in an
+// Authorization header or the legacy ?token= query, or "" if there is none.
+func sioLegacyToken(s socketio.Conn) string {
+	if h := s.RemoteHeader().Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return h[7:]
+	}
+	u := s.URL()
+	return u.Query().Get("token")
+}
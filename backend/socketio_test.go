@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/googollee/go-socket.io/engineio"
+	"github.com/googollee/go-socket.io/engineio/session"
+	"github.com/googollee/go-socket.io/engineio/transport"
+	"github.com/googollee/go-socket.io/engineio/transport/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sioClient speaks just enough Socket.IO v2 over engine.io to emit events
+// and read their acks.
+type sioClient struct {
+	t    *testing.T
+	conn engineio.Conn
+	ack  int
+}
+
+func dialSIO(t *testing.T, srv *httptest.Server, query string, h http.Header) *sioClient {
+	t.Helper()
+	d := engineio.Dialer{Transports: []transport.Transport{websocket.Default}}
+	conn, err := d.Dial(srv.URL+"/socket.io/"+query, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &sioClient{t: t, conn: conn}
+	if p, err := c.read(); err != nil || p != "0" {
+		t.Fatalf("connect packet %q, %v", p, err)
+	}
+	return c
+}
+
+func (c *sioClient) read() (string, error) {
+	_, r, err := c.conn.NextReader()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+// emit sends event with args and returns the first argument of its ack.
+func (c *sioClient) emit(event string, args ...any) string {
+	c.t.Helper()
+	c.ack++
+	body, err := json.Marshal(append([]any{event}, args...))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	w, err := c.conn.NextWriter(session.TEXT)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	id := strconv.Itoa(c.ack)
+	io.WriteString(w, "2"+id+string(body))
+	if err := w.Close(); err != nil {
+		c.t.Fatal(err)
+	}
+	p, err := c.read()
+	if err != nil {
+		c.t.Fatalf("%s: %v", event, err)
+	}
+	var res []string
+	if !strings.HasPrefix(p, "3"+id) || json.Unmarshal([]byte(p[1+len(id):]), &res) != nil || len(res) != 1 {
+		c.t.Fatalf("%s: ack %q", event, p)
+	}
+	return res[0]
+}
+
+func newSIOTestServer(t *testing.T) *httptest.Server {
+	sio := newSocketIOServer(nil)
+	go sio.Serve()
+	srv := httptest.NewServer(sio)
+	t.Cleanup(func() {
+		srv.Close()
+		sio.Close()
+	})
+	return srv
+}
+
+func TestSocketIOAuthPayload(t *testing.T) {
+	srv := newSIOTestServer(t)
+	ann := User{ID: primitive.NewObjectID(), Username: "ann"}
+	bob := User{ID: primitive.NewObjectID(), Username: "bob"}
+
+	c := dialSIO(t, srv, "", nil)
+	if got := c.emit("join", primitive.NewObjectID().Hex()); got != "unauthorized" {
+		t.Fatalf("join before auth: %q", got)
+	}
+	for _, p := range []any{map[string]string{}, map[string]string{"token": "x"}} {
+		if got := c.emit("auth", p); got != "unauthorized" {
+			t.Fatalf("auth %v: %q", p, got)
+		}
+	}
+	if got := c.emit("auth", sioAuthPayload{Token: tokenFor(t, ann)}); got != "ok" {
+		t.Fatalf("auth: %q", got)
+	}
+	// past auth: refused for the id, not the caller
+	if got := c.emit("join", "nope"); got != "invalid conversation id" {
+		t.Fatalf("join after auth: %q", got)
+	}
+
+	// legacy handshake token, then an auth packet for someone else
+	legacy := dialSIO(t, srv, "?token="+tokenFor(t, ann), nil)
+	if got := legacy.emit("join", "nope"); got != "invalid conversation id" {
+		t.Fatalf("join with ?token=: %q", got)
+	}
+	if got := legacy.emit("auth", sioAuthPayload{Token: tokenFor(t, bob)}); got != "unauthorized" {
+		t.Fatalf("auth as another user: %q", got)
+	}
+	if got := legacy.emit("auth", sioAuthPayload{Token: tokenFor(t, ann)}); got != "ok" {
+		t.Fatalf("auth as the same user: %q", got)
+	}
+	header := dialSIO(t, srv, "", http.Header{"Authorization": {"Bearer " + tokenFor(t, ann)}})
+	if got := header.emit("join", "nope"); got != "invalid conversation id" {
+		t.Fatalf("join with a bearer header: %q", got)
+	}
+}
+
+func TestSocketIOAuthTimeout(t *testing.T) {
+	old := sioAuthTimeout
+	sioAuthTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { sioAuthTimeout = old })
+	srv := newSIOTestServer(t)
+
+	authed := dialSIO(t, srv, "", nil)
+	if got := authed.emit("auth", sioAuthPayload{Token: tokenFor(t, testUser)}); got != "ok" {
+		t.Fatalf("auth: %q", got)
+	}
+	idle := dialSIO(t, srv, "", nil)
+	if p, err := idle.read(); err == nil {
+		t.Fatalf("unauthenticated socket still open, read %q", p)
+	}
+	if got := authed.emit("join", "nope"); got != "invalid conversation id" {
+		t.Fatalf("authenticated socket after the timeout: %q", got)
+	}
+}
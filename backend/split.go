@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// forwardRef points a copied message back at its original.
+type forwardRef struct {
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	MessageID      primitive.ObjectID `bson:"message_id" json:"message_id"`
+}
+
+// most follow-up messages a split copies along with the anchor
+const maxSplitFollow = 50
+
+// any member may split unless SPLIT_OWNER_ONLY=true
+func splitOwnerOnly() bool { return os.Getenv("SPLIT_OWNER_ONLY") == "true" }
+
+// POST /messages/:cid/:mid/split
+// Body: { "title": "...", "members": ["alice", "bob"], "follow": 10 }
+// Returns: { conversation, copied_ids, system_message_id }
+//
+// Starts a new conversation (caller is owner) with a subset of the source's
+// members, copies the anchor message plus up to follow later messages sent
+// by those members (with forwarded_from), and leaves a system message in the
+// source whose split_to is the new conversation id. Expiring messages are
+// never copied.
+func SplitConversationHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		mid, err := mustOID(c.Param("mid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+		var in struct {
+			Title   string   `json:"title"`
+			Members []string `json:"members"`
+			Follow  int      `json:"follow"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+			return
+		}
+		title, err := normalizeTitle(in.Title)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": titleErrCode(err)})
+			return
+		}
+		if in.Follow < 0 || in.Follow > maxSplitFollow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "follow must be 0-50"})
+			return
+		}
+		names := uniqLower(append(in.Members, c.GetString("uname")))
+		if len(names) < 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at least 2 unique members required"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+		if splitOwnerOnly() && role != "owner" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+			return
+		}
+
+		var anchor Message
+		err = db.Collection("messages").FindOne(ctx, visible(bson.M{"_id": mid, "conversation_id": cid})).Decode(&anchor)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if anchor.ExpiresAt > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expiring messages can't be split"})
+			return
+		}
+
+		// every member of the new conversation must already be in the source
+		ids, err := userIDsByName(ctx, db, names)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		members := make([]Member, 0, len(names))
+		inSplit := make(map[primitive.ObjectID]bool, len(names))
+		for _, n := range names {
+			id, ok := ids[n]
+			if ok {
+				ok, err = isMember(ctx, db, cid, id)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+					return
+				}
+			}
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "not a member of this conversation: " + n})
+				return
+			}
+			r := "member"
+			if id == uid {
+				r = "owner"
+			}
+			members = append(members, Member{UserID: id, Role: r})
+			inSplit[id] = true
+		}
+
+		toCopy := []Message{anchor}
+		if in.Follow > 0 {
+			senders := make([]primitive.ObjectID, 0, len(inSplit))
+			for id := range inSplit {
+				senders = append(senders, id)
+			}
+			cur, err := db.Collection("messages").Find(ctx,
+				visible(bson.M{
+					"conversation_id": cid,
+					"ts":              bson.M{"$gt": anchor.Ts},
+					"sender_id":       bson.M{"$in": senders},
+					"expires_at_ms":   bson.M{"$exists": false},
+				}),
+				options.Find().SetSort(bson.D{{Key: "ts", Value: 1}}).SetLimit(int64(in.Follow)),
+			)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			var more []Message
+			if err := cur.All(ctx, &more); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+				return
+			}
+			toCopy = append(toCopy, more...)
+		}
+
+		// the copies stay under the source's residency tag
+		residency, err := convResidency(ctx, db, cid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		now := time.Now().UnixMilli()
+		conv := Conversation{
+			Title:     title,
+			Members:   members,
+			CreatedAt: now,
+			UpdatedAt: now,
+			Residency: residency,
+		}
+		if err := createConversation(ctx, db, &conv); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		// copies get fresh, strictly increasing ts so they read in order
+		copied := make([]string, 0, len(toCopy))
+		for i, m := range toCopy {
+			body, err := loadFullBody(ctx, db, m)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			cp, _, err := storeMessage(ctx, db, Message{
+				ConversationID: conv.ID,
+				SenderID:       m.SenderID,
+				Type:           m.Type,
+				Body:           body,
+				Ts:             now + int64(i),
+				ForwardedFrom:  &forwardRef{ConversationID: cid, MessageID: m.ID},
+			})
+			if err != nil {
+				writeSvcError(c, err)
+				return
+			}
+			copied = append(copied, cp.ID.Hex())
+		}
+
+		sys, _, err := storeMessage(ctx, db, Message{
+			ConversationID: cid,
+			SenderID:       uid,
+			Type:           "system",
+			Body:           c.GetString("udisplay") + " moved this thread to \"" + title + "\"",
+			Ts:             time.Now().UnixMilli(),
+			SplitTo:        &conv.ID,
+		})
+		if err != nil {
+			writeSvcError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"conversation":      gin.H{"id": conv.ID.Hex(), "title": conv.Title, "members": conv.Members},
+			"copied_ids":        copied,
+			"system_message_id": sys.ID.Hex(),
+		})
+	}
+}
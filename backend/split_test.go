@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSplitConversation(t *testing.T) {
+	client, db := testDB(t)
+	ann, bob, carol := seedUser(t, db, "ann"), seedUser(t, db, "bob"), seedUser(t, db, "carol")
+	eve := seedUser(t, db, "eve")
+	src := seedConv(t, db, "ops", ann, bob, carol)
+	r, api := testAPI()
+	api.POST("/messages/:cid", SendMessageHandler(client))
+	api.GET("/messages/:cid", ListMessagesHandler(client))
+	api.POST("/messages/:cid/:mid/split", SplitConversationHandler(client))
+	base := "/messages/" + src.ID.Hex()
+
+	send := func(as User, body string) Message {
+		t.Helper()
+		w := serve(t, r, http.MethodPost, base, &as, gin.H{"body": body})
+		var m Message
+		decode(t, w, &m)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("send: %d %s", w.Code, w.Body)
+		}
+		return m
+	}
+	anchor := send(ann, "anchor")
+	first := send(bob, "bob one")
+	send(carol, "carol, not in the split")
+	second := send(bob, "bob two")
+	split := base + "/" + anchor.ID.Hex() + "/split"
+
+	for _, tt := range []struct {
+		as      User
+		members []string
+		code    int
+		err     string
+	}{
+		{eve, []string{"ann"}, http.StatusForbidden, "not a member"},
+		{ann, []string{"bob", "eve"}, http.StatusBadRequest, "not a member of this conversation: eve"},
+		{ann, []string{"bob", "nobody"}, http.StatusBadRequest, "not a member of this conversation: nobody"},
+	} {
+		w := serve(t, r, http.MethodPost, split, &tt.as, gin.H{"title": "side", "members": tt.members})
+		var out struct {
+			Error string `json:"error"`
+		}
+		decode(t, w, &out)
+		if w.Code != tt.code || out.Error != tt.err {
+			t.Errorf("%s splits with %v: %d %q, want %d %q", tt.as.Username, tt.members, w.Code, out.Error, tt.code, tt.err)
+		}
+	}
+	if n := countDocs(t, db, "conversations", bson.M{}); n != 1 {
+		t.Fatalf("%d conversations after refused splits", n)
+	}
+
+	w := serve(t, r, http.MethodPost, split, &ann, gin.H{"title": "side", "members": []string{"Bob"}, "follow": 5})
+	var out struct {
+		Conversation struct {
+			ID      primitive.ObjectID `json:"id"`
+			Members []Member           `json:"members"`
+		} `json:"conversation"`
+		CopiedIDs []string `json:"copied_ids"`
+		SystemID  string   `json:"system_message_id"`
+	}
+	decode(t, w, &out)
+	if w.Code != http.StatusCreated || len(out.CopiedIDs) != 3 || len(out.Conversation.Members) != 2 {
+		t.Fatalf("split: %d %s", w.Code, w.Body)
+	}
+
+	// copies point back at their originals, oldest first
+	w = serve(t, r, http.MethodGet, "/messages/"+out.Conversation.ID.Hex(), &bob, nil)
+	var copies []Message
+	decode(t, w, &copies)
+	if w.Code != http.StatusOK || len(copies) != 3 {
+		t.Fatalf("split conversation: %d %s", w.Code, w.Body)
+	}
+	for i, orig := range []Message{second, first, anchor} {
+		f := copies[i].ForwardedFrom
+		if f == nil || f.ConversationID != src.ID || f.MessageID != orig.ID || copies[i].Body != orig.Body {
+			t.Errorf("copy %d: %+v forwarded from %+v, want %s", i, copies[i].Body, f, orig.ID.Hex())
+		}
+	}
+
+	// the source gets a system message linking to the new conversation
+	var msgs []Message
+	decode(t, serve(t, r, http.MethodGet, base, &carol, nil), &msgs)
+	link := msgs[0]
+	if link.ID.Hex() != out.SystemID || link.Type != "system" || link.SplitTo == nil || *link.SplitTo != out.Conversation.ID {
+		t.Fatalf("link-back message %+v", link)
+	}
+	if link.SenderID != ann.ID || link.Body != `ann moved this thread to "side"` {
+		t.Fatalf("link-back message from %s: %q", link.SenderID.Hex(), link.Body)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  stickers:
+    - _id        (string, sticker id, e.g. "party_parrot")
+    - set        (string, the pack it belongs to)
+    - name       (string, alt text; also the message body)
+    - url        (string, http(s) image clients render)
+    - created_at (int64, millis)
+
+Server-managed: admins add and remove stickers, everyone lists them. A
+"sticker" message stores sticker_id and the sticker's name as body, so
+lists, previews and pushes read sensibly without the set.
+*/
+
+type Sticker struct {
+	ID        string `bson:"_id" json:"id"`
+	Set       string `bson:"set" json:"set"`
+	Name      string `bson:"name" json:"name"`
+	URL       string `bson:"url" json:"url"`
+	CreatedAt int64  `bson:"created_at" json:"created_at"`
+}
+
+var stickerIDRe = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// lookupSticker returns the sticker with id, or a 400 svcError if there
+// is none.
+func lookupSticker(ctx context.Context, db *mongo.Database, id string) (Sticker, error) {
+	var s Sticker
+	if !stickerIDRe.MatchString(id) {
+		return s, svcFail(http.StatusBadRequest, "unknown sticker")
+	}
+	err := db.Collection("stickers").FindOne(ctx, bson.M{"_id": id}).Decode(&s)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return s, svcFail(http.StatusBadRequest, "unknown sticker")
+	}
+	return s, err
+}
+
+// GET /stickers
+// Returns: { stickers: [{ id, set, name, url }] } ordered by set, then name.
+func ListStickersHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		cur, err := db.Collection("stickers").Find(ctx, bson.M{},
+			options.Find().SetSort(bson.D{{Key: "set", Value: 1}, {Key: "name", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		out := []Sticker{}
+		if err := cur.All(ctx, &out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"stickers": out})
+	}
+}
+
+// PUT /admin/stickers/:id (admin only)
+// Body: { "set": "parrots", "name": "party parrot", "url": "https://..." }
+// Creates or replaces the sticker.
+func PutStickerHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if !stickerIDRe.MatchString(id) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "id must be 1-64 of a-z, 0-9, _ or -"})
+			return
+		}
+		var in struct {
+			Set  string `json:"set"`
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+			return
+		}
+		in.Set, in.Name = strings.TrimSpace(in.Set), strings.TrimSpace(in.Name)
+		if in.Set == "" || in.Name == "" || len(in.Set) > 64 || len(in.Name) > 64 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "set and name must be 1-64 characters"})
+			return
+		}
+		url, err := cleanAvatarURL(in.URL)
+		if err != nil || url == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "url must be an http(s) URL"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		s := Sticker{ID: id, Set: in.Set, Name: in.Name, URL: url, CreatedAt: time.Now().UnixMilli()}
+		if _, err := db.Collection("stickers").ReplaceOne(ctx, bson.M{"_id": id}, s,
+			options.Replace().SetUpsert(true)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		c.JSON(http.StatusOK, s)
+	}
+}
+
+// DELETE /admin/stickers/:id (admin only)
+// Messages already sent keep their sticker_id; clients show the name.
+func DeleteStickerHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		res, err := getDB(client).Collection("stickers").DeleteOne(ctx, bson.M{"_id": c.Param("id")})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if res.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "sticker not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// participants listed in a summary, most active first
+const summaryTopSenders = 10
+
+const summaryTTL = 30 * time.Second
+
+type summaryParticipant struct {
+	UserID   primitive.ObjectID `bson:"_id" json:"user_id"`
+	Username string             `bson:"-" json:"username,omitempty"`
+	Messages int64              `bson:"n" json:"messages"`
+	LastTs   int64              `bson:"last" json:"last_ts"`
+}
+
+type convSummary struct {
+	Total        int64                `json:"total_messages"`
+	FirstTs      int64                `json:"first_ts,omitempty"`
+	LastTs       int64                `json:"last_ts,omitempty"`
+	Participants []summaryParticipant `json:"participants"`
+	BusiestDay   *summaryDay          `json:"busiest_day,omitempty"`
+	Timezone     string               `json:"timezone"`
+	ComputedAt   int64                `json:"computed_at"`
+}
+
+type summaryDay struct {
+	Day      string `bson:"_id" json:"day"`
+	Messages int64  `bson:"n" json:"messages"`
+}
+
+// summaries are cheap to serve stale for a few seconds and not to compute
+var summaryCache = struct {
+	sync.Mutex
+	m map[string]*convSummary
+}{m: make(map[string]*convSummary)}
+
+func cachedSummary(key string) (*convSummary, bool) {
+	summaryCache.Lock()
+	defer summaryCache.Unlock()
+	s, ok := summaryCache.m[key]
+	if !ok || time.Since(time.UnixMilli(s.ComputedAt)) > summaryTTL {
+		return nil, false
+	}
+	return s, true
+}
+
+func storeSummary(key string, s *convSummary) {
+	summaryCache.Lock()
+	defer summaryCache.Unlock()
+	if len(summaryCache.m) > 1000 {
+		for k, v := range summaryCache.m {
+			if time.Since(time.UnixMilli(v.ComputedAt)) > summaryTTL {
+				delete(summaryCache.m, k)
+			}
+		}
+	}
+	summaryCache.m[key] = s
+}
+
+// computeSummary runs one $facet aggregation over cid's visible messages
+// with from <= ts < to (millis; 0 = unbounded). Summary cards themselves
+// are not counted.
+func computeSummary(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, loc *time.Location, from, to int64) (*convSummary, error) {
+	match := bson.M{"conversation_id": cid, "type": bson.M{"$ne": msgTypeSummary}}
+	if from > 0 || to > 0 {
+		ts := bson.M{}
+		if from > 0 {
+			ts["$gte"] = from
+		}
+		if to > 0 {
+			ts["$lt"] = to
+		}
+		match["ts"] = ts
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: visible(match)}},
+		{{Key: "$facet", Value: bson.M{
+			"totals": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":   nil,
+					"n":     bson.M{"$sum": 1},
+					"first": bson.M{"$min": "$ts"},
+					"last":  bson.M{"$max": "$ts"},
+				}},
+			},
+			"senders": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":  "$sender_id",
+					"n":    bson.M{"$sum": 1},
+					"last": bson.M{"$max": "$ts"},
+				}},
+				bson.M{"$sort": bson.D{{Key: "n", Value: -1}, {Key: "last", Value: -1}}},
+				bson.M{"$limit": summaryTopSenders},
+			},
+			"days": bson.A{
+				bson.M{"$group": bson.M{
+					"_id": bson.M{"$dateToString": bson.M{
+						"format":   "%Y-%m-%d",
+						"date":     bson.M{"$toDate": "$ts"},
+						"timezone": loc.String(),
+					}},
+					"n": bson.M{"$sum": 1},
+				}},
+				// ties go to the most recent day
+				bson.M{"$sort": bson.D{{Key: "n", Value: -1}, {Key: "_id", Value: -1}}},
+				bson.M{"$limit": 1},
+			},
+		}}},
+	}
+	cur, err := db.Collection("messages").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var res []struct {
+		Totals []struct {
+			N     int64 `bson:"n"`
+			First int64 `bson:"first"`
+			Last  int64 `bson:"last"`
+		} `bson:"totals"`
+		Senders []summaryParticipant `bson:"senders"`
+		Days    []summaryDay         `bson:"days"`
+	}
+	if err := cur.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	out := &convSummary{
+		Participants: []summaryParticipant{},
+		Timezone:     loc.String(),
+		ComputedAt:   time.Now().UnixMilli(),
+	}
+	if len(res) == 0 || len(res[0].Totals) == 0 {
+		return out, nil
+	}
+	r := res[0]
+	out.Total, out.FirstTs, out.LastTs = r.Totals[0].N, r.Totals[0].First, r.Totals[0].Last
+	out.Participants = r.Senders
+	if len(r.Days) > 0 {
+		out.BusiestDay = &r.Days[0]
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(out.Participants))
+	for _, p := range out.Participants {
+		ids = append(ids, p.UserID)
+	}
+	names, err := usernamesByID(ctx, db, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out.Participants {
+		out.Participants[i].Username = names[out.Participants[i].UserID]
+	}
+	return out, nil
+}
+
+// GET /conversations/:cid/summary[?tz=Area/City]
+// Returns: { total_messages, first_ts, last_ts, busiest_day: { day, messages },
+//
+//	participants: [{ user_id, username, messages, last_ts }], timezone, computed_at }
+//
+// Plain counts over visible messages; days are local to tz (default UTC).
+// Cached for 30s per conversation and zone.
+func ConversationSummaryHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		loc := time.UTC
+		if tz := c.Query("tz"); tz != "" {
+			l, ok := loadZone(tz)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown timezone"})
+				return
+			}
+			loc = l
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		ok, err := isMember(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+
+		key := cid.Hex() + "|" + loc.String()
+		if s, ok := cachedSummary(key); ok {
+			c.JSON(http.StatusOK, s)
+			return
+		}
+		s, err := computeSummary(ctx, db, cid, loc, 0, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		storeSummary(key, s)
+		c.JSON(http.StatusOK, s)
+	}
+}
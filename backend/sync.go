@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Read-state sync for offline-first clients, cheap enough for every launch.
+
+GET /sync/read-state is one aggregation (the unread badge pipeline plus the
+last message ts). POST /sync/read-state merges the client's local read
+positions with $max semantics: one aggregation to load membership and the
+current positions, then one BulkWrite for the positions that move forward.
+Either side may be ahead for any conversation; the reply carries the merged
+position for each, which the client should adopt.
+*/
+
+// max positions accepted per POST
+const maxSyncPositions = 2000
+
+type readStateRow struct {
+	CID        primitive.ObjectID `bson:"cid" json:"cid"`
+	LastReadTS int64              `bson:"last_read_ts" json:"last_read_ts"`
+	LastTS     int64              `bson:"last_ts" json:"last_ts"`
+	Unread     int64              `bson:"unread" json:"unread"`
+}
+
+// GET /sync/read-state
+// Returns: { server_time, conversations: [{ cid, last_read_ts, last_ts, unread }] }
+// last_ts is the newest visible message (0 for an empty conversation).
+func GetReadStateHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		convFilter, err := memberConvFilter(ctx, db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		kw, err := loadKeywordMatcher(ctx, db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		pipeline := append(unreadPipeline(convFilter, uid, kw),
+			bson.D{{Key: "$lookup", Value: bson.M{
+				"from": "messages",
+				"let":  bson.M{"cid": "$_id"},
+				"pipeline": bson.A{
+					bson.M{"$match": visible(bson.M{
+						"$expr": bson.M{"$eq": bson.A{"$conversation_id", "$$cid"}},
+					})},
+					bson.M{"$sort": bson.M{"ts": -1}},
+					bson.M{"$limit": 1},
+					bson.M{"$project": bson.M{"ts": 1}},
+				},
+				"as": "last",
+			}}},
+			bson.D{{Key: "$project", Value: bson.M{
+				"_id":          0,
+				"cid":          "$_id",
+				"last_read_ts": "$since",
+				"last_ts":      bson.M{"$ifNull": bson.A{bson.M{"$first": "$last.ts"}, 0}},
+				"unread":       bson.M{"$ifNull": bson.A{bson.M{"$first": "$unread.n"}, 0}},
+			}}},
+		)
+		cur, err := db.Collection("conversations").Aggregate(ctx, pipeline)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		rows := []readStateRow{}
+		if err := cur.All(ctx, &rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"server_time": time.Now().UnixMilli(), "conversations": rows})
+	}
+}
+
+// POST /sync/read-state
+// Body: { positions: [{ cid, last_read_ts }] }
+// Returns: { positions: [{ cid, last_read_ts, advanced }], rejected: [cid] }
+// last_read_ts is the merged (max) position; advanced is true where the
+// client's position moved the server's forward. Conversations the caller
+// isn't in, and bad ids, come back in rejected.
+func PostReadStateHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		var in struct {
+			Positions []struct {
+				CID        string `json:"cid"`
+				LastReadTS int64  `json:"last_read_ts"`
+			} `json:"positions"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+			return
+		}
+		if len(in.Positions) > maxSyncPositions {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "too many positions", "max": maxSyncPositions})
+			return
+		}
+
+		// a client clock may run ahead; never store a future position
+		now := time.Now().UnixMilli()
+		want := make(map[primitive.ObjectID]int64, len(in.Positions))
+		rejected := []string{}
+		for _, p := range in.Positions {
+			cid, err := primitive.ObjectIDFromHex(p.CID)
+			if err != nil || p.LastReadTS < 0 {
+				rejected = append(rejected, p.CID)
+				continue
+			}
+			ts := min(p.LastReadTS, now)
+			if prev, ok := want[cid]; !ok || ts > prev {
+				want[cid] = ts
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		convFilter, err := memberConvFilter(ctx, db, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		ids := make([]primitive.ObjectID, 0, len(want))
+		for cid := range want {
+			ids = append(ids, cid)
+		}
+
+		// membership, receipts flag and current position in one pass
+		cur, err := db.Collection("conversations").Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{"$and": bson.A{convFilter, bson.M{"_id": bson.M{"$in": ids}}}}}},
+			{{Key: "$project", Value: bson.M{"receipts_enabled": 1}}},
+			{{Key: "$lookup", Value: bson.M{
+				"from": "receipts",
+				"let":  bson.M{"cid": "$_id"},
+				"pipeline": bson.A{
+					bson.M{"$match": bson.M{
+						"user_id": uid,
+						"$expr":   bson.M{"$eq": bson.A{"$conversation_id", "$$cid"}},
+					}},
+					bson.M{"$project": bson.M{"last_read_ts": 1}},
+				},
+				"as": "rc",
+			}}},
+			{{Key: "$project", Value: bson.M{
+				"receipts_enabled": 1,
+				"since":            bson.M{"$ifNull": bson.A{bson.M{"$first": "$rc.last_read_ts"}, 0}},
+			}}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		var current []struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Receipts *bool              `bson:"receipts_enabled"`
+			Since    int64              `bson:"since"`
+		}
+		if err := cur.All(ctx, &current); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+
+		out := make([]gin.H, 0, len(current))
+		var writes []mongo.WriteModel
+		var advanced []Event
+		for _, cv := range current {
+			ts := want[cv.ID]
+			delete(want, cv.ID)
+			if ts <= cv.Since {
+				// server is ahead (or equal): the client should catch up
+				out = append(out, gin.H{"cid": cv.ID, "last_read_ts": cv.Since, "advanced": false})
+				continue
+			}
+			out = append(out, gin.H{"cid": cv.ID, "last_read_ts": ts, "advanced": true})
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"conversation_id": cv.ID, "user_id": uid}).
+				SetUpdate(bson.M{
+					"$max":         bson.M{"last_read_ts": ts},
+					"$set":         bson.M{"updated_at": now},
+					"$setOnInsert": bson.M{"conversation_id": cv.ID, "user_id": uid},
+				}).
+				SetUpsert(true))
+			if cv.Receipts == nil || *cv.Receipts {
+				advanced = append(advanced, events.New(cv.ID.Hex(), events.ReceiptUpdated{UserID: uid.Hex(), LastReadTS: ts}))
+			}
+		}
+		for cid := range want {
+			rejected = append(rejected, cid.Hex())
+		}
+
+		if len(writes) > 0 {
+			if _, err := db.Collection("receipts").BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			for _, e := range advanced {
+				broadcaster.Publish(e)
+			}
+			invalidateConvList(uid)
+		}
+		c.JSON(http.StatusOK, gin.H{"positions": out, "rejected": rejected})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestReadStateSyncConflicts posts local positions that are behind, ahead
+// of and missing from the server's, and checks the merge keeps the max
+// of each without ever moving a position back.
+func TestReadStateSyncConflicts(t *testing.T) {
+	client, db := testDB(t)
+	ann, bob := seedUser(t, db, "ann"), seedUser(t, db, "bob")
+	behind := seedConv(t, db, "behind", ann)
+	ahead := seedConv(t, db, "ahead", ann)
+	fresh := seedConv(t, db, "fresh", ann)
+	foreign := seedConv(t, db, "foreign", bob)
+	r, api := testAPI()
+	api.POST("/sync/read-state", PostReadStateHandler(client))
+
+	base := time.Now().Add(-time.Hour).UnixMilli()
+	for cid, ts := range map[primitive.ObjectID]int64{behind.ID: base + 200, ahead.ID: base + 100} {
+		if _, err := db.Collection("receipts").InsertOne(testCtx(t), Receipt{ConversationID: cid, UserID: ann.ID, LastReadTS: ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored := func(cid primitive.ObjectID) int64 {
+		t.Helper()
+		var rc Receipt
+		if err := db.Collection("receipts").FindOne(testCtx(t), bson.M{"conversation_id": cid, "user_id": ann.ID}).Decode(&rc); err != nil {
+			t.Fatal(err)
+		}
+		return rc.LastReadTS
+	}
+	type position struct {
+		CID        primitive.ObjectID `json:"cid"`
+		LastReadTS int64              `json:"last_read_ts"`
+		Advanced   bool               `json:"advanced"`
+	}
+	post := func(positions ...gin.H) (map[primitive.ObjectID]position, []string) {
+		t.Helper()
+		w := serve(t, r, http.MethodPost, "/sync/read-state", &ann, gin.H{"positions": positions})
+		var out struct {
+			Positions []position `json:"positions"`
+			Rejected  []string   `json:"rejected"`
+		}
+		decode(t, w, &out)
+		if w.Code != http.StatusOK {
+			t.Fatalf("sync: %d %s", w.Code, w.Body)
+		}
+		got := make(map[primitive.ObjectID]position, len(out.Positions))
+		for _, p := range out.Positions {
+			got[p.CID] = p
+		}
+		return got, out.Rejected
+	}
+
+	ch := broadcaster.Subscribe(ahead.ID)
+	defer broadcaster.Unsubscribe(ahead.ID, ch)
+	got, rejected := post(
+		gin.H{"cid": behind.ID.Hex(), "last_read_ts": base + 150},
+		gin.H{"cid": ahead.ID.Hex(), "last_read_ts": base + 120},
+		gin.H{"cid": ahead.ID.Hex(), "last_read_ts": base + 180}, // two offline devices
+		gin.H{"cid": fresh.ID.Hex(), "last_read_ts": base + 50},
+		gin.H{"cid": foreign.ID.Hex(), "last_read_ts": base + 50},
+		gin.H{"cid": "nope", "last_read_ts": base},
+	)
+	for cid, want := range map[primitive.ObjectID]position{
+		behind.ID: {behind.ID, base + 200, false},
+		ahead.ID:  {ahead.ID, base + 180, true},
+		fresh.ID:  {fresh.ID, base + 50, true},
+	} {
+		if got[cid] != want {
+			t.Errorf("merged %+v, want %+v", got[cid], want)
+		}
+		if ts := stored(cid); ts != want.LastReadTS {
+			t.Errorf("stored %d for %s, want %d", ts, cid.Hex(), want.LastReadTS)
+		}
+	}
+	if len(got) != 3 || len(rejected) != 2 {
+		t.Fatalf("%d positions, rejected %v", len(got), rejected)
+	}
+	select {
+	case e := <-ch:
+		p, ok := e.Payload.(events.ReceiptUpdated)
+		if !ok || p.UserID != ann.ID.Hex() || p.LastReadTS != base+180 {
+			t.Fatalf("event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no receipt.updated for the advanced position")
+	}
+
+	// a stale device syncing later can't move anything back, and a clock
+	// running ahead is clamped to the server's now
+	before := time.Now().UnixMilli()
+	got, _ = post(
+		gin.H{"cid": ahead.ID.Hex(), "last_read_ts": base + 10},
+		gin.H{"cid": fresh.ID.Hex(), "last_read_ts": before + time.Hour.Milliseconds()},
+	)
+	if p := got[ahead.ID]; p.Advanced || p.LastReadTS != base+180 || stored(ahead.ID) != base+180 {
+		t.Fatalf("stale position: %+v, stored %d", p, stored(ahead.ID))
+	}
+	if p := got[fresh.ID]; !p.Advanced || p.LastReadTS < before || p.LastReadTS > time.Now().UnixMilli() {
+		t.Fatalf("future position not clamped: %+v", p)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Thread view over reply_to (refs.go). Replies are counted at read time, not
+kept as a counter, so deletes, purges and expiry need no bookkeeping;
+reply_count is the parent's visible replies. A partial index on
+(reply_to, ts) covers both the count and the thread page.
+*/
+
+func ensureThreadIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "reply_to", Value: 1}, {Key: "ts", Value: 1}},
+		Options: options.Index().SetPartialFilterExpression(
+			bson.M{"reply_to": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+// annotateReplyCounts sets ReplyCount on the messages in msgs that have
+// visible replies, with one aggregation over the page.
+func annotateReplyCounts(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	if err := ensureThreadIndexes(ctx, db); err != nil {
+		return err
+	}
+	ids := make([]primitive.ObjectID, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+	}
+	cur, err := db.Collection("messages").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: visible(bson.M{"reply_to": bson.M{"$in": ids}, "conversation_id": cid})}},
+		{{Key: "$group", Value: bson.M{"_id": "$reply_to", "n": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var rows []struct {
+		ID primitive.ObjectID `bson:"_id"`
+		N  int64              `bson:"n"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return err
+	}
+	counts := make(map[primitive.ObjectID]int64, len(rows))
+	for _, r := range rows {
+		counts[r.ID] = r.N
+	}
+	for i := range msgs {
+		msgs[i].ReplyCount = counts[msgs[i].ID]
+	}
+	return nil
+}
+
+// GET /messages/:cid/:mid/replies?since=<ts>&before=<ts>&limit=50
+// Returns the replies to :mid oldest -> newest, deleted ones as tombstones.
+// limit, since and before work as on GET /messages/:cid (since wins); page
+// forward with since = the last reply's ts.
+func ListRepliesHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		mid, err := mustOID(c.Param("mid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+		limit := 50
+		if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 {
+			limit = min(n, 200)
+		}
+		var since, before int64
+		if n, err := strconv.ParseInt(c.Query("since"), 10, 64); err == nil && n > 0 {
+			since = n
+		}
+		if n, err := strconv.ParseInt(c.Query("before"), 10, 64); err == nil && n > 0 {
+			before = n
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		member, err := isMember(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !member {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+		if err := ensureThreadIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
+			return
+		}
+
+		filter := unexpired(bson.M{"conversation_id": cid, "reply_to": mid})
+		if since > 0 {
+			filter["ts"] = bson.M{"$gt": since}
+		} else if before > 0 {
+			filter["ts"] = bson.M{"$lt": before}
+		}
+		cur, err := db.Collection("messages").Find(ctx, filter, options.Find().
+			SetSort(bson.D{{Key: "ts", Value: 1}}).
+			SetLimit(int64(limit)))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		out := make([]Message, 0, limit)
+		if err := cur.All(ctx, &out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+			return
+		}
+		for i := range out {
+			out[i].tombstone()
+		}
+		if err := annotateReplyCounts(ctx, db, cid, out); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		c.JSON(http.StatusOK, out)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+	_ "time/tzdata" // zone data built in, so day keys don't depend on the host
+)
+
+/*
+Day grouping hints for GET /messages/:cid. Every listed message has a
+day_bucket (YYYY-MM-DD), in UTC unless the client names its zone with
+?tz=Area/City or an X-Timezone header (IANA, e.g. "Europe/Berlin",
+"Asia/Kolkata"). With a zone the response is wrapped and also carries
+day_key per message and day_boundaries, so all clients split days the same
+way across DST changes. An unknown ?tz is a 400; an unknown X-Timezone falls
+back to UTC and the response says so in X-Timezone-Fallback.
+*/
+
+// loaded zones; only valid names are kept, so the map stays IANA-sized
+var tzCache sync.Map // string -> *time.Location
+
+func loadZone(name string) (*time.Location, bool) {
+	if v, ok := tzCache.Load(name); ok {
+		return v.(*time.Location), true
+	}
+	// time.LoadLocation maps "" and "Local" to UTC / the host zone; neither
+	// is what a client asking for its own zone means
+	if name == "" || name == "Local" {
+		return time.UTC, false
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC, false
+	}
+	tzCache.Store(name, loc)
+	return loc, true
+}
+
+// dayBoundary marks where a new local day starts within a page. Index is
+// the first message of that day in page order; Start is local midnight
+// (millis), which on DST days is not a multiple of 24h from the previous one.
+type dayBoundary struct {
+	DayKey string `json:"day_key"`
+	Index  int    `json:"index"`
+	Start  int64  `json:"start"`
+}
+
+// tagDays fills DayBucket (and DayKey when the zone was asked for) on msgs
+// and returns one boundary per distinct day.
+func tagDays(msgs []Message, loc *time.Location, withKey bool) []dayBoundary {
+	out := []dayBoundary{}
+	for i := range msgs {
+		t := time.UnixMilli(msgs[i].Ts).In(loc)
+		msgs[i].DayBucket = t.Format("2006-01-02")
+		if withKey {
+			msgs[i].DayKey = msgs[i].DayBucket
+		}
+		if i > 0 && msgs[i-1].DayBucket == msgs[i].DayBucket {
+			continue
+		}
+		y, m, d := t.Date()
+		out = append(out, dayBoundary{
+			DayKey: msgs[i].DayBucket,
+			Index:  i,
+			Start:  time.Date(y, m, d, 0, 0, 0, 0, loc).UnixMilli(),
+		})
+	}
+	return out
+}
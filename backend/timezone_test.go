@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLoadZone(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"Europe/Berlin": true,
+		"Asia/Kolkata":  true,
+		"UTC":           true,
+		"":              false,
+		"Local":         false,
+		"Mars/Olympus":  false,
+		"../etc/passwd": false,
+	} {
+		loc, got := loadZone(name)
+		if got != ok {
+			t.Errorf("loadZone(%q) ok = %v, want %v", name, got, ok)
+		}
+		if !ok && loc != time.UTC {
+			t.Errorf("loadZone(%q) = %s, want the UTC fallback", name, loc)
+		}
+		if _, cached := tzCache.Load(name); cached != ok {
+			t.Errorf("loadZone(%q) cached = %v", name, cached)
+		}
+	}
+}
+
+func TestTagDaysAcrossDST(t *testing.T) {
+	berlin, _ := loadZone("Europe/Berlin")
+	at := func(s string) Message {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Message{Ts: tm.UnixMilli()}
+	}
+	// newest first, as pages are; clocks went forward at 02:00 on the 31st
+	page := func() []Message {
+		return []Message{
+			at("2024-04-01 00:10"),
+			at("2024-03-31 23:30"),
+			at("2024-03-31 03:30"),
+			at("2024-03-31 01:00"),
+			at("2024-03-30 23:59"),
+		}
+	}
+	msgs := page()
+
+	days := tagDays(msgs, berlin, true)
+	want := []dayBoundary{
+		{"2024-04-01", 0, time.Date(2024, 4, 1, 0, 0, 0, 0, berlin).UnixMilli()},
+		{"2024-03-31", 1, time.Date(2024, 3, 31, 0, 0, 0, 0, berlin).UnixMilli()},
+		{"2024-03-30", 4, time.Date(2024, 3, 30, 0, 0, 0, 0, berlin).UnixMilli()},
+	}
+	if len(days) != len(want) {
+		t.Fatalf("boundaries %+v", days)
+	}
+	for i := range want {
+		if days[i] != want[i] {
+			t.Errorf("boundary %d = %+v, want %+v", i, days[i], want[i])
+		}
+	}
+	if d := days[0].Start - days[1].Start; d != (23 * time.Hour).Milliseconds() {
+		t.Errorf("the DST day is %s long", time.Duration(d)*time.Millisecond)
+	}
+	for i, m := range msgs {
+		if m.DayKey != m.DayBucket || m.DayKey == "" {
+			t.Errorf("message %d: day_key %q, day_bucket %q", i, m.DayKey, m.DayBucket)
+		}
+	}
+
+	// in UTC, 00:10 on the 1st in Berlin is still 22:10 on the 31st
+	msgs = page()
+	utc := tagDays(msgs, time.UTC, false)
+	if len(utc) != 2 || utc[0].DayKey != "2024-03-31" || utc[0].Index != 0 || utc[1].Index != 4 {
+		t.Fatalf("UTC boundaries %+v", utc)
+	}
+	for i, m := range msgs {
+		if m.DayKey != "" {
+			t.Errorf("message %d: day_key %q without a requested zone", i, m.DayKey)
+		}
+	}
+}
+
+func TestListMessagesDayKeys(t *testing.T) {
+	client, db := testDB(t)
+	ann := seedUser(t, db, "ann")
+	conv := seedConv(t, db, "ops", ann)
+	// 20:00 UTC is 01:30 the next day in Kolkata
+	ts := time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC).UnixMilli()
+	if _, err := db.Collection("messages").InsertOne(testCtx(t), Message{
+		ID: primitive.NewObjectID(), ConversationID: conv.ID, SenderID: ann.ID, Type: "text", Body: "hi", Ts: ts,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r, api := testAPI()
+	api.GET("/messages/:cid", ListMessagesHandler(client))
+	list := func(query, header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/messages/"+conv.ID.Hex()+query, nil)
+		req.Header.Set("Authorization", "Bearer "+tokenFor(t, ann))
+		if header != "" {
+			req.Header.Set("X-Timezone", header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	type page struct {
+		Timezone      string        `json:"timezone"`
+		DayBoundaries []dayBoundary `json:"day_boundaries"`
+		Messages      []Message     `json:"messages"`
+	}
+
+	w := list("", "")
+	var plain []Message
+	decode(t, w, &plain)
+	if len(plain) != 1 || plain[0].DayBucket != "2024-06-01" || plain[0].DayKey != "" {
+		t.Fatalf("no zone: %s", w.Body)
+	}
+
+	for _, tt := range []struct {
+		query, header, zone, day, fallback string
+	}{
+		{"", "Asia/Kolkata", "Asia/Kolkata", "2024-06-02", ""},
+		{"?tz=Asia/Kolkata", "Europe/Berlin", "Asia/Kolkata", "2024-06-02", ""},
+		{"", "Mars/Olympus", "UTC", "2024-06-01", "UTC"},
+	} {
+		w := list(tt.query, tt.header)
+		var p page
+		decode(t, w, &p)
+		if w.Code != http.StatusOK || p.Timezone != tt.zone || w.Header().Get("X-Timezone-Fallback") != tt.fallback {
+			t.Fatalf("%q, X-Timezone %q: %d %v %s", tt.query, tt.header, w.Code, w.Header(), w.Body)
+		}
+		if len(p.Messages) != 1 || p.Messages[0].DayKey != tt.day || len(p.DayBoundaries) != 1 || p.DayBoundaries[0].DayKey != tt.day {
+			t.Fatalf("%q, X-Timezone %q: %s", tt.query, tt.header, w.Body)
+		}
+	}
+
+	if w := list("?tz=Mars/Olympus", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown ?tz: %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Title suggestions for conversations still called "New Conversation" (or
+anything else). Members only; picking one is an ordinary PATCH
+/conversations/:cid with { "title": "<suggestion>" }.
+
+  members: the first members' names, "alice, bob & 3 others"
+  topic:   the most frequent words in the last titleSampleMsgs text
+           messages, stopwords and member names left out
+  month:   the creation month plus the top word, "March planning"
+
+Only words used at least titleMinWordCount times across the sample count
+as topics, so a one-off in a single message never surfaces, and expiring
+(burn after reading) messages are not sampled at all. Every candidate goes
+through cleanTitle; ones it rejects are dropped.
+*/
+
+const (
+	titleSampleMsgs    = 200
+	titleMinWordCount  = 2
+	titleTopicWords    = 3
+	titleMemberNames   = 3
+	titleMinWordLength = 3
+)
+
+var titleStopwords = func() map[string]struct{} {
+	m := make(map[string]struct{})
+	for _, w := range strings.Fields(`
+		the and for are but not you your yours all any can had has have her
+		his him she they them their theirs our ours out was were what when
+		where which who whom why how with this that these those there here
+		then than from into onto over under about after before just also
+		very too only some such more most much many own same other its it's
+		i'm i've i'll i'd you're you've you'll we're we've we'll they're
+		don't doesn't didn't can't won't isn't aren't wasn't weren't
+		will would shall should could might must may been being did does
+		doing done get got gets let lets like yes yeah yep nope okay lol
+		thanks thank please sure now today tomorrow yesterday one two
+		http https www com org net`) {
+		m[w] = struct{}{}
+	}
+	return m
+}()
+
+type titleSuggestion struct {
+	Kind  string `json:"kind"`
+	Title string `json:"title"`
+}
+
+// topicWords returns up to n of the most frequent words in bodies, each
+// used at least titleMinWordCount times, skipping stopwords and exclude.
+// Ties go alphabetically, so the result depends only on the input.
+func topicWords(bodies []string, exclude map[string]struct{}, n int) []string {
+	counts := make(map[string]int)
+	for _, b := range bodies {
+		words := strings.FieldsFunc(strings.ToLower(b), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+		})
+		for _, w := range words {
+			w = strings.Trim(w, "'")
+			if utf8.RuneCountInString(w) < titleMinWordLength || !strings.ContainsFunc(w, unicode.IsLetter) {
+				continue
+			}
+			if _, skip := titleStopwords[w]; skip {
+				continue
+			}
+			if _, skip := exclude[w]; skip {
+				continue
+			}
+			counts[w]++
+		}
+	}
+	words := make([]string, 0, len(counts))
+	for w, k := range counts {
+		if k >= titleMinWordCount {
+			words = append(words, w)
+		}
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if counts[words[i]] != counts[words[j]] {
+			return counts[words[i]] > counts[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	if len(words) > n {
+		words = words[:n]
+	}
+	return words
+}
+
+// memberNamesTitle is "alice, bob & 3 others" for names in join order.
+func memberNamesTitle(names []string, total int) string {
+	if len(names) == 0 {
+		return ""
+	}
+	s := strings.Join(names, ", ")
+	switch rest := total - len(names); {
+	case rest == 1:
+		s += " & 1 other"
+	case rest > 1:
+		s += fmt.Sprintf(" & %d others", rest)
+	}
+	return s
+}
+
+func capitalize(w string) string {
+	r, size := utf8.DecodeRuneInString(w)
+	return string(unicode.ToUpper(r)) + w[size:]
+}
+
+// recentBodies is the bodies of cid's last titleSampleMsgs visible,
+// non-expiring text messages.
+func recentBodies(ctx context.Context, db *mongo.Database, cid primitive.ObjectID) ([]string, error) {
+	filter := visible(bson.M{
+		"conversation_id": cid,
+		"type":            "text",
+		"expires_at_ms":   bson.M{"$exists": false},
+	})
+	cur, err := db.Collection("messages").Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "ts", Value: -1}}).
+		SetLimit(titleSampleMsgs).
+		SetProjection(bson.M{"body": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Body string `bson:"body"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	out := make([]string, len(rows))
+	for i, r := range rows {
+		out[i] = r.Body
+	}
+	return out, nil
+}
+
+// GET /conversations/:cid/title-suggestions (members only)
+// Returns: { current, suggestions: [{ kind: "members"|"topic"|"month", title }] }
+// Candidates equal to the current title, or to each other, are left out.
+func TitleSuggestionsHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		ok, err := isMember(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member"})
+			return
+		}
+
+		var conv Conversation
+		err = db.Collection("conversations").FindOne(ctx, bson.M{"_id": cid}).Decode(&conv)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "conversation not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		members, err := listMembers(ctx, db, &conv)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		ids := make([]primitive.ObjectID, len(members))
+		for i, m := range members {
+			ids[i] = m.UserID
+		}
+		names, err := usernamesByID(ctx, db, ids)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		bodies, err := recentBodies(ctx, db, cid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		// names are @mentioned all the time; they'd crowd out the topic
+		exclude := make(map[string]struct{}, len(names))
+		for _, n := range names {
+			exclude[strings.ToLower(n)] = struct{}{}
+		}
+		shown := make([]string, 0, titleMemberNames)
+		for _, id := range ids {
+			if n := names[id]; n != "" && len(shown) < titleMemberNames {
+				shown = append(shown, n)
+			}
+		}
+		topic := topicWords(bodies, exclude, titleTopicWords)
+
+		candidates := []titleSuggestion{{"members", memberNamesTitle(shown, len(members))}}
+		if len(topic) > 0 {
+			for i := range topic {
+				topic[i] = capitalize(topic[i])
+			}
+			candidates = append(candidates, titleSuggestion{"topic", strings.Join(topic, ", ")})
+		}
+		if conv.CreatedAt > 0 {
+			w := "chat"
+			if len(topic) > 0 {
+				w = strings.ToLower(topic[0])
+			}
+			month := time.UnixMilli(conv.CreatedAt).UTC().Month().String()
+			candidates = append(candidates, titleSuggestion{"month", month + " " + w})
+		}
+
+		out := []titleSuggestion{}
+		seen := map[string]bool{conv.Title: true}
+		for _, s := range candidates {
+			t, err := cleanTitle(s.Title)
+			if err != nil || seen[t] {
+				continue
+			}
+			seen[t] = true
+			out = append(out, titleSuggestion{s.Kind, t})
+		}
+		c.JSON(http.StatusOK, gin.H{"current": conv.Title, "suggestions": out})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTopicWordsDeterministic(t *testing.T) {
+	bodies := []string{
+		"Deploy the release tonight",
+		"release notes for the deploy?",
+		"@bob can you check the deploy",
+		"rollback plan if the release fails",
+		"ROLLBACK! it's 2024",
+		"bob bob bob",
+	}
+	exclude := map[string]struct{}{"bob": {}}
+	want := []string{"deploy", "release", "rollback"}
+	// every order of the same bodies gives the same words
+	for i := range bodies {
+		in := append(slices.Clone(bodies[i:]), bodies[:i]...)
+		slices.Reverse(in)
+		if got := topicWords(in, exclude, 3); !slices.Equal(got, want) {
+			t.Fatalf("rotation %d: %v, want %v", i, got, want)
+		}
+	}
+	// a tie at the cut goes to the alphabetically first word
+	if got := topicWords([]string{"zebra yak zebra yak"}, nil, 1); !slices.Equal(got, []string{"yak"}) {
+		t.Fatalf("tie: %v", got)
+	}
+	// once is not a topic
+	if got := topicWords([]string{"kubernetes upgrade"}, nil, 3); len(got) != 0 {
+		t.Fatalf("single use: %v", got)
+	}
+}
+
+func TestMemberNamesTitle(t *testing.T) {
+	for _, tt := range []struct {
+		names []string
+		total int
+		want  string
+	}{
+		{nil, 0, ""},
+		{[]string{"ann"}, 1, "ann"},
+		{[]string{"ann", "bob"}, 3, "ann, bob & 1 other"},
+		{[]string{"ann", "bob", "carol"}, 7, "ann, bob, carol & 4 others"},
+	} {
+		if got := memberNamesTitle(tt.names, tt.total); got != tt.want {
+			t.Errorf("memberNamesTitle(%v, %d) = %q, want %q", tt.names, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestTitleSuggestionsHandler(t *testing.T) {
+	client, db := testDB(t)
+	ann, bob := seedUser(t, db, "ann"), seedUser(t, db, "bob")
+	carol, dave := seedUser(t, db, "carol"), seedUser(t, db, "dave")
+	conv := seedConv(t, db, "New Conversation", ann, bob, carol, dave)
+	r, api := testAPI()
+	api.POST("/messages/:cid", SendMessageHandler(client))
+	api.GET("/conversations/:cid/title-suggestions", TitleSuggestionsHandler(client))
+	for _, b := range []string{
+		"deploy the release tonight",
+		"@bob release notes for the deploy?",
+		"rollback plan if the release fails, bob",
+		"rollback first, deploy later",
+	} {
+		if w := serve(t, r, http.MethodPost, "/messages/"+conv.ID.Hex(), &bob, gin.H{"body": b}); w.Code != http.StatusCreated {
+			t.Fatalf("send: %d %s", w.Code, w.Body)
+		}
+	}
+
+	month := time.UnixMilli(conv.CreatedAt).UTC().Month().String()
+	want := []titleSuggestion{
+		{"members", "ann, bob, carol & 1 other"},
+		{"topic", "Deploy, Release, Rollback"},
+		{"month", month + " deploy"},
+	}
+	for range 2 {
+		w := serve(t, r, http.MethodGet, "/conversations/"+conv.ID.Hex()+"/title-suggestions", &ann, nil)
+		var got struct {
+			Current     string            `json:"current"`
+			Suggestions []titleSuggestion `json:"suggestions"`
+		}
+		decode(t, w, &got)
+		if w.Code != http.StatusOK || got.Current != "New Conversation" || !slices.Equal(got.Suggestions, want) {
+			t.Fatalf("suggestions: %d %+v", w.Code, got)
+		}
+	}
+
+	outsider := seedUser(t, db, "eve")
+	if w := serve(t, r, http.MethodGet, "/conversations/"+conv.ID.Hex()+"/title-suggestions", &outsider, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("non-member: %d", w.Code)
+	}
+}
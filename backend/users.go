@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Username consistency contract:
+  - stored messages and previews (last_msg, pushes) keep only sender ids;
+    names are joined at read time, so a rename shows up on the next read
+    with no backfill
+  - ?include=senders on message lists returns a fresh { uid: username } map
+    for the page
+  - a rename publishes user.updated to every conversation the user is in so
+    live clients can refresh their uid -> name cache
+*/
+
+// usernamesByID looks up current display names; unknown ids are absent.
+func usernamesByID(ctx context.Context, db *mongo.Database, ids []primitive.ObjectID) (map[primitive.ObjectID]string, error) {
+	out := make(map[primitive.ObjectID]string, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	cur, err := db.Collection("users").Find(ctx,
+		bson.M{"_id": bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{"username": 1, "username_display": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var u User
+		if err := cur.Decode(&u); err != nil {
+			return nil, err
+		}
+		out[u.ID] = u.DisplayName()
+	}
+	return out, nil
+}
+
+// PATCH /me
+// Body: { "username": "new_name" }
+// Returns a fresh token, since the old one still carries the old name.
+// A case-only change ("echoratz" -> "EchoRatz") only updates the display
+// casing.
+func RenameUserHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		var in struct {
+			Username string `json:"username"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+			return
+		}
+		u := normalizeUsername(in.Username)
+		if err := validateUsername(u); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		display := strings.TrimSpace(in.Username)
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+		_ = ensureUserIndexes(ctx, db)
+
+		res, err := db.Collection("users").UpdateByID(ctx, uid, bson.M{"$set": bson.M{
+			"username":         u,
+			"username_display": display,
+		}})
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "username taken"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		// cached lists embed sender names
+		convCache.invalidateAll()
+
+		if err := publishUserUpdated(ctx, db, uid, display); err != nil {
+			// the rename itself succeeded; clients catch up on their next read
+			fmt.Println("user.updated broadcast error:", err)
+		}
+
+		tok, _ := signJWT(User{ID: uid, Username: u, Display: display}, 24*time.Hour)
+		c.JSON(http.StatusOK, gin.H{"token": tok, "user": gin.H{"id": uid.Hex(), "username": display}})
+	}
+}
+
+// publishUserUpdated tells every conversation uid belongs to about the new name.
+func publishUserUpdated(ctx context.Context, db *mongo.Database, uid primitive.ObjectID, username string) error {
+	filter, err := memberConvFilter(ctx, db, uid)
+	if err != nil {
+		return err
+	}
+	cids, err := db.Collection("conversations").Distinct(ctx, "_id", filter)
+	if err != nil {
+		return err
+	}
+	for _, v := range cids {
+		if cid, ok := v.(primitive.ObjectID); ok {
+			broadcaster.Publish(events.New(cid.Hex(), events.UserUpdated{UserID: uid.Hex(), Username: username}))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type renameReply struct {
+	Token string `json:"token"`
+	User  struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+func TestRenameUser(t *testing.T) {
+	client, db := testDB(t)
+	ann, bob := seedUser(t, db, "ann"), seedUser(t, db, "bob")
+	conv := seedConv(t, db, "ops", ann, bob)
+	r, api := testAPI()
+	api.PATCH("/me", RenameUserHandler(client))
+	api.POST("/messages/:cid", SendMessageHandler(client))
+	api.GET("/messages/:cid", ListMessagesHandler(client))
+	if w := serve(t, r, http.MethodPost, "/messages/"+conv.ID.Hex(), &ann, gin.H{"body": "hi"}); w.Code != http.StatusCreated {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+
+	for _, tt := range []struct {
+		name string
+		code int
+	}{
+		{"x", http.StatusBadRequest},
+		{"admin", http.StatusBadRequest},
+		{"BOB", http.StatusConflict},
+	} {
+		if w := serve(t, r, http.MethodPatch, "/me", &ann, gin.H{"username": tt.name}); w.Code != tt.code {
+			t.Errorf("rename to %q: %d %s, want %d", tt.name, w.Code, w.Body, tt.code)
+		}
+	}
+
+	ch := broadcaster.Subscribe(conv.ID)
+	defer broadcaster.Unsubscribe(conv.ID, ch)
+	w := serve(t, r, http.MethodPatch, "/me", &ann, gin.H{"username": "annie"})
+	var out renameReply
+	decode(t, w, &out)
+	if w.Code != http.StatusOK || out.User.ID != ann.ID.Hex() || out.User.Username != "annie" {
+		t.Fatalf("rename: %d %s", w.Code, w.Body)
+	}
+	claims, err := parseToken(out.Token)
+	if err != nil || claims.UserID != ann.ID.Hex() || claims.Username != "annie" {
+		t.Fatalf("new token: %+v, %v", claims, err)
+	}
+	select {
+	case e := <-ch:
+		p, ok := e.Payload.(events.UserUpdated)
+		if !ok || p.UserID != ann.ID.Hex() || p.Username != "annie" {
+			t.Fatalf("event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no user.updated")
+	}
+
+	// the stored message keeps only the id; the name is joined on read
+	var page struct {
+		Messages []Message         `json:"messages"`
+		Senders  map[string]string `json:"senders"`
+	}
+	decode(t, serve(t, r, http.MethodGet, "/messages/"+conv.ID.Hex()+"?include=senders", &bob, nil), &page)
+	if len(page.Messages) != 1 || page.Senders[ann.ID.Hex()] != "annie" {
+		t.Fatalf("senders %v", page.Senders)
+	}
+	if w := serve(t, r, http.MethodPatch, "/me", &bob, gin.H{"username": "ann"}); w.Code != http.StatusOK {
+		t.Fatalf("taking the freed name: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCaseOnlyRename(t *testing.T) {
+	client, db := testDB(t)
+	er, bob := seedUser(t, db, "echoratz"), seedUser(t, db, "bob")
+	r, api := testAPI()
+	api.PATCH("/me", RenameUserHandler(client))
+
+	w := serve(t, r, http.MethodPatch, "/me", &er, gin.H{"username": " EchoRatz "})
+	var out renameReply
+	decode(t, w, &out)
+	if w.Code != http.StatusOK || out.User.Username != "EchoRatz" {
+		t.Fatalf("case-only rename: %d %s", w.Code, w.Body)
+	}
+	claims, err := parseToken(out.Token)
+	if err != nil || claims.Username != "echoratz" || claims.Display != "EchoRatz" {
+		t.Fatalf("new token: %+v, %v", claims, err)
+	}
+	var stored User
+	if err := db.Collection("users").FindOne(testCtx(t), bson.M{"_id": er.ID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Username != "echoratz" || stored.DisplayName() != "EchoRatz" {
+		t.Fatalf("stored %q / %q", stored.Username, stored.DisplayName())
+	}
+	if w := serve(t, r, http.MethodPatch, "/me", &bob, gin.H{"username": "ECHORATZ"}); w.Code != http.StatusConflict {
+		t.Fatalf("taking another casing of a used name: %d, want 409", w.Code)
+	}
+}
+
+func TestMentionsMatchAnyCase(t *testing.T) {
+	client, db := testDB(t)
+	ann, er := seedUser(t, db, "ann"), seedUser(t, db, "echoratz")
+	seedUser(t, db, "eve")
+	if _, err := db.Collection("users").UpdateByID(testCtx(t), er.ID, bson.M{"$set": bson.M{"username_display": "EchoRatz"}}); err != nil {
+		t.Fatal(err)
+	}
+	conv := seedConv(t, db, "ops", ann, er)
+	r, api := testAPI()
+	api.POST("/messages/:cid", SendMessageHandler(client))
+	base := "/messages/" + conv.ID.Hex()
+
+	for _, in := range []gin.H{
+		{"body": "ping", "mentions": []string{"ECHORATZ"}},
+		{"body": "ping @EchoRatz"},
+		{"body": "ping @echoRATZ and @EchoRatz", "mentions": []string{"EchoRatz", "echoratz"}},
+	} {
+		w := serve(t, r, http.MethodPost, base, &ann, in)
+		var m Message
+		decode(t, w, &m)
+		if w.Code != http.StatusCreated || len(m.Mentions) != 1 || m.Mentions[0] != er.ID {
+			t.Errorf("%v: %d, mentions %v", in, w.Code, m.Mentions)
+		}
+	}
+
+	// eve isn't in the conversation; the error is keyed by the normalized name
+	w := serve(t, r, http.MethodPost, base, &ann, gin.H{"body": "hi", "mentions": []string{"EVE"}})
+	var out struct {
+		Errors struct {
+			Mentions map[string]string `json:"mentions"`
+		} `json:"errors"`
+	}
+	decode(t, w, &out)
+	if w.Code != http.StatusBadRequest || out.Errors.Mentions["eve"] != "not a member" {
+		t.Fatalf("mention of a non-member: %d %s", w.Code, w.Body)
+	}
+	if w := serve(t, r, http.MethodPost, base, &ann, gin.H{"body": "hi @EVE"}); w.Code != http.StatusCreated {
+		t.Fatalf("body mention of a non-member: %d %s", w.Code, w.Body)
+	}
+}
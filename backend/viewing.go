@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"backend/events"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+"Is viewing" state per conversation, from client ops on /ws/:cid:
+  {"op":"focus"}      the conversation is on screen (also a heartbeat)
+  {"op":"heartbeat"}  still focused; ignored on unfocused sockets
+  {"op":"blur"}       no longer on screen
+A focused socket that sends nothing for viewingLapse is blurred, as is
+one that disconnects. A user is viewing while any of their sockets is
+focused (multi-device). Changes are published as conversation.viewing
+with the whole set, at most once per viewingDebounce per conversation.
+Compliance watchers' sockets are never counted.
+*/
+
+const (
+	viewingLapse    = 60 * time.Second
+	viewingDebounce = time.Second
+)
+
+type viewingTracker struct {
+	mu sync.Mutex
+	// focused sockets per conversation -> last focus/heartbeat
+	rooms map[primitive.ObjectID]map[*wsClient]time.Time
+}
+
+var viewing = &viewingTracker{rooms: make(map[primitive.ObjectID]map[*wsClient]time.Time)}
+
+func (v *viewingTracker) focus(cl *wsClient) {
+	v.update(cl, func(m map[*wsClient]time.Time) { m[cl] = jobs.Now() })
+}
+
+func (v *viewingTracker) heartbeat(cl *wsClient) {
+	v.update(cl, func(m map[*wsClient]time.Time) {
+		if _, ok := m[cl]; ok {
+			m[cl] = jobs.Now()
+		}
+	})
+}
+
+func (v *viewingTracker) blur(cl *wsClient) {
+	v.update(cl, func(m map[*wsClient]time.Time) { delete(m, cl) })
+}
+
+// update applies fn to cl's room and schedules an event if the set of
+// viewing users changed.
+func (v *viewingTracker) update(cl *wsClient, fn func(map[*wsClient]time.Time)) {
+	v.mu.Lock()
+	m := v.rooms[cl.cid]
+	if m == nil {
+		m = make(map[*wsClient]time.Time)
+		v.rooms[cl.cid] = m
+	}
+	before := viewingUsers(m)
+	fn(m)
+	after := viewingUsers(m)
+	if len(m) == 0 {
+		delete(v.rooms, cl.cid)
+	}
+	v.mu.Unlock()
+	if !sameUsers(before, after) {
+		v.schedule(cl.cid)
+	}
+}
+
+// users returns the uids viewing cid, sorted.
+func (v *viewingTracker) users(cid primitive.ObjectID) []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return viewingUsers(v.rooms[cid])
+}
+
+func viewingUsers(m map[*wsClient]time.Time) []string {
+	seen := make(map[primitive.ObjectID]bool, len(m))
+	out := []string{}
+	for cl := range m {
+		if !seen[cl.uid] {
+			seen[cl.uid] = true
+			out = append(out, cl.uid.Hex())
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func sameUsers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// schedule publishes cid's current set once things settle; a newer change
+// replaces the pending job (jobs.go), so bursts collapse to one event.
+func (v *viewingTracker) schedule(cid primitive.ObjectID) {
+	jobs.Schedule("viewing:"+cid.Hex(), viewingDebounce, func() {
+		broadcaster.Publish(events.New(cid.Hex(), events.ConversationViewing{UserIDs: v.users(cid)}))
+	})
+}
+
+// expireLoop runs expire every quarter lapse; run once from main.
+func (v *viewingTracker) expireLoop() {
+	for range time.Tick(viewingLapse / 4) {
+		v.expire()
+	}
+}
+
+// expire blurs sockets whose heartbeat lapsed.
+func (v *viewingTracker) expire() {
+	cutoff := jobs.Now().Add(-viewingLapse)
+	var lapsed []*wsClient
+	v.mu.Lock()
+	for _, m := range v.rooms {
+		for cl, at := range m {
+			if at.Before(cutoff) {
+				lapsed = append(lapsed, cl)
+			}
+		}
+	}
+	v.mu.Unlock()
+	for _, cl := range lapsed {
+		v.blur(cl)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"backend/events"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestViewingHeartbeatsAndDevices(t *testing.T) {
+	clk := useJobClock(t)
+	v := &viewingTracker{rooms: make(map[primitive.ObjectID]map[*wsClient]time.Time)}
+	cid := primitive.NewObjectID()
+	ann, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	phone, laptop := &wsClient{uid: ann, cid: cid}, &wsClient{uid: ann, cid: cid}
+	desk := &wsClient{uid: bob, cid: cid}
+	ch := broadcaster.Subscribe(cid)
+	defer broadcaster.Unsubscribe(cid, ch)
+
+	hexes := func(uids []primitive.ObjectID) []string {
+		out := []string{}
+		for _, u := range uids {
+			out = append(out, u.Hex())
+		}
+		slices.Sort(out)
+		return out
+	}
+	check := func(step string, want ...primitive.ObjectID) {
+		t.Helper()
+		if got := v.users(cid); !slices.Equal(got, hexes(want)) {
+			t.Fatalf("%s: viewing %v, want %v", step, got, hexes(want))
+		}
+	}
+	published := func(step string, want ...primitive.ObjectID) {
+		t.Helper()
+		clk.Advance(viewingDebounce)
+		select {
+		case e := <-ch:
+			p, ok := e.Payload.(events.ConversationViewing)
+			if !ok || !slices.Equal(p.UserIDs, hexes(want)) {
+				t.Fatalf("%s: event %+v", step, e)
+			}
+		default:
+			t.Fatalf("%s: no conversation.viewing", step)
+		}
+	}
+
+	v.heartbeat(desk) // not focused: ignored
+	check("heartbeat before focus")
+	v.focus(phone)
+	v.focus(laptop)
+	v.focus(desk)
+	check("all focused", ann, bob)
+	published("all focused", ann, bob)
+
+	// one device blurring leaves ann viewing on the other, with no event
+	v.blur(phone)
+	check("phone blurred", ann, bob)
+	clk.Advance(viewingDebounce)
+	select {
+	case e := <-ch:
+		t.Fatalf("event for an unchanged set: %+v", e)
+	default:
+	}
+
+	// the laptop keeps beating; bob's desk goes quiet
+	clk.Advance(viewingLapse / 2)
+	v.heartbeat(laptop)
+	clk.Advance(viewingLapse/2 + time.Second)
+	v.expire()
+	check("desk lapsed", ann)
+	published("desk lapsed", ann)
+
+	clk.Advance(viewingLapse)
+	v.expire()
+	check("laptop lapsed")
+	published("laptop lapsed")
+	if len(v.rooms) != 0 {
+		t.Fatalf("%d rooms left", len(v.rooms))
+	}
+}
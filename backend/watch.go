@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Schema:
+  watchers (compliance read access without membership):
+    - conversation_id (ObjectId)
+    - user_id         (ObjectId)
+    - username        (string, at grant time)
+    - granted_by      (ObjectId, the admin)
+    - ts              (int64, millis)
+  unique index on (conversation_id, user_id)
+
+A watcher passes canRead (messages, conversation info, members, WS) but
+never isMember, so sends, reactions, receipts and prefs stay closed to them.
+Owners see the watcher list on GET /conversations/:cid; every watched read
+is written to audit_log as "watch.read".
+*/
+
+type Watcher struct {
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	UserID         primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Username       string             `bson:"username" json:"username"`
+	GrantedBy      primitive.ObjectID `bson:"granted_by" json:"granted_by"`
+	Ts             int64              `bson:"ts" json:"ts"`
+}
+
+func ensureWatcherIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("watchers").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "conversation_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func isWatcher(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID) (bool, error) {
+	n, err := db.Collection("watchers").CountDocuments(ctx,
+		bson.M{"conversation_id": cid, "user_id": uid},
+		options.Count().SetLimit(1),
+	)
+	return n > 0, err
+}
+
+// canRead is isMember for read-only endpoints: watchers pass too, and each
+// such read is audited under what (e.g. "messages.list").
+func canRead(ctx context.Context, db *mongo.Database, cid, uid primitive.ObjectID, what string) (bool, error) {
+	ok, err := isMember(ctx, db, cid, uid)
+	if err != nil || ok {
+		return ok, err
+	}
+	ok, err = isWatcher(ctx, db, cid, uid)
+	if err != nil || !ok {
+		return false, err
+	}
+	writeAudit(ctx, db, AuditEntry{
+		Action:         "watch.read",
+		ActorID:        uid,
+		ConversationID: cid,
+		Details:        gin.H{"what": what},
+	})
+	return true, nil
+}
+
+func listWatchers(ctx context.Context, db *mongo.Database, cid primitive.ObjectID) ([]Watcher, error) {
+	cur, err := db.Collection("watchers").Find(ctx,
+		bson.M{"conversation_id": cid},
+		options.Find().SetSort(bson.D{{Key: "ts", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	out := []Watcher{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// POST /admin/watch/:cid (admin only)
+// Body: { "username": "compliance1" }
+func AddWatcherHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		var in struct {
+			Username string `json:"username"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil || in.Username == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username required"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		n, err := db.Collection("conversations").CountDocuments(ctx, bson.M{"_id": cid})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "conversation not found"})
+			return
+		}
+		in.Username = normalizeUsername(in.Username)
+		ids, err := userIDsByName(ctx, db, []string{in.Username})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		wid, ok := ids[in.Username]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+
+		if err := ensureWatcherIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
+			return
+		}
+		w := Watcher{
+			ConversationID: cid,
+			UserID:         wid,
+			Username:       in.Username,
+			GrantedBy:      uid,
+			Ts:             time.Now().UnixMilli(),
+		}
+		if _, err := db.Collection("watchers").InsertOne(ctx, w); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				c.JSON(http.StatusConflict, gin.H{"error": "already watching"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		writeAudit(ctx, db, AuditEntry{
+			Action:         "watch.granted",
+			ActorID:        uid,
+			ConversationID: cid,
+			Details:        gin.H{"user_id": wid.Hex(), "username": in.Username},
+		})
+		c.JSON(http.StatusCreated, w)
+	}
+}
+
+// DELETE /admin/watch/:cid/:uid (admin only)
+// Revokes access and closes the watcher's open sockets on the conversation.
+func RemoveWatcherHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		wid, err := mustOID(c.Param("uid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		var w Watcher
+		err = db.Collection("watchers").FindOneAndDelete(ctx,
+			bson.M{"conversation_id": cid, "user_id": wid}).Decode(&w)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not watching"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		// a watcher who is also a member keeps their member sockets
+		member, err := isMember(ctx, db, cid, wid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		closed := 0
+		if !member {
+			closed = broadcaster.CloseUserInRoom(wid, cid)
+		}
+		writeAudit(ctx, db, AuditEntry{
+			Action:         "watch.revoked",
+			ActorID:        uid,
+			ConversationID: cid,
+			Details:        gin.H{"user_id": wid.Hex(), "username": w.Username},
+		})
+		c.JSON(http.StatusOK, gin.H{"ok": true, "closed_sockets": closed})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestWatcherReadOnly grants a compliance user watch access and checks
+// that reads open up, audited, while every write stays closed.
+func TestWatcherReadOnly(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "boss")
+	client, db := testDB(t)
+	boss, ann, wendy := seedUser(t, db, "boss"), seedUser(t, db, "ann"), seedUser(t, db, "wendy")
+	conv := seedConv(t, db, "ops", ann)
+	cid := conv.ID.Hex()
+	r, api := testAPI()
+	api.GET("/conversations/:cid", GetConverHandler(client))
+	api.GET("/conversations/:cid/members", ListMembersHandler(client))
+	api.POST("/conversations/:cid/read", MarkReadHandler(client))
+	api.PUT("/conversations/:cid/mute", SetMuteHandler(client))
+	api.POST("/messages/:cid", SendMessageHandler(client))
+	api.GET("/messages/:cid", ListMessagesHandler(client))
+	api.GET("/messages/:cid/:mid", GetMessageHandler(client))
+	api.PUT("/messages/:cid/:mid/reactions/:emoji", AddReactionHandler(client))
+	admin := r.Group("/admin", AuthRequired(), AdminRequired())
+	admin.POST("/watch/:cid", AddWatcherHandler(client))
+	admin.DELETE("/watch/:cid/:uid", RemoveWatcherHandler(client))
+
+	w := serve(t, r, http.MethodPost, "/messages/"+cid, &ann, gin.H{"body": "hi"})
+	var m Message
+	decode(t, w, &m)
+	mid := m.ID.Hex()
+
+	reads := []string{"/conversations/" + cid, "/conversations/" + cid + "/members", "/messages/" + cid, "/messages/" + cid + "/" + mid}
+	writes := []struct{ method, path string }{
+		{http.MethodPost, "/messages/" + cid},
+		{http.MethodPut, "/messages/" + cid + "/" + mid + "/reactions/👍"},
+		{http.MethodPost, "/conversations/" + cid + "/read"},
+		{http.MethodPut, "/conversations/" + cid + "/mute"},
+	}
+	bodies := map[string]any{
+		"/messages/" + cid:                gin.H{"body": "watching"},
+		"/conversations/" + cid + "/read": gin.H{"up_to_message": mid},
+		"/conversations/" + cid + "/mute": gin.H{"muted": true},
+	}
+	readAs := func(step string, want int) {
+		t.Helper()
+		for _, p := range reads {
+			if w := serve(t, r, http.MethodGet, p, &wendy, nil); w.Code != want {
+				t.Errorf("%s: GET %s: %d %s, want %d", step, p, w.Code, w.Body, want)
+			}
+		}
+	}
+
+	readAs("before the grant", http.StatusForbidden)
+	if w := serve(t, r, http.MethodPost, "/admin/watch/"+cid, &ann, gin.H{"username": "wendy"}); w.Code != http.StatusForbidden {
+		t.Fatalf("grant by a non-admin: %d", w.Code)
+	}
+	if w := serve(t, r, http.MethodPost, "/admin/watch/"+cid, &boss, gin.H{"username": "Wendy"}); w.Code != http.StatusCreated {
+		t.Fatalf("grant: %d %s", w.Code, w.Body)
+	}
+	if w := serve(t, r, http.MethodPost, "/admin/watch/"+cid, &boss, gin.H{"username": "wendy"}); w.Code != http.StatusConflict {
+		t.Fatalf("second grant: %d, want 409", w.Code)
+	}
+
+	readAs("watching", http.StatusOK)
+	if n := countDocs(t, db, "audit_log", bson.M{"action": "watch.read", "actor_id": wendy.ID}); n != int64(len(reads)) {
+		t.Errorf("%d watch.read entries for %d reads", n, len(reads))
+	}
+	for _, wr := range writes {
+		if w := serve(t, r, wr.method, wr.path, &wendy, bodies[wr.path]); w.Code != http.StatusForbidden {
+			t.Errorf("watching: %s %s: %d %s, want 403", wr.method, wr.path, w.Code, w.Body)
+		}
+	}
+	if n := countDocs(t, db, "messages", bson.M{}); n != 1 {
+		t.Errorf("%d messages after the watcher's send", n)
+	}
+
+	if w := serve(t, r, http.MethodDelete, "/admin/watch/"+cid+"/"+wendy.ID.Hex(), &boss, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body)
+	}
+	readAs("after the revoke", http.StatusForbidden)
+}
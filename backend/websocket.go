@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"backend/events"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
@@ -16,6 +21,25 @@ import (
 /*
 Events pushed to clients:
 
+Every published event carries an "event_id", assigned once in Publish (or
+PublishToUser) and shared by every socket, feed and bridge that receives
+that event. A client holding more than one connection that can see the same
+conversation must drop any event_id it has already handled; the server does
+not suppress duplicates across connections. hello has no event_id.
+
+Every event also has "schema_version" (events.SchemaVersion, now 1); it
+goes up only when a payload field is renamed, removed or retyped. Payload
+shapes are the structs in package events, one per type.
+
+hello (first frame after connect):
+{
+  "type": "hello",
+  "conversation_id": "<cid>",
+  "payload": { "version": "dev", "features": { "widgets": true, ... },
+               "resume_token": "<token>", "resumed": false }
+}
+Every other frame has a "stream_seq" for resuming (ws_resume.go).
+
 message.created:
 {
   "type": "message.created",
@@ -25,9 +49,36 @@ message.created:
     "sender_id": "<uid>",
     "type": "text",
     "body": "...",
-    "ts": 1712345678901
+    "ts": 1712345678901,
+    "server_time": 1712345678905,
+    "expires_at": 1712345738901,  (only for expiring messages)
+    "reply_to": "<msgId>",        (only for replies)
+    "mentions": ["<uid>", ...],   (only when someone is mentioned)
+    "priority": "high",           (only on high-priority messages, see priority.go)
+    "sticker_id": "<id>",         (only on "sticker" messages; body is its name)
+    "width": 1280, "height": 720, (only on "image" messages; body is the upload
+                                   id, GET /files/:id, see images.go)
+    "forwarded_from": { "conversation_id": "<cid>", "message_id": "<msgId>" },
+                                  (only on copies made by a thread split)
+    "split_to": "<cid>",          (only on the "system" message a split leaves)
+    "card": { "week": "2026-W41", ... },
+                                  (only on "summary" messages, see weeklysummary.go)
+    "truncated": true,            (body is a preview of a large message, see
+    "body_len": 15000              blobs.go; GET /messages/:cid/:mid/body)
   }
 }
+(bodies over EVENT_BODY_INLINE_MAX bytes are omitted; the payload then has
+"truncated": true and "body_len", and clients GET /messages/:cid/:mid)
+
+message.updated (the sender edited a text message):
+{
+  "type": "message.updated",
+  "conversation_id": "<cid>",
+  "payload": { "id": "<msgId>", "body": "...", "edited_at": 1712345699000 }
+}
+(large or over EVENT_BODY_INLINE_MAX bodies get truncated/body_len as in
+message.created). Also sent for an image message whose file later fails
+its malware scan, with "attachment_infected": true (scanner.go).
 
 receipt.updated:
 {
@@ -38,29 +89,176 @@ receipt.updated:
     "last_read_ts": 1712345678901
   }
 }
+
+receipt.delivered:
+{
+  "type": "receipt.delivered",
+  "conversation_id": "<cid>",
+  "payload": {
+    "user_id": "<uid>",
+    "last_delivered_ts": 1712345678901
+  }
+}
+
+pins.updated:
+{
+  "type": "pins.updated",
+  "conversation_id": "<cid>",
+  "payload": {
+    "pins": [{ "message_id": "<msgId>", "pinned_by": "<uid>", "pinned_at": 1712345678901 }]
+  }
+}
+
+message.pinned / message.unpinned (just the change; pins.updated follows
+with the whole list):
+{
+  "type": "message.pinned",
+  "conversation_id": "<cid>",
+  "payload": { "message_id": "<msgId>", "pinned_by": "<uid>", "pinned_at": 1712345678901 }
+}
+{
+  "type": "message.unpinned",
+  "conversation_id": "<cid>",
+  "payload": { "message_id": "<msgId>", "unpinned_by": "<uid>" }
+}
+
+conversation.updated:
+{
+  "type": "conversation.updated",
+  "conversation_id": "<cid>",
+  "payload": { "title": "..." }   (only the fields that changed: title,
+                                   description, avatar_url; or
+                                   { "receipts_enabled": false }, { "slow_mode_secs": 30 },
+                                   { "weekly_summary": true })
+}
+(the flood breaker sends { "flood_guard": { "active": true, "mode": "slow",
+"until": 1712345738901, "slow_mode_secs": 10 } } when it trips and
+{ "flood_guard": { "active": false } } when it resets)
+(receipt.updated / receipt.delivered are not sent for conversations with
+receipts_enabled = false)
+
+maintenance (sent to every open socket when read-only mode flips):
+{
+  "type": "maintenance",
+  "conversation_id": "<cid>",
+  "payload": { "enabled": true, "message": "..." }
+}
+
+reaction.added / reaction.removed:
+{
+  "type": "reaction.added",
+  "conversation_id": "<cid>",
+  "payload": { "message_id": "<msgId>", "user_id": "<uid>", "emoji": "👍" }
+}
+
+reaction (only to the message author's sockets, when the reactor asked to
+notify and the author hasn't muted or snoozed the conversation):
+same payload as reaction.added
+
+user.updated (a member changed their username):
+{
+  "type": "user.updated",
+  "conversation_id": "<cid>",
+  "payload": { "user_id": "<uid>", "username": "new_name" }
+}
+
+message.deleted (sender or owner deleted one message; show a tombstone):
+{
+  "type": "message.deleted",
+  "conversation_id": "<cid>",
+  "payload": { "id": "<msgId>" }
+}
+
+messages.purged:
+{
+  "type": "messages.purged",
+  "conversation_id": "<cid>",
+  "payload": { "ids": ["<msgId>", ...] }
+}
+
+messages.imported (history was bulk-loaded; refetch the timeline):
+{
+  "type": "messages.imported",
+  "conversation_id": "<cid>",
+  "payload": { "count": 250 }
+}
+
+member.added / member.removed:
+{
+  "type": "member.added",
+  "conversation_id": "<cid>",
+  "payload": { "user_id": "<uid>", "role": "member" }
+}
+The removed user's own sockets on the room don't get member.removed; they
+are closed with code 4002 ("removed from conversation") and should not
+reconnect. Leaving the conversation closes them the same way, and ends
+the conversation's part of their gRPC SubscribeEvents streams (grpc.go).
+
+conversation.viewing (who has the conversation on screen, see viewing.go;
+clients report it with {"op":"focus"} / {"op":"heartbeat"} / {"op":"blur"}):
+{
+  "type": "conversation.viewing",
+  "conversation_id": "<cid>",
+  "payload": { "user_ids": ["<uid>", ...] }
+}
+
+batch (only on sockets opened with ?batch_ms=N, 1..WS_BATCH_MAX_MS):
+{
+  "type": "batch",
+  "conversation_id": "<cid>",
+  "payload": { "events": [ <event>, <event>, ... ] }
+}
+An event arriving after a quiet spell of at least N ms is sent on its own
+at once; events that follow within N ms of the last frame are held and sent
+together (one batch frame, in order) when the window closes. A window that
+collects a single event sends it as a plain frame.
 */
 
-type Event struct {
-	Type           string      `json:"type"`
-	ConversationID string      `json:"conversation_id"`
-	Payload        interface{} `json:"payload,omitempty"`
+// Event is the wire envelope; payload types live in package events.
+type Event = events.Event
+
+// envelope is what travels through a socket's send channel: the event plus
+// when it was published, for the delivery lag metric (metrics.go).
+type envelope struct {
+	Event
+	at time.Time
 }
 
 type wsClient struct {
 	conn *websocket.Conn
-	send chan Event
+	send chan envelope
 	uid  primitive.ObjectID
 	cid  primitive.ObjectID
+	// coalescing window for batch frames; 0 = one frame per event
+	batch time.Duration
+	// compliance watcher socket: never counted as viewing
+	readOnly bool
+	// resume state, kept past the socket (ws_resume.go)
+	sess *wsSession
 }
 
+// most events held for one batch frame; a full batch is sent early
+const wsMaxBatch = 100
+
 type Broadcaster struct {
 	mu    sync.RWMutex
 	rooms map[primitive.ObjectID]map[*wsClient]struct{}
+	// non-websocket listeners (SSE feeds etc.), each with the user it
+	// streams to; NilObjectID for anonymous ones like widget feeds
+	feeds map[primitive.ObjectID]map[chan Event]primitive.ObjectID
+	// in-process hooks run on every Publish (cache invalidation etc.)
+	taps []func(Event)
+	// resume sessions by token, and those without a socket by conversation
+	sessions map[string]*wsSession
+	detached map[primitive.ObjectID]map[*wsSession]struct{}
 }
 
 func NewBroadcaster() *Broadcaster {
 	return &Broadcaster{
-		rooms: make(map[primitive.ObjectID]map[*wsClient]struct{}),
+		rooms:    make(map[primitive.ObjectID]map[*wsClient]struct{}),
+		feeds:    make(map[primitive.ObjectID]map[chan Event]primitive.ObjectID),
+		sessions: make(map[string]*wsSession),
+		detached: make(map[primitive.ObjectID]map[*wsSession]struct{}),
 	}
 }
 
@@ -71,6 +269,10 @@ func (b *Broadcaster) Join(c *wsClient) {
 		b.rooms[c.cid] = make(map[*wsClient]struct{})
 	}
 	b.rooms[c.cid][c] = struct{}{}
+	if c.sess != nil {
+		c.sess.client = c
+		b.sessions[c.sess.token] = c.sess
+	}
 }
 
 func (b *Broadcaster) Leave(c *wsClient) {
@@ -82,6 +284,106 @@ func (b *Broadcaster) Leave(c *wsClient) {
 			delete(b.rooms, c.cid)
 		}
 	}
+	b.detachLocked(c)
+}
+
+// ConnectedUsers returns the uids with at least one open socket on cid.
+func (b *Broadcaster) ConnectedUsers(cid primitive.ObjectID) map[primitive.ObjectID]struct{} {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := make(map[primitive.ObjectID]struct{}, len(b.rooms[cid]))
+	for cl := range b.rooms[cid] {
+		out[cl.uid] = struct{}{}
+	}
+	return out
+}
+
+// wsCloseRemoved is the close code for a socket whose user lost access to
+// the room (removed, left, or watch revoked).
+const wsCloseRemoved = 4002
+
+// CloseUserInRoom closes uid's open sockets on cid with wsCloseRemoved, ends
+// their resume sessions, closes uid's feeds on cid (SubscribeAs) and returns
+// how many sockets it closed. Call it after the access change is written, so
+// a reconnect is refused at upgrade.
+func (b *Broadcaster) CloseUserInRoom(uid, cid primitive.ObjectID) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for cl := range b.rooms[cid] {
+		if cl.uid != uid {
+			continue
+		}
+		if cl.sess != nil {
+			cl.sess.client = nil // not resumable
+			b.dropSessionLocked(cl.sess)
+		}
+		go func(ws *websocket.Conn) {
+			_ = ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(wsCloseRemoved, "removed from conversation"), time.Now().Add(time.Second))
+			ws.Close()
+		}(cl.conn)
+		delete(b.rooms[cid], cl)
+		n++
+	}
+	if len(b.rooms[cid]) == 0 {
+		delete(b.rooms, cid)
+	}
+	for s := range b.detached[cid] {
+		if s.uid == uid {
+			b.dropSessionLocked(s)
+		}
+	}
+	// closed under the write lock, so Publish never sends on them
+	for ch, owner := range b.feeds[cid] {
+		if owner == uid {
+			delete(b.feeds[cid], ch)
+			close(ch)
+		}
+	}
+	if len(b.feeds[cid]) == 0 {
+		delete(b.feeds, cid)
+	}
+	return n
+}
+
+// Subscribe registers a plain channel listener for a conversation.
+// Events are dropped (not blocked on) when the channel is full.
+func (b *Broadcaster) Subscribe(cid primitive.ObjectID) chan Event {
+	return b.SubscribeAs(primitive.NilObjectID, cid)
+}
+
+// SubscribeAs is Subscribe for a feed streaming to uid. CloseUserInRoom
+// closes the channel when uid loses access to cid; readers must treat a
+// closed channel as the end of the feed.
+func (b *Broadcaster) SubscribeAs(uid, cid primitive.ObjectID) chan Event {
+	ch := make(chan Event, 32)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.feeds[cid]; !ok {
+		b.feeds[cid] = make(map[chan Event]primitive.ObjectID)
+	}
+	b.feeds[cid][ch] = uid
+	return ch
+}
+
+func (b *Broadcaster) Unsubscribe(cid primitive.ObjectID, ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if m, ok := b.feeds[cid]; ok {
+		delete(m, ch)
+		if len(m) == 0 {
+			delete(b.feeds, cid)
+		}
+	}
+}
+
+// Tap registers fn to run synchronously on every Publish. fn must be quick
+// and must not call back into the Broadcaster.
+func (b *Broadcaster) Tap(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.taps = append(b.taps, fn)
 }
 
 func (b *Broadcaster) Publish(e Event) {
@@ -89,13 +391,19 @@ func (b *Broadcaster) Publish(e Event) {
 	if err != nil {
 		return
 	}
+	if e.ID == "" {
+		e.ID = primitive.NewObjectID().Hex()
+	}
 	b.mu.RLock()
 	defer b.mu.RUnlock()
+	for _, fn := range b.taps {
+		fn(e)
+	}
+	env := envelope{Event: e, at: time.Now()}
 	m := b.rooms[cid]
 	for cl := range m {
-		select {
-		case cl.send <- e:
-		default:
+		if !cl.enqueue(env) {
+			wsMetrics.dropped.Add(1)
 			// client buffer full : drop connection
 			go func(cl *wsClient) {
 				cl.conn.Close()
@@ -103,6 +411,95 @@ func (b *Broadcaster) Publish(e Event) {
 			delete(m, cl)
 		}
 	}
+	for s := range b.detached[cid] {
+		s.mu.Lock()
+		s.record(e)
+		s.mu.Unlock()
+	}
+	for ch := range b.feeds[cid] {
+		select {
+		case ch <- e:
+		default:
+			wsMetrics.dropped.Add(1)
+		}
+	}
+}
+
+// PublishAll sends e to every open room (websockets and feeds), stamping
+// each copy with that room's conversation id.
+func (b *Broadcaster) PublishAll(e Event) {
+	b.mu.RLock()
+	cids := make([]primitive.ObjectID, 0, len(b.rooms)+len(b.feeds))
+	for cid := range b.rooms {
+		cids = append(cids, cid)
+	}
+	for cid := range b.feeds {
+		if _, ok := b.rooms[cid]; !ok {
+			cids = append(cids, cid)
+		}
+	}
+	b.mu.RUnlock()
+	for _, cid := range cids {
+		e.ConversationID = cid.Hex()
+		b.Publish(e)
+	}
+}
+
+// PublishToUser sends e only to uid's open sockets, in whichever rooms they
+// are. Returns how many sockets it reached.
+func (b *Broadcaster) PublishToUser(uid primitive.ObjectID, e Event) int {
+	if e.ID == "" {
+		e.ID = primitive.NewObjectID().Hex()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	env := envelope{Event: e, at: time.Now()}
+	n := 0
+	for _, m := range b.rooms {
+		for cl := range m {
+			if cl.uid != uid {
+				continue
+			}
+			if cl.enqueue(env) {
+				n++
+			} else {
+				wsMetrics.dropped.Add(1)
+			}
+		}
+	}
+	for _, m := range b.detached {
+		for s := range m {
+			if s.uid == uid {
+				s.mu.Lock()
+				s.record(e)
+				s.mu.Unlock()
+			}
+		}
+	}
+	return n
+}
+
+// writeFrames sends envs as one frame: the event itself when there is one,
+// otherwise a batch frame.
+func (cl *wsClient) writeFrames(envs []envelope) error {
+	cl.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	var err error
+	if len(envs) == 1 {
+		err = cl.conn.WriteJSON(envs[0].Event)
+	} else {
+		batch := make([]Event, len(envs))
+		for i, env := range envs {
+			batch[i] = env.Event
+		}
+		err = cl.conn.WriteJSON(events.New(cl.cid.Hex(), events.Batch{Events: batch}))
+	}
+	if err != nil {
+		return err
+	}
+	for _, env := range envs {
+		observeDelivery(env, cl.cid, cl.uid)
+	}
+	return nil
 }
 
 // glocal broadcaster
@@ -123,15 +520,7 @@ func parseBearer(c *gin.Context) (*Claims, error) {
 	if len(h) < 8 || h[:7] != "Bearer " {
 		return nil, jwt.ErrTokenMalformed
 	}
-	tok := h[7:]
-	var claims Claims
-	_, err := jwt.ParseWithClaims(tok, &claims, func(t *jwt.Token) (interface{}, error) {
-		return jwtSecret(), nil
-	})
-	if err != nil {
-		return nil, err
-	}
-	return &claims, nil
+	return parseToken(h[7:])
 }
 
 // add near parseBearer:
@@ -145,20 +534,17 @@ func parseBearerOrQuery(c *gin.Context) (*Claims, error) {
 	if tok == "" {
 		return nil, jwt.ErrTokenMalformed
 	}
-	var claims Claims
-	_, err := jwt.ParseWithClaims(tok, &claims, func(t *jwt.Token) (interface{}, error) {
-		return jwtSecret(), nil
-	})
-	if err != nil {
-		return nil, err
-	}
-	return &claims, nil
+	return parseToken(tok)
 }
 
-// GET /ws/:cid (Authorization: Bearer <token>)
+// GET /ws/:cid[?batch_ms=50] (Authorization: Bearer <token>)
+// GET /ws/:cid?resume=<token>&after=<stream_seq> (ws_resume.go)
 // Upgrades to WebSocket if the user is a member of conversation
 func WSHandler(client *mongo.Client) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if tok := c.Query("resume"); tok != "" && resumeWS(c, client, tok) {
+			return
+		}
 		claims, err := parseBearerOrQuery(c)
 		if err != nil {
 			c.AbortWithStatus(http.StatusUnauthorized)
@@ -169,18 +555,43 @@ func WSHandler(client *mongo.Client) gin.HandlerFunc {
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
+		// the handshake counts toward the uid's in-flight cap; the open
+		// socket doesn't (inflight.go)
+		if !apiInFlight.acquire(claims.UserID) {
+			abortRateLimited(c, "too many concurrent requests", apiInFlight.status())
+			return
+		}
+		handshake := true
+		defer func() {
+			if handshake {
+				apiInFlight.release(claims.UserID)
+			}
+		}()
 		cidHex := c.Param("cid")
 		cid, err := primitive.ObjectIDFromHex(cidHex)
 		if err != nil {
 			c.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
+		var batch time.Duration
+		if s := c.Query("batch_ms"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > envInt("WS_BATCH_MAX_MS", 250) {
+				c.AbortWithStatus(http.StatusBadRequest)
+				return
+			}
+			batch = time.Duration(n) * time.Millisecond
+		}
 
-		// membership check
+		// membership check (compliance watchers get a read-only socket)
 		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 		defer cancel()
 		db := getDB(client)
-		ok, err := isMember(ctx, db, cid, uid)
+		member, err := isMember(ctx, db, cid, uid)
+		ok := member
+		if err == nil && !member {
+			ok, err = canRead(ctx, db, cid, uid, "ws.connect")
+		}
 		if err != nil {
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
@@ -194,52 +605,171 @@ func WSHandler(client *mongo.Client) gin.HandlerFunc {
 		if err != nil {
 			return
 		}
+		apiInFlight.release(claims.UserID)
+		handshake = false
 		cl := &wsClient{
-			conn: ws,
-			send: make(chan Event, 32),
-			uid:  uid,
-			cid:  cid,
+			conn:     ws,
+			send:     make(chan envelope, 32),
+			uid:      uid,
+			cid:      cid,
+			batch:    batch,
+			readOnly: !member,
+			sess:     newWSSession(uid, cid, batch, !member),
 		}
 		broadcaster.Join(cl)
 
-		// writer
-		go func() {
-			defer func() {
-				broadcaster.Leave(cl)
-				_ = cl.conn.Close()
-			}()
-			cl.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
-			for {
-				select {
-				case e, ok := <-cl.send:
-					if !ok {
-						return
-					}
-					cl.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-					if err := cl.conn.WriteJSON(e); err != nil {
+		// the user is online now: everything they can receive counts as delivered
+		go markDeliveredAsync(db, uid)
+
+		// first frame tells the client which features are on
+		cl.send <- envelope{Event: wsHello(cl.sess, false), at: time.Now()}
+		cl.serve()
+	}
+}
+
+// resumeWS reattaches a dropped socket's session. False means the token
+// can't be used and nothing was written: carry on with a normal connect.
+func resumeWS(c *gin.Context, client *mongo.Client, token string) bool {
+	cid, err := primitive.ObjectIDFromHex(c.Param("cid"))
+	if err != nil {
+		return false
+	}
+	after, err := strconv.ParseInt(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil || after < 0 {
+		return false
+	}
+	s := broadcaster.resumable(token, cid, after)
+	if s == nil {
+		return false
+	}
+	if !apiInFlight.acquire(s.uid.Hex()) {
+		abortRateLimited(c, "too many concurrent requests", apiInFlight.status())
+		return true
+	}
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	apiInFlight.release(s.uid.Hex())
+	if err != nil {
+		return true
+	}
+	cl := broadcaster.resume(s, after, func(buf int) *wsClient {
+		return &wsClient{
+			conn:     ws,
+			send:     make(chan envelope, 32+buf),
+			uid:      s.uid,
+			cid:      s.cid,
+			batch:    s.batch,
+			readOnly: s.readOnly,
+		}
+	}, wsHello(s, true))
+	if cl == nil {
+		// expired between the lookup and the upgrade
+		_ = ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(4409, "resume expired"), time.Now().Add(time.Second))
+		_ = ws.Close()
+		return true
+	}
+	go markDeliveredAsync(getDB(client), s.uid)
+	cl.serve()
+	return true
+}
+
+func wsHello(s *wsSession, resumed bool) Event {
+	flags := make(map[string]bool)
+	for f, on := range features.All() {
+		flags[string(f)] = on
+	}
+	return events.New(s.cid.Hex(), events.Hello{
+		Version:     version,
+		Features:    flags,
+		ResumeToken: s.token,
+		Resumed:     resumed,
+	})
+}
+
+func markDeliveredAsync(db *mongo.Database, uid primitive.ObjectID) {
+	if err := markAllDelivered(db, uid); err != nil {
+		fmt.Println("mark delivered error:", err)
+	}
+}
+
+// serve starts the socket's writer and reader.
+func (cl *wsClient) serve() {
+	// writer
+	go func() {
+		defer func() {
+			broadcaster.Leave(cl)
+			_ = cl.conn.Close()
+		}()
+		cl.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		var (
+			held      []envelope
+			flush     <-chan time.Time // nil until something is held
+			lastWrite time.Time
+		)
+		for {
+			select {
+			case env, ok := <-cl.send:
+				if !ok {
+					return
+				}
+				if cl.batch == 0 || (len(held) == 0 && time.Since(lastWrite) >= cl.batch) {
+					if err := cl.writeFrames([]envelope{env}); err != nil {
 						return
 					}
-				case <-time.After(25 * time.Second):
-					// ping to keep alive
-					cl.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-					if err := cl.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					lastWrite = time.Now()
+					continue
+				}
+				held = append(held, env)
+				if flush == nil {
+					flush = time.After(cl.batch - time.Since(lastWrite))
+				}
+				if len(held) >= wsMaxBatch {
+					if err := cl.writeFrames(held); err != nil {
 						return
 					}
+					held, flush, lastWrite = held[:0], nil, time.Now()
 				}
-			}
-		}()
-
-		// reader
-		go func() {
-			defer func() {
-				broadcaster.Leave(cl)
-				_ = cl.conn.Close()
-			}()
-			for {
-				if _, _, err := cl.conn.ReadMessage(); err != nil {
+			case <-flush:
+				if err := cl.writeFrames(held); err != nil {
+					return
+				}
+				held, flush, lastWrite = held[:0], nil, time.Now()
+			case <-time.After(25 * time.Second):
+				// ping to keep alive
+				cl.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+				if err := cl.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 					return
 				}
 			}
+		}
+	}()
+
+	// reader: viewing ops (viewing.go); anything else is ignored
+	go func() {
+		defer func() {
+			viewing.blur(cl)
+			broadcaster.Leave(cl)
+			_ = cl.conn.Close()
 		}()
-	}
+		for {
+			_, data, err := cl.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var in struct {
+				Op string `json:"op"`
+			}
+			if cl.readOnly || json.Unmarshal(data, &in) != nil {
+				continue
+			}
+			switch in.Op {
+			case "focus":
+				viewing.focus(cl)
+			case "heartbeat":
+				viewing.heartbeat(cl)
+			case "blur":
+				viewing.blur(cl)
+			}
+		}
+	}()
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"backend/events"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCloseUserInRoomClosesTheirFeeds(t *testing.T) {
+	b := NewBroadcaster()
+	cid, other := primitive.NewObjectID(), primitive.NewObjectID()
+	gone, stays := primitive.NewObjectID(), primitive.NewObjectID()
+
+	goneCh := b.SubscribeAs(gone, cid)
+	goneElsewhere := b.SubscribeAs(gone, other)
+	staysCh := b.SubscribeAs(stays, cid)
+	anon := b.Subscribe(cid)
+
+	b.CloseUserInRoom(gone, cid)
+	b.Publish(events.New(cid.Hex(), events.MessageDeleted{ID: "m1"}))
+	b.Publish(events.New(other.Hex(), events.MessageDeleted{ID: "m2"}))
+
+	if e, ok := <-goneCh; ok {
+		t.Fatalf("removed user's feed got %s", e.Type)
+	}
+	for name, ch := range map[string]chan Event{"other member": staysCh, "anonymous feed": anon, "other room": goneElsewhere} {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				t.Fatalf("%s was closed", name)
+			}
+		default:
+			t.Fatalf("%s got nothing", name)
+		}
+	}
+
+	// unsubscribing a closed feed is a no-op
+	b.Unsubscribe(cid, goneCh)
+	b.Unsubscribe(cid, staysCh)
+	b.Unsubscribe(cid, anon)
+	if _, ok := b.feeds[cid]; ok {
+		t.Fatal("feeds for cid left behind")
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"backend/events"
+)
+
+/*
+Schema:
+  conversations.weekly_summary: true          (owners opt in)
+  weekly_summaries: { _id: "<cid>:<ISO week>", conversation_id, week,
+                      message_id, created_at }
+
+Weekly activity card. On WEEKLY_SUMMARY_SCHEDULE (cron-style
+"minute hour * * weekday", UTC; default "0 9 * * 1", Mondays 09:00; "off"
+disables) every opted-in conversation gets one message of type "summary"
+covering the previous ISO week (Monday 00:00 to Monday 00:00 UTC): total
+messages, the most active members and the busiest day, from the same
+aggregation as GET /conversations/:cid/summary. The message has no sender
+(sender_id is all zeros), a plain-text body for old clients and the
+structured card clients render:
+
+  "card": { "week": "2026-W41", "from": <ms>, "to": <ms>, "messages": 42,
+            "top_members": [{ user_id, username, messages }],
+            "busiest_day": { "day": "2026-10-07", "messages": 15 } }
+
+Cards never count as unread and never push. A weekly_summaries marker is
+inserted before posting, so a rerun of the job (a restart, or several
+instances) posts each (conversation, week) at most once; the marker is
+removed again if posting fails, so the next run retries. Weeks without
+messages get a marker but no card.
+*/
+
+const (
+	msgTypeSummary     = "summary"
+	weeklyTopMembers   = 3
+	weeklySummaryJob   = "weekly-summaries"
+	defaultWeeklyCron  = "0 9 * * 1"
+	weeklySummaryBatch = 10 * time.Minute
+)
+
+type summaryCard struct {
+	Week       string              `bson:"week" json:"week"`
+	From       int64               `bson:"from" json:"from"`
+	To         int64               `bson:"to" json:"to"`
+	Messages   int64               `bson:"messages" json:"messages"`
+	TopMembers []summaryCardMember `bson:"top_members" json:"top_members"`
+	BusiestDay *summaryCardDay     `bson:"busiest_day,omitempty" json:"busiest_day,omitempty"`
+}
+
+type summaryCardMember struct {
+	UserID   primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Username string             `bson:"username,omitempty" json:"username,omitempty"`
+	Messages int64              `bson:"messages" json:"messages"`
+}
+
+type summaryCardDay struct {
+	Day      string `bson:"day" json:"day"`
+	Messages int64  `bson:"messages" json:"messages"`
+}
+
+func (s *summaryCard) event() *events.SummaryCard {
+	out := &events.SummaryCard{
+		Week:       s.Week,
+		From:       s.From,
+		To:         s.To,
+		Messages:   s.Messages,
+		TopMembers: make([]events.SummaryMember, len(s.TopMembers)),
+	}
+	for i, m := range s.TopMembers {
+		out.TopMembers[i] = events.SummaryMember{UserID: m.UserID.Hex(), Username: m.Username, Messages: m.Messages}
+	}
+	if d := s.BusiestDay; d != nil {
+		out.BusiestDay = &events.SummaryDay{Day: d.Day, Messages: d.Messages}
+	}
+	return out
+}
+
+// text is the body old clients show instead of the card.
+func (s *summaryCard) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Week %s: %d messages.", s.Week, s.Messages)
+	if len(s.TopMembers) > 0 {
+		names := make([]string, len(s.TopMembers))
+		for i, m := range s.TopMembers {
+			n := m.Username
+			if n == "" {
+				n = "someone"
+			}
+			names[i] = fmt.Sprintf("%s (%d)", n, m.Messages)
+		}
+		b.WriteString(" Most active: " + strings.Join(names, ", ") + ".")
+	}
+	if d := s.BusiestDay; d != nil {
+		fmt.Fprintf(&b, " Busiest day: %s (%d).", d.Day, d.Messages)
+	}
+	return b.String()
+}
+
+// weeklyCron is a parsed "minute hour * * weekday"; -1 = any.
+type weeklyCron struct {
+	minute, hour, weekday int
+}
+
+func parseWeeklyCron(s string) (weeklyCron, error) {
+	f := strings.Fields(s)
+	if len(f) != 5 || f[2] != "*" || f[3] != "*" {
+		return weeklyCron{}, errors.New(`want "minute hour * * weekday"`)
+	}
+	field := func(v string, max int) (int, error) {
+		if v == "*" {
+			return -1, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > max {
+			return 0, fmt.Errorf("bad field %q", v)
+		}
+		return n, nil
+	}
+	var c weeklyCron
+	var err error
+	if c.minute, err = field(f[0], 59); err != nil {
+		return c, err
+	}
+	if c.hour, err = field(f[1], 23); err != nil {
+		return c, err
+	}
+	if c.weekday, err = field(f[4], 7); err != nil {
+		return c, err
+	}
+	if c.weekday == 7 {
+		c.weekday = 0 // both 0 and 7 are Sunday, as in cron
+	}
+	return c, nil
+}
+
+// next is the first matching minute strictly after now, in UTC.
+func (c weeklyCron) next(now time.Time) time.Time {
+	t := now.UTC().Truncate(time.Minute).Add(time.Minute)
+	for end := t.Add(8 * 24 * time.Hour); t.Before(end); t = t.Add(time.Minute) {
+		if (c.minute < 0 || t.Minute() == c.minute) &&
+			(c.hour < 0 || t.Hour() == c.hour) &&
+			(c.weekday < 0 || int(t.Weekday()) == c.weekday) {
+			return t
+		}
+	}
+	return t
+}
+
+// weeklySchedule reads WEEKLY_SUMMARY_SCHEDULE; ok is false when off.
+func weeklySchedule() (weeklyCron, bool, error) {
+	s := strings.TrimSpace(os.Getenv("WEEKLY_SUMMARY_SCHEDULE"))
+	switch s {
+	case "off":
+		return weeklyCron{}, false, nil
+	case "":
+		s = defaultWeeklyCron
+	}
+	c, err := parseWeeklyCron(s)
+	return c, err == nil, err
+}
+
+// lastWeek is the ISO week before the one now falls in: [from, to) in
+// UTC and its "2006-W01" label.
+func lastWeek(now time.Time) (from, to time.Time, week string) {
+	d := now.UTC().Truncate(24 * time.Hour)
+	to = d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7)) // back to Monday
+	from = to.AddDate(0, 0, -7)
+	y, w := from.ISOWeek()
+	return from, to, fmt.Sprintf("%d-W%02d", y, w)
+}
+
+// startWeeklySummaries schedules the job on the jobs runner, by its
+// clock; each run schedules the next one.
+func startWeeklySummaries(client *mongo.Client) {
+	cron, on, err := weeklySchedule()
+	if err != nil {
+		fmt.Println("WEEKLY_SUMMARY_SCHEDULE:", err, "- weekly summaries off")
+		return
+	}
+	if !on {
+		return
+	}
+	var schedule func()
+	schedule = func() {
+		at := cron.next(jobs.Now())
+		jobs.Schedule(weeklySummaryJob, at.Sub(jobs.Now()), func() {
+			ctx, cancel := context.WithTimeout(context.Background(), weeklySummaryBatch)
+			defer cancel()
+			if err := runWeeklySummaries(ctx, getDB(client), at); err != nil {
+				fmt.Println("weekly summary error:", err)
+			}
+			schedule()
+		})
+	}
+	schedule()
+}
+
+// runWeeklySummaries posts the card for the week before now to every
+// opted-in conversation. now is a parameter so a rerun (or a test) can
+// pin the week.
+func runWeeklySummaries(ctx context.Context, db *mongo.Database, now time.Time) error {
+	from, to, week := lastWeek(now)
+	cur, err := db.Collection("conversations").Find(ctx, bson.M{"weekly_summary": true},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var convs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cur.All(ctx, &convs); err != nil {
+		return err
+	}
+	for _, conv := range convs {
+		if err := postWeeklySummary(ctx, db, conv.ID, from, to, week); err != nil {
+			fmt.Println("weekly summary error:", conv.ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// postWeeklySummary posts cid's card for week unless its marker exists.
+func postWeeklySummary(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, from, to time.Time, week string) error {
+	markers := db.Collection("weekly_summaries")
+	key := cid.Hex() + ":" + week
+	_, err := markers.InsertOne(ctx, bson.M{
+		"_id":             key,
+		"conversation_id": cid,
+		"week":            week,
+		"created_at":      time.Now().UnixMilli(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil // already posted
+	}
+	if err != nil {
+		return err
+	}
+
+	msg, err := weeklySummaryMessage(ctx, db, cid, from, to, week)
+	if err == nil && msg != nil {
+		var stored Message
+		stored, _, err = storeMessage(ctx, db, *msg)
+		if err == nil {
+			_, err = markers.UpdateByID(ctx, key, bson.M{"$set": bson.M{"message_id": stored.ID}})
+			return err
+		}
+	}
+	if err != nil {
+		// let the next run try again
+		if _, derr := markers.DeleteOne(ctx, bson.M{"_id": key}); derr != nil {
+			fmt.Println("weekly summary marker error:", derr)
+		}
+	}
+	return err
+}
+
+// weeklySummaryMessage builds cid's card for [from, to); nil when the
+// week had no messages.
+func weeklySummaryMessage(ctx context.Context, db *mongo.Database, cid primitive.ObjectID, from, to time.Time, week string) (*Message, error) {
+	s, err := computeSummary(ctx, db, cid, time.UTC, from.UnixMilli(), to.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	if s.Total == 0 {
+		return nil, nil
+	}
+	card := &summaryCard{
+		Week:       week,
+		From:       from.UnixMilli(),
+		To:         to.UnixMilli(),
+		Messages:   s.Total,
+		TopMembers: []summaryCardMember{},
+	}
+	for _, p := range s.Participants[:min(len(s.Participants), weeklyTopMembers)] {
+		card.TopMembers = append(card.TopMembers, summaryCardMember{UserID: p.UserID, Username: p.Username, Messages: p.Messages})
+	}
+	if d := s.BusiestDay; d != nil {
+		card.BusiestDay = &summaryCardDay{Day: d.Day, Messages: d.Messages}
+	}
+	return &Message{
+		ConversationID: cid,
+		SenderID:       primitive.NilObjectID,
+		Type:           msgTypeSummary,
+		Body:           card.text(),
+		Ts:             time.Now().UnixMilli(),
+		Card:           card,
+	}, nil
+}
+
+// PUT /conversations/:cid/weekly-summary (owner only)
+// Body: { "enabled": true }
+// Opts the conversation in to (or out of) the weekly activity card.
+func SetWeeklySummaryHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		var in struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil || in.Enabled == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "enabled required"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role != "owner" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+			return
+		}
+
+		if _, err := db.Collection("conversations").UpdateByID(ctx, cid,
+			bson.M{"$set": bson.M{"weekly_summary": *in.Enabled, "updated_at": time.Now().UnixMilli()}}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+
+		broadcaster.Publish(events.New(cid.Hex(), events.ConversationUpdated{WeeklySummary: in.Enabled}))
+		c.JSON(http.StatusOK, gin.H{"ok": true, "weekly_summary": *in.Enabled})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWeeklyCronNext(t *testing.T) {
+	c, err := parseWeeklyCron(defaultWeeklyCron)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for now, want := range map[string]string{
+		"2026-10-11T12:00:00Z": "2026-10-12T09:00:00Z", // Sunday
+		"2026-10-12T09:00:00Z": "2026-10-19T09:00:00Z", // strictly after
+		"2026-10-12T08:59:30Z": "2026-10-12T09:00:00Z",
+	} {
+		at, _ := time.Parse(time.RFC3339, now)
+		if got := c.next(at).Format(time.RFC3339); got != want {
+			t.Errorf("next(%s) = %s, want %s", now, got, want)
+		}
+	}
+	for _, bad := range []string{"0 9 * *", "60 9 * * 1", "0 9 1 * 1", "0 9 * * 8"} {
+		if _, err := parseWeeklyCron(bad); err == nil {
+			t.Errorf("parseWeeklyCron(%q) accepted", bad)
+		}
+	}
+}
+
+// TestWeeklySummaryCard runs the schedule on the fake clock: the Monday
+// run posts last week's card once, and a quiet week posts nothing.
+func TestWeeklySummaryCard(t *testing.T) {
+	client, db := testDB(t)
+	clk := useJobClock(t)
+	clk.now, _ = time.Parse(time.RFC3339, "2026-10-11T12:00:00Z")
+	t.Setenv("WEEKLY_SUMMARY_SCHEDULE", "")
+	ann, bob := seedUser(t, db, "ann"), seedUser(t, db, "bob")
+	conv := seedConv(t, db, "ops", ann, bob)
+	r, api := testAPI()
+	api.PUT("/conversations/:cid/weekly-summary", SetWeeklySummaryHandler(client))
+	if w := serve(t, r, http.MethodPut, "/conversations/"+conv.ID.Hex()+"/weekly-summary", &bob, gin.H{"enabled": true}); w.Code != http.StatusForbidden {
+		t.Fatalf("opt-in by a member: %d", w.Code)
+	}
+	if w := serve(t, r, http.MethodPut, "/conversations/"+conv.ID.Hex()+"/weekly-summary", &ann, gin.H{"enabled": true}); w.Code != http.StatusOK {
+		t.Fatalf("opt-in: %d %s", w.Code, w.Body)
+	}
+
+	// W41 is Monday 2026-10-05 to Monday 2026-10-12
+	for _, m := range []struct {
+		from User
+		at   string
+	}{
+		{ann, "2026-10-04T23:59:00Z"}, // W40
+		{ann, "2026-10-06T10:00:00Z"},
+		{bob, "2026-10-07T10:00:00Z"},
+		{bob, "2026-10-07T11:00:00Z"},
+		{bob, "2026-10-11T23:59:00Z"},
+	} {
+		at, _ := time.Parse(time.RFC3339, m.at)
+		if _, err := db.Collection("messages").InsertOne(testCtx(t), Message{
+			ID: primitive.NewObjectID(), ConversationID: conv.ID, SenderID: m.from.ID,
+			Type: "text", Body: "hi", Ts: at.UnixMilli(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cards := func() []Message {
+		t.Helper()
+		cur, err := db.Collection("messages").Find(testCtx(t), bson.M{"conversation_id": conv.ID, "type": msgTypeSummary})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []Message
+		if err := cur.All(testCtx(t), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	startWeeklySummaries(client)
+	t.Cleanup(func() { jobs.Cancel(weeklySummaryJob) })
+	clk.Advance(21*time.Hour - time.Minute)
+	if n := len(cards()); n != 0 {
+		t.Fatalf("%d cards before Monday 09:00", n)
+	}
+	clk.Advance(time.Minute)
+	got := cards()
+	if len(got) != 1 || got[0].Card == nil {
+		t.Fatalf("%d cards after Monday 09:00", len(got))
+	}
+	card := got[0].Card
+	if card.Week != "2026-W41" || card.Messages != 4 || len(card.TopMembers) != 2 ||
+		card.TopMembers[0].UserID != bob.ID || card.TopMembers[0].Messages != 3 ||
+		card.BusiestDay == nil || card.BusiestDay.Day != "2026-10-07" || card.BusiestDay.Messages != 2 {
+		t.Fatalf("card %+v", card)
+	}
+	if got[0].SenderID != primitive.NilObjectID || got[0].Body != card.text() {
+		t.Fatalf("card message from %s: %q", got[0].SenderID.Hex(), got[0].Body)
+	}
+
+	// a rerun for the same week posts nothing new
+	at, _ := time.Parse(time.RFC3339, "2026-10-12T09:00:00Z")
+	if err := runWeeklySummaries(testCtx(t), db, at); err != nil {
+		t.Fatal(err)
+	}
+	// W42 had no messages: a marker, no card
+	clk.Advance(7 * 24 * time.Hour)
+	if n := len(cards()); n != 1 {
+		t.Fatalf("%d cards after the rerun and a quiet week", n)
+	}
+	if n := countDocs(t, db, "weekly_summaries", bson.M{"conversation_id": conv.ID}); n != 2 {
+		t.Fatalf("%d markers, want 2", n)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Public, read-only embed of a conversation.
+
+  widget_tokens:
+    - token           (string, "wgt_" + 48 hex chars, unique)
+    - conversation_id (ObjectId)
+    - created_by      (ObjectId)
+    - allowed_origins ([]string, exact match against Origin header)
+    - include_sender  (bool)
+    - revoked         (bool)
+
+Widget tokens are separate from any other link/token type and are only
+accepted on /widget/* routes.
+*/
+
+type WidgetToken struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Token          string             `bson:"token" json:"token"`
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	CreatedBy      primitive.ObjectID `bson:"created_by" json:"created_by"`
+	AllowedOrigins []string           `bson:"allowed_origins" json:"allowed_origins"`
+	IncludeSender  bool               `bson:"include_sender" json:"include_sender"`
+	Revoked        bool               `bson:"revoked" json:"revoked"`
+	CreatedAt      int64              `bson:"created_at" json:"created_at"`
+}
+
+const widgetTokenPrefix = "wgt_"
+
+// per-IP limiter shared by the feed and stream endpoints
+var widgetLimiter = newRateLimiter(60, 20)
+
+func ensureWidgetIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("widget_tokens").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "token", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// widgetOrigins returns the embedding origins from WIDGET_ORIGINS (comma
+// separated), used for the public /widget/ and /snapshots/ CORS policy
+// (cors.go).
+func widgetOrigins() []string {
+	return originList("WIDGET_ORIGINS")
+}
+
+func newWidgetToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return widgetTokenPrefix + hex.EncodeToString(b), nil
+}
+
+// loadWidget returns the active widget for token, or nil if unknown/revoked.
+func loadWidget(ctx context.Context, db *mongo.Database, token string) (*WidgetToken, error) {
+	if !strings.HasPrefix(token, widgetTokenPrefix) {
+		return nil, nil
+	}
+	var w WidgetToken
+	err := db.Collection("widget_tokens").FindOne(ctx, bson.M{"token": token, "revoked": false}).Decode(&w)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (w *WidgetToken) originAllowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, o := range w.AllowedOrigins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeForWidget strips a message down to what public embeds may see.
+// Only text bodies go out; the rest become "[image]", "[summary]", ...
+// since an image's body is an upload id.
+func sanitizeForWidget(w *WidgetToken, id, senderID string, typ, body string, ts int64) gin.H {
+	if typ != "text" {
+		body = "[" + typ + "]"
+	}
+	out := gin.H{"id": id, "type": typ, "body": body, "ts": ts}
+	if w.IncludeSender {
+		out["sender_id"] = senderID
+	}
+	return out
+}
+
+// open SSE streams per token so revocation can cut them off immediately
+var widgetStreams = struct {
+	sync.Mutex
+	m map[string]map[chan struct{}]struct{}
+}{m: make(map[string]map[chan struct{}]struct{})}
+
+func trackWidgetStream(token string) chan struct{} {
+	done := make(chan struct{})
+	widgetStreams.Lock()
+	defer widgetStreams.Unlock()
+	if _, ok := widgetStreams.m[token]; !ok {
+		widgetStreams.m[token] = make(map[chan struct{}]struct{})
+	}
+	widgetStreams.m[token][done] = struct{}{}
+	return done
+}
+
+func untrackWidgetStream(token string, done chan struct{}) {
+	widgetStreams.Lock()
+	defer widgetStreams.Unlock()
+	if m, ok := widgetStreams.m[token]; ok {
+		delete(m, done)
+		if len(m) == 0 {
+			delete(widgetStreams.m, token)
+		}
+	}
+}
+
+func closeWidgetStreams(token string) {
+	widgetStreams.Lock()
+	defer widgetStreams.Unlock()
+	for done := range widgetStreams.m[token] {
+		close(done)
+	}
+	delete(widgetStreams.m, token)
+}
+
+// === Handlers ===
+
+// POST /conversations/:cid/widgets (owner only)
+// Body: { "allowed_origins": ["https://example.com"], "include_sender": false }
+func CreateWidgetHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+
+		var in struct {
+			AllowedOrigins []string `json:"allowed_origins"`
+			IncludeSender  bool     `json:"include_sender"`
+		}
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad json"})
+			return
+		}
+		origins := make([]string, 0, len(in.AllowedOrigins))
+		for _, o := range in.AllowedOrigins {
+			if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+				origins = append(origins, o)
+			}
+		}
+		if len(origins) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "allowed_origins required"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role != "owner" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+			return
+		}
+
+		if err := ensureWidgetIndexes(ctx, db); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "index error"})
+			return
+		}
+
+		tok, err := newWidgetToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "token error"})
+			return
+		}
+		w := WidgetToken{
+			Token:          tok,
+			ConversationID: cid,
+			CreatedBy:      uid,
+			AllowedOrigins: origins,
+			IncludeSender:  in.IncludeSender,
+			CreatedAt:      time.Now().UnixMilli(),
+		}
+		res, err := db.Collection("widget_tokens").InsertOne(ctx, w)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		w.ID = res.InsertedID.(primitive.ObjectID)
+		c.JSON(http.StatusCreated, w)
+	}
+}
+
+// DELETE /conversations/:cid/widgets/:token (owner only)
+// Revocation takes effect immediately, including for open SSE streams.
+func RevokeWidgetHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uidHex, _ := c.Get("uid")
+		uid, err := mustOID(uidHex.(string))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		cid, err := mustOID(c.Param("cid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid conversation id"})
+			return
+		}
+		token := c.Param("token")
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		role, err := memberRole(ctx, db, cid, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if role != "owner" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "owner only"})
+			return
+		}
+
+		res, err := db.Collection("widget_tokens").UpdateOne(ctx,
+			bson.M{"token": token, "conversation_id": cid},
+			bson.M{"$set": bson.M{"revoked": true}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		if res.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "widget not found"})
+			return
+		}
+		closeWidgetStreams(token)
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	}
+}
+
+// widgetAuth resolves the token and enforces the Origin allow-list.
+// It writes the error response itself and returns nil on failure.
+func widgetAuth(c *gin.Context, ctx context.Context, db *mongo.Database) *WidgetToken {
+	w, err := loadWidget(ctx, db, c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+		return nil
+	}
+	if w == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "widget not found"})
+		return nil
+	}
+	if !w.originAllowed(c.GetHeader("Origin")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "origin not allowed"})
+		return nil
+	}
+	return w
+}
+
+// GET /widget/:token/feed?limit=20
+// Latest messages, newest first, sanitized for public display.
+func WidgetFeedHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 20
+		if s := c.Query("limit"); s != "" {
+			if n, err := strconv.Atoi(s); err == nil && n > 0 {
+				if n > 50 {
+					n = 50
+				}
+				limit = n
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		db := getDB(client)
+
+		w := widgetAuth(c, ctx, db)
+		if w == nil {
+			return
+		}
+
+		cur, err := db.Collection("messages").Find(ctx,
+			visible(bson.M{"conversation_id": w.ConversationID}),
+			options.Find().
+				SetSort(bson.D{{Key: "ts", Value: -1}}).
+				SetLimit(int64(limit)),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+			return
+		}
+		defer cur.Close(ctx)
+
+		out := make([]gin.H, 0, limit)
+		for cur.Next(ctx) {
+			var m Message
+			if err := cur.Decode(&m); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "decode error"})
+				return
+			}
+			body, err := loadFullBody(ctx, db, m)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db error"})
+				return
+			}
+			out = append(out, sanitizeForWidget(w, m.ID.Hex(), m.SenderID.Hex(), m.Type, body, m.Ts))
+		}
+
+		body, err := json.Marshal(gin.H{"messages": out})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "encode error"})
+			return
+		}
+		sum := sha1.Sum(body)
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+		c.Header("Cache-Control", "public, max-age=15")
+		c.Header("ETag", etag)
+		c.Header("Vary", "Origin")
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	}
+}
+
+// GET /widget/:token/stream
+// Server-Sent Events variant of the feed: one "message" event per new message.
+func WidgetStreamHandler(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		w := widgetAuth(c, ctx, getDB(client))
+		cancel()
+		if w == nil {
+			return
+		}
+
+		feed := broadcaster.Subscribe(w.ConversationID)
+		defer broadcaster.Unsubscribe(w.ConversationID, feed)
+		revoked := trackWidgetStream(w.Token)
+		defer untrackWidgetStream(w.Token, revoked)
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+
+		keepAlive := time.NewTicker(25 * time.Second)
+		defer keepAlive.Stop()
+
+		c.Stream(func(out io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-revoked:
+				c.SSEvent("revoked", gin.H{})
+				return false
+			case <-keepAlive.C:
+				c.SSEvent("ping", gin.H{})
+				return true
+			case e := <-feed:
+				p, ok := e.Payload.(events.MessageCreated)
+				if !ok {
+					return true
+				}
+				body := p.Body
+				if p.Truncated {
+					body = widgetFullBody(client, p.ID)
+				}
+				c.SSEvent("message", sanitizeForWidget(w, p.ID, p.SenderID, p.Type, body, p.Ts))
+				return true
+			}
+		})
+	}
+}
+
+// widgetFullBody loads a body that was trimmed out of the broadcast event,
+// from GridFS if it is stored there (blobs.go). The preview is what's left
+// if that fails.
+func widgetFullBody(client *mongo.Client, id string) string {
+	mid, err := mustOID(id)
+	if err != nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	db := getDB(client)
+	var m Message
+	if err := db.Collection("messages").FindOne(ctx, visible(bson.M{"_id": mid})).Decode(&m); err != nil {
+		return ""
+	}
+	body, err := loadFullBody(ctx, db, m)
+	if err != nil {
+		return m.Body
+	}
+	return body
+}
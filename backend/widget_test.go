@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWidgetOriginAllowed(t *testing.T) {
+	w := &WidgetToken{AllowedOrigins: []string{"https://example.com", "http://localhost:3000"}}
+	for origin, want := range map[string]bool{
+		"https://example.com":      true,
+		"http://localhost:3000":    true,
+		"":                         false,
+		"https://example.com/":     false,
+		"http://example.com":       false,
+		"https://evil.example.com": false,
+		"https://example.com.evil": false,
+		"https://EXAMPLE.com":      false,
+		"null":                     false,
+	} {
+		if got := w.originAllowed(origin); got != want {
+			t.Errorf("originAllowed(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestSanitizeForWidget(t *testing.T) {
+	w := &WidgetToken{}
+	for typ, want := range map[string]string{
+		"text":         "body",
+		"image":        "[image]",
+		msgTypeSummary: "[summary]",
+		"sticker":      "[sticker]",
+		"system":       "[system]",
+	} {
+		if got := sanitizeForWidget(w, "m1", "u1", typ, "body", 1)["body"]; got != want {
+			t.Errorf("%s body %q, want %q", typ, got, want)
+		}
+	}
+	if _, ok := sanitizeForWidget(w, "m1", "u1", "text", "body", 1)["sender_id"]; ok {
+		t.Error("sender_id without include_sender")
+	}
+	w.IncludeSender = true
+	if got := sanitizeForWidget(w, "m1", "u1", "text", "body", 1)["sender_id"]; got != "u1" {
+		t.Errorf("sender_id %v with include_sender", got)
+	}
+}
+
+// widgetFixture is a conversation owned by owner with one widget for
+// https://example.com.
+type widgetFixture struct {
+	r     *gin.Engine
+	owner User
+	conv  Conversation
+	token string
+}
+
+func newWidgetFixture(t *testing.T) *widgetFixture {
+	t.Helper()
+	client, db := testDB(t)
+	f := &widgetFixture{owner: seedUser(t, db, "owner")}
+	f.conv = seedConv(t, db, "ops", f.owner, seedUser(t, db, "bob"))
+
+	r, api := testAPI()
+	api.POST("/conversations/:cid/widgets", CreateWidgetHandler(client))
+	api.DELETE("/conversations/:cid/widgets/:token", RevokeWidgetHandler(client))
+	api.POST("/messages/:cid", SendMessageHandler(client))
+	r.GET("/widget/:token/feed", WidgetFeedHandler(client))
+	r.GET("/widget/:token/stream", WidgetStreamHandler(client))
+	f.r = r
+
+	w := serve(t, r, http.MethodPost, "/conversations/"+f.conv.ID.Hex()+"/widgets", &f.owner,
+		gin.H{"allowed_origins": []string{" https://example.com/ "}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create widget: %d %s", w.Code, w.Body)
+	}
+	var wt WidgetToken
+	decode(t, w, &wt)
+	if len(wt.AllowedOrigins) != 1 || wt.AllowedOrigins[0] != "https://example.com" {
+		t.Fatalf("allowed_origins stored as %q", wt.AllowedOrigins)
+	}
+	f.token = wt.Token
+	return f
+}
+
+func (f *widgetFixture) feed(t *testing.T, origin, etag string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/widget/"+f.token+"/feed", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	f.r.ServeHTTP(w, req)
+	return w
+}
+
+func (f *widgetFixture) send(t *testing.T, body string) {
+	t.Helper()
+	if w := serve(t, f.r, http.MethodPost, "/messages/"+f.conv.ID.Hex(), &f.owner, gin.H{"body": body}); w.Code != http.StatusCreated {
+		t.Fatalf("send: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestWidgetFeedOrigins(t *testing.T) {
+	f := newWidgetFixture(t)
+	for origin, want := range map[string]int{
+		"https://example.com":      http.StatusOK,
+		"":                         http.StatusForbidden,
+		"https://other.example":    http.StatusForbidden,
+		"https://example.com.evil": http.StatusForbidden,
+	} {
+		if w := f.feed(t, origin, ""); w.Code != want {
+			t.Errorf("origin %q: %d, want %d", origin, w.Code, want)
+		}
+	}
+	f.token = widgetTokenPrefix + strings.Repeat("0", 48)
+	if w := f.feed(t, "https://example.com", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown token: %d, want 404", w.Code)
+	}
+}
+
+func TestWidgetFeedETag(t *testing.T) {
+	f := newWidgetFixture(t)
+	f.send(t, "hello")
+
+	w := f.feed(t, "https://example.com", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("feed: %d, ETag %q", w.Code, etag)
+	}
+	if !strings.Contains(w.Body.String(), `"body":"hello"`) || strings.Contains(w.Body.String(), "sender_id") {
+		t.Fatalf("feed body %s", w.Body)
+	}
+
+	w = f.feed(t, "https://example.com", etag)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("unchanged feed with If-None-Match: %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if got := w.Header().Get("ETag"); got != etag {
+		t.Fatalf("304 ETag %q, want %q", got, etag)
+	}
+	// the origin check comes before the cache check
+	if w := f.feed(t, "https://other.example", etag); w.Code != http.StatusForbidden {
+		t.Fatalf("If-None-Match from a foreign origin: %d", w.Code)
+	}
+
+	f.send(t, "again")
+	w = f.feed(t, "https://example.com", etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("changed feed: %d, ETag %q (old %q)", w.Code, w.Header().Get("ETag"), etag)
+	}
+}
+
+func TestWidgetRevokeClosesStreams(t *testing.T) {
+	f := newWidgetFixture(t)
+	srv := httptest.NewServer(f.r)
+	defer srv.Close()
+
+	req, err := http.NewRequestWithContext(testCtx(t), http.MethodGet, srv.URL+"/widget/"+f.token+"/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "https://example.com")
+	lines := make(chan string, 64)
+	go func() {
+		defer close(lines)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+
+	// the stream is subscribed once a message gets through
+	cid := f.conv.ID.Hex()
+	for subscribed := false; !subscribed; {
+		broadcaster.Publish(events.New(cid, events.MessageCreated{ID: "m1", Type: "text", Body: "hi"}))
+		select {
+		case l, ok := <-lines:
+			if !ok {
+				t.Fatal("stream ended before revocation")
+			}
+			subscribed = l == "event:message"
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	if w := serve(t, f.r, http.MethodDelete, "/conversations/"+cid+"/widgets/"+f.token, &f.owner, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke: %d %s", w.Code, w.Body)
+	}
+	revoked := false
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case l, ok := <-lines:
+			if !ok {
+				if !revoked {
+					t.Fatal("stream closed without a revoked event")
+				}
+				if w := f.feed(t, "https://example.com", ""); w.Code != http.StatusNotFound {
+					t.Fatalf("feed after revocation: %d", w.Code)
+				}
+				return
+			}
+			if l == "event:revoked" {
+				revoked = true
+			}
+		case <-timeout:
+			t.Fatal("stream still open after revocation")
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+WebSocket resume.
+
+Every socket gets a session whose token is sent in hello
+(payload.resume_token). Events queued for the socket are numbered
+(stream_seq on the frame, and on each event inside a batch frame) and the
+last wsResumeRing of them are kept. When the socket drops, the session is
+detached but keeps collecting events for wsResumeTTL.
+
+Reconnecting with GET /ws/:cid?resume=<token>&after=<last stream_seq seen>
+skips the JWT and membership checks, replays the kept events after that
+number (hello comes first, with "resumed": true) and carries on with the
+same token and numbering. If the server hasn't noticed the old socket is
+gone yet, it is closed and replaced. A token that is unknown, expired, or
+whose events after "after" have partly fallen out of the ring goes through
+the normal connect path instead (so send the JWT as well), and the client
+should refetch. Removal from the conversation (CloseUserInRoom) ends
+the session for good.
+*/
+
+const (
+	wsResumeTTL  = 60 * time.Second
+	wsResumeRing = 256
+)
+
+type wsSession struct {
+	token    string
+	uid      primitive.ObjectID
+	cid      primitive.ObjectID
+	batch    time.Duration
+	readOnly bool
+
+	// guarded by broadcaster.mu: the socket using the session, nil while
+	// detached
+	client     *wsClient
+	detachedAt time.Time
+
+	mu    sync.Mutex
+	ring  []Event // stream_seq first .. next-1
+	first int64
+	next  int64
+	// a full send buffer got the socket dropped; events may have been
+	// missed before it detached, so it can't be resumed
+	broken bool
+}
+
+func newWSSession(uid, cid primitive.ObjectID, batch time.Duration, readOnly bool) *wsSession {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return &wsSession{
+		token: hex.EncodeToString(b[:]), uid: uid, cid: cid,
+		batch: batch, readOnly: readOnly, first: 1, next: 1,
+	}
+}
+
+// record numbers e and keeps it. Caller holds s.mu.
+func (s *wsSession) record(e Event) Event {
+	e.StreamSeq = s.next
+	s.next++
+	if len(s.ring) == wsResumeRing {
+		s.ring = append(s.ring[:0], s.ring[1:]...)
+		s.first++
+	}
+	s.ring = append(s.ring, e)
+	return e
+}
+
+// since returns the kept events after seq, or false when some of them are
+// gone (or seq is ahead of anything handed out).
+func (s *wsSession) since(seq int64) ([]Event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.broken || seq+1 < s.first || seq >= s.next {
+		return nil, false
+	}
+	out := make([]Event, 0, s.next-1-seq)
+	for _, e := range s.ring {
+		if e.StreamSeq > seq {
+			out = append(out, e)
+		}
+	}
+	return out, true
+}
+
+// enqueue hands env to cl's writer, numbering it first when cl has a
+// session. Reports false when the send buffer is full.
+func (cl *wsClient) enqueue(env envelope) bool {
+	if s := cl.sess; s != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		env.Event = s.record(env.Event)
+	}
+	select {
+	case cl.send <- env:
+		return true
+	default:
+		if cl.sess != nil {
+			cl.sess.broken = true
+		}
+		return false
+	}
+}
+
+// detachLocked keeps cl's session collecting events after its socket is
+// gone. Caller holds b.mu.
+func (b *Broadcaster) detachLocked(cl *wsClient) {
+	s := cl.sess
+	if s == nil || s.client != cl {
+		return
+	}
+	s.client = nil
+	s.detachedAt = time.Now()
+	if b.detached[s.cid] == nil {
+		b.detached[s.cid] = make(map[*wsSession]struct{})
+	}
+	b.detached[s.cid][s] = struct{}{}
+	jobs.Schedule("ws-resume:"+s.token, wsResumeTTL, func() { b.expireSession(s) })
+}
+
+func (b *Broadcaster) expireSession(s *wsSession) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s.client == nil && time.Since(s.detachedAt) >= wsResumeTTL {
+		b.dropSessionLocked(s)
+	}
+}
+
+// dropSessionLocked forgets s. Caller holds b.mu.
+func (b *Broadcaster) dropSessionLocked(s *wsSession) {
+	delete(b.sessions, s.token)
+	if m := b.detached[s.cid]; m != nil {
+		delete(m, s)
+		if len(m) == 0 {
+			delete(b.detached, s.cid)
+		}
+	}
+	jobs.Cancel("ws-resume:" + s.token)
+}
+
+// live reports whether s can still be resumed. Caller holds b.mu.
+func (b *Broadcaster) live(s *wsSession) bool {
+	return b.sessions[s.token] == s && (s.client != nil || time.Since(s.detachedAt) < wsResumeTTL)
+}
+
+// resumable looks up a session for cid that can replay from after.
+func (b *Broadcaster) resumable(token string, cid primitive.ObjectID, after int64) *wsSession {
+	b.mu.RLock()
+	s := b.sessions[token]
+	ok := s != nil && s.cid == cid && b.live(s)
+	b.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	if _, ok := s.since(after); !ok {
+		return nil
+	}
+	return s
+}
+
+// resume attaches a new socket to s, replacing one still attached: hello,
+// then the kept events after after, then live events. Nothing published
+// meanwhile is lost, since Publish waits for b.mu. Returns nil if s
+// stopped being resumable.
+func (b *Broadcaster) resume(s *wsSession, after int64, newClient func(buf int) *wsClient, hello Event) *wsClient {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.live(s) {
+		return nil
+	}
+	if old := s.client; old != nil {
+		// its Leave won't detach s any more
+		s.client = nil
+		delete(b.rooms[s.cid], old)
+		go old.conn.Close()
+	}
+	replay, ok := s.since(after)
+	if !ok {
+		b.dropSessionLocked(s)
+		return nil
+	}
+	cl := newClient(len(replay) + 1)
+	cl.sess = s
+	now := time.Now()
+	cl.send <- envelope{Event: hello, at: now}
+	for _, e := range replay {
+		cl.send <- envelope{Event: e, at: now}
+	}
+
+	delete(b.detached[s.cid], s)
+	if len(b.detached[s.cid]) == 0 {
+		delete(b.detached, s.cid)
+	}
+	jobs.Cancel("ws-resume:" + s.token)
+	s.client = cl
+	if b.rooms[s.cid] == nil {
+		b.rooms[s.cid] = make(map[*wsClient]struct{})
+	}
+	b.rooms[s.cid][cl] = struct{}{}
+	return cl
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/events"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestWSSessionSince(t *testing.T) {
+	s := newWSSession([12]byte{1}, [12]byte{2}, 0, false)
+	if got, ok := s.since(0); !ok || len(got) != 0 {
+		t.Fatalf("fresh session since(0) = %v, %v", got, ok)
+	}
+	if _, ok := s.since(1); ok {
+		t.Fatal("since a stream_seq never handed out")
+	}
+	s.mu.Lock()
+	for i := range wsResumeRing + 10 {
+		s.record(events.New("", events.MessageDeleted{ID: strconv.Itoa(i + 1)}))
+	}
+	s.mu.Unlock()
+
+	// 1..10 fell out of the ring, 11..266 are kept
+	for _, tt := range []struct {
+		after int64
+		n     int
+		ok    bool
+	}{
+		{0, 0, false},
+		{9, 0, false},
+		{10, wsResumeRing, true},
+		{200, wsResumeRing + 10 - 200, true},
+		{wsResumeRing + 10, 0, true},
+		{wsResumeRing + 11, 0, false},
+	} {
+		got, ok := s.since(tt.after)
+		if ok != tt.ok || len(got) != tt.n {
+			t.Errorf("since(%d) = %d events, %v; want %d, %v", tt.after, len(got), ok, tt.n, tt.ok)
+			continue
+		}
+		if ok && tt.n > 0 && got[0].StreamSeq != tt.after+1 {
+			t.Errorf("since(%d) starts at %d", tt.after, got[0].StreamSeq)
+		}
+	}
+
+	s.broken = true
+	if _, ok := s.since(wsResumeRing + 10); ok {
+		t.Fatal("a broken session resumed")
+	}
+}
+
+// wsFrame is a single-event frame as clients see it.
+type wsFrame struct {
+	Type      string          `json:"type"`
+	StreamSeq int64           `json:"stream_seq"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+type wsResumeFixture struct {
+	srv   *httptest.Server
+	owner User
+	cid   string
+}
+
+func newWSResumeFixture(t *testing.T) *wsResumeFixture {
+	t.Helper()
+	client, db := testDB(t)
+	f := &wsResumeFixture{owner: seedUser(t, db, "owner")}
+	f.cid = seedConv(t, db, "ops", f.owner, seedUser(t, db, "bob")).ID.Hex()
+	r := gin.New()
+	r.GET("/ws/:cid", WSHandler(client))
+	f.srv = httptest.NewServer(r)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// dial opens /ws/:cid with query q, sending a JWT unless anonymous.
+func (f *wsResumeFixture) dial(t *testing.T, q string, anonymous bool) (*websocket.Conn, int) {
+	t.Helper()
+	h := http.Header{}
+	if !anonymous {
+		h.Set("Authorization", "Bearer "+tokenFor(t, f.owner))
+	}
+	u := "ws" + strings.TrimPrefix(f.srv.URL, "http") + "/ws/" + f.cid + q
+	conn, resp, err := websocket.DefaultDialer.Dial(u, h)
+	if err != nil {
+		if resp == nil {
+			t.Fatal(err)
+		}
+		return nil, resp.StatusCode
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, resp.StatusCode
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) wsFrame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var fr wsFrame
+	if err := conn.ReadJSON(&fr); err != nil {
+		t.Fatal(err)
+	}
+	return fr
+}
+
+func readHello(t *testing.T, conn *websocket.Conn) events.Hello {
+	t.Helper()
+	fr := readFrame(t, conn)
+	var h events.Hello
+	if fr.Type != events.TypeHello || json.Unmarshal(fr.Payload, &h) != nil {
+		t.Fatalf("first frame %s %s, want hello", fr.Type, fr.Payload)
+	}
+	return h
+}
+
+func (f *wsResumeFixture) publish(ids ...string) {
+	for _, id := range ids {
+		broadcaster.Publish(events.New(f.cid, events.MessageDeleted{ID: id}))
+	}
+}
+
+// waitDetached waits for the server to notice the socket of token is gone.
+func waitDetached(t *testing.T, token string) *wsSession {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		broadcaster.mu.RLock()
+		s := broadcaster.sessions[token]
+		detached := s != nil && s.client == nil
+		broadcaster.mu.RUnlock()
+		if detached {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("session never detached")
+	return nil
+}
+
+func expectIDs(t *testing.T, conn *websocket.Conn, firstSeq int64, ids ...string) {
+	t.Helper()
+	for i, id := range ids {
+		fr := readFrame(t, conn)
+		var p events.MessageDeleted
+		_ = json.Unmarshal(fr.Payload, &p)
+		if p.ID != id || fr.StreamSeq != firstSeq+int64(i) {
+			t.Fatalf("frame %d: %s stream_seq %d, want %s stream_seq %d", i, p.ID, fr.StreamSeq, id, firstSeq+int64(i))
+		}
+	}
+}
+
+// connectAndDrop opens a fresh socket, receives m1 and m2, and drops it after
+// having only processed m1; m3 and m4 arrive while it is away.
+func (f *wsResumeFixture) connectAndDrop(t *testing.T) (token string, s *wsSession) {
+	t.Helper()
+	conn, code := f.dial(t, "", false)
+	if conn == nil {
+		t.Fatalf("connect: %d", code)
+	}
+	hello := readHello(t, conn)
+	if hello.Resumed || hello.ResumeToken == "" {
+		t.Fatalf("hello %+v", hello)
+	}
+	f.publish("m1", "m2")
+	expectIDs(t, conn, 1, "m1", "m2")
+	conn.Close()
+	s = waitDetached(t, hello.ResumeToken)
+	f.publish("m3", "m4")
+	return hello.ResumeToken, s
+}
+
+func TestWSResumeReplays(t *testing.T) {
+	f := newWSResumeFixture(t)
+	token, _ := f.connectAndDrop(t)
+
+	// no JWT needed: the token is the credential
+	conn, code := f.dial(t, "?resume="+token+"&after=1", true)
+	if conn == nil {
+		t.Fatalf("resume: %d", code)
+	}
+	if h := readHello(t, conn); !h.Resumed || h.ResumeToken != token {
+		t.Fatalf("hello on resume %+v", h)
+	}
+	expectIDs(t, conn, 2, "m2", "m3", "m4")
+	f.publish("m5")
+	expectIDs(t, conn, 5, "m5")
+}
+
+func TestWSResumeReplacesLiveSocket(t *testing.T) {
+	f := newWSResumeFixture(t)
+	old, _ := f.dial(t, "", false)
+	token := readHello(t, old).ResumeToken
+	f.publish("m1")
+	expectIDs(t, old, 1, "m1")
+
+	// the server still thinks old is connected
+	conn, code := f.dial(t, "?resume="+token+"&after=1", true)
+	if conn == nil {
+		t.Fatalf("resume: %d", code)
+	}
+	readHello(t, conn)
+	f.publish("m2")
+	expectIDs(t, conn, 2, "m2")
+	old.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := old.ReadMessage(); err == nil {
+		t.Fatal("replaced socket still open")
+	}
+}
+
+func TestWSResumeExpired(t *testing.T) {
+	f := newWSResumeFixture(t)
+	token, s := f.connectAndDrop(t)
+
+	// the window has passed but the expiry job hasn't run yet
+	broadcaster.mu.Lock()
+	s.detachedAt = time.Now().Add(-wsResumeTTL)
+	broadcaster.mu.Unlock()
+
+	if conn, code := f.dial(t, "?resume="+token+"&after=1", true); conn != nil || code != http.StatusUnauthorized {
+		t.Fatalf("expired resume without a JWT: %d, want 401", code)
+	}
+	// with one it is a plain new connection
+	conn, code := f.dial(t, "?resume="+token+"&after=1", false)
+	if conn == nil {
+		t.Fatalf("connect: %d", code)
+	}
+	if h := readHello(t, conn); h.Resumed || h.ResumeToken == token {
+		t.Fatalf("hello after expired resume %+v", h)
+	}
+	f.publish("m9")
+	expectIDs(t, conn, 1, "m9")
+
+	broadcaster.expireSession(s)
+	broadcaster.mu.RLock()
+	_, kept := broadcaster.sessions[token]
+	broadcaster.mu.RUnlock()
+	if kept {
+		t.Fatal("expired session kept")
+	}
+}
+
+func TestWSResumeRingOverflow(t *testing.T) {
+	f := newWSResumeFixture(t)
+	token, _ := f.connectAndDrop(t)
+	for i := range wsResumeRing {
+		f.publish("x" + strconv.Itoa(i))
+	}
+
+	// m2.. have partly fallen out of the ring: refetch instead
+	if conn, code := f.dial(t, "?resume="+token+"&after=1", true); conn != nil || code != http.StatusUnauthorized {
+		t.Fatalf("resume past the ring: %d, want 401", code)
+	}
+	// from the latest event it can still resume
+	after := strconv.Itoa(4 + wsResumeRing)
+	conn, code := f.dial(t, "?resume="+token+"&after="+after, true)
+	if conn == nil {
+		t.Fatalf("resume at the head: %d", code)
+	}
+	if h := readHello(t, conn); !h.Resumed {
+		t.Fatalf("hello %+v", h)
+	}
+	f.publish("m5")
+	expectIDs(t, conn, 5+wsResumeRing, "m5")
+}